@@ -0,0 +1,564 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cache"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+	"expeer/pkg/project"
+	"expeer/pkg/report"
+)
+
+// newFlagSet creates the flag set of a subcommand. synopsis describes the
+// positional arguments.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: expeer %s [options] %s\n", name, synopsis)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses a subcommand's arguments, allowing flags before and
+// after the positional arguments. Exits with usage unless at least min
+// positional arguments were given.
+func parseArgs(fs *flag.FlagSet, args []string, min int) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) < min {
+		fs.Usage()
+		os.Exit(1)
+	}
+	return positional
+}
+
+func cmdInfo(args []string) {
+	fs := newFlagSet("info", "<executable>")
+	verbose := fs.Bool("v", false, "Verbose output")
+	var lo loadOptions
+	lo.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, lo, os.Stdout, func(t target) {
+		binary := loadBinary(t.path, t.loadOptions, *verbose)
+		defer binary.Close()
+		printInfo(binary)
+	})
+}
+
+// printInfo prints the summary of a binary shown by the info command
+func printInfo(binary *parser.Binary) {
+	analysis := analyzer.Identify(binary)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "File:\t%s\n", binary.FilePath)
+	fmt.Fprintf(w, "Size:\t%d bytes\n", len(binary.RawData))
+	fmt.Fprintf(w, "Format:\t%s\n", binary.Format)
+	fmt.Fprintf(w, "Architecture:\t%s\n", binary.Arch)
+	fmt.Fprintf(w, "Type:\t%s\n", binary.Kind)
+	if sl := binary.Slice; sl != nil {
+		fmt.Fprintf(w, "Universal slice:\toffset 0x%x\n", sl.Offset)
+	}
+	fmt.Fprintf(w, "Entry point:\t0x%x\n", binary.EntryPoint)
+	if pe := binary.PE; pe != nil {
+		kind := "executable"
+		if pe.IsDLL() {
+			kind = "DLL"
+		}
+		fmt.Fprintf(w, "Image base:\t0x%x\n", binary.ImageBase)
+		fmt.Fprintf(w, "Image type:\t%s (%s)\n", kind, pe.SubsystemName())
+		fmt.Fprintf(w, "DLL characteristics:\t%s\n", strings.Join(pe.DllCharacteristicNames(), ", "))
+	}
+	fmt.Fprintf(w, "Compiler:\t%s\n", analysis.Compiler)
+	fmt.Fprintf(w, "Language:\t%s (confidence: %.2f%%)\n", analysis.DetectedLanguage, analysis.Confidence*100)
+	if p := analysis.Packing; p.Packer != "" {
+		packer := p.Packer
+		if p.Unpacked {
+			packer += " (unpacked)"
+		}
+		fmt.Fprintf(w, "Packer:\t%s\n", packer)
+	}
+	for i, anomaly := range analysis.Packing.Anomalies {
+		label := ""
+		if i == 0 {
+			label = "Anomalies:"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", label, anomaly)
+	}
+	fmt.Fprintf(w, "Sections:\t%d\n", len(binary.Sections))
+	fmt.Fprintf(w, "Symbols:\t%d\n", len(binary.Symbols))
+	fmt.Fprintf(w, "Imports:\t%d\n", len(binary.Imports))
+	fmt.Fprintf(w, "Exports:\t%d\n", len(binary.Exports))
+	fmt.Fprintf(w, "Libraries:\t%s\n", strings.Join(binary.Libraries, ", "))
+	w.Flush()
+}
+
+func cmdSections(args []string) {
+	fs := newFlagSet("sections", "<executable>")
+	verbose := fs.Bool("v", false, "Verbose output")
+	var lo loadOptions
+	lo.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, lo, os.Stdout, func(t target) {
+		binary := loadBinary(t.path, t.loadOptions, *verbose)
+		defer binary.Close()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME\tADDRESS\tSIZE\tFLAGS\tENTROPY\n")
+		for _, sec := range binary.Sections {
+			fmt.Fprintf(w, "%s\t0x%x\t0x%x\t0x%x\t%.2f\n", sec.Name, sec.Address, sec.Size, sec.Flags, analyzer.Entropy(sec.Data))
+		}
+		w.Flush()
+	})
+}
+
+func cmdSymbols(args []string) {
+	fs := newFlagSet("symbols", "<executable>")
+	verbose := fs.Bool("v", false, "Verbose output")
+	filter := fs.String("filter", "", "Only list symbols whose name contains this text")
+	var lo loadOptions
+	lo.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, lo, os.Stdout, func(t target) {
+		binary := loadBinary(t.path, t.loadOptions, *verbose)
+		defer binary.Close()
+
+		symbols := make([]parser.Symbol, 0, len(binary.Symbols))
+		for _, sym := range binary.Symbols {
+			if sym.Name != "" && strings.Contains(sym.Name, *filter) {
+				symbols = append(symbols, sym)
+			}
+		}
+		sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].Address < symbols[j].Address })
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ADDRESS\tSIZE\tTYPE\tNAME\n")
+		for _, sym := range symbols {
+			fmt.Fprintf(w, "0x%x\t%d\t%s\t%s\n", sym.Address, sym.Size, sym.Type, sym.Name)
+		}
+		w.Flush()
+	})
+}
+
+func cmdImports(args []string) {
+	fs := newFlagSet("imports", "<executable>")
+	verbose := fs.Bool("v", false, "Verbose output")
+	var lo loadOptions
+	lo.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, lo, os.Stdout, func(t target) {
+		binary := loadBinary(t.path, t.loadOptions, *verbose)
+		defer binary.Close()
+		for _, imp := range binary.Imports {
+			fmt.Println(imp)
+		}
+	})
+}
+
+func cmdStrings(args []string) {
+	fs := newFlagSet("strings", "<executable>")
+	verbose := fs.Bool("v", false, "Verbose output")
+	minLen := fs.Int("min", 4, "Minimum string length")
+	var lo loadOptions
+	lo.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, lo, os.Stdout, func(t target) {
+		binary := loadBinary(t.path, t.loadOptions, *verbose)
+		defer binary.Close()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, str := range analyzer.FindStrings(binary, *minLen) {
+			fmt.Fprintf(w, "0x%x\t%s\t%s\n", str.Address, str.Section, strconv.Quote(str.Value))
+		}
+		w.Flush()
+	})
+}
+
+func cmdDisasm(args []string) {
+	fs := newFlagSet("disasm", "<executable>")
+	var opts analysisOptions
+	opts.register(fs)
+	funcFilter := fs.String("func", "", "Comma-separated function names to disassemble (default: all)")
+	addr := fs.String("addr", "", "Disassemble the function containing this 0x address")
+	outputFile := fs.String("o", "", "Output file (default: stdout)")
+	path := parseArgs(fs, args, 1)[0]
+
+	filter := *funcFilter
+	if *addr != "" {
+		if !strings.HasPrefix(*addr, "0x") {
+			*addr = "0x" + *addr
+		}
+		filter = *addr
+	}
+
+	forEachTarget(path, opts.loadOptions, os.Stdout, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+
+		functions := selectFunctions(analysis.Functions, filter)
+		if len(functions) == 0 {
+			fmt.Fprintf(os.Stderr, "No matching functions\n")
+			os.Exit(1)
+		}
+
+		var sb strings.Builder
+		for i, fn := range functions {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(disassembleFunction(fn))
+		}
+		writeOutput(sb.String(), t.file(*outputFile), opts.verbose)
+	})
+}
+
+// disassembleFunction renders a function's instructions with addresses and
+// raw bytes
+func disassembleFunction(fn disasm.Function) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("; %s @ 0x%x - 0x%x\n", fn.Name, fn.StartAddr, fn.EndAddr))
+
+	for _, inst := range fn.Instructions {
+		var hex strings.Builder
+		for i, b := range inst.Bytes {
+			if i > 0 {
+				hex.WriteString(" ")
+			}
+			hex.WriteString(fmt.Sprintf("%02x", b))
+		}
+		text := inst.Mnemonic
+		if inst.Operands != "" {
+			text += " " + inst.Operands
+		}
+		sb.WriteString(fmt.Sprintf("0x%08x:  %-24s  %s\n", inst.Address, hex.String(), text))
+	}
+	return sb.String()
+}
+
+func cmdCFG(args []string) {
+	fs := newFlagSet("cfg", "<executable>")
+	var opts analysisOptions
+	opts.register(fs)
+	format := fs.String("format", "dot", "Output format: dot or mermaid")
+	funcFilter := fs.String("func", "", "Comma-separated function names or 0x addresses to export (default: all)")
+	outputFile := fs.String("o", "", "Output file (default: stdout)")
+	outputDir := fs.String("outdir", "", "Write one file per function into this directory")
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, opts.loadOptions, os.Stderr, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+		exportCFGs(analysis, *format, *funcFilter, t.file(*outputFile), t.dir(*outputDir), opts)
+	})
+}
+
+func cmdCallGraph(args []string) {
+	fs := newFlagSet("callgraph", "<executable>")
+	var opts analysisOptions
+	opts.register(fs)
+	format := fs.String("format", "dot", "Output format: dot, json or graphml")
+	outputFile := fs.String("o", "", "Output file (default: stdout)")
+	path := parseArgs(fs, args, 1)[0]
+
+	out := &jsonOutput{file: *outputFile, verbose: opts.verbose}
+	forEachTarget(path, opts.loadOptions, os.Stderr, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+		exportCallGraph(analysis, *format, t, out)
+	})
+	out.flush()
+}
+
+func cmdDecompile(args []string) {
+	fs := newFlagSet("decompile", "<executable>")
+	var opts analysisOptions
+	opts.register(fs)
+	var co codeOptions
+	co.register(fs)
+	path := parseArgs(fs, args, 1)[0]
+
+	forEachTarget(path, opts.loadOptions, os.Stdout, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+		decompile(analysis, t.codeOptions(co), opts)
+	})
+}
+
+func cmdReport(args []string) {
+	fs := newFlagSet("report", "<executable>")
+	var opts analysisOptions
+	opts.register(fs)
+	printSchema := fs.Bool("schema", false, "Print the JSON Schema of the report and exit")
+	outputFile := fs.String("o", "", "Output file (default: stdout)")
+
+	min := 1
+	if containsFlag(args, "schema") {
+		min = 0
+	}
+	positional := parseArgs(fs, args, min)
+
+	if *printSchema {
+		writeOutput(report.Schema(), *outputFile, opts.verbose)
+		return
+	}
+
+	out := &jsonOutput{file: *outputFile, verbose: opts.verbose}
+	forEachTarget(positional[0], opts.loadOptions, os.Stderr, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+		writeReport(analysis, t, out, opts)
+	})
+	out.flush()
+}
+
+// containsFlag returns true if a boolean flag is present in args
+func containsFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "-"+name || arg == "--"+name {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdMakeSig(args []string) {
+	fs := newFlagSet("mksig", "<output.sig> <object or archive>...")
+	verbose := fs.Bool("v", false, "Verbose output")
+	positional := parseArgs(fs, args, 2)
+
+	buildSignatures(positional[0], positional[1:], *verbose)
+}
+
+func cmdCache(args []string) {
+	fs := newFlagSet("cache", "[clear]")
+	positional := parseArgs(fs, args, 0)
+
+	c, err := cache.Open(buildVersion())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(positional) > 0 {
+		if positional[0] != "clear" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		removed, err := c.Clear()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error clearing cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d entries from %s\n", removed, c.Dir)
+		return
+	}
+
+	count, size, err := c.Size()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Directory:\t%s\n", c.Dir)
+	fmt.Fprintf(w, "Entries:\t%d\n", count)
+	fmt.Fprintf(w, "Size:\t%d bytes\n", size)
+	fmt.Fprintf(w, "Version:\t%s\n", c.Version)
+	w.Flush()
+}
+
+// annotateUsage describes the actions of the annotate command
+const annotateUsage = `Actions:
+  list                                   Show all annotations
+  rename <func> <name>                   Rename a function
+  rename <func>:<var> <name>             Rename a variable of a function
+  retype <func>:<var> <type>             Set the C type of a variable
+  prototype <func> <declaration>         Set a function's C prototype
+  comment <addr> [text]                  Comment an address (no text removes it)
+  struct <name> <field>...               Define a structure, e.g. "char name[16]"
+  code <start> <end>                     Mark [start, end) as code
+  data <start> <end>                     Mark [start, end) as data
+  unmark <addr>                          Remove the region containing addr
+
+<func> is an address (0x401000, sub_401000) or a function or symbol name.
+`
+
+func cmdAnnotate(args []string) {
+	fs := newFlagSet("annotate", "<action> <executable> [arguments]")
+	projectFile := fs.String("project", "", "Project file (default: <executable>"+project.Suffix+")")
+	var lo loadOptions
+	lo.register(fs)
+	usage := fs.Usage
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\n%s", annotateUsage)
+	}
+	positional := parseArgs(fs, args, 2)
+	action, path, rest := positional[0], positional[1], positional[2:]
+
+	binary := loadBinary(path, lo, false)
+	defer binary.Close()
+	proj := loadProject(binary, *projectFile)
+
+	need := func(n int) {
+		if len(rest) < n {
+			fs.Usage()
+			os.Exit(1)
+		}
+	}
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	address := func(s string) uint64 {
+		addr, err := project.ParseAddress(s)
+		if err != nil {
+			fail(fmt.Errorf("invalid address %q", s))
+		}
+		return addr
+	}
+
+	switch action {
+	case "list":
+		listAnnotations(proj)
+		return
+
+	case "rename":
+		need(2)
+		fn, variable, _ := strings.Cut(rest[0], ":")
+		addr := resolveFunction(binary, proj, fn)
+		if variable != "" {
+			proj.RenameVariable(addr, variable, rest[1])
+		} else {
+			proj.Rename(addr, rest[1])
+		}
+
+	case "retype":
+		need(2)
+		fn, variable, ok := strings.Cut(rest[0], ":")
+		if !ok {
+			fail(fmt.Errorf("expected <func>:<var>, got %q", rest[0]))
+		}
+		proj.RetypeVariable(resolveFunction(binary, proj, fn), variable, strings.Join(rest[1:], " "))
+
+	case "prototype":
+		need(2)
+		if err := proj.SetPrototype(resolveFunction(binary, proj, rest[0]), strings.Join(rest[1:], " ")); err != nil {
+			fail(err)
+		}
+
+	case "comment":
+		need(1)
+		proj.SetComment(address(rest[0]), strings.Join(rest[1:], " "))
+
+	case "struct":
+		need(2)
+		s, err := proj.DefineStruct(rest[0], rest[1:], binary.PointerSize())
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("struct %s: %d bytes\n", s.Name, s.Size)
+
+	case project.RegionCode, project.RegionData:
+		need(2)
+		if err := proj.MarkRegion(address(rest[0]), address(rest[1]), action); err != nil {
+			fail(err)
+		}
+
+	case "unmark":
+		need(1)
+		if !proj.Unmark(address(rest[0])) {
+			fail(fmt.Errorf("no region contains %s", rest[0]))
+		}
+
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := proj.Save(); err != nil {
+		fail(err)
+	}
+}
+
+// resolveFunction finds the address of a function given as an address,
+// a name from the project or a symbol name, exiting if there is none
+func resolveFunction(binary *parser.Binary, proj *project.Project, spec string) uint64 {
+	if addr, err := project.ParseAddress(spec); err == nil {
+		return addr
+	}
+	if addr, ok := proj.FunctionByName(spec); ok {
+		return addr
+	}
+	for _, sym := range binary.Symbols {
+		if sym.Name == spec && sym.Address != 0 {
+			return sym.Address
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Error: no function named %q\n", spec)
+	os.Exit(1)
+	return 0
+}
+
+// listAnnotations prints every annotation of a project, by address
+func listAnnotations(proj *project.Project) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	var addrs []project.Address
+	for addr := range proj.Functions {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		fn := proj.Functions[addr]
+		fmt.Fprintf(w, "function\t0x%x\t%s\t%s\n", uint64(addr), fn.Name, fn.Prototype)
+		var names []string
+		for name := range fn.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := fn.Variables[name]
+			fmt.Fprintf(w, "  variable\t%s\t%s\t%s\n", name, v.Name, v.Type)
+		}
+	}
+
+	addrs = addrs[:0]
+	for addr := range proj.Comments {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "comment\t0x%x\t%s\n", uint64(addr), proj.Comments[addr])
+	}
+
+	for _, s := range proj.Structs {
+		fmt.Fprintf(w, "struct\t%s\t%d bytes\t%d fields\n", s.Name, s.Size, len(s.Fields))
+	}
+	for _, r := range proj.Regions {
+		fmt.Fprintf(w, "region\t0x%x-0x%x\t%s\n", uint64(r.Start), uint64(r.End), r.Kind)
+	}
+}
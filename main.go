@@ -1,91 +1,884 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"expeer/pkg/analyzer"
+	"expeer/pkg/cache"
+	"expeer/pkg/callgraph"
+	"expeer/pkg/cdecl"
 	"expeer/pkg/codegen"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
 	"expeer/pkg/parser"
+	"expeer/pkg/project"
+	"expeer/pkg/prototypes"
+	"expeer/pkg/report"
+	"expeer/pkg/signature"
 )
 
+// version is the expeer release. Together with the VCS revision of the
+// build it keys the analysis cache.
+const version = "0.1.0"
+
+// command is an expeer subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands lists the subcommands in the order shown by "expeer help"
+var commands []command
+
+func init() {
+	commands = []command{
+		{"info", "Show format, architecture, entry point and compiler", cmdInfo},
+		{"sections", "List sections", cmdSections},
+		{"symbols", "List symbols", cmdSymbols},
+		{"imports", "List imported symbols", cmdImports},
+		{"strings", "List printable strings with their addresses", cmdStrings},
+		{"disasm", "Disassemble functions", cmdDisasm},
+		{"cfg", "Export control flow graphs (DOT, Mermaid)", cmdCFG},
+		{"callgraph", "Export the call graph (DOT, JSON, GraphML)", cmdCallGraph},
+		{"decompile", "Generate C or Go code", cmdDecompile},
+		{"report", "Write the full analysis as JSON", cmdReport},
+		{"mksig", "Build a library signature file from .o/.a/.lib files", cmdMakeSig},
+		{"annotate", "Rename, retype and comment functions; define structs and regions", cmdAnnotate},
+		{"cache", "Show or clear the analysis cache", cmdCache},
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		name := os.Args[1]
+		if name == "help" {
+			printUsage()
+			return
+		}
+		for _, cmd := range commands {
+			if cmd.name == name {
+				cmd.run(os.Args[2:])
+				return
+			}
+		}
+	}
+
+	// No subcommand: the original single-mode interface
+	runLegacy()
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: expeer <command> [options] <executable>\n")
+	fmt.Fprintf(os.Stderr, "       expeer [options] <executable>    (same as decompile)\n")
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'expeer <command> -h' for command options.\n")
+}
+
+// runLegacy implements the flag-only interface: parse, analyze and
+// generate code, or export a graph when -callgraph/-cfg is given
+func runLegacy() {
 	// CLI flags
-	outputLang := flag.String("lang", "auto", "Output language: auto, c, or go")
-	verbose := flag.Bool("v", false, "Verbose output")
-	outputFile := flag.String("o", "", "Output file (default: stdout)")
+	var opts analysisOptions
+	opts.register(flag.CommandLine)
+	var co codeOptions
+	co.register(flag.CommandLine)
+	makeSig := flag.String("mksig", "", "Build a signature file from the given .o/.a/.lib files and exit")
+	callGraph := flag.String("callgraph", "", "Export the call graph instead of code: dot, json, or graphml")
+	cfgFormat := flag.String("cfg", "", "Export per-function control flow graphs instead of code: dot or mermaid")
+	outputDir := flag.String("outdir", "", "Write one CFG file per function into this directory")
+	format := flag.String("format", "code", "Output format: code (C/Go source) or json (full analysis)")
+	flag.Usage = func() {
+		printUsage()
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if flag.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: expeer [options] <executable>\n")
-		fmt.Fprintf(os.Stderr, "\nOptions:\n")
-		flag.PrintDefaults()
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	executablePath := flag.Arg(0)
+	if *makeSig != "" {
+		buildSignatures(*makeSig, flag.Args(), opts.verbose)
+		return
+	}
+
+	if *format != "code" && *format != "json" && *callGraph == "" && *cfgFormat == "" {
+		fmt.Fprintf(os.Stderr, "Unsupported output format: %s (use code or json)\n", *format)
+		os.Exit(1)
+	}
+
+	// Headers naming the targets must not break JSON and graph output
+	headers := io.Writer(os.Stdout)
+	if *format == "json" || *callGraph != "" || *cfgFormat != "" {
+		headers = os.Stderr
+	}
+	out := &jsonOutput{file: co.outputFile, verbose: opts.verbose}
+	forEachTarget(flag.Arg(0), opts.loadOptions, headers, func(t target) {
+		analysis := loadAnalysis(t.path, t.options(opts))
+		defer analysis.Binary.Close()
+
+		switch {
+		case *callGraph != "":
+			exportCallGraph(analysis, *callGraph, t, out)
+		case *cfgFormat != "":
+			exportCFGs(analysis, *cfgFormat, co.funcFilter, t.file(co.outputFile), t.dir(*outputDir), opts)
+		case *format == "json":
+			writeReport(analysis, t, out, opts)
+		default:
+			decompile(analysis, t.codeOptions(co), opts)
+		}
+	})
+	out.flush()
+}
+
+// analysisOptions holds the flags shared by every command that analyzes code
+type analysisOptions struct {
+	verbose  bool
+	sigFiles string
+	hideLib  bool
+	userOnly bool // Keep only the program's own functions
+	workers  int
+	noCache  bool
+	project  string // Project file, empty for the one next to the binary
+	symbols  string // Directories searched for PDBs and separate debug files
+	loadOptions
+}
+
+// loadOptions selects what to load from a file: a slice of a universal
+// Mach-O, the code of an image that has no headers, or a packed program
+// as stored
+type loadOptions struct {
+	arch     string // Slice of a universal Mach-O, empty or "all" for every slice; architecture of images without headers
+	raw      bool   // Load the file as code without headers
+	base     uint64 // Load address of a raw image
+	noUnpack bool   // Keep UPX-packed binaries packed
+}
+
+// archUsage documents the -arch flag
+const archUsage = "Slice of a universal Mach-O to use, e.g. arm64 (default: all, each written separately); code of -raw, Intel HEX and S-record images"
+
+// register adds the flags selecting what to load to a command's flag set
+func (o *loadOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.arch, "arch", "", archUsage)
+	fs.BoolVar(&o.raw, "raw", false, "Load the file as -arch code without headers, such as a firmware dump")
+	fs.Func("base", "Load address of a -raw image (default 0)", func(s string) error {
+		base, err := strconv.ParseUint(s, 0, 64)
+		o.base = base
+		return err
+	})
+	fs.BoolVar(&o.noUnpack, "no-unpack", false, "Analyze UPX-packed binaries as stored, unpacking stub included")
+}
+
+// register adds the shared analysis flags to a command's flag set
+func (o *analysisOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.verbose, "v", false, "Verbose output")
+	fs.StringVar(&o.sigFiles, "sigs", "", "Comma-separated library signature files to apply")
+	fs.BoolVar(&o.hideLib, "hide-lib", false, "Omit functions matched by a library signature")
+	fs.BoolVar(&o.userOnly, "user-only", false, "Omit compiler runtime, standard library and third-party functions; calls to them keep their names")
+	fs.IntVar(&o.workers, "j", 0, "Number of parallel workers (default: one per CPU)")
+	fs.BoolVar(&o.noCache, "no-cache", false, "Neither read nor write the analysis cache")
+	fs.StringVar(&o.project, "project", "", "Project file with user annotations (default: <executable>"+project.Suffix+")")
+	fs.StringVar(&o.symbols, "symbols", "", "Comma-separated directories searched for PDBs and separate debug files")
+	o.loadOptions.register(fs)
+}
+
+// symbolPath returns the directories given to -symbols
+func (o *analysisOptions) symbolPath() []string {
+	if o.symbols == "" {
+		return nil
+	}
+	return strings.Split(o.symbols, ",")
+}
+
+// loadBinary parses an executable, its slice for the selected arch if it
+// is a universal Mach-O, or a raw image, exiting on failure
+func loadBinary(path string, lo loadOptions, verbose bool) *parser.Binary {
+	if lo.base != 0 && !lo.raw {
+		fmt.Fprintf(os.Stderr, "Error: -base only applies to -raw images\n")
+		os.Exit(1)
+	}
 
 	// Parse the executable
-	if *verbose {
-		fmt.Fprintf(os.Stderr, "[*] Parsing executable: %s\n", executablePath)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[*] Parsing executable: %s\n", path)
 	}
 
-	binary, err := parser.ParseExecutable(executablePath)
+	var binary *parser.Binary
+	var err error
+	if lo.raw {
+		binary, err = parser.ParseRaw(path, lo.arch, lo.base)
+	} else {
+		binary, err = parser.ParseArch(path, lo.arch)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing executable: %v\n", err)
+		// Both are only returned when no -arch was given
+		var universal *parser.UniversalError
+		switch {
+		case errors.Is(err, parser.ErrNoArch):
+			fmt.Fprintf(os.Stderr, "Error parsing executable: %v (use -arch to name the instruction set of the image)\n", err)
+		case errors.As(err, &universal):
+			fmt.Fprintf(os.Stderr, "Error parsing executable: %v (use -arch to pick a slice)\n", err)
+		default:
+			fmt.Fprintf(os.Stderr, "Error parsing executable: %v\n", err)
+		}
 		os.Exit(1)
 	}
 
+	if !lo.noUnpack {
+		unpacked, err := parser.UnpackUPX(binary)
+		switch {
+		case err == nil:
+			if verbose {
+				fmt.Fprintf(os.Stderr, "[*] Unpacked UPX: entry point 0x%x\n", unpacked.EntryPoint)
+			}
+			binary = unpacked
+		case !errors.Is(err, parser.ErrNotPacked):
+			fmt.Fprintf(os.Stderr, "Warning: cannot unpack: %v; analyzing the packed binary\n", err)
+		}
+	}
+	return binary
+}
+
+// loadAnalysis parses and analyzes an executable, applies library
+// signatures and leaves out the functions the options hide, exiting on
+// failure
+func loadAnalysis(path string, opts analysisOptions) *analyzer.Analysis {
+	binary := loadBinary(path, opts.loadOptions, opts.verbose)
+
 	// Analyze the binary
-	if *verbose {
+	if opts.verbose {
 		fmt.Fprintf(os.Stderr, "[*] Analyzing binary format: %s\n", binary.Format)
 		fmt.Fprintf(os.Stderr, "[*] Architecture: %s\n", binary.Arch)
 	}
 
-	analysis, err := analyzer.Analyze(binary, *verbose)
+	proj := loadProject(binary, opts.project)
+	if proj.Empty() {
+		proj = nil
+	} else if opts.verbose {
+		fmt.Fprintf(os.Stderr, "[*] Applying annotations from %s\n", proj.Path())
+	}
+
+	analysis := analyzeCached(binary, proj, opts)
+
+	// Name library functions from signature databases
+	if opts.sigFiles != "" {
+		db, err := signature.Load(strings.Split(opts.sigFiles, ",")...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading signatures: %v\n", err)
+			os.Exit(1)
+		}
+		matched := analysis.ApplySignatures(db)
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Matched %d library functions (%d signatures)\n",
+				matched, len(db.Signatures))
+		}
+		if opts.hideLib {
+			analysis.RemoveLibraryFunctions()
+		}
+	}
+	if opts.userOnly {
+		analysis.RemoveNonUserFunctions()
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Keeping %d user functions\n", len(analysis.Functions))
+		}
+	}
+
+	return analysis
+}
+
+// loadProject loads the annotations of a binary from file, or from the
+// project next to the binary if file is empty, exiting on failure. Each
+// slice of a universal Mach-O has its own project, named after its arch.
+func loadProject(binary *parser.Binary, file string) *project.Project {
+	if file == "" {
+		path := binary.FilePath
+		if binary.Slice != nil {
+			path += "." + binary.Arch
+		}
+		file = project.DefaultPath(path)
+	}
+	proj, err := project.Load(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading project: %v\n", err)
+		os.Exit(1)
+	}
+	return proj
+}
+
+// target is one executable a command works on: the file given, a slice of
+// a universal Mach-O or a member of a static library
+type target struct {
+	path string // File, or "archive(member)"
+	loadOptions
+	name   string // Member or architecture, when a command handles several targets
+	suffix string // Added to output file names when a command handles several targets
+}
+
+// forEachTarget runs fn on the executable at path. Static libraries are
+// handled member by member, universal Mach-O files slice by slice unless
+// lo selects one: fn then runs once per target, after a header naming it
+// written to headers, and names its output files after the target. Raw
+// images are a single target. Commands writing JSON or graphs pass
+// stderr, so that their output stays parseable.
+func forEachTarget(path string, lo loadOptions, headers io.Writer, fn func(t target)) {
+	if lo.arch == "all" {
+		lo.arch = ""
+	}
+	if lo.raw {
+		fn(target{path: path, loadOptions: lo})
+		return
+	}
+
+	members, err := parser.Members(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing executable: %v\n", err)
+		os.Exit(1)
+	}
+	if members != nil {
+		if len(members) == 0 {
+			fmt.Fprintf(os.Stderr, "Error parsing executable: no object files in %s\n", path)
+			os.Exit(1)
+		}
+		for i, member := range members {
+			// A later member of a duplicated name, foo.o#2, becomes foo#2
+			base, index := member, ""
+			if j := strings.LastIndexByte(member, '#'); j > 0 {
+				base, index = member[:j], member[j:]
+			}
+			t := target{
+				path:        path + "(" + member + ")",
+				loadOptions: lo,
+				name:        member,
+				suffix:      strings.TrimSuffix(base, filepath.Ext(base)) + index,
+			}
+			printTargetHeader(headers, i, t.path)
+			fn(t)
+		}
+		return
+	}
+
+	if lo.arch != "" {
+		fn(target{path: path, loadOptions: lo})
+		return
+	}
+	slices, err := parser.Slices(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing executable: %v\n", err)
+		os.Exit(1)
+	}
+	if slices == nil {
+		fn(target{path: path, loadOptions: lo})
+		return
+	}
+	for i, sl := range slices {
+		printTargetHeader(headers, i, fmt.Sprintf("%s (%s)", path, sl.Arch))
+		lo.arch = sl.Arch
+		fn(target{path: path, loadOptions: lo, name: sl.Arch, suffix: sl.Arch})
+	}
+}
+
+// printTargetHeader separates the output of the targets of a file
+func printTargetHeader(w io.Writer, i int, name string) {
+	if i > 0 && w == os.Stdout {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "==> %s <==\n", name)
+}
+
+// file returns the name of an output file of the target: name itself, or
+// with the target's suffix added before the extension when several
+// targets are written. An empty name (stdout) is kept.
+func (t target) file(name string) string {
+	if t.suffix == "" || name == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + t.suffix + ext
+}
+
+// dir returns the output directory of the target: a subdirectory of dir
+// named after the target when several targets are written
+func (t target) dir(dir string) string {
+	if t.suffix == "" || dir == "" {
+		return dir
+	}
+	return filepath.Join(dir, t.suffix)
+}
+
+// options returns the analysis options selecting the target
+func (t target) options(opts analysisOptions) analysisOptions {
+	opts.loadOptions = t.loadOptions
+	return opts
+}
+
+// codeOptions returns the code generation options with the target's
+// output files
+func (t target) codeOptions(co codeOptions) codeOptions {
+	co.outputFile = t.file(co.outputFile)
+	co.mapFile = t.file(co.mapFile)
+	return co
+}
+
+// analyzeCached analyzes a binary, reusing the result of a previous run on
+// the same file contents unless caching is disabled. Cache failures are
+// never fatal: the binary is analyzed as if there were no cache.
+func analyzeCached(binary *parser.Binary, proj *project.Project, opts analysisOptions) *analyzer.Analysis {
+	var c *cache.Cache
+	if !opts.noCache {
+		var err error
+		c, err = cache.Open(buildVersion())
+		if err != nil && opts.verbose {
+			fmt.Fprintf(os.Stderr, "Warning: analysis cache unavailable: %v\n", err)
+		}
+	}
+
+	if c != nil {
+		if analysis, ok := c.Load(binary, proj, opts.symbolPath()); ok {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Loaded analysis from cache (%d functions)\n", len(analysis.Functions))
+			}
+			return analysis
+		}
+	}
+
+	analysis, err := analyzer.Analyze(binary, analyzer.Options{
+		Project:    proj,
+		SymbolPath: opts.symbolPath(),
+		Workers:    opts.workers,
+		Verbose:    opts.verbose,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error analyzing binary: %v\n", err)
 		os.Exit(1)
 	}
 
+	if c != nil {
+		if err := c.Store(analysis, opts.symbolPath()); err != nil && opts.verbose {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	return analysis
+}
+
+// buildVersion identifies this build of expeer: the release plus the VCS
+// revision. Builds without a clean revision (local changes, or built from
+// a file list) add the executable's modification time, so rebuilding
+// always invalidates the cache.
+func buildVersion() string {
+	v := version
+	clean := false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				v += "+" + setting.Value
+				clean = true
+			case "vcs.modified":
+				clean = clean && setting.Value != "true"
+			}
+		}
+	}
+	if !clean {
+		if exe, err := os.Executable(); err == nil {
+			if st, err := os.Stat(exe); err == nil {
+				v += fmt.Sprintf("-dev.%d", st.ModTime().UnixNano())
+			}
+		}
+	}
+	return v
+}
+
+// codeOptions holds the flags that control code generation
+type codeOptions struct {
+	lang       string
+	funcFilter string
+	outputFile string
+	mapFile    string // Side-car line to address map, empty for none
+	interleave bool
+	protoFiles string // Prototype files added to the bundled database
+	headers    string // C headers declaring types and functions of the binary
+}
+
+// register adds the code generation flags to a command's flag set
+func (o *codeOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.lang, "lang", "auto", "Output language: auto, c, go, or asm")
+	fs.StringVar(&o.funcFilter, "func", "", "Comma-separated function names or 0x addresses to decompile (default: all)")
+	fs.StringVar(&o.outputFile, "o", "", "Output file (default: stdout)")
+	fs.StringVar(&o.mapFile, "map", "", "Write a JSON map of output lines to instruction addresses (C and Go)")
+	fs.BoolVar(&o.interleave, "interleave", false, "Print the disassembly of each statement below it (C and Go)")
+	fs.StringVar(&o.protoFiles, "protos", "", "Comma-separated function prototype files to add to the bundled ones")
+	fs.StringVar(&o.headers, "headers", "", "Comma-separated C headers declaring types and functions of the binary")
+}
+
+// decompile generates code for the selected functions
+func decompile(analysis *analyzer.Analysis, co codeOptions, opts analysisOptions) {
+	lang := co.lang
+	verbose := opts.verbose
+
 	// Detect language if auto mode
-	lang := *outputLang
 	if lang == "auto" {
 		lang = analysis.DetectedLanguage
-		if *verbose {
+		if verbose {
 			fmt.Fprintf(os.Stderr, "[*] Detected language: %s (confidence: %.2f%%)\n",
 				lang, analysis.Confidence*100)
 		}
 	}
 
+	// The other functions still name call targets and cross-references
+	genOpts := codegen.Options{Interleave: co.interleave, Workers: opts.workers}
+	if co.funcFilter != "" {
+		selected := selectFunctions(analysis.Functions, co.funcFilter)
+		if len(selected) == 0 {
+			fmt.Fprintf(os.Stderr, "No matching functions\n")
+			os.Exit(1)
+		}
+		genOpts.Only = make(map[uint64]bool, len(selected))
+		for _, fn := range selected {
+			genOpts.Only[fn.StartAddr] = true
+		}
+	}
+
 	// Generate code
-	if *verbose {
+	if verbose {
 		fmt.Fprintf(os.Stderr, "[*] Generating %s code...\n", lang)
 	}
 
+	if co.protoFiles != "" {
+		db, err := prototypes.Load(strings.Split(co.protoFiles, ",")...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading prototypes: %v\n", err)
+			os.Exit(1)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[*] Loaded %d function prototypes\n", db.Len())
+		}
+		genOpts.Prototypes = db
+	}
+	if co.headers != "" {
+		// The user's declarations override those of the debug information
+		h := loadHeaders(co.headers, analysis.Binary.PointerSize(), verbose)
+		if analysis.Header != nil {
+			analysis.Header.Merge(h)
+		} else {
+			analysis.Header = h
+		}
+	}
+
 	var code string
+	var sourceMap *codegen.SourceMap
 	switch lang {
 	case "c":
-		code = codegen.GenerateC(analysis)
+		code, sourceMap = codegen.GenerateCWithMap(analysis, genOpts)
 	case "go", "golang":
-		code = codegen.GenerateGo(analysis)
+		code, sourceMap = codegen.GenerateGoWithMap(analysis, genOpts)
+	case "asm":
+		code = codegen.GenerateAsm(analysis, genOpts)
 	default:
 		fmt.Fprintf(os.Stderr, "Unsupported language: %s\n", lang)
 		os.Exit(1)
 	}
 
 	// Output results
-	if *outputFile != "" {
-		err = os.WriteFile(*outputFile, []byte(code), 0644)
+	writeOutput(code, co.outputFile, verbose)
+
+	if co.mapFile != "" {
+		if sourceMap == nil {
+			fmt.Fprintf(os.Stderr, "Warning: no line map for %s output\n", lang)
+			return
+		}
+		out, err := sourceMap.JSON()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding line map: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(co.mapFile, []byte(out), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing line map: %v\n", err)
+			os.Exit(1)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[+] Line map written to: %s\n", co.mapFile)
+		}
+	}
+}
+
+// loadHeaders parses the C headers given to -headers and lays out their
+// structures for the binary's architecture
+func loadHeaders(paths string, ptrSize int, verbose bool) *cdecl.Header {
+	h, err := cdecl.ParseHeaderFiles(strings.Split(paths, ",")...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading headers: %v\n", err)
+		os.Exit(1)
+	}
+	warnings := h.Layout(ptrSize)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[*] Loaded %d types and %d function prototypes from headers\n",
+			len(h.Structs)+len(h.Enums)+len(h.Typedefs), len(h.Prototypes))
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		for _, decl := range h.Skipped {
+			fmt.Fprintf(os.Stderr, "Warning: skipped declaration: %s\n", decl)
+		}
+	}
+	return h
+}
+
+// writeReport writes the JSON report of the analysis of the target
+func writeReport(analysis *analyzer.Analysis, t target, out *jsonOutput, opts analysisOptions) {
+	text, err := report.Build(analysis, opts.workers).JSON()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding report: %v\n", err)
+		os.Exit(1)
+	}
+	out.write(t, text)
+}
+
+// exportCallGraph builds the call graph of the target and writes it in the
+// given format. DOT files may hold several graphs, so those of several
+// targets are written one after the other; GraphML files may not.
+func exportCallGraph(analysis *analyzer.Analysis, format string, t target, out *jsonOutput) {
+	if format == "graphml" && t.name != "" && out.file == "" {
+		fmt.Fprintf(os.Stderr, "Error exporting call graph: GraphML output of %s needs -o, one file per target\n", t.path)
+		os.Exit(1)
+	}
+	verbose := out.verbose
+	graph := callgraph.Build(analysis.Binary, analysis.Functions, analysis.Main)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[*] Call graph: %d nodes, %d call sites, %d recursive components, %d unreachable\n",
+			len(graph.Nodes), len(graph.Edges), len(graph.SCCs), len(graph.Unreachable()))
+	}
+	text, err := graph.Export(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting call graph: %v\n", err)
+		os.Exit(1)
+	}
+	if format == "json" {
+		out.write(t, text)
+		return
+	}
+	writeOutput(text, t.file(out.file), verbose)
+}
+
+// jsonOutput writes the JSON documents of the targets of a command. With
+// an output file each target gets its own; on stdout, the documents of
+// several targets are gathered and printed by flush as a single object
+// keyed by target name, so that the output stays one JSON value.
+type jsonOutput struct {
+	file    string // Output file, empty for stdout
+	verbose bool
+	names   []string
+	texts   []string
+}
+
+// write writes or gathers the document of a target
+func (o *jsonOutput) write(t target, text string) {
+	if t.name == "" || o.file != "" {
+		writeOutput(text, t.file(o.file), o.verbose)
+		return
+	}
+	o.names = append(o.names, t.name)
+	o.texts = append(o.texts, text)
+}
+
+// flush prints the gathered documents
+func (o *jsonOutput) flush() {
+	if len(o.names) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, name := range o.names {
+		key, _ := json.Marshal(name)
+		fmt.Fprintf(&buf, "  %s: ", key)
+		if err := json.Indent(&buf, bytes.TrimSpace([]byte(o.texts[i])), "  ", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding output of %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		if i < len(o.names)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+	writeOutput(buf.String(), "", o.verbose)
+}
+
+// writeOutput writes generated text to the output file, or stdout
+func writeOutput(text string, outputFile string, verbose bool) {
+	if outputFile != "" {
+		err := os.WriteFile(outputFile, []byte(text), 0644)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
 			os.Exit(1)
 		}
-		if *verbose {
-			fmt.Fprintf(os.Stderr, "[+] Code written to: %s\n", *outputFile)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[+] Code written to: %s\n", outputFile)
 		}
 	} else {
-		fmt.Print(code)
+		fmt.Print(text)
+	}
+}
+
+// buildSignatures generates a signature database from object files and
+// static libraries
+func buildSignatures(outPath string, inputs []string, verbose bool) {
+	db := signature.NewDatabase()
+
+	for _, input := range inputs {
+		sigs, err := signature.BuildFromFile(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", input, err)
+			os.Exit(1)
+		}
+		for _, sig := range sigs {
+			db.Add(sig)
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[*] %s: %d signatures\n", input, len(sigs))
+		}
 	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := db.Write(f); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[+] %d signatures written to: %s\n", len(db.Signatures), outPath)
+	}
+}
+
+// selectFunctions returns the functions matching a comma-separated list of
+// names or 0x-prefixed addresses. An address selects the function that
+// contains it. An empty filter selects every function.
+func selectFunctions(functions []disasm.Function, filter string) []disasm.Function {
+	if filter == "" {
+		return functions
+	}
+
+	var selected []disasm.Function
+	for _, item := range strings.Split(filter, ",") {
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "0x") {
+			addr, err := strconv.ParseUint(item[2:], 16, 64)
+			if err != nil {
+				continue
+			}
+			for _, fn := range functions {
+				if addr >= fn.StartAddr && addr <= fn.EndAddr {
+					selected = append(selected, fn)
+					break
+				}
+			}
+			continue
+		}
+		for _, fn := range functions {
+			if fn.Name == item {
+				selected = append(selected, fn)
+			}
+		}
+	}
+	return selected
+}
+
+// exportCFGs renders the control flow graph of each selected function,
+// either concatenated to the output or as one file per function
+func exportCFGs(analysis *analyzer.Analysis, format, filter, outputFile, outputDir string, opts analysisOptions) {
+	functions := selectFunctions(analysis.Functions, filter)
+	if len(functions) == 0 {
+		fmt.Fprintf(os.Stderr, "No matching functions\n")
+		os.Exit(1)
+	}
+
+	ext := ".dot"
+	if format == "mermaid" || format == "mmd" {
+		ext = ".mmd"
+	}
+
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Build and render the graphs concurrently, then write them in order
+	type rendered struct {
+		out      string
+		buildErr error
+		err      error
+	}
+	results := parallel.Map(functions, opts.workers, func(fn disasm.Function) rendered {
+		graph, err := analysis.CFG(&fn)
+		if err != nil {
+			return rendered{buildErr: err}
+		}
+		out, err := graph.Export(format)
+		return rendered{out: out, err: err}
+	})
+
+	var combined strings.Builder
+	for i := range functions {
+		fn := &functions[i]
+		if results[i].buildErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: CFG for %s: %v\n", fn.Name, results[i].buildErr)
+			continue
+		}
+		if results[i].err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting CFG: %v\n", results[i].err)
+			os.Exit(1)
+		}
+
+		if outputDir == "" {
+			combined.WriteString(results[i].out)
+			continue
+		}
+
+		path := filepath.Join(outputDir, fileNameFor(fn)+ext)
+		if err := os.WriteFile(path, []byte(results[i].out), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if outputDir != "" {
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[+] %d CFGs written to: %s\n", len(functions), outputDir)
+		}
+		return
+	}
+	writeOutput(combined.String(), outputFile, opts.verbose)
+}
+
+// fileNameFor returns a file system safe name for a function
+func fileNameFor(fn *disasm.Function) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, fn.Name)
+	return fmt.Sprintf("%s_%x", name, fn.StartAddr)
 }
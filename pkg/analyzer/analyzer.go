@@ -2,10 +2,19 @@ package analyzer
 
 import (
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 
+	"expeer/pkg/cdecl"
+	"expeer/pkg/cfg"
+	"expeer/pkg/debuginfo"
 	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
 	"expeer/pkg/parser"
+	"expeer/pkg/project"
+	"expeer/pkg/rtti"
+	"expeer/pkg/signature"
 )
 
 // Analysis contains the results of analyzing a binary
@@ -17,68 +26,145 @@ type Analysis struct {
 	Strings          []string
 	GoIndicators     []string
 	CIndicators      []string
+	Compiler         string
+	RTTI             *rtti.Hierarchy                  // C++ classes, nil if no RTTI was found
+	Project          *project.Project                 // User annotations, nil if none
+	Header           *cdecl.Header                    // Declarations from debug information and the user's C headers, nil if none
+	Debug            *debuginfo.Info                  // Debug information of the binary, nil if none
+	Packing          *Packing                         // Packer, section entropy and anomalies
+	Main             uint64                           // Address of the program's main function, 0 if not found
+	Omitted          map[uint64]string                // Functions removed from Functions, by address, with their names
+	CFGs             map[uint64]*cfg.ControlFlowGraph // Control flow graph of each function, by start address
 }
 
-// Analyze performs comprehensive analysis on a binary
-func Analyze(binary *parser.Binary, verbose bool) (*Analysis, error) {
+// Options controls analysis
+type Options struct {
+	Project    *project.Project // User annotations overriding the function finder's heuristics, may be nil
+	SymbolPath []string         // Directories searched for separate debug files
+	Workers    int              // Code sections disassembled concurrently, 0 for one per CPU
+	Verbose    bool             // Report progress and ignored problems on stderr
+}
+
+// Analyze performs comprehensive analysis on a binary. The user's
+// annotations in opts.Project, and debug information when the binary has
+// some, bound and name functions ahead of the function finder's
+// heuristics. The result does not depend on the number of workers. The
+// returned Analysis is safe for concurrent reads; passes that modify it
+// must not run concurrently with readers.
+func Analyze(binary *parser.Binary, opts Options) (*Analysis, error) {
+	analysis := Identify(binary)
+	analysis.Project = opts.Project
+
+	info, err := debuginfo.Load(binary, opts.SymbolPath)
+	if err != nil && opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring debug information: %v\n", err)
+	}
+	analysis.UseDebugInfo(info)
+	if info != nil && opts.Verbose {
+		source := "the binary"
+		if info.Path != "" {
+			source = info.Path
+		}
+		fmt.Fprintf(os.Stderr, "[*] Loaded %s from %s: %d functions, %d types, %d line entries\n",
+			info.Format, source, len(info.Functions), len(info.Types.Structs)+len(info.Types.Typedefs)+len(info.Types.Enums), len(info.Lines))
+	}
+
+	// Disassemble code sections and find functions
+	err = analysis.disassembleCode(opts.Verbose, opts.Workers)
+	if err != nil && opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Warning: disassembly issues: %v\n", err)
+	}
+
+	// Recover C++ classes from RTTI and vtables
+	analysis.recoverClasses()
+
+	// Tell the program's code from the runtime around it
+	analysis.classifyFunctions()
+	analysis.nameMain()
+	analysis.classifyStartupCode()
+	analysis.classifyStaticLibraryCode()
+	analysis.classifyColdParts()
+
+	// Build the control flow graph of every function
+	analysis.buildCFGs(opts.Workers)
+
+	return analysis, nil
+}
+
+// Identify runs the inexpensive passes that do not need disassembly:
+// string extraction, language detection, compiler identification and
+// packer detection
+func Identify(binary *parser.Binary) *Analysis {
 	analysis := &Analysis{
-		Binary: binary,
+		Binary:  binary,
+		Packing: DetectPacking(binary),
 	}
 
 	// Extract strings from all sections
 	analysis.extractStrings()
 
-	// Disassemble code sections and find functions
-	err := analysis.disassembleCode(verbose)
-	if err != nil && verbose {
-		fmt.Printf("Warning: disassembly issues: %v\n", err)
-	}
-
 	// Detect language
 	analysis.detectLanguage()
+	analysis.Compiler = DetectCompiler(binary)
 
-	return analysis, nil
+	return analysis
 }
 
-// extractStrings extracts readable strings from the binary
-func (a *Analysis) extractStrings() {
-	for _, section := range a.Binary.Sections {
-		// Look for sections that might contain strings
-		if strings.Contains(strings.ToLower(section.Name), "data") ||
-			strings.Contains(strings.ToLower(section.Name), "rodata") ||
-			strings.Contains(strings.ToLower(section.Name), "rdata") {
-
-			strings := extractReadableStrings(section.Data)
-			a.Strings = append(a.Strings, strings...)
-		}
+// UseDebugInfo attaches the debug information of the binary, whose types,
+// if any, become the analysis' declarations. info may be nil.
+func (a *Analysis) UseDebugInfo(info *debuginfo.Info) {
+	a.Debug = info
+	if info != nil && len(info.Types.Structs)+len(info.Types.Typedefs)+len(info.Types.Enums) > 0 {
+		a.Header = info.Types
 	}
 }
 
-func extractReadableStrings(data []byte) []string {
-	var result []string
-	var current []byte
+// buildCFGs builds the control flow graphs of the functions concurrently.
+// Functions whose graph cannot be built are left without one.
+func (a *Analysis) buildCFGs(workers int) {
+	indices := make([]int, len(a.Functions))
+	for i := range indices {
+		indices[i] = i
+	}
+	graphs := parallel.Map(indices, workers, func(i int) *cfg.ControlFlowGraph {
+		graph, err := cfg.BuildCFG(&a.Functions[i])
+		if err != nil {
+			return nil
+		}
+		return graph
+	})
 
-	for _, b := range data {
-		if b >= 32 && b <= 126 { // Printable ASCII
-			current = append(current, b)
-		} else if len(current) >= 4 { // Minimum string length
-			result = append(result, string(current))
-			current = nil
-		} else {
-			current = nil
+	a.CFGs = make(map[uint64]*cfg.ControlFlowGraph, len(graphs))
+	for i, graph := range graphs {
+		if graph != nil {
+			a.CFGs[a.Functions[i].StartAddr] = graph
 		}
 	}
+}
 
-	if len(current) >= 4 {
-		result = append(result, string(current))
+// CFG returns the control flow graph of fn, built with the analysis or,
+// for functions it does not know, now
+func (a *Analysis) CFG(fn *disasm.Function) (*cfg.ControlFlowGraph, error) {
+	if graph, ok := a.CFGs[fn.StartAddr]; ok && graph.Function.EndAddr == fn.EndAddr {
+		return graph, nil
 	}
+	return cfg.BuildCFG(fn)
+}
 
-	return result
+// extractStrings extracts readable strings from the binary
+func (a *Analysis) extractStrings() {
+	for _, str := range FindStrings(a.Binary, 4) {
+		a.Strings = append(a.Strings, str.Value)
+	}
 }
 
-// disassembleCode disassembles code sections
-func (a *Analysis) disassembleCode(verbose bool) error {
-	for _, section := range a.Binary.Sections {
+// disassembleCode disassembles code sections concurrently, appending
+// their functions in section order
+func (a *Analysis) disassembleCode(verbose bool, workers int) error {
+	var sections []*parser.Section
+	for i := range a.Binary.Sections {
+		section := &a.Binary.Sections[i]
+
 		// Look for executable sections
 		isCode := false
 		sectionLower := strings.ToLower(section.Name)
@@ -94,30 +180,222 @@ func (a *Analysis) disassembleCode(verbose bool) error {
 			isCode = true
 		}
 
-		if !isCode {
-			continue
+		// Sections holding a region the user marked as code
+		if a.Project != nil {
+			for _, r := range a.Project.Regions {
+				if r.Kind == project.RegionCode && uint64(r.Start) >= section.Address &&
+					uint64(r.Start) < section.Address+section.Size {
+					isCode = true
+				}
+			}
 		}
 
-		if verbose {
-			fmt.Printf("[*] Disassembling section: %s (0x%x bytes)\n", section.Name, section.Size)
+		if isCode {
+			sections = append(sections, section)
+		}
+	}
+
+	symbols := make(map[uint64]bool, len(a.Binary.Symbols))
+	for _, sym := range a.Binary.Symbols {
+		if sym.Name != "" {
+			symbols[sym.Address] = true
 		}
+	}
+
+	entries := a.startupFunctions(symbols)
 
-		instructions, err := disasm.DisassembleSection(&section, a.Binary.Arch)
+	type sectionResult struct {
+		functions []disasm.Function
+		err       error
+	}
+	results := parallel.Map(sections, workers, func(section *parser.Section) sectionResult {
+		instructions, err := disasm.DisassembleSection(section, a.Binary)
 		if err != nil {
-			return err
+			return sectionResult{err: err}
+		}
+		var hints disasm.Hints
+		if a.Project != nil || a.Debug != nil || len(a.Binary.FunctionStarts) > 0 || len(entries) > 0 {
+			hints = functionHints{a.Project, a.Debug, symbols, a.Binary.FunctionStarts, entries}
 		}
+		return sectionResult{functions: disasm.FindFunctionsWithHints(instructions, a.Binary.Symbols, a.Binary.Arch, hints)}
+	})
 
-		functions := disasm.FindFunctions(instructions, a.Binary.Symbols)
-		a.Functions = append(a.Functions, functions...)
+	for i, section := range sections {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[*] Disassembling section: %s (0x%x bytes)\n", section.Name, section.Size)
+		}
+		if results[i].err != nil {
+			return results[i].err
+		}
+
+		a.Functions = append(a.Functions, results[i].functions...)
 
 		if verbose {
-			fmt.Printf("[*] Found %d functions in section %s\n", len(functions), section.Name)
+			fmt.Fprintf(os.Stderr, "[*] Found %d functions in section %s\n", len(results[i].functions), section.Name)
 		}
 	}
 
 	return nil
 }
 
+// functionHints guides the function finder with the user's annotations
+// and, where the user said nothing, the debug information, then the
+// function starts the linker recorded and those the startup code reveals.
+// Debug names only apply to functions without a symbol: symbols are what
+// calls and signatures refer to.
+type functionHints struct {
+	proj    *project.Project
+	debug   *debuginfo.Info
+	symbols map[uint64]bool   // Addresses with a named symbol
+	starts  []uint64          // Sorted function starts of the binary, nil if unknown
+	entries map[uint64]string // Entry point and main, with the name main gets
+}
+
+// start returns the index of addr in starts, or -1
+func (h functionHints) start(addr uint64) int {
+	i := sort.Search(len(h.starts), func(i int) bool { return h.starts[i] >= addr })
+	if i < len(h.starts) && h.starts[i] == addr {
+		return i
+	}
+	return -1
+}
+
+func (h functionHints) FunctionName(addr uint64) (string, bool) {
+	if h.proj != nil {
+		if name, ok := h.proj.FunctionName(addr); ok {
+			return name, true
+		}
+	}
+	if h.debug != nil {
+		if fn := h.debug.Function(addr); fn != nil {
+			if h.symbols[addr] {
+				return "", true
+			}
+			return fn.SymbolName(), true
+		}
+	}
+	if name, ok := h.entries[addr]; ok {
+		return name, true
+	}
+	if h.start(addr) >= 0 {
+		return "", true
+	}
+	return "", false
+}
+
+// FunctionEnd returns the extent from the debug information, otherwise
+// the next recorded function start
+func (h functionHints) FunctionEnd(addr uint64) (uint64, bool) {
+	if h.debug != nil {
+		if end, ok := h.debug.Extent(addr); ok {
+			return end, true
+		}
+	}
+	if i := h.start(addr); i >= 0 && i+1 < len(h.starts) {
+		return h.starts[i+1], true
+	}
+	return 0, false
+}
+
+func (h functionHints) IsCode(addr uint64) bool {
+	return h.proj != nil && h.proj.IsCode(addr)
+}
+
+func (h functionHints) IsData(addr uint64) bool {
+	return h.proj != nil && h.proj.IsData(addr)
+}
+
+// recoverClasses reconstructs C++ classes and names unnamed functions
+// that appear in a vtable after the method they implement
+func (a *Analysis) recoverClasses() {
+	a.RTTI = rtti.Recover(a.Binary)
+	if a.RTTI == nil {
+		return
+	}
+
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		if !strings.HasPrefix(fn.Name, "sub_") {
+			continue
+		}
+		if name, ok := a.RTTI.MethodName(fn.StartAddr); ok {
+			fn.Name = name
+		}
+	}
+}
+
+// ApplySignatures matches every function against a library signature
+// database, naming matched functions and marking them as library code.
+// Returns the number of functions matched.
+func (a *Analysis) ApplySignatures(db *signature.Database) int {
+	names := make(map[uint64]string)
+	for _, sym := range a.Binary.Symbols {
+		if sym.Name != "" {
+			names[sym.Address] = sym.Name
+		}
+	}
+	for _, fn := range a.Functions {
+		names[fn.StartAddr] = fn.Name
+	}
+
+	matched := 0
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		code := a.Binary.BytesAt(fn.StartAddr, db.MaxSize())
+
+		sig := db.Match(code, fn, names)
+		if sig == nil {
+			continue
+		}
+
+		// Keep real symbol names; only replace generated ones
+		if strings.HasPrefix(fn.Name, "sub_") {
+			fn.Name = sig.Name
+			names[fn.StartAddr] = sig.Name
+		}
+		fn.IsLibrary = true
+		if fn.Class == disasm.ClassUser {
+			fn.Class = disasm.ClassStdlib
+		}
+		matched++
+	}
+
+	return matched
+}
+
+// RemoveLibraryFunctions drops functions matched by a library signature so
+// that code generation only covers user code
+func (a *Analysis) RemoveLibraryFunctions() {
+	a.removeFunctions(func(fn *disasm.Function) bool { return fn.IsLibrary })
+}
+
+// RemoveNonUserFunctions drops the runtime, standard library and
+// third-party functions, keeping the program's own code. Calls to them
+// remain, by name.
+func (a *Analysis) RemoveNonUserFunctions() {
+	a.removeFunctions(func(fn *disasm.Function) bool {
+		return fn.Class != disasm.ClassUser && fn.Class != ""
+	})
+}
+
+// removeFunctions drops the functions remove selects, recording their
+// names in Omitted
+func (a *Analysis) removeFunctions(remove func(fn *disasm.Function) bool) {
+	if a.Omitted == nil {
+		a.Omitted = make(map[uint64]string)
+	}
+	var kept []disasm.Function
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		if remove(fn) {
+			a.Omitted[fn.StartAddr] = fn.Name
+		} else {
+			kept = append(kept, *fn)
+		}
+	}
+	a.Functions = kept
+}
+
 // detectLanguage attempts to detect if the binary was compiled from C or Go
 func (a *Analysis) detectLanguage() {
 	goScore := 0.0
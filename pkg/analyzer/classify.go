@@ -0,0 +1,621 @@
+package analyzer
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/callgraph"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+	"expeer/pkg/prototypes"
+)
+
+// crtFunctions are the startup and teardown functions C and C++ compilers
+// link into every program
+var crtFunctions = map[string]bool{
+	"_start": true, "_init": true, "_fini": true, "start": true,
+	"deregister_tm_clones": true, "register_tm_clones": true,
+	"__do_global_dtors_aux": true, "__do_global_ctors_aux": true, "frame_dummy": true,
+	"__libc_csu_init": true, "__libc_csu_fini": true, "_dl_relocate_static_pie": true,
+	"mainCRTStartup": true, "wmainCRTStartup": true, "WinMainCRTStartup": true, "wWinMainCRTStartup": true,
+	"_DllMainCRTStartup": true, "DllMainCRTStartup": true, "__tmainCRTStartup": true, "_CRT_INIT": true,
+	"pre_c_init": true, "pre_cpp_init": true, "__main": true, "__do_global_ctors": true, "__do_global_dtors": true,
+	"_pei386_runtime_relocator": true, "__chkstk": true, "___chkstk_ms": true, "__report_gsfailure": true,
+	"__GSHandlerCheck": true, "__GSHandlerCheck_EH": true, "_guard_dispatch_icall_nop": true,
+}
+
+// crtPrefixes start the names of compiler runtime functions
+var crtPrefixes = []string{
+	"__scrt_", "__security_", "__acrt_", "__vcrt_", "_RTC_", "__mingw_", "_mingw_", "__x86.get_pc_thunk",
+	"_GLOBAL__sub_I_", "_GLOBAL__sub_D_", "dyld_", "_dyld_", "__cxa_", "__gxx_", "__gcc_", "_Unwind_", "__stack_chk_",
+}
+
+// libcPrefixes start the names of C library internals
+var libcPrefixes = []string{
+	"__libc_", "_IO_", "_dl_", "__GI_", "_nl_", "__gconv", "__nss_", "__pthread_", "__memcpy", "__memset", "__strlen",
+}
+
+// goRuntimePrefixes start the names of the functions the Go toolchain
+// generates: type equality and hash functions, the build ID, linker stubs
+var goRuntimePrefixes = []string{"type:", "type.", "go:", "go.", "_rt0_"}
+
+var goModulePattern = regexp.MustCompile(`(?m)^mod\t([^\t\n]+)`)
+
+// classifyFunctions sorts every function into user code, compiler runtime,
+// standard library or third-party package
+func (a *Analysis) classifyFunctions() {
+	isGo := a.isGoBinary()
+	module := ""
+	if isGo {
+		module = goMainModule(a.Binary)
+	}
+	symbols := sizedSymbols(a.Binary)
+	called := make(map[uint64]bool)
+	for _, fn := range a.Functions {
+		for _, target := range fn.Calls {
+			called[target] = true
+		}
+	}
+
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		named := *fn
+		// Pieces of a function the finder split off belong with it: they
+		// lie within its symbol, or follow it and are never called
+		if strings.HasPrefix(fn.Name, "sub_") {
+			if sym := containingSymbol(symbols, fn.StartAddr); sym != nil {
+				named.Name = sym.Name
+			} else if i > 0 && fn.StartAddr <= a.Functions[i-1].EndAddr+1 && !called[fn.StartAddr] &&
+				sectionAt(a.Binary, fn.StartAddr) == sectionAt(a.Binary, a.Functions[i-1].StartAddr) {
+				fn.Class = a.Functions[i-1].Class
+				continue
+			}
+		}
+		if isGo {
+			fn.Class = classifyGoFunction(named.Name, module)
+		} else {
+			fn.Class = classifyCFunction(a.Binary, named)
+		}
+	}
+}
+
+// isGoBinary reports whether the Go toolchain built the binary: it has Go
+// build info or a Go runtime
+func (a *Analysis) isGoBinary() bool {
+	if goBuildInfo(a.Binary) != nil {
+		return true
+	}
+	for _, fn := range a.Functions {
+		if fn.Name == "runtime.main" {
+			return true
+		}
+	}
+	return false
+}
+
+// sizedSymbols returns the named symbols with a size, sorted by address
+func sizedSymbols(b *parser.Binary) []parser.Symbol {
+	var symbols []parser.Symbol
+	for _, sym := range b.Symbols {
+		if sym.Name != "" && sym.Size > 0 {
+			symbols = append(symbols, sym)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Address < symbols[j].Address })
+	return symbols
+}
+
+// containingSymbol returns the symbol whose extent holds addr, or nil
+func containingSymbol(symbols []parser.Symbol, addr uint64) *parser.Symbol {
+	i := sort.Search(len(symbols), func(i int) bool { return symbols[i].Address > addr })
+	if i > 0 && addr < symbols[i-1].Address+symbols[i-1].Size {
+		return &symbols[i-1]
+	}
+	return nil
+}
+
+// classifyCFunction tells CRT scaffolding and C and C++ library functions
+// by their names and sections, and by library signatures. Other functions
+// are the program's.
+func classifyCFunction(b *parser.Binary, fn disasm.Function) disasm.FunctionClass {
+	name := fn.Name
+	if b.Format == "Mach-O" {
+		name = strings.TrimPrefix(name, "_")
+	}
+	if crtFunctions[name] || hasAnyPrefix(name, crtPrefixes) {
+		return disasm.ClassRuntime
+	}
+
+	// Parts GCC splits off, such as foo.cold and foo.part.0, are foo's
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	switch {
+	case crtFunctions[name]:
+		return disasm.ClassRuntime
+	case reservedName(name):
+		// Identifiers starting with __ or _ and a capital are the
+		// implementation's
+		return disasm.ClassStdlib
+	case fn.IsLibrary || hasAnyPrefix(name, libcPrefixes) ||
+		strings.HasPrefix(name, "_ZNSt") || strings.HasPrefix(name, "_ZSt") || strings.HasPrefix(name, "_ZN9__gnu_cxx") ||
+		strings.HasPrefix(name, "std::") || strings.HasPrefix(name, "__gnu_cxx::"):
+		return disasm.ClassStdlib
+	}
+	if !strings.HasPrefix(name, "sub_") {
+		if _, ok := prototypes.Default().Lookup(name); ok {
+			return disasm.ClassStdlib
+		}
+	}
+
+	// Stubs the linker put in the PLT and the .init/.fini sections
+	if sec := sectionAt(b, fn.StartAddr); sec != nil {
+		switch sec.Name {
+		case ".init", ".fini", ".plt", ".plt.got", ".plt.sec":
+			return disasm.ClassRuntime
+		}
+	}
+	return disasm.ClassUser
+}
+
+// reservedName reports whether a C identifier is reserved for the compiler
+// and the standard library
+func reservedName(name string) bool {
+	if strings.HasPrefix(name, "_Z") {
+		return false // Mangled C++ names, checked by namespace
+	}
+	return strings.HasPrefix(name, "__") || len(name) > 1 && name[0] == '_' && name[1] >= 'A' && name[1] <= 'Z'
+}
+
+// classifyGoFunction classifies a Go function by its package path: the
+// runtime and internal packages, other standard packages, packages of the
+// program's module and packages of other modules. Functions without a
+// package are the runtime's assembly.
+func classifyGoFunction(name, module string) disasm.FunctionClass {
+	if strings.HasPrefix(name, "sub_") {
+		return disasm.ClassUser
+	}
+	if hasAnyPrefix(name, goRuntimePrefixes) {
+		return disasm.ClassRuntime
+	}
+	pkg := GoPackage(name)
+	switch {
+	case pkg == "":
+		return disasm.ClassRuntime
+	case pkg == "main" || module != "" && (pkg == module || strings.HasPrefix(pkg, module+"/")):
+		return disasm.ClassUser
+	case pkg == "runtime" || strings.HasPrefix(pkg, "runtime/") || strings.HasPrefix(pkg, "internal/"):
+		return disasm.ClassRuntime
+	case !strings.Contains(strings.SplitN(pkg, "/", 2)[0], "."):
+		// Standard packages, vendored golang.org/x ones included, have no
+		// domain in their path
+		return disasm.ClassStdlib
+	}
+	return disasm.ClassThirdParty
+}
+
+// GoPackage returns the package path of a Go symbol, such as net/http for
+// net/http.(*Client).Do, or "" if it has none
+func GoPackage(name string) string {
+	// Type arguments of generic functions may hold other package paths
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot <= 0 {
+		return ""
+	}
+	return name[:slash+1+dot]
+}
+
+// goMainModule returns the path of the main module recorded in the Go
+// build info, or "" if there is none
+func goMainModule(b *parser.Binary) string {
+	data := goBuildInfo(b)
+	if data == nil || data[15]&0x2 == 0 {
+		return ""
+	}
+	// The version string, then the module information
+	length, n := binary.Uvarint(data[32:])
+	off := 32 + n + int(length)
+	if n <= 0 || off > len(data) {
+		return ""
+	}
+	length, n = binary.Uvarint(data[off:])
+	if n <= 0 || off+n+int(length) > len(data) {
+		return ""
+	}
+	if m := goModulePattern.FindSubmatch(data[off+n : off+n+int(length)]); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// startupFunctions returns the functions the startup code reveals: the
+// entry point, named as its format's linkers name it, and the main
+// function it passes to __libc_start_main, named main, each unless a
+// symbol names it. Stripped binaries have neither symbols nor, with
+// optimization, prologues telling where they start.
+func (a *Analysis) startupFunctions(symbols map[uint64]bool) map[uint64]string {
+	entries := make(map[uint64]string)
+	if entry := a.Binary.EntryPoint; entry != 0 {
+		entries[entry] = ""
+		if !symbols[entry] {
+			entries[entry] = entryName(a.Binary.Format)
+		}
+	}
+	if main := a.libcStartMainArg(); main != 0 && !symbols[main] {
+		entries[main] = "main"
+	}
+	return entries
+}
+
+// entryName returns the name linkers of a format give the entry point
+func entryName(format string) string {
+	switch format {
+	case "ELF":
+		return "_start"
+	case "Mach-O":
+		return "start"
+	}
+	return "entry"
+}
+
+// nameMain records the address of the program's main function and names
+// it main, or main.main in Go, if it has no symbol. An entry point
+// calling __libc_start_main is the C runtime's.
+func (a *Analysis) nameMain() {
+	a.Main = a.findMain()
+	startup := a.libcStartMainArg() != 0
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		if startup && fn.StartAddr == a.Binary.EntryPoint {
+			fn.Class = disasm.ClassRuntime
+		}
+		if a.Main != 0 && fn.StartAddr == a.Main {
+			if strings.HasPrefix(fn.Name, "sub_") {
+				fn.Name = "main"
+				if a.isGoBinary() {
+					fn.Name = "main.main"
+				}
+			}
+			fn.Class = disasm.ClassUser
+		}
+	}
+}
+
+// classifyStartupCode classifies as runtime the functions that only the
+// startup and teardown code reaches: those reachable from the entry point
+// or the .init_array and .fini_array constructors and destructors, but
+// not from main. Stripped binaries name neither, so their names cannot
+// tell.
+func (a *Analysis) classifyStartupCode() {
+	if a.Main == 0 || a.isGoBinary() {
+		return
+	}
+	g := callgraph.Build(a.Binary, a.Functions, a.Main)
+	main := g.Node(a.Main)
+	if main == nil {
+		return
+	}
+
+	fromMain := reachableFrom([]*callgraph.Node{main}, nil)
+	var roots []*callgraph.Node
+	if g.Entry != nil {
+		roots = append(roots, g.Entry)
+	}
+	for _, addr := range a.initFiniFunctions() {
+		if node := g.Node(addr); node != nil {
+			roots = append(roots, node)
+		}
+	}
+	fromStartup := reachableFrom(roots, main)
+
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		node := g.Node(fn.StartAddr)
+		if fn.Class == disasm.ClassUser && fromStartup[node] && !fromMain[node] {
+			fn.Class = disasm.ClassRuntime
+		}
+	}
+}
+
+// reachableFrom returns the functions reachable from roots through direct
+// calls and tail calls, without passing through stop
+func reachableFrom(roots []*callgraph.Node, stop *callgraph.Node) map[*callgraph.Node]bool {
+	seen := make(map[*callgraph.Node]bool)
+	work := roots
+	for len(work) > 0 {
+		n := work[len(work)-1]
+		work = work[:len(work)-1]
+		if n == stop || seen[n] || n.IsImport {
+			continue
+		}
+		seen[n] = true
+		work = append(work, n.Callees...)
+	}
+	return seen
+}
+
+// classifyStaticLibraryCode classifies the C library a static link pulled
+// into the program. Its internal functions have no prefix or prototype
+// telling them apart, and main reaches them through the library functions
+// it calls. The program's functions are main and those it reaches through
+// the program's own functions, by calling them or taking their address,
+// and the static functions of the source files these come from, as the
+// STT_FILE symbols tell. The rest are the library's.
+func (a *Analysis) classifyStaticLibraryCode() {
+	b := a.Binary
+	if a.Main == 0 || a.isGoBinary() || b.Format != "ELF" || b.Kind != parser.KindExecutable || len(b.Libraries) > 0 {
+		return
+	}
+
+	starts := make(map[uint64]*disasm.Function, len(a.Functions))
+	for i := range a.Functions {
+		starts[a.Functions[i].StartAddr] = &a.Functions[i]
+	}
+	files := sourceFiles(b)
+	inFile := make(map[string][]uint64)
+	for addr, file := range files {
+		inFile[file] = append(inFile[file], addr)
+	}
+	g := callgraph.Build(b, a.Functions, a.Main)
+
+	program := make(map[uint64]bool)
+	work := []uint64{a.Main}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		fn := starts[addr]
+		if program[addr] || fn == nil || fn.Class != disasm.ClassUser {
+			continue
+		}
+		program[addr] = true
+		if node := g.Node(addr); node != nil {
+			for _, callee := range node.Callees {
+				if !callee.IsImport {
+					work = append(work, callee.Address)
+				}
+			}
+		}
+		work = append(work, functionReferences(fn)...)
+		if file, ok := files[addr]; ok {
+			work = append(work, inFile[file]...)
+		}
+	}
+
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		if fn.Class == disasm.ClassUser && !program[fn.StartAddr] {
+			fn.Class = disasm.ClassStdlib
+		}
+	}
+}
+
+// sourceFiles returns the source file or object each static function of
+// an ELF binary comes from: its local symbols follow the STT_FILE symbol
+// naming their file in the symbol table
+func sourceFiles(b *parser.Binary) map[uint64]string {
+	files := make(map[uint64]string)
+	file := ""
+	for _, sym := range b.Symbols {
+		info, ok := strings.CutPrefix(sym.Type, "ELF_SYM_")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(info)
+		if err != nil {
+			continue
+		}
+		switch {
+		case elf.ST_TYPE(uint8(n)) == elf.STT_FILE:
+			file = sym.Name
+		case elf.ST_BIND(uint8(n)) != elf.STB_LOCAL:
+			// Global symbols follow the local ones of every file
+			file = ""
+		case elf.ST_TYPE(uint8(n)) == elf.STT_FUNC && file != "":
+			files[sym.Address] = file
+		}
+	}
+	return files
+}
+
+// functionReferences returns the addresses a function loads as constants,
+// among them those of the functions it passes as callbacks
+func functionReferences(fn *disasm.Function) []uint64 {
+	var refs []uint64
+	for _, inst := range fn.Instructions {
+		switch inst.Mnemonic {
+		case "lea":
+			if inst.MemoryBase == "rip" {
+				refs = append(refs, uint64(inst.MemoryDisp))
+			}
+		case "mov", "push":
+			operands := strings.Split(inst.Operands, ", ")
+			if v, err := strconv.ParseUint(operands[len(operands)-1], 0, 64); err == nil {
+				refs = append(refs, v)
+			}
+		}
+	}
+	return refs
+}
+
+// classifyColdParts gives the parts GCC splits off a function, such as
+// foo.cold, the class of the function itself
+func (a *Analysis) classifyColdParts() {
+	classes := make(map[string]disasm.FunctionClass, len(a.Functions))
+	for _, fn := range a.Functions {
+		classes[fn.Name] = fn.Class
+	}
+	for i := range a.Functions {
+		fn := &a.Functions[i]
+		parent, _, ok := strings.Cut(fn.Name, ".cold")
+		if class, found := classes[parent]; ok && found {
+			fn.Class = class
+		}
+	}
+}
+
+// initFiniFunctions returns the functions the ELF .init_array and
+// .fini_array sections list. Position-independent binaries may leave the
+// slots zero and fill them in through relative relocations.
+func (a *Analysis) initFiniFunctions() []uint64 {
+	ptrSize := a.Binary.PointerSize()
+	addends := make(map[uint64]uint64)
+	for _, r := range a.Binary.Relocations {
+		if r.Symbol == "" && r.Section == "" {
+			addends[r.Address] = uint64(r.Addend)
+		}
+	}
+
+	var funcs []uint64
+	for _, sec := range a.Binary.Sections {
+		if sec.Name != ".init_array" && sec.Name != ".fini_array" {
+			continue
+		}
+		data := a.Binary.BytesAt(sec.Address, int(sec.Size))
+		for off := 0; off+ptrSize <= len(data); off += ptrSize {
+			var addr uint64
+			if ptrSize == 4 {
+				addr = uint64(binary.LittleEndian.Uint32(data[off:]))
+			} else {
+				addr = binary.LittleEndian.Uint64(data[off:])
+			}
+			if addr == 0 {
+				addr = addends[sec.Address+uint64(off)]
+			}
+			if addr != 0 && addr != ^uint64(0) {
+				funcs = append(funcs, addr)
+			}
+		}
+	}
+	return funcs
+}
+
+// findMain locates the program's main function: main.main for Go, else
+// the function runtime.main calls through its funcval; main for C, else
+// the first argument the entry point passes to __libc_start_main, which
+// also finds main in stripped binaries. Returns 0 if there is none.
+func (a *Analysis) findMain() uint64 {
+	starts := make(map[uint64]*disasm.Function, len(a.Functions))
+	for i := range a.Functions {
+		starts[a.Functions[i].StartAddr] = &a.Functions[i]
+	}
+
+	for _, name := range []string{"main.main", "main", "_main", "wmain", "WinMain", "wWinMain"} {
+		for _, fn := range a.Functions {
+			if fn.Name == name {
+				return fn.StartAddr
+			}
+		}
+	}
+
+	for _, fn := range a.Functions {
+		if fn.Name != "runtime.main" {
+			continue
+		}
+		// lea of main.main·f, the funcval holding main.main
+		for _, inst := range fn.Instructions {
+			if inst.Mnemonic != "lea" || inst.MemoryBase != "rip" {
+				continue
+			}
+			ptr := a.Binary.BytesAt(uint64(inst.MemoryDisp), 8)
+			if len(ptr) < 8 {
+				continue
+			}
+			target := binary.LittleEndian.Uint64(ptr)
+			if callee := starts[target]; callee != nil && callee.Class == disasm.ClassUser {
+				return target
+			}
+		}
+	}
+
+	return a.libcStartMainArg()
+}
+
+// maxStartupInstructions bounds the startup code decoded from the entry
+// point
+const maxStartupInstructions = 64
+
+// libcStartMainArg decodes the entry point up to its call of
+// __libc_start_main, an import, or in static binaries the call it never
+// returns from, and returns the first argument of the call
+func (a *Analysis) libcStartMainArg() uint64 {
+	arch, ok := disasm.LookupArch(a.Binary.Arch)
+	if !ok || arch.Decode == nil || a.Binary.EntryPoint == 0 {
+		return 0
+	}
+	start := disasm.Function{StartAddr: a.Binary.EntryPoint, EndAddr: a.Binary.EntryPoint}
+	for len(start.Instructions) < maxStartupInstructions {
+		inst, size := arch.Decode(a.Binary.BytesAt(start.EndAddr, 16), start.EndAddr)
+		if size == 0 {
+			break
+		}
+		start.Instructions = append(start.Instructions, inst)
+		start.EndAddr += uint64(size)
+		if n := len(start.Instructions); inst.Category == disasm.CatReturn || n > 1 && start.Instructions[n-2].Category == disasm.CatCall {
+			break
+		}
+	}
+
+	imports := make(map[uint64]string)
+	for _, e := range callgraph.Build(a.Binary, []disasm.Function{start}, 0).Edges {
+		if e.To.IsImport {
+			imports[e.Site] = e.To.Name
+		}
+	}
+
+	abi := disasm.ABIFor(a.Binary.Format, a.Binary.Arch)
+	var arg, pushed uint64
+	insts := start.Instructions
+	for i, inst := range insts {
+		if inst.Category == disasm.CatCall {
+			name := imports[inst.Address]
+			noReturn := i+1 < len(insts) && insts[i+1].Mnemonic == "hlt"
+			if !strings.HasPrefix(name, "__libc_start_main") && (name != "" || !noReturn) {
+				return 0
+			}
+			if len(abi.IntArgs) == 0 {
+				return pushed // 32-bit: main is pushed last
+			}
+			return arg
+		}
+
+		dest, src, _ := strings.Cut(inst.Operands, ", ")
+		switch inst.Mnemonic {
+		case "push":
+			if v, err := strconv.ParseUint(inst.Operands, 0, 64); err == nil {
+				pushed = v
+			}
+		case "lea":
+			if len(abi.IntArgs) > 0 && isRegister(dest, abi.IntArgs[0]) && inst.MemoryBase == "rip" {
+				arg = uint64(inst.MemoryDisp)
+			}
+		case "mov":
+			if len(abi.IntArgs) > 0 && isRegister(dest, abi.IntArgs[0]) {
+				arg, _ = strconv.ParseUint(src, 0, 64)
+			}
+		}
+	}
+	return 0
+}
+
+// isRegister reports whether reg is full or one of its parts
+func isRegister(reg, full string) bool {
+	f, ok := disasm.FullRegister(reg)
+	return ok && f == full
+}
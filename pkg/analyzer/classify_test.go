@@ -0,0 +1,90 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+)
+
+// In a stripped binary, the CRT functions that only the entry point and
+// the .init_array and .fini_array entries reach are the runtime's, and
+// main and the functions it calls are the program's
+func TestClassifyStrippedStartupCode(t *testing.T) {
+	binary, err := parser.ParseExecutable("testdata/stripped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binary.Close()
+	analysis, err := Analyze(binary, Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := 0
+	for _, fn := range analysis.Functions {
+		if fn.Class != disasm.ClassUser {
+			continue
+		}
+		user++
+		if fn.StartAddr != analysis.Main && !calledBy(analysis, analysis.Main, fn.StartAddr) {
+			t.Errorf("%s @ 0x%x is classified as user code but main does not call it", fn.Name, fn.StartAddr)
+		}
+	}
+	// main, count, fact and search
+	if user != 4 {
+		t.Errorf("got %d user functions, want 4", user)
+	}
+}
+
+// calledBy reports whether the function at caller calls callee directly
+func calledBy(analysis *Analysis, caller, callee uint64) bool {
+	for _, fn := range analysis.Functions {
+		if fn.StartAddr != caller {
+			continue
+		}
+		for _, target := range fn.Calls {
+			if target == callee {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// In a statically linked binary, the C library's internals are not the
+// program's though main reaches them, and the parts GCC splits off a
+// function have its class
+func TestClassifyStaticLibraryCode(t *testing.T) {
+	binary, err := parser.ParseExecutable("testdata/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binary.Close()
+	analysis, err := Analyze(binary, Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"main": true, "checksum": true, "compare": true, "fail.constprop.0": true}
+	classes := make(map[string]disasm.FunctionClass)
+	for _, fn := range analysis.Functions {
+		classes[fn.Name] = fn.Class
+	}
+	for _, fn := range analysis.Functions {
+		if (fn.Class == disasm.ClassUser) != want[fn.Name] {
+			t.Errorf("%s @ 0x%x is classified as %s", fn.Name, fn.StartAddr, fn.Class)
+		}
+		if parent, _, ok := strings.Cut(fn.Name, ".cold"); ok {
+			if class, found := classes[parent]; found && fn.Class != class {
+				t.Errorf("%s is classified as %s, %s as %s", fn.Name, fn.Class, parent, class)
+			}
+		}
+	}
+	for name := range want {
+		if _, ok := classes[name]; !ok {
+			t.Errorf("no function %s", name)
+		}
+	}
+}
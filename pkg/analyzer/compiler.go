@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+var goVersionPattern = regexp.MustCompile(`go1\.[0-9]+(\.[0-9]+)?`)
+
+// DetectCompiler identifies the toolchain that produced a binary from
+// build metadata: the Go build info blob, the ELF .comment section, the
+// PE Rich header and well-known runtime symbols. Returns "unknown" if no
+// evidence was found.
+func DetectCompiler(b *parser.Binary) string {
+	if version := goBuildVersion(b); version != "" {
+		return "Go " + strings.TrimPrefix(version, "go") // Releases are recorded as go1.x
+	}
+
+	var found []string
+	for _, sec := range b.Sections {
+		if sec.Name != ".comment" {
+			continue
+		}
+		// NUL-separated producer strings, one per linked object toolchain
+		for _, part := range bytes.Split(sec.Data, []byte{0}) {
+			s := strings.TrimSpace(string(part))
+			if s != "" && !containsString(found, s) {
+				found = append(found, s)
+			}
+		}
+	}
+	if len(found) > 0 {
+		return strings.Join(found, "; ")
+	}
+
+	for _, sym := range b.Symbols {
+		switch {
+		case strings.HasPrefix(sym.Name, "rust_begin_unwind") || strings.Contains(sym.Name, "rust_panic"):
+			return "Rust (rustc)"
+		case strings.HasPrefix(sym.Name, "__mingw_") || strings.HasPrefix(sym.Name, "_mingw_"):
+			return "GCC (MinGW-w64)"
+		}
+	}
+
+	if b.Format == "PE" && hasRichHeader(b.RawData) {
+		return "Microsoft Visual C++ (Rich header)"
+	}
+
+	return "unknown"
+}
+
+// goBuildVersion returns the Go release recorded in .go.buildinfo, or by
+// the runtime.buildVersion string as a fallback
+func goBuildVersion(b *parser.Binary) string {
+	// Go 1.18+: flags bit 1 means the version string is inlined after
+	// the 32-byte header as a varint-prefixed string
+	if data := goBuildInfo(b); data != nil && data[15]&0x2 != 0 {
+		length, n := binary.Uvarint(data[32:])
+		if n > 0 && 32+n+int(length) <= len(data) {
+			return string(data[32+n : 32+n+int(length)])
+		}
+	}
+
+	for _, sec := range b.Sections {
+		name := strings.ToLower(sec.Name)
+		if !strings.Contains(name, "rodata") && !strings.Contains(name, "rdata") && !strings.Contains(name, "data") {
+			continue
+		}
+		if bytes.Contains(sec.Data, []byte("runtime.")) {
+			if m := goVersionPattern.Find(sec.Data); m != nil {
+				return string(m)
+			}
+		}
+	}
+
+	return ""
+}
+
+// goBuildInfo returns the Go build info blob and the data after it, nil if
+// the binary has none
+func goBuildInfo(b *parser.Binary) []byte {
+	magic := []byte("\xff Go buildinf:")
+	for _, sec := range b.Sections {
+		idx := bytes.Index(sec.Data, magic)
+		if idx >= 0 && len(sec.Data)-idx >= 32 {
+			return sec.Data[idx:]
+		}
+	}
+	return nil
+}
+
+// hasRichHeader returns true if the DOS stub carries the MSVC linker's
+// "Rich" signature
+func hasRichHeader(data []byte) bool {
+	if len(data) < 0x40 {
+		return false
+	}
+	peOff := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if peOff <= 0x40 || peOff > len(data) {
+		return false
+	}
+	return bytes.Contains(data[0x40:peOff], []byte("Rich"))
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
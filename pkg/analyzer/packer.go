@@ -0,0 +1,268 @@
+package analyzer
+
+import (
+	"debug/elf"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+// Packing reports signs that a binary is packed or obfuscated: the packer
+// that produced it, the entropy of its sections and layout anomalies
+type Packing struct {
+	Packer    string // Packer identified, with its version if recorded; "" if none
+	Unpacked  bool   // The binary was unpacked before analysis
+	Sections  []SectionEntropy
+	Anomalies []string
+}
+
+// SectionEntropy is the Shannon entropy of the contents of a section, in
+// bits per byte: 0 for constant data, 8 for random data. Compressed and
+// encrypted data score above 7, code around 6.
+type SectionEntropy struct {
+	Name    string
+	Entropy float64
+}
+
+// Suspicious reports whether the binary looks packed or obfuscated
+func (p *Packing) Suspicious() bool {
+	return p != nil && (p.Packer != "" || len(p.Anomalies) > 0)
+}
+
+// highEntropy is the entropy above which a section is reported as
+// compressed or encrypted, and minEntropySize the size below which
+// entropy says too little to report
+const (
+	highEntropy    = 7.2
+	minEntropySize = 512
+)
+
+// Entropy returns the Shannon entropy of data in bits per byte
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	h := 0.0
+	n := float64(len(data))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// packerSignature identifies a packer by the names of the sections it adds
+// or by the first bytes of its stub at the entry point, in hex with ??
+// for any byte
+type packerSignature struct {
+	name     string
+	sections []string
+	entry    []string
+}
+
+var packerSignatures = []packerSignature{
+	{name: "UPX", sections: []string{"UPX0", "UPX1", "UPX2", ".UPX0", ".UPX1"}, entry: []string{
+		"60 be ?? ?? ?? ?? 8d be ?? ?? ?? ??",                   // pusha; mov esi, UPX1; lea edi, [esi-UPX0]
+		"53 56 57 55 48 8d 35 ?? ?? ?? ?? 48 8d be ?? ?? ?? ??", // push rbx..rbp; lea rsi, [UPX1]; lea rdi, [rsi-UPX0]
+	}},
+	{name: "ASPack", sections: []string{".aspack", ".adata"}, entry: []string{"60 e8 03 00 00 00 e9 eb"}},
+	{name: "MPRESS", sections: []string{".MPRESS1", ".MPRESS2"}, entry: []string{"60 e8 00 00 00 00 58 05"}},
+	{name: "Themida/WinLicense", sections: []string{".themida", ".winlice"}, entry: []string{"b8 ?? ?? ?? ?? 60 0b c0 74"}},
+}
+
+var upxVersionPattern = regexp.MustCompile(`\$Id: UPX ([0-9]+\.[0-9]+)`)
+
+// DetectPacking looks for the signatures of known packers (UPX, ASPack,
+// MPRESS, Themida), measures the entropy of every section and reports
+// anomalies: sections both writable and executable, an entry point
+// outside the code section, high entropy of the code and data loaded and
+// tiny import tables.
+func DetectPacking(b *parser.Binary) *Packing {
+	p := &Packing{Packer: b.Packer, Unpacked: b.Packer != ""}
+	if p.Packer == "" {
+		p.Packer = detectPacker(b)
+	}
+
+	for _, sec := range b.Sections {
+		e := Entropy(sec.Data)
+		p.Sections = append(p.Sections, SectionEntropy{Name: sec.Name, Entropy: e})
+		if e > highEntropy && len(sec.Data) >= minEntropySize && isLoadedProgram(b, sec) {
+			p.Anomalies = append(p.Anomalies, fmt.Sprintf("section %s has high entropy (%.2f bits/byte): compressed or encrypted", sectionName(sec), e))
+		}
+	}
+
+	// Images without headers, modules and objects have no layout to judge.
+	// Unpacked ones have the packer's: the program fills the writable and
+	// executable section the stub decompressed it into, and ELF files come
+	// back without their section headers.
+	if p.Unpacked || b.Kind == parser.KindObject || (b.Format != "PE" && b.Format != "ELF" && b.Format != "Mach-O") {
+		return p
+	}
+
+	for _, sec := range b.Sections {
+		if isWritable(b, sec) && isExecutable(b, sec) {
+			p.Anomalies = append(p.Anomalies, fmt.Sprintf("section %s is writable and executable", sectionName(sec)))
+		}
+	}
+
+	if b.EntryPoint != 0 {
+		if sec := sectionAt(b, b.EntryPoint); sec == nil {
+			p.Anomalies = append(p.Anomalies, fmt.Sprintf("entry point 0x%x lies outside every section", b.EntryPoint))
+		} else if !isCodeSectionName(sec.Name) {
+			p.Anomalies = append(p.Anomalies, fmt.Sprintf("entry point 0x%x lies in %s, not in the code section", b.EntryPoint, sectionName(*sec)))
+		}
+	}
+
+	// Packed images import the few functions their stub needs and resolve
+	// the program's imports themselves
+	if b.Format == "PE" && b.Kind == parser.KindExecutable && len(b.ImportTable) > 0 && len(b.ImportTable) < 5 {
+		p.Anomalies = append(p.Anomalies, fmt.Sprintf("only %d imported functions", len(b.ImportTable)))
+	}
+	if b.Format == "ELF" && len(b.Sections) > 0 && strings.HasPrefix(b.Sections[0].Name, "LOAD") {
+		p.Anomalies = append(p.Anomalies, "no section headers")
+	}
+
+	return p
+}
+
+// detectPacker returns the packer whose signature the binary bears.
+// Signatures are structural, so that programs merely containing a
+// packer's strings, like this one, are not mistaken for packed ones.
+func detectPacker(b *parser.Binary) string {
+	// Packed ELF files are told by the UPX header after the program headers
+	if b.Format == "ELF" && parser.IsUPXELF(b.RawData) {
+		return upxVersion(b.RawData)
+	}
+
+	entry := b.BytesAt(b.EntryPoint, 32)
+	for _, sig := range packerSignatures {
+		found := false
+		for _, pattern := range sig.entry {
+			found = found || matchBytes(entry, pattern)
+		}
+		for _, sec := range b.Sections {
+			for _, name := range sig.sections {
+				found = found || sec.Name == name
+			}
+		}
+		if found {
+			if sig.name == "UPX" {
+				return upxVersion(b.RawData)
+			}
+			return sig.name
+		}
+	}
+	return ""
+}
+
+// upxVersion names UPX with the version its stub records, if any
+func upxVersion(data []byte) string {
+	if m := upxVersionPattern.FindSubmatch(data); m != nil {
+		return "UPX " + string(m[1])
+	}
+	return "UPX"
+}
+
+// matchBytes reports whether data starts with a hex pattern
+func matchBytes(data []byte, pattern string) bool {
+	for i, h := range strings.Fields(pattern) {
+		if i >= len(data) {
+			return false
+		}
+		if h == "??" {
+			continue
+		}
+		b, err := strconv.ParseUint(h, 16, 8)
+		if err != nil || data[i] != byte(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// sectionName returns the name of a section for messages; PE packers
+// often leave them blank
+func sectionName(sec parser.Section) string {
+	if strings.TrimSpace(sec.Name) == "" {
+		return fmt.Sprintf("at 0x%x", sec.Address)
+	}
+	return sec.Name
+}
+
+func sectionAt(b *parser.Binary, addr uint64) *parser.Section {
+	for i := range b.Sections {
+		sec := &b.Sections[i]
+		if sec.Address != 0 && addr >= sec.Address && addr < sec.Address+sec.Size {
+			return sec
+		}
+	}
+	return nil
+}
+
+// isCodeSectionName reports whether a section is one compilers put code in
+func isCodeSectionName(name string) bool {
+	switch name {
+	case ".text", "__text", "CODE", ".init", ".plt", ".plt.got", ".plt.sec":
+		return true
+	}
+	return strings.HasPrefix(name, ".text")
+}
+
+// isLoadedProgram reports whether a section holds code or data the
+// program loads, whose entropy tells whether it is packed. Debug
+// information, often compressed, and Go's pointer-free data, often
+// embedded compressed files, are left out.
+func isLoadedProgram(b *parser.Binary, sec parser.Section) bool {
+	switch b.Format {
+	case "PE":
+		if sec.Flags&0x02000000 != 0 { // IMAGE_SCN_MEM_DISCARDABLE
+			return false
+		}
+	case "ELF":
+		if sec.Flags&uint32(elf.SHF_ALLOC) == 0 {
+			return false
+		}
+	case "Mach-O":
+		if sec.Flags&0x02000000 != 0 { // S_ATTR_DEBUG
+			return false
+		}
+	}
+	for _, prefix := range []string{".debug", ".zdebug", "__debug", "__zdebug", "__DWARF"} {
+		if strings.HasPrefix(sec.Name, prefix) {
+			return false
+		}
+	}
+	return sec.Name != ".noptrdata" && sec.Name != "__noptrdata"
+}
+
+// isWritable and isExecutable read PE section characteristics and ELF
+// section flags
+func isWritable(b *parser.Binary, sec parser.Section) bool {
+	switch b.Format {
+	case "PE":
+		return sec.Flags&0x80000000 != 0 // IMAGE_SCN_MEM_WRITE
+	case "ELF":
+		return sec.Flags&uint32(elf.SHF_WRITE) != 0
+	}
+	return false // Mach-O records write permission per segment only
+}
+
+func isExecutable(b *parser.Binary, sec parser.Section) bool {
+	switch b.Format {
+	case "PE":
+		return sec.Flags&0x20000000 != 0 // IMAGE_SCN_MEM_EXECUTE
+	case "ELF":
+		return sec.Flags&uint32(elf.SHF_EXECINSTR) != 0
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package analyzer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"expeer/pkg/parser"
+)
+
+// The layout of an unpacked image is the packer's: the report names the
+// packer, but not the writable and executable UPX0 the program was
+// decompressed into, nor the entry point in it
+func TestDetectPackingUnpacked(t *testing.T) {
+	for _, name := range []string{"hello32.nrv.exe", "hello64.lzma.exe", "hello.nrv"} {
+		t.Run(name, func(t *testing.T) {
+			packed, err := parser.ParseExecutable(filepath.Join("..", "parser", "testdata", "upx", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := DetectPacking(packed); !strings.HasPrefix(p.Packer, "UPX") || p.Unpacked {
+				t.Errorf("packed file: packer %q, unpacked %v", p.Packer, p.Unpacked)
+			}
+			u, err := parser.UnpackUPX(packed)
+			if err != nil {
+				packed.Close()
+				t.Fatal(err)
+			}
+			defer u.Close()
+
+			p := DetectPacking(u)
+			if p.Packer != "UPX" || !p.Unpacked {
+				t.Errorf("unpacked file: packer %q, unpacked %v", p.Packer, p.Unpacked)
+			}
+			for _, anomaly := range p.Anomalies {
+				t.Errorf("unpacked file: anomaly %q", anomaly)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package analyzer
+
+import (
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+// StringLiteral is a printable string found in a data section
+type StringLiteral struct {
+	Address uint64
+	Section string
+	Value   string
+}
+
+// FindStrings extracts printable ASCII strings of at least minLen bytes
+// from the data sections of a binary, with their addresses
+func FindStrings(b *parser.Binary, minLen int) []StringLiteral {
+	var result []StringLiteral
+
+	for _, section := range b.Sections {
+		if !isStringSection(section.Name) {
+			continue
+		}
+
+		start := -1
+		for i := 0; i <= len(section.Data); i++ {
+			printable := i < len(section.Data) && section.Data[i] >= 32 && section.Data[i] <= 126
+			if printable {
+				if start < 0 {
+					start = i
+				}
+				continue
+			}
+			if start >= 0 && i-start >= minLen {
+				result = append(result, StringLiteral{
+					Address: section.Address + uint64(start),
+					Section: section.Name,
+					Value:   string(section.Data[start:i]),
+				})
+			}
+			start = -1
+		}
+	}
+
+	return result
+}
+
+// isStringSection returns true for sections that may hold string literals
+func isStringSection(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.Contains(lower, "data") ||
+		strings.Contains(lower, "rodata") ||
+		strings.Contains(lower, "rdata") ||
+		strings.Contains(lower, "cstring")
+}
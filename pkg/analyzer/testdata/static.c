@@ -0,0 +1,38 @@
+/* A statically linked program, the fixture of the classification tests:
+ *
+ *   gcc -O2 -static -o static static.c
+ */
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int compare(const void *a, const void *b) {
+	return *(const int *)a - *(const int *)b;
+}
+
+__attribute__((noinline)) static void fail(const char *msg) {
+	fprintf(stderr, "%s\n", msg);
+	exit(1);
+}
+
+__attribute__((noinline)) int checksum(const char *s) {
+	int sum = 0;
+	for (int i = 0; s[i]; i++) {
+		if (__builtin_expect(s[i] < 0, 0)) {
+			fail("not ASCII");
+		}
+		sum = sum * 31 + s[i];
+	}
+	return sum;
+}
+
+int main(int argc, char **argv) {
+	int *values = malloc(argc * sizeof(int));
+	for (int i = 0; i < argc; i++)
+		values[i] = checksum(argv[i]);
+	qsort(values, argc, sizeof(int), compare);
+	for (int i = 0; i < argc; i++)
+		printf("%d\n", values[i]);
+	free(values);
+	return 0;
+}
@@ -0,0 +1,35 @@
+/* A stripped program, the fixture of the classification tests:
+ *
+ *   gcc -O1 -s -o stripped stripped.c
+ */
+#include <stdio.h>
+#include <string.h>
+int count(const char *s, int n) {
+	int c = 0;
+	for (int j = 0; j < n; j++) {
+		for (int i = 0; s[i]; i++) {
+			if (s[i] == 'a')
+				c++;
+			else if (s[i] == 'b')
+				c += 2;
+		}
+	}
+	return c;
+}
+long fact(long n) {
+	long r = 1;
+	while (n > 1) r *= n--;
+	return r;
+}
+int search(int *a, int n, int k) {
+	for (int i = 0; i < n; i++)
+		if (a[i] == k) return i;
+	for (int i = 0; i < n; i++)
+		if (a[i] == -k) return -i;
+	return -1;
+}
+int main(int argc, char **argv) {
+	int a[5] = {1, 20, 3, 40, 5};
+	printf("%d %ld %d\n", count(argv[0], argc), fact(argc), search(a, 5, argc));
+	return 0;
+}
@@ -0,0 +1,269 @@
+package cache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cfg"
+	"expeer/pkg/debuginfo"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+	"expeer/pkg/project"
+	"expeer/pkg/rtti"
+)
+
+// formatVersion changes whenever the layout of an entry changes
+const formatVersion = 5
+
+// staleTemp is the age after which a temporary file is taken as left
+// behind by a writer that died, rather than being written by another
+// expeer at the same time
+const staleTemp = time.Hour
+
+// Cache stores analysis results on disk, one entry per file content. An
+// entry is only used by the expeer build that wrote it, with the same
+// annotations, the same symbol path and an unchanged separate debug file:
+// any other build, other annotations, another symbol path, a modified
+// debug file or a modified binary miss and overwrite it.
+type Cache struct {
+	Dir     string
+	Version string // Build of expeer, part of the entry key
+}
+
+// entry is the on-disk form of an Analysis. The binary itself is not
+// stored; it is mapped again on load and its sections are sliced lazily.
+type entry struct {
+	Format      int
+	Version     string
+	Hash        string
+	Annotations string   // Digest of the project the analysis honoured
+	SymbolPath  []string // Directories searched for separate debug files
+	Debug       string   // Digest of the separate debug file the analysis used
+
+	DetectedLanguage string
+	Confidence       float64
+	Compiler         string
+	Strings          []string
+	GoIndicators     []string
+	CIndicators      []string
+	Functions        []disasm.Function // Disassembly, boundaries, call xrefs and classes
+	Main             uint64
+	CFGs             map[uint64]cfg.Shape // Control flow graphs by function start
+	DebugInfo        *debuginfo.Info      // Debug functions, variables, types and lines
+	Classes          []*rtti.Class        // Recovered C++ types
+	PtrSize          int
+}
+
+// Open returns the cache in the user cache directory (or $EXPEER_CACHE_DIR
+// if set), creating it if needed
+func Open(version string) (*Cache, error) {
+	dir := os.Getenv("EXPEER_CACHE_DIR")
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "expeer")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: dir, Version: version}, nil
+}
+
+// Hash returns the hex SHA-256 of a binary's file contents. For images
+// whose architecture, and for raw images load address, the user chose,
+// these choices are hashed too, as is whether the binary was unpacked.
+func Hash(b *parser.Binary) string {
+	h := sha256.New()
+	h.Write(b.RawData)
+	switch b.Format {
+	case parser.FormatRaw, parser.FormatIntelHex, parser.FormatSRecord:
+		fmt.Fprintf(h, "\x00%s@%x", b.Arch, b.Sections[0].Address)
+	}
+	if b.Packer != "" {
+		fmt.Fprintf(h, "\x00unpacked from %s", b.Packer)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(hash string) string {
+	return filepath.Join(c.Dir, hash+".gob.gz")
+}
+
+// Load returns the cached analysis of a binary made with the annotations
+// of proj (which may be nil) and the debug information found with
+// symbolPath. The second result is false on a miss, including entries
+// written by another expeer build, with other annotations, another symbol
+// path or a since modified debug file, and unreadable entries.
+func (c *Cache) Load(b *parser.Binary, proj *project.Project, symbolPath []string) (*analyzer.Analysis, bool) {
+	hash := Hash(b)
+	f, err := os.Open(c.path(hash))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, false
+	}
+	var e entry
+	if err := gob.NewDecoder(zr).Decode(&e); err != nil {
+		return nil, false
+	}
+	if e.Format != formatVersion || e.Version != c.Version || e.Hash != hash ||
+		e.Annotations != proj.Digest() || strings.Join(e.SymbolPath, "\x00") != strings.Join(symbolPath, "\x00") ||
+		e.Debug != e.DebugInfo.Digest() {
+		return nil, false
+	}
+
+	analysis := &analyzer.Analysis{
+		Binary:           b,
+		DetectedLanguage: e.DetectedLanguage,
+		Confidence:       e.Confidence,
+		Compiler:         e.Compiler,
+		Strings:          e.Strings,
+		GoIndicators:     e.GoIndicators,
+		CIndicators:      e.CIndicators,
+		Functions:        e.Functions,
+		Main:             e.Main,
+		Project:          proj,
+		Packing:          analyzer.DetectPacking(b),
+	}
+	analysis.UseDebugInfo(e.DebugInfo)
+	analysis.CFGs = make(map[uint64]*cfg.ControlFlowGraph, len(e.CFGs))
+	for i := range analysis.Functions {
+		fn := &analysis.Functions[i]
+		shape, ok := e.CFGs[fn.StartAddr]
+		if !ok {
+			continue
+		}
+		graph, err := cfg.FromShape(fn, shape)
+		if err != nil {
+			return nil, false
+		}
+		analysis.CFGs[fn.StartAddr] = graph
+	}
+	if len(e.Classes) > 0 {
+		analysis.RTTI = rtti.NewHierarchy(e.Classes, e.PtrSize)
+	}
+	return analysis, true
+}
+
+// Store writes the analysis of a binary made with the debug information
+// found with symbolPath, replacing any previous entry for the same
+// contents. The entry is written to a temporary file first so concurrent
+// runs never read a partial entry.
+func (c *Cache) Store(analysis *analyzer.Analysis, symbolPath []string) error {
+	e := entry{
+		Format:           formatVersion,
+		Version:          c.Version,
+		Hash:             Hash(analysis.Binary),
+		Annotations:      analysis.Project.Digest(),
+		SymbolPath:       symbolPath,
+		Debug:            analysis.Debug.Digest(),
+		DetectedLanguage: analysis.DetectedLanguage,
+		Confidence:       analysis.Confidence,
+		Compiler:         analysis.Compiler,
+		Strings:          analysis.Strings,
+		GoIndicators:     analysis.GoIndicators,
+		CIndicators:      analysis.CIndicators,
+		Functions:        analysis.Functions,
+		Main:             analysis.Main,
+		CFGs:             make(map[uint64]cfg.Shape, len(analysis.CFGs)),
+		DebugInfo:        analysis.Debug,
+	}
+	for start, graph := range analysis.CFGs {
+		e.CFGs[start] = graph.Shape()
+	}
+	if analysis.RTTI != nil {
+		e.Classes = analysis.RTTI.Classes
+		e.PtrSize = analysis.RTTI.PointerSize()
+	}
+
+	tmp, err := os.CreateTemp(c.Dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	zw := gzip.NewWriter(w)
+	err = gob.NewEncoder(zw).Encode(&e)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), c.path(e.Hash))
+}
+
+// Clear removes every entry and returns how many were removed. Temporary
+// files are only removed once stale: younger ones may be entries another
+// expeer is storing.
+func (c *Cache) Clear() (int, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, de := range entries {
+		switch {
+		case strings.HasSuffix(de.Name(), ".gob.gz"):
+			if err := os.Remove(filepath.Join(c.Dir, de.Name())); err != nil {
+				return removed, err
+			}
+			removed++
+		case strings.HasSuffix(de.Name(), ".tmp"):
+			info, err := de.Info()
+			if err != nil || time.Since(info.ModTime()) < staleTemp {
+				continue
+			}
+			// Already gone if its writer finished in the meantime
+			if err := os.Remove(filepath.Join(c.Dir, de.Name())); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// Size returns the number of entries and their total size in bytes
+func (c *Cache) Size() (int, int64, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	count := 0
+	var total int64
+	for _, de := range entries {
+		if !strings.HasSuffix(de.Name(), ".gob.gz") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		count++
+		total += info.Size()
+	}
+	return count, total, nil
+}
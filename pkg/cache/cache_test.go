@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cdecl"
+	"expeer/pkg/debuginfo"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+	"expeer/pkg/project"
+)
+
+// testAnalysis returns an analysis of a test binary whose debug
+// information was read from debugPath
+func testAnalysis(t *testing.T, debugPath string) *analyzer.Analysis {
+	t.Helper()
+	b, err := parser.ParseExecutable(filepath.Join("..", "analyzer", "testdata", "stripped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &analyzer.Analysis{
+		Binary:           b,
+		DetectedLanguage: "C",
+		Functions:        []disasm.Function{{Name: "main", StartAddr: 0x1040, EndAddr: 0x1050}},
+		Main:             0x1040,
+		Debug:            &debuginfo.Info{Format: "DWARF", Path: debugPath, Types: cdecl.NewHeader()},
+	}
+}
+
+// rewrite changes the stored entry of a binary
+func rewrite(t *testing.T, c *Cache, b *parser.Binary, change func(*entry)) {
+	t.Helper()
+	path := c.path(Hash(b))
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e entry
+	err = gob.NewDecoder(zr).Decode(&e)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(&e)
+
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(out)
+	if err := gob.NewEncoder(zw).Encode(&e); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	out.Close()
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	debugPath := filepath.Join(dir, "stripped.debug")
+	if err := os.WriteFile(debugPath, []byte("debug"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	symbolPath := []string{dir}
+	c := &Cache{Dir: dir, Version: "1.0"}
+
+	store := func() *analyzer.Analysis {
+		t.Helper()
+		analysis := testAnalysis(t, debugPath)
+		if err := c.Store(analysis, symbolPath); err != nil {
+			t.Fatal(err)
+		}
+		return analysis
+	}
+
+	analysis := store()
+	got, ok := c.Load(analysis.Binary, nil, symbolPath)
+	if !ok {
+		t.Fatal("Load() missed the stored entry")
+	}
+	if got.Main != 0x1040 || len(got.Functions) != 1 || got.Debug == nil || got.Debug.Path != debugPath {
+		t.Errorf("Load() = %+v, want the stored analysis", got)
+	}
+
+	misses := []struct {
+		name   string
+		change func(*entry)
+		load   func() (*analyzer.Analysis, bool)
+	}{
+		{name: "format version", change: func(e *entry) { e.Format = formatVersion - 1 }},
+		{name: "input hash", change: func(e *entry) { e.Hash = Hash(analysis.Binary)[1:] + "0" }},
+		{name: "project digest", change: func(e *entry) { e.Annotations = "other" }},
+		{name: "debug file digest", change: func(e *entry) { e.Debug = debugPath + ":0:0" }},
+		{name: "expeer build", load: func() (*analyzer.Analysis, bool) {
+			return (&Cache{Dir: dir, Version: "1.1"}).Load(analysis.Binary, nil, symbolPath)
+		}},
+		{name: "annotations", load: func() (*analyzer.Analysis, bool) {
+			proj := project.New(filepath.Join(dir, "stripped.expeer"))
+			proj.Rename(0x1040, "entry")
+			return c.Load(analysis.Binary, proj, symbolPath)
+		}},
+		{name: "symbol path", load: func() (*analyzer.Analysis, bool) {
+			return c.Load(analysis.Binary, nil, nil)
+		}},
+		{name: "binary", load: func() (*analyzer.Analysis, bool) {
+			b := *analysis.Binary
+			b.RawData = append(append([]byte(nil), b.RawData...), 0)
+			return c.Load(&b, nil, symbolPath)
+		}},
+		{name: "debug file", load: func() (*analyzer.Analysis, bool) {
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(debugPath, later, later); err != nil {
+				t.Fatal(err)
+			}
+			return c.Load(analysis.Binary, nil, symbolPath)
+		}},
+	}
+	for _, tt := range misses {
+		analysis = store()
+		if tt.change != nil {
+			rewrite(t, c, analysis.Binary, tt.change)
+			tt.load = func() (*analyzer.Analysis, bool) { return c.Load(analysis.Binary, nil, symbolPath) }
+		}
+		if _, ok := tt.load(); ok {
+			t.Errorf("%s changed: Load() hit", tt.name)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cache{Dir: dir, Version: "1.0"}
+	if err := c.Store(testAnalysis(t, ""), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	young := filepath.Join(dir, "entry-young.tmp")
+	stale := filepath.Join(dir, "entry-stale.tmp")
+	other := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{young, stale, other} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTemp)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Clear()
+	if err != nil || removed != 1 {
+		t.Errorf("Clear() = %d, %v, want the one entry", removed, err)
+	}
+	for path, want := range map[string]bool{young: true, stale: false, other: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists = %v after Clear(), want %v", filepath.Base(path), err == nil, want)
+		}
+	}
+	if n, _, _ := c.Size(); n != 0 {
+		t.Errorf("Size() = %d entries after Clear(), want 0", n)
+	}
+}
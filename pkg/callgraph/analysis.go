@@ -0,0 +1,91 @@
+package callgraph
+
+// findRecursion computes strongly connected components with Tarjan's
+// algorithm and records the ones that form call cycles
+func (g *Graph) findRecursion() {
+	index := make(map[*Node]int)
+	lowlink := make(map[*Node]int)
+	onStack := make(map[*Node]bool)
+	var stack []*Node
+	next := 0
+
+	var strongConnect func(n *Node)
+	strongConnect = func(n *Node) {
+		index[n] = next
+		lowlink[n] = next
+		next++
+		stack = append(stack, n)
+		onStack[n] = true
+
+		for _, callee := range n.Callees {
+			if _, visited := index[callee]; !visited {
+				strongConnect(callee)
+				if lowlink[callee] < lowlink[n] {
+					lowlink[n] = lowlink[callee]
+				}
+			} else if onStack[callee] && index[callee] < lowlink[n] {
+				lowlink[n] = index[callee]
+			}
+		}
+
+		if lowlink[n] != index[n] {
+			return
+		}
+
+		// n is the root of a component
+		var scc []*Node
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc = append(scc, top)
+			if top == n {
+				break
+			}
+		}
+
+		if len(scc) > 1 || callsItself(n) {
+			for _, member := range scc {
+				member.Recursive = true
+			}
+			g.SCCs = append(g.SCCs, scc)
+		}
+	}
+
+	for _, n := range g.Nodes {
+		if _, visited := index[n]; !visited {
+			strongConnect(n)
+		}
+	}
+}
+
+func callsItself(n *Node) bool {
+	for _, callee := range n.Callees {
+		if callee == n {
+			return true
+		}
+	}
+	return false
+}
+
+// markReachable flags every node reachable from the roots
+func (g *Graph) markReachable() {
+	var queue []*Node
+	for _, root := range g.Roots {
+		if !root.Reachable {
+			root.Reachable = true
+			queue = append(queue, root)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for _, callee := range n.Callees {
+			if !callee.Reachable {
+				callee.Reachable = true
+				queue = append(queue, callee)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package callgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Export renders the graph in the given format: "dot", "json" or "graphml"
+func (g *Graph) Export(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "dot":
+		return g.ExportDOT(), nil
+	case "json":
+		return g.ExportJSON()
+	case "graphml":
+		return g.ExportGraphML(), nil
+	default:
+		return "", fmt.Errorf("unsupported call graph format: %s (use dot, json or graphml)", format)
+	}
+}
+
+// uniqueEdges returns one edge per (caller, callee, kind) triple
+func (g *Graph) uniqueEdges() []Edge {
+	type key struct {
+		from, to int
+		kind     EdgeKind
+	}
+	seen := make(map[key]bool)
+
+	var result []Edge
+	for _, e := range g.Edges {
+		k := key{e.From.ID, e.To.ID, e.Kind}
+		if !seen[k] {
+			seen[k] = true
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// ExportDOT renders the graph in Graphviz DOT format. Imports are dashed
+// ellipses, recursive functions red, unreachable functions grey and roots
+// drawn with a thick border.
+func (g *Graph) ExportDOT() string {
+	var sb strings.Builder
+
+	sb.WriteString("digraph callgraph {\n")
+	sb.WriteString("    node [shape=box, fontname=\"monospace\"];\n")
+
+	for _, n := range g.Nodes {
+		var attrs []string
+		if n.IsImport {
+			attrs = append(attrs, fmt.Sprintf("label=%q", n.Name), "shape=ellipse", "style=dashed")
+		} else {
+			attrs = append(attrs, fmt.Sprintf("label=%q", fmt.Sprintf("%s\n0x%x", n.Name, n.Address)))
+			if !n.Reachable {
+				attrs = append(attrs, "style=filled", "fillcolor=lightgrey")
+			}
+		}
+		if n.Recursive {
+			attrs = append(attrs, "color=red")
+		}
+		if g.isRoot(n) {
+			attrs = append(attrs, "penwidth=2")
+		}
+		sb.WriteString(fmt.Sprintf("    n%d [%s];\n", n.ID, strings.Join(attrs, ", ")))
+	}
+
+	for _, e := range g.uniqueEdges() {
+		switch e.Kind {
+		case EdgeTailCall:
+			sb.WriteString(fmt.Sprintf("    n%d -> n%d [style=dashed, label=\"tail\"];\n", e.From.ID, e.To.ID))
+		case EdgeImport:
+			sb.WriteString(fmt.Sprintf("    n%d -> n%d [color=blue];\n", e.From.ID, e.To.ID))
+		default:
+			sb.WriteString(fmt.Sprintf("    n%d -> n%d;\n", e.From.ID, e.To.ID))
+		}
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+type jsonNode struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Import    bool   `json:"import"`
+	Reachable bool   `json:"reachable"`
+	Recursive bool   `json:"recursive"`
+	Callers   []int  `json:"callers"`
+	Callees   []int  `json:"callees"`
+}
+
+type jsonEdge struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Site string `json:"site"`
+	Kind string `json:"kind"`
+}
+
+type jsonGraph struct {
+	Entry       *int       `json:"entry"`
+	Roots       []int      `json:"roots"`
+	Nodes       []jsonNode `json:"nodes"`
+	Edges       []jsonEdge `json:"edges"`
+	SCCs        [][]int    `json:"recursive_components"`
+	Unreachable []int      `json:"unreachable"`
+}
+
+// ExportJSON renders the graph as JSON. Nodes are referenced by ID and
+// every call site is listed as an edge.
+func (g *Graph) ExportJSON() (string, error) {
+	out := jsonGraph{
+		Nodes:       []jsonNode{},
+		Edges:       []jsonEdge{},
+		SCCs:        [][]int{},
+		Unreachable: []int{},
+	}
+
+	if g.Entry != nil {
+		out.Entry = &g.Entry.ID
+	}
+	out.Roots = nodeIDs(g.Roots)
+
+	for _, n := range g.Nodes {
+		out.Nodes = append(out.Nodes, jsonNode{
+			ID:        n.ID,
+			Name:      n.Name,
+			Address:   fmt.Sprintf("0x%x", n.Address),
+			Import:    n.IsImport,
+			Reachable: n.Reachable,
+			Recursive: n.Recursive,
+			Callers:   nodeIDs(n.Callers),
+			Callees:   nodeIDs(n.Callees),
+		})
+	}
+
+	for _, e := range g.Edges {
+		out.Edges = append(out.Edges, jsonEdge{
+			From: e.From.ID,
+			To:   e.To.ID,
+			Site: fmt.Sprintf("0x%x", e.Site),
+			Kind: e.Kind.String(),
+		})
+	}
+
+	for _, scc := range g.SCCs {
+		out.SCCs = append(out.SCCs, nodeIDs(scc))
+	}
+	out.Unreachable = nodeIDs(g.Unreachable())
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// isRoot returns true if n is one of the graph's roots
+func (g *Graph) isRoot(n *Node) bool {
+	for _, root := range g.Roots {
+		if root == n {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeIDs(nodes []*Node) []int {
+	ids := []int{}
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+// ExportGraphML renders the graph as GraphML for yEd, Gephi and similar
+func (g *Graph) ExportGraphML() string {
+	var sb strings.Builder
+
+	sb.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	sb.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	sb.WriteString("  <key id=\"name\" for=\"node\" attr.name=\"name\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <key id=\"address\" for=\"node\" attr.name=\"address\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <key id=\"import\" for=\"node\" attr.name=\"import\" attr.type=\"boolean\"/>\n")
+	sb.WriteString("  <key id=\"reachable\" for=\"node\" attr.name=\"reachable\" attr.type=\"boolean\"/>\n")
+	sb.WriteString("  <key id=\"recursive\" for=\"node\" attr.name=\"recursive\" attr.type=\"boolean\"/>\n")
+	sb.WriteString("  <key id=\"kind\" for=\"edge\" attr.name=\"kind\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <key id=\"site\" for=\"edge\" attr.name=\"site\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <graph id=\"callgraph\" edgedefault=\"directed\">\n")
+
+	for _, n := range g.Nodes {
+		sb.WriteString(fmt.Sprintf("    <node id=\"n%d\">\n", n.ID))
+		sb.WriteString(fmt.Sprintf("      <data key=\"name\">%s</data>\n", xmlEscape(n.Name)))
+		sb.WriteString(fmt.Sprintf("      <data key=\"address\">0x%x</data>\n", n.Address))
+		sb.WriteString(fmt.Sprintf("      <data key=\"import\">%t</data>\n", n.IsImport))
+		sb.WriteString(fmt.Sprintf("      <data key=\"reachable\">%t</data>\n", n.Reachable))
+		sb.WriteString(fmt.Sprintf("      <data key=\"recursive\">%t</data>\n", n.Recursive))
+		sb.WriteString("    </node>\n")
+	}
+
+	for i, e := range g.Edges {
+		sb.WriteString(fmt.Sprintf("    <edge id=\"e%d\" source=\"n%d\" target=\"n%d\">\n", i, e.From.ID, e.To.ID))
+		sb.WriteString(fmt.Sprintf("      <data key=\"kind\">%s</data>\n", e.Kind))
+		sb.WriteString(fmt.Sprintf("      <data key=\"site\">0x%x</data>\n", e.Site))
+		sb.WriteString("    </edge>\n")
+	}
+
+	sb.WriteString("  </graph>\n")
+	sb.WriteString("</graphml>\n")
+	return sb.String()
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,283 @@
+package callgraph
+
+import (
+	"sort"
+
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+)
+
+// EdgeKind describes how control reaches the callee
+type EdgeKind int
+
+const (
+	EdgeCall     EdgeKind = iota // Direct call
+	EdgeTailCall                 // Jump to the start of another function
+	EdgeImport                   // Call through an import thunk or IAT slot
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeTailCall:
+		return "tailcall"
+	case EdgeImport:
+		return "import"
+	default:
+		return "call"
+	}
+}
+
+// Node is a function or imported symbol in the call graph
+type Node struct {
+	ID        int
+	Name      string
+	Address   uint64
+	IsImport  bool
+	Callers   []*Node
+	Callees   []*Node
+	Reachable bool // Reachable from a root through direct calls
+	Recursive bool // Member of a call cycle
+}
+
+// Edge is a single call site
+type Edge struct {
+	From *Node
+	To   *Node
+	Site uint64 // Address of the call/jmp instruction
+	Kind EdgeKind
+}
+
+// Graph is the call graph of a binary. It is read-only once Build returns
+// and safe for concurrent use.
+type Graph struct {
+	Nodes []*Node
+	Edges []Edge
+	Entry *Node
+	Roots []*Node   // Functions called from outside: the entry point, main, and the exports of libraries and objects
+	SCCs  [][]*Node // Recursive strongly connected components
+
+	byAddr  map[uint64]*Node
+	imports map[string]*Node
+	starts  []uint64 // Sorted function start addresses
+	binary  *parser.Binary
+	slots   map[uint64]string // Import slot address -> symbol
+}
+
+// Build constructs the call graph of the given functions, resolving direct
+// calls, calls through import thunks (PLT stubs, IAT jumps) and tail jumps.
+// main is the address of the program's main function, 0 if unknown: the
+// startup code calls it through a pointer, which no edge records.
+func Build(binary *parser.Binary, functions []disasm.Function, main uint64) *Graph {
+	g := &Graph{
+		byAddr:  make(map[uint64]*Node),
+		imports: make(map[string]*Node),
+		binary:  binary,
+		slots:   make(map[uint64]string),
+	}
+
+	for _, r := range binary.Relocations {
+		if r.Symbol != "" {
+			g.slots[r.Address] = r.Symbol
+		}
+	}
+	for _, imp := range binary.ImportTable {
+		g.slots[imp.IAT] = imp.Symbol()
+	}
+
+	for i := range functions {
+		fn := &functions[i]
+		if _, exists := g.byAddr[fn.StartAddr]; exists {
+			continue
+		}
+		node := &Node{ID: len(g.Nodes), Name: fn.Name, Address: fn.StartAddr}
+		g.Nodes = append(g.Nodes, node)
+		g.byAddr[fn.StartAddr] = node
+		g.starts = append(g.starts, fn.StartAddr)
+	}
+	sort.Slice(g.starts, func(i, j int) bool { return g.starts[i] < g.starts[j] })
+
+	for i := range functions {
+		fn := &functions[i]
+		from := g.byAddr[fn.StartAddr]
+
+		for _, inst := range fn.Instructions {
+			switch inst.Category {
+			case disasm.CatCall:
+				if to, kind := g.resolveCall(inst); to != nil {
+					g.addEdge(from, to, inst.Address, kind)
+				}
+			case disasm.CatJump:
+				if inst.IsConditional {
+					continue
+				}
+				// A jump leaving the function to another function's start
+				// is a tail call
+				if inst.BranchTarget != 0 && (inst.BranchTarget < fn.StartAddr || inst.BranchTarget > fn.EndAddr) {
+					if to := g.byAddr[inst.BranchTarget]; to != nil {
+						g.addEdge(from, to, inst.Address, EdgeTailCall)
+					} else if to := g.importThunk(inst.BranchTarget); to != nil {
+						g.addEdge(from, to, inst.Address, EdgeImport)
+					}
+				} else if name := g.slotSymbol(inst); name != "" {
+					g.addEdge(from, g.importNode(name, uint64(inst.MemoryDisp)), inst.Address, EdgeImport)
+				}
+			}
+		}
+	}
+
+	if binary.EntryPoint != 0 {
+		g.Entry = g.containing(binary.EntryPoint)
+	}
+	g.findRoots(main)
+
+	g.findRecursion()
+	g.markReachable()
+
+	return g
+}
+
+// resolveCall returns the callee of a call instruction
+func (g *Graph) resolveCall(inst disasm.Instruction) (*Node, EdgeKind) {
+	// call [rip+slot] straight through an import slot
+	if name := g.slotSymbol(inst); name != "" {
+		return g.importNode(name, uint64(inst.MemoryDisp)), EdgeImport
+	}
+
+	if inst.BranchTarget == 0 {
+		return nil, EdgeCall // Indirect call
+	}
+
+	if node := g.byAddr[inst.BranchTarget]; node != nil {
+		return node, EdgeCall
+	}
+	if node := g.importThunk(inst.BranchTarget); node != nil {
+		return node, EdgeImport
+	}
+	if node := g.containing(inst.BranchTarget); node != nil {
+		return node, EdgeCall
+	}
+	return nil, EdgeCall
+}
+
+// slotSymbol returns the import referenced by an indirect call/jmp through
+// a relocated memory slot
+func (g *Graph) slotSymbol(inst disasm.Instruction) string {
+	if !inst.HasMemoryAccess || (inst.MemoryBase != "rip" && inst.MemoryBase != "") || inst.MemoryIndex != "" {
+		return ""
+	}
+	return g.slots[uint64(inst.MemoryDisp)]
+}
+
+// importThunk decodes the instruction at addr and, if it is a jump through
+// an import slot (PLT stub or PE thunk), returns the import node. Object
+// files call the slots of their undefined symbols directly.
+func (g *Graph) importThunk(addr uint64) *Node {
+	if name := g.slots[addr]; name != "" {
+		return g.importNode(name, addr)
+	}
+
+	arch, ok := disasm.LookupArch(g.binary.Arch)
+	if !ok || arch.Decode == nil {
+		return nil
+	}
+	code := g.binary.BytesAt(addr, 16)
+	if len(code) == 0 {
+		return nil
+	}
+
+	// PLT entries may start with endbr64 (f3 0f 1e fa)
+	if len(code) >= 4 && code[0] == 0xf3 && code[1] == 0x0f && code[2] == 0x1e && code[3] == 0xfa {
+		code = code[4:]
+		addr += 4
+	}
+
+	inst, size := arch.Decode(code, addr)
+	if size == 0 || inst.Mnemonic != "jmp" {
+		return nil
+	}
+	if name := g.slotSymbol(inst); name != "" {
+		return g.importNode(name, addr)
+	}
+	return nil
+}
+
+// importNode returns the node for an imported symbol, creating it once
+func (g *Graph) importNode(name string, addr uint64) *Node {
+	if node := g.imports[name]; node != nil {
+		return node
+	}
+	node := &Node{ID: len(g.Nodes), Name: name, Address: addr, IsImport: true}
+	g.Nodes = append(g.Nodes, node)
+	g.imports[name] = node
+	return node
+}
+
+// findRoots collects the functions called from outside the binary or
+// through the pointer the startup code passes to __libc_start_main and
+// its kin. The exports of executables are left out: their entry point is
+// what runs.
+func (g *Graph) findRoots(main uint64) {
+	if g.Entry != nil {
+		g.Roots = append(g.Roots, g.Entry)
+	}
+	if node := g.byAddr[main]; main != 0 && node != nil && node != g.Entry {
+		g.Roots = append(g.Roots, node)
+	}
+	if g.binary.Kind == parser.KindExecutable {
+		return
+	}
+	for _, exp := range g.binary.ExportTable {
+		if node := g.byAddr[exp.Address]; node != nil && node != g.Entry && !node.IsImport {
+			g.Roots = append(g.Roots, node)
+		}
+	}
+}
+
+// containing returns the function whose range starts at or before addr
+func (g *Graph) containing(addr uint64) *Node {
+	i := sort.Search(len(g.starts), func(i int) bool { return g.starts[i] > addr })
+	if i == 0 {
+		return nil
+	}
+	return g.byAddr[g.starts[i-1]]
+}
+
+func (g *Graph) addEdge(from, to *Node, site uint64, kind EdgeKind) {
+	g.Edges = append(g.Edges, Edge{From: from, To: to, Site: site, Kind: kind})
+
+	for _, c := range from.Callees {
+		if c == to {
+			return
+		}
+	}
+	from.Callees = append(from.Callees, to)
+	to.Callers = append(to.Callers, from)
+}
+
+// Node returns the node for the function starting at addr
+func (g *Graph) Node(addr uint64) *Node {
+	return g.byAddr[addr]
+}
+
+// Find returns the node with the given name
+func (g *Graph) Find(name string) *Node {
+	for _, n := range g.Nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// Unreachable returns the functions that are not reachable from a root
+// through direct calls. Functions only reached through indirect
+// calls or data references also appear here.
+func (g *Graph) Unreachable() []*Node {
+	var result []*Node
+	for _, n := range g.Nodes {
+		if !n.Reachable && !n.IsImport {
+			result = append(result, n)
+		}
+	}
+	return result
+}
@@ -0,0 +1,30 @@
+package callgraph
+
+import (
+	"testing"
+
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+)
+
+// The startup code passes main to __libc_start_main as a pointer, so only
+// the main root makes main and its callees reachable
+func TestBuildMainRoot(t *testing.T) {
+	b := &parser.Binary{Kind: parser.KindExecutable, EntryPoint: 0x1000}
+	call := disasm.Instruction{Address: 0x1100, Mnemonic: "call", Category: disasm.CatCall, BranchTarget: 0x1200}
+	functions := []disasm.Function{
+		{Name: "_start", StartAddr: 0x1000, EndAddr: 0x1010},
+		{Name: "main", StartAddr: 0x1100, EndAddr: 0x1110, Instructions: []disasm.Instruction{call}},
+		{Name: "helper", StartAddr: 0x1200, EndAddr: 0x1210},
+		{Name: "dead", StartAddr: 0x1300, EndAddr: 0x1310},
+	}
+
+	g := Build(b, functions, 0x1100)
+	var unreachable []string
+	for _, n := range g.Unreachable() {
+		unreachable = append(unreachable, n.Name)
+	}
+	if len(unreachable) != 1 || unreachable[0] != "dead" {
+		t.Errorf("unreachable functions %v, want [dead]", unreachable)
+	}
+}
@@ -0,0 +1,268 @@
+package cdecl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prototype is a C function declaration
+type Prototype struct {
+	Name     string
+	Return   string
+	Params   []Param
+	Variadic bool
+	CallConv string // "__stdcall", "__fastcall", ... empty for the default
+}
+
+// CallConvGo is the calling convention of Go functions (ABIInternal),
+// which pass arguments in registers and split strings, slices and
+// interfaces across several of them
+const CallConvGo = "ABIInternal"
+
+// Param is a named and typed declaration: a function parameter or a
+// struct field. Name is empty for unnamed parameters.
+type Param struct {
+	Name string
+	Type string
+}
+
+// callConvs are the calling convention keywords accepted in prototypes,
+// mapped to their canonical spelling
+var callConvs = map[string]string{
+	"__cdecl":    "__cdecl",
+	"__stdcall":  "__stdcall",
+	"__fastcall": "__fastcall",
+	"__thiscall": "__thiscall",
+	"WINAPI":     "__stdcall",
+	"APIENTRY":   "__stdcall",
+	"CALLBACK":   "__stdcall",
+	"NTAPI":      "__stdcall",
+}
+
+// typeWords are keywords that can only be part of a type, never a name
+var typeWords = map[string]bool{
+	"void": true, "char": true, "short": true, "int": true, "long": true,
+	"float": true, "double": true, "signed": true, "unsigned": true,
+	"const": true, "volatile": true, "struct": true, "union": true,
+	"enum": true, "_Bool": true, "bool": true, "restrict": true,
+}
+
+// ParsePrototype parses a C function declaration such as
+// "int parse(const char *buf, size_t len, ...)"
+func ParsePrototype(s string) (*Prototype, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("not a function prototype: %q", s)
+	}
+
+	p := &Prototype{}
+
+	// Calling convention keywords may appear anywhere in the head, also
+	// glued to a pointer star as in "LPWSTR *WINAPI"
+	var head []string
+	for _, word := range tokenize(s[:open]) {
+		if conv, ok := callConvs[word]; ok {
+			p.CallConv = conv
+			continue
+		}
+		head = append(head, word)
+	}
+	decl, err := ParseDeclaration(strings.Join(head, " "))
+	if err != nil {
+		return nil, err
+	}
+	if decl.Name == "" {
+		return nil, fmt.Errorf("prototype has no function name: %q", s)
+	}
+	p.Name = decl.Name
+	p.Return = decl.Type
+
+	for _, arg := range splitTopLevel(s[open+1 : len(s)-1]) {
+		arg = strings.TrimSpace(arg)
+		switch arg {
+		case "":
+			continue
+		case "...":
+			p.Variadic = true
+			continue
+		case "void":
+			if len(p.Params) == 0 {
+				continue
+			}
+		}
+		param, err := ParseDeclaration(arg)
+		if err != nil {
+			return nil, err
+		}
+		p.Params = append(p.Params, param)
+	}
+
+	return p, nil
+}
+
+// ParseDeclaration parses a single declaration such as "const char *name",
+// "int counts[4]" or "void (*cb)(int)". The declared name is empty if the
+// declaration is only a type.
+func ParseDeclaration(s string) (Param, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Param{}, fmt.Errorf("empty declaration")
+	}
+
+	// Function pointer: the name sits inside "(*name)"
+	if i := strings.Index(s, "(*"); i >= 0 {
+		end := strings.Index(s[i:], ")")
+		if end < 0 {
+			return Param{}, fmt.Errorf("malformed function pointer: %q", s)
+		}
+		name := strings.TrimSpace(s[i+2 : i+end])
+		return Param{Name: name, Type: NormalizeType(s[:i+2] + s[i+end:])}, nil
+	}
+
+	// Array suffixes belong to the type
+	suffix := ""
+	if i := strings.Index(s, "["); i >= 0 {
+		suffix = strings.ReplaceAll(s[i:], " ", "")
+		s = strings.TrimSpace(s[:i])
+	}
+
+	tokens := tokenize(s)
+	if len(tokens) == 0 {
+		return Param{}, fmt.Errorf("malformed declaration: %q", s)
+	}
+
+	name := ""
+	last := tokens[len(tokens)-1]
+	if len(tokens) > 1 && isIdentifier(last) && !typeWords[last] {
+		prev := tokens[len(tokens)-2]
+		if prev != "struct" && prev != "union" && prev != "enum" {
+			name = last
+			tokens = tokens[:len(tokens)-1]
+		}
+	}
+
+	return Param{Name: name, Type: NormalizeType(strings.Join(tokens, " ")) + suffix}, nil
+}
+
+// NormalizeType canonicalizes the spelling of a C type: single spaces
+// between words and pointer stars attached to the type ("char *" becomes
+// "char*")
+func NormalizeType(t string) string {
+	var sb strings.Builder
+	for _, tok := range tokenize(t) {
+		prev := sb.String()
+		if prev != "" && tok != "*" && tok != ")" && tok != "," && !strings.HasSuffix(prev, "(") &&
+			!(tok == "(" && strings.HasSuffix(prev, ")")) {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(tok)
+	}
+	return sb.String()
+}
+
+// String renders the prototype as a C declaration without trailing ';'
+func (p *Prototype) String() string {
+	return p.Declare(p.Name)
+}
+
+// Declare renders the prototype under another function name
+func (p *Prototype) Declare(name string) string {
+	var sb strings.Builder
+	sb.WriteString(p.Return)
+	sb.WriteString(" ")
+	if p.CallConv != "" {
+		sb.WriteString(p.CallConv + " ")
+	}
+	sb.WriteString(name)
+	sb.WriteString("(")
+	for i, param := range p.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(param.String())
+	}
+	if p.Variadic {
+		if len(p.Params) > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("...")
+	}
+	if len(p.Params) == 0 && !p.Variadic {
+		sb.WriteString("void")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// String renders the declaration, placing array suffixes after the name
+func (p Param) String() string {
+	if p.Name == "" {
+		return p.Type
+	}
+	if i := strings.Index(p.Type, "(*)"); i >= 0 {
+		return p.Type[:i+2] + p.Name + p.Type[i+2:]
+	}
+	if i := strings.Index(p.Type, "["); i >= 0 {
+		return p.Type[:i] + " " + p.Name + p.Type[i:]
+	}
+	return p.Type + " " + p.Name
+}
+
+// tokenize splits a declaration into identifiers and punctuation
+func tokenize(s string) []string {
+	var tokens []string
+	word := ""
+	flush := func() {
+		if word != "" {
+			tokens = append(tokens, word)
+			word = ""
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		case r == '*' || r == '(' || r == ')' || r == ',':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			word += string(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// splitTopLevel splits a parameter list at commas outside parentheses
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
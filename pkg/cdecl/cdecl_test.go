@@ -0,0 +1,50 @@
+package cdecl
+
+import "testing"
+
+func TestParsePrototype(t *testing.T) {
+	tests := []struct {
+		decl string
+		want string // Prototype.String(), "" if the declaration is rejected
+	}{
+		{"int parse(const char *buf, size_t len, ...);", "int parse(const char* buf, size_t len, ...)"},
+		{"void exit(int)", "void exit(int)"},
+		{"unsigned long hash(void)", "unsigned long hash(void)"},
+		{"char *strdup(const char *s)", "char* strdup(const char* s)"},
+		{"void qsort(void *base, size_t n, size_t size, int (*cmp)(const void *, const void *))",
+			"void qsort(void* base, size_t n, size_t size, int (*cmp)(const void*, const void*))"},
+		{"BOOL WINAPI CloseHandle(HANDLE h)", "BOOL __stdcall CloseHandle(HANDLE h)"},
+		{"int x", ""},
+		{"int f(int", ""},
+	}
+	for _, tt := range tests {
+		p, err := ParsePrototype(tt.decl)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("ParsePrototype(%q) = %s, want an error", tt.decl, p)
+		case tt.want != "" && err != nil:
+			t.Errorf("ParsePrototype(%q): %v", tt.decl, err)
+		case err == nil && p.String() != tt.want:
+			t.Errorf("ParsePrototype(%q) = %s, want %s", tt.decl, p, tt.want)
+		}
+	}
+}
+
+func TestParseDeclaration(t *testing.T) {
+	tests := []struct {
+		decl string
+		want Param
+	}{
+		{"char name[16]", Param{"name", "char[16]"}},
+		{"const struct node *next", Param{"next", "const struct node*"}},
+		{"int (*handler)(int, char **)", Param{"handler", "int (*)(int, char**)"}},
+		{"unsigned int", Param{"", "unsigned int"}},
+		{"int grid[2][3]", Param{"grid", "int[2][3]"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseDeclaration(tt.decl)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDeclaration(%q) = %+v, %v; want %+v", tt.decl, got, err, tt.want)
+		}
+	}
+}
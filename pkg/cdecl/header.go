@@ -0,0 +1,892 @@
+package cdecl
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Header holds C declarations, parsed from header files or built from
+// debug information. Parsed structures are laid out by Layout once
+// parsing is complete.
+type Header struct {
+	Typedefs   []Typedef
+	Structs    []*Struct
+	Enums      []*Enum
+	Prototypes []*Prototype
+	Skipped    []string // Declarations that could not be parsed
+
+	typedefs map[string]string
+	structs  map[string]*Struct
+	protos   map[string]*Prototype
+}
+
+// Typedef is a type alias
+type Typedef struct {
+	Name string
+	Type string
+}
+
+// Struct is a structure or union. Anonymous aggregates are named after
+// their typedef, or after the aggregate containing them.
+type Struct struct {
+	Name   string
+	Union  bool
+	Fields []Field
+	Size   int
+	Align  int
+	Pack   int // Maximum field alignment set by #pragma pack, 0 for none
+
+	laidOut bool
+}
+
+// Field is a structure member
+type Field struct {
+	Name   string
+	Type   string
+	Bits   int // Bit-field width, 0 for ordinary fields
+	Offset int
+}
+
+// Enum is an enumeration; its constants are ints
+type Enum struct {
+	Name   string // Empty for anonymous enumerations
+	Values []EnumValue
+}
+
+// EnumValue is an enumeration constant
+type EnumValue struct {
+	Name  string
+	Value int64
+}
+
+// ParseHeaderFiles preprocesses and parses C header files. Quoted
+// includes are followed relative to the including file.
+func ParseHeaderFiles(paths ...string) (*Header, error) {
+	pp := newPreprocessor()
+	for _, path := range paths {
+		if err := pp.file(path); err != nil {
+			return nil, err
+		}
+	}
+	return parseHeader(pp), nil
+}
+
+// ParseHeader preprocesses and parses C header text
+func ParseHeader(src string) (*Header, error) {
+	pp := newPreprocessor()
+	if err := pp.source(src, ".", "<header>"); err != nil {
+		return nil, err
+	}
+	return parseHeader(pp), nil
+}
+
+func parseHeader(pp *preprocessor) *Header {
+	p := &headerParser{
+		h:      NewHeader(),
+		funcs:  pp.funcs,
+		consts: make(map[string]int64),
+	}
+	p.parse(lex(pp.out.String()))
+	return p.h
+}
+
+// NewHeader creates an empty set of declarations, to be filled from a
+// source other than C text such as debug information
+func NewHeader() *Header {
+	return &Header{
+		typedefs: make(map[string]string),
+		structs:  make(map[string]*Struct),
+		protos:   make(map[string]*Prototype),
+	}
+}
+
+// AddStruct adds or replaces a structure. A structure with a size is taken
+// as laid out: its field offsets are kept by Layout.
+func (h *Header) AddStruct(s *Struct) {
+	s.laidOut = s.laidOut || s.Size > 0
+	if old, ok := h.structs[s.Name]; ok {
+		*old = *s
+		return
+	}
+	h.structs[s.Name] = s
+	h.Structs = append(h.Structs, s)
+}
+
+// AddTypedef adds or replaces a type alias
+func (h *Header) AddTypedef(name, typ string) {
+	_, redeclared := h.typedefs[name]
+	h.typedefs[name] = typ
+	// "typedef struct foo foo" only names a structure by its tag, which
+	// the generated code does for every structure
+	if typ == "struct "+name || typ == "union "+name {
+		return
+	}
+	if redeclared {
+		for i := range h.Typedefs {
+			if h.Typedefs[i].Name == name {
+				h.Typedefs[i].Type = typ
+				return
+			}
+		}
+	}
+	h.Typedefs = append(h.Typedefs, Typedef{Name: name, Type: typ})
+}
+
+// AddEnum adds or replaces an enumeration. Anonymous enumerations are
+// told apart by their first constant.
+func (h *Header) AddEnum(e *Enum) {
+	for i, old := range h.Enums {
+		if old.Name == e.Name && (e.Name != "" || len(old.Values) > 0 && len(e.Values) > 0 && old.Values[0].Name == e.Values[0].Name) {
+			h.Enums[i] = e
+			return
+		}
+	}
+	h.Enums = append(h.Enums, e)
+}
+
+// AddPrototype adds or replaces a function declaration
+func (h *Header) AddPrototype(p *Prototype) {
+	if _, ok := h.protos[p.Name]; ok {
+		for i, old := range h.Prototypes {
+			if old.Name == p.Name {
+				h.Prototypes[i] = p
+			}
+		}
+	} else {
+		h.Prototypes = append(h.Prototypes, p)
+	}
+	h.protos[p.Name] = p
+}
+
+// Merge adds the declarations of other, which replace those of h with
+// the same name
+func (h *Header) Merge(other *Header) {
+	for _, s := range other.Structs {
+		h.AddStruct(s)
+	}
+	for _, e := range other.Enums {
+		h.AddEnum(e)
+	}
+	for _, t := range other.Typedefs {
+		h.AddTypedef(t.Name, t.Type)
+	}
+	for name, typ := range other.typedefs {
+		if _, ok := h.typedefs[name]; !ok {
+			h.typedefs[name] = typ
+		}
+	}
+	for _, p := range other.Prototypes {
+		h.AddPrototype(p)
+	}
+	h.Skipped = append(h.Skipped, other.Skipped...)
+}
+
+// headerData is the stored form of a Header, which keeps the typedefs
+// that only name a structure tag and whether structures were laid out
+type headerData struct {
+	Typedefs   []Typedef
+	Aliases    map[string]string
+	Structs    []*Struct
+	LaidOut    []bool
+	Enums      []*Enum
+	Prototypes []*Prototype
+	Skipped    []string
+}
+
+// GobEncode stores the declarations, for caches
+func (h *Header) GobEncode() ([]byte, error) {
+	d := headerData{
+		Typedefs:   h.Typedefs,
+		Aliases:    h.typedefs,
+		Structs:    h.Structs,
+		LaidOut:    make([]bool, len(h.Structs)),
+		Enums:      h.Enums,
+		Prototypes: h.Prototypes,
+		Skipped:    h.Skipped,
+	}
+	for i, s := range h.Structs {
+		d.LaidOut[i] = s.laidOut
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&d)
+	return buf.Bytes(), err
+}
+
+// GobDecode restores declarations stored with GobEncode
+func (h *Header) GobDecode(data []byte) error {
+	var d headerData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return err
+	}
+	*h = *NewHeader()
+	h.Typedefs, h.Structs, h.Enums, h.Prototypes, h.Skipped = d.Typedefs, d.Structs, d.Enums, d.Prototypes, d.Skipped
+	for name, typ := range d.Aliases {
+		h.typedefs[name] = typ
+	}
+	for i, s := range h.Structs {
+		s.laidOut = i < len(d.LaidOut) && d.LaidOut[i]
+		h.structs[s.Name] = s
+	}
+	for _, p := range h.Prototypes {
+		h.protos[p.Name] = p
+	}
+	return nil
+}
+
+// Typedef returns the type a typedef name stands for
+func (h *Header) Typedef(name string) (string, bool) {
+	t, ok := h.typedefs[name]
+	return t, ok
+}
+
+// Struct returns the structure or union with the given tag. Typedef names
+// of structures are accepted as well.
+func (h *Header) Struct(name string) *Struct {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "struct "), "union ")
+	if s, ok := h.structs[name]; ok {
+		return s
+	}
+	t := h.Resolve(name)
+	if strings.HasPrefix(t, "struct ") || strings.HasPrefix(t, "union ") {
+		return h.structs[strings.TrimPrefix(strings.TrimPrefix(t, "struct "), "union ")]
+	}
+	return nil
+}
+
+// Prototype returns the declared prototype of a function
+func (h *Header) Prototype(name string) (*Prototype, bool) {
+	p, ok := h.protos[name]
+	return p, ok
+}
+
+// Resolve replaces typedef names in a type with the types they stand for,
+// keeping pointer and array suffixes. Enumerations become int.
+func (h *Header) Resolve(t string) string {
+	t = NormalizeType(t)
+	for range maxExpansion {
+		if strings.Contains(t, "(*)") {
+			return t
+		}
+		base, suffix := splitTypeSuffix(t)
+		var words []string
+		for _, w := range strings.Fields(base) {
+			if w != "const" && w != "volatile" {
+				words = append(words, w)
+			}
+		}
+		base = strings.Join(words, " ")
+		if strings.HasPrefix(base, "enum ") {
+			return "int" + suffix
+		}
+		alias, ok := h.typedefs[base]
+		if !ok {
+			return base + suffix
+		}
+		t = NormalizeType(alias + suffix)
+	}
+	return t
+}
+
+// splitTypeSuffix splits the trailing pointer stars and array dimensions
+// off a type
+func splitTypeSuffix(t string) (string, string) {
+	end := len(t)
+	for end > 0 && (t[end-1] == '*' || t[end-1] == ']' || t[end-1] == ' ') {
+		if t[end-1] == ']' {
+			open := strings.LastIndex(t[:end], "[")
+			if open < 0 {
+				break
+			}
+			end = open
+			continue
+		}
+		end--
+	}
+	return strings.TrimSpace(t[:end]), strings.ReplaceAll(t[end:], " ", "")
+}
+
+// Sizeof returns the size and alignment of a type, resolving the
+// typedefs and structures of the header. The header must be laid out.
+func (h *Header) Sizeof(t string, ptrSize int) (int, int, bool) {
+	return Sizeof(h.Resolve(t), ptrSize, h.structLayout)
+}
+
+func (h *Header) structLayout(name string) (int, int, bool) {
+	if s := h.Struct(name); s != nil && s.laidOut {
+		return s.Size, s.Align, true
+	}
+	return 0, 0, false
+}
+
+// Layout computes field offsets and the sizes of all structures for a
+// target with the given pointer size. It returns a warning for each
+// field of unknown size, which is laid out as an int, and for each
+// bit-field wider than its type, which is laid out as an ordinary field.
+func (h *Header) Layout(ptrSize int) []string {
+	var warnings []string
+	visiting := make(map[*Struct]bool)
+
+	var layout func(s *Struct)
+	layout = func(s *Struct) {
+		if s.laidOut || visiting[s] {
+			return
+		}
+		visiting[s] = true
+
+		sizeof := func(t string) (int, int, bool) {
+			resolved := h.Resolve(t)
+			// Structures embedded by value are laid out first
+			if inner := h.Struct(strings.TrimSpace(strings.SplitN(resolved, "[", 2)[0])); inner != nil {
+				layout(inner)
+			}
+			return Sizeof(resolved, ptrSize, h.structLayout)
+		}
+
+		s.Size, s.Align = 0, 1
+		bitUnit, bitUsed := 0, 0 // Storage unit of the current bit-field run
+		for i := range s.Fields {
+			f := &s.Fields[i]
+			size, align, ok := sizeof(f.Type)
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("%s.%s: unknown size of type %q", s.Name, f.Name, f.Type))
+				size, align = 4, 4
+			}
+			if f.Bits > size*8 {
+				warnings = append(warnings, fmt.Sprintf("%s.%s: bit-field of %d bits is wider than its type %q", s.Name, f.Name, f.Bits, f.Type))
+				f.Bits = 0
+			}
+			if s.Pack > 0 && align > s.Pack {
+				align = s.Pack
+			}
+			if align > s.Align {
+				s.Align = align
+			}
+
+			if s.Union {
+				f.Offset = 0
+				s.Size = max(s.Size, size)
+				continue
+			}
+
+			// Adjacent bit-fields share a storage unit of their type
+			if f.Bits > 0 {
+				if bitUnit == size && bitUsed+f.Bits <= size*8 && i > 0 {
+					f.Offset = s.Fields[i-1].Offset
+					bitUsed += f.Bits
+					continue
+				}
+				bitUnit, bitUsed = size, f.Bits
+			} else {
+				bitUnit, bitUsed = 0, 0
+			}
+
+			f.Offset = alignTo(s.Size, align)
+			s.Size = f.Offset + size
+		}
+		s.Size = alignTo(s.Size, s.Align)
+		s.laidOut = true
+	}
+
+	for _, s := range h.Structs {
+		layout(s)
+	}
+	return warnings
+}
+
+// FieldAt returns the field of a structure at a byte offset, descending
+// into embedded structures. The name of a nested field is the path to it,
+// such as "hdr.len".
+func (h *Header) FieldAt(name string, offset int) (string, bool) {
+	s := h.Struct(name)
+	if s == nil {
+		return "", false
+	}
+	for _, f := range s.Fields {
+		if f.Offset == offset {
+			return f.Name, true
+		}
+		if inner := h.Struct(h.Resolve(f.Type)); inner != nil && offset > f.Offset && offset < f.Offset+inner.Size {
+			if sub, ok := h.FieldAt(inner.Name, offset-f.Offset); ok {
+				return f.Name + "." + sub, true
+			}
+		}
+	}
+	return "", false
+}
+
+func alignTo(n, align int) int {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// headerParser turns preprocessed header tokens into declarations
+type headerParser struct {
+	h      *Header
+	funcs  map[string]bool  // Function-like macros, whose uses are dropped
+	consts map[string]int64 // Enumeration constants and numeric macros
+	pack   int
+	anon   int
+}
+
+// ignoredWords are storage classes and compiler keywords that do not
+// change a declaration's type
+var ignoredWords = map[string]bool{
+	"extern": true, "static": true, "inline": true, "__inline": true,
+	"__inline__": true, "__forceinline": true, "register": true,
+	"__extension__": true, "restrict": true, "__restrict": true,
+	"__restrict__": true, "_Noreturn": true, "__ptr32": true, "__ptr64": true,
+	"__unaligned": true, "__w64": true,
+}
+
+// attributeWords are followed by a parenthesized argument that is dropped
+var attributeWords = map[string]bool{
+	"__attribute__": true, "__attribute": true, "__declspec": true,
+	"__asm__": true, "__asm": true, "asm": true, "_Alignas": true, "alignas": true,
+	"__pragma": true, "_Pragma": true,
+}
+
+// constant looks up an enumeration constant or numeric macro
+func (p *headerParser) constant(name string) (int64, bool) {
+	v, ok := p.consts[name]
+	return v, ok
+}
+
+func (p *headerParser) parse(tokens []string) {
+	for i := 0; i < len(tokens); {
+		switch {
+		case tokens[i] == ";" || tokens[i] == "}":
+			// A stray '}' closes an extern "C" block
+			i++
+		case tokens[i] == "extern" && i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], "\""):
+			i += 2
+			if i < len(tokens) && tokens[i] == "{" {
+				i++
+			}
+		default:
+			end := statementEnd(tokens, i)
+			p.statement(p.clean(tokens[i:end]))
+			i = end
+		}
+	}
+}
+
+// statementEnd returns the index just past the statement starting at i:
+// its ';', or the closing brace of a function body
+func statementEnd(tokens []string, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+		case "{":
+			if depth == 0 && j > i && tokens[j-1] == ")" {
+				// Function definition: the body ends the statement
+				return matchingBrace(tokens, j) + 1
+			}
+			j = matchingBrace(tokens, j)
+		case "}":
+			if depth == 0 {
+				return j
+			}
+		case ";":
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(tokens)
+}
+
+// matchingBrace returns the index of the brace closing the one at open
+func matchingBrace(tokens []string, open int) int {
+	depth := 0
+	for j := open; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// clean drops storage classes, attributes and uses of function-like
+// macros from a statement
+func (p *headerParser) clean(tokens []string) []string {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if ignoredWords[tok] {
+			continue
+		}
+		if (attributeWords[tok] || p.funcs[tok]) && i+1 < len(tokens) && tokens[i+1] == "(" {
+			i = matchingParenToken(tokens, i+1)
+			continue
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+// matchingParenToken returns the index of the parenthesis closing the one
+// at open
+func matchingParenToken(tokens []string, open int) int {
+	depth := 0
+	for j := open; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// statement parses one top-level declaration
+func (p *headerParser) statement(tokens []string) {
+	if len(tokens) == 0 {
+		return
+	}
+	// Function definitions contribute their prototype
+	if tokens[len(tokens)-1] == "}" {
+		for j, tok := range tokens {
+			if tok == "{" {
+				if j > 0 && tokens[j-1] == ")" {
+					p.prototype(tokens[:j])
+				}
+				return
+			}
+		}
+		return
+	}
+	if tokens[len(tokens)-1] == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	if tokens[0] == packMarker {
+		if len(tokens) >= 3 {
+			p.pack, _ = strconv.Atoi(tokens[2])
+		}
+		return
+	}
+
+	typedef := tokens[0] == "typedef"
+	if typedef {
+		tokens = tokens[1:]
+	}
+
+	tokens = p.aggregate(tokens, "", typedef)
+	base, declarators := splitDeclarators(tokens)
+	for _, decl := range declarators {
+		full := append(append([]string{}, base...), decl...)
+		switch {
+		case typedef:
+			p.typedef(full)
+		case isFunctionDeclarator(decl):
+			p.prototype(full)
+		}
+		// Variables are not needed
+	}
+}
+
+// aggregate defines the struct, union or enum whose body a declaration
+// contains, and replaces the body with a reference to the type. owner
+// names the enclosing structure, for naming anonymous members.
+func (p *headerParser) aggregate(tokens []string, owner string, typedef bool) []string {
+	open := -1
+	for j, tok := range tokens {
+		if tok == "{" {
+			open = j
+			break
+		}
+		if tok == "(" || tok == "[" {
+			return tokens
+		}
+	}
+	if open < 1 {
+		return tokens
+	}
+
+	kw, tag := open-1, ""
+	if tokens[kw] != "struct" && tokens[kw] != "union" && tokens[kw] != "enum" {
+		tag = tokens[kw]
+		kw--
+	}
+	if kw < 0 || (tokens[kw] != "struct" && tokens[kw] != "union" && tokens[kw] != "enum") {
+		return tokens
+	}
+	closing := matchingBrace(tokens, open)
+	body := tokens[open+1 : closing]
+	rest := tokens[closing+1:]
+
+	if tag == "" {
+		// Anonymous aggregates take their typedef name
+		if typedef && len(rest) > 0 && isIdentifier(rest[0]) && (len(rest) == 1 || rest[1] == ",") {
+			tag = rest[0]
+		} else if owner != "" {
+			p.anon++
+			tag = fmt.Sprintf("%s_anon%d", owner, p.anon)
+		} else if tokens[kw] != "enum" {
+			p.anon++
+			tag = fmt.Sprintf("anon%d", p.anon)
+		}
+	}
+
+	ref := []string{tokens[kw], tag}
+	if tokens[kw] == "enum" {
+		p.enum(tag, body)
+		if tag == "" {
+			ref = []string{"int"}
+		}
+	} else {
+		p.structure(tag, tokens[kw] == "union", body)
+	}
+
+	out := append(append(append([]string{}, tokens[:kw]...), ref...), rest...)
+	return out
+}
+
+// structure defines a struct or union from the tokens of its body
+func (p *headerParser) structure(tag string, union bool, body []string) {
+	s := &Struct{Name: tag, Union: union, Pack: p.pack}
+
+	for i := 0; i < len(body); {
+		end := statementEnd(body, i)
+		member := p.clean(body[i:end])
+		i = end
+		if len(member) > 0 && member[len(member)-1] == ";" {
+			member = member[:len(member)-1]
+		}
+		if len(member) == 0 {
+			continue
+		}
+
+		member = p.aggregate(member, tag, false)
+		base, declarators := splitDeclarators(member)
+		if len(declarators) == 0 && len(member) == 2 && (member[0] == "struct" || member[0] == "union") {
+			// Anonymous member: its fields are reached through a generated name
+			p.anon++
+			declarators = [][]string{{fmt.Sprintf("anon%d", p.anon)}}
+		}
+		for _, decl := range declarators {
+			bits, bitField := 0, false
+			for j, tok := range decl {
+				if tok == ":" {
+					bitField = true
+					if v, err := evalExpr(decl[j+1:], p.constant); err == nil {
+						bits = int(v)
+					}
+					decl = decl[:j]
+					break
+				}
+			}
+			if len(decl) == 0 {
+				continue // Unnamed padding bit-field
+			}
+			field, err := ParseDeclaration(p.join(append(append([]string{}, base...), decl...)))
+			if err == nil && field.Name == "" && bitField {
+				continue
+			}
+			// Named bit-fields have a positive width
+			if err != nil || field.Name == "" || bitField && bits <= 0 {
+				p.h.Skipped = append(p.h.Skipped, tag+": "+strings.Join(member, " "))
+				continue
+			}
+			s.Fields = append(s.Fields, Field{Name: field.Name, Type: field.Type, Bits: bits})
+		}
+	}
+
+	// Nested aggregates were defined while parsing the body, ahead of s
+	p.h.AddStruct(s)
+}
+
+// enum defines an enumeration from the tokens of its body
+func (p *headerParser) enum(tag string, body []string) {
+	e := &Enum{Name: tag}
+	next := int64(0)
+	for _, entry := range splitTokens(body, ",") {
+		if len(entry) == 0 {
+			continue
+		}
+		value := next
+		if len(entry) > 2 && entry[1] == "=" {
+			if v, err := evalExpr(entry[2:], p.constant); err == nil {
+				value = v
+			}
+		}
+		e.Values = append(e.Values, EnumValue{Name: entry[0], Value: value})
+		p.consts[entry[0]] = value
+		next = value + 1
+	}
+	p.h.AddEnum(e)
+}
+
+// typedef records a type alias
+func (p *headerParser) typedef(tokens []string) {
+	decl, err := ParseDeclaration(p.join(dropCallConvs(tokens)))
+	if err != nil || decl.Name == "" || (strings.Contains(decl.Type, "(") && !strings.Contains(decl.Type, "(*)")) {
+		p.h.Skipped = append(p.h.Skipped, "typedef "+strings.Join(tokens, " "))
+		return
+	}
+	p.h.AddTypedef(decl.Name, decl.Type)
+}
+
+// prototype records a function declaration. Anything after the parameter
+// list, such as an attribute macro, is ignored.
+func (p *headerParser) prototype(tokens []string) {
+	for j, tok := range tokens {
+		if tok == "(" && j > 0 && !isPointerParen(tokens, j) {
+			end := matchingParenToken(tokens, j)
+			tokens = tokens[:end+1]
+			break
+		}
+	}
+	proto, err := ParsePrototype(p.join(tokens))
+	if err != nil {
+		p.h.Skipped = append(p.h.Skipped, strings.Join(tokens, " "))
+		return
+	}
+	p.h.AddPrototype(proto)
+}
+
+// join renders tokens as declaration text, evaluating array dimensions.
+// Only words are separated by spaces, so that "( * cb )" reads "(*cb)".
+func (p *headerParser) join(tokens []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "[" {
+			end := i + 1
+			for end < len(tokens) && tokens[end] != "]" {
+				end++
+			}
+			if v, err := evalExpr(tokens[i+1:end], p.constant); err == nil {
+				fmt.Fprintf(&sb, "[%d]", v)
+			} else {
+				sb.WriteString("[" + strings.Join(tokens[i+1:end], "") + "]")
+			}
+			i = end
+			continue
+		}
+		if prev := sb.String(); prev != "" && isIdentByte(prev[len(prev)-1], true) && isIdentByte(tokens[i][0], true) {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(tokens[i])
+	}
+	return sb.String()
+}
+
+// splitDeclarators splits a declaration into the type specifier shared by
+// its declarators and each declarator, as in "int a, *b, c[4]"
+func splitDeclarators(tokens []string) ([]string, [][]string) {
+	parts := splitTokens(tokens, ",")
+	first := parts[0]
+
+	// The first declarator starts at its pointer stars or the parenthesis
+	// of a function pointer, or with the name before a parameter list,
+	// array dimension or bit-field width
+	start := len(first)
+	for j, tok := range first {
+		if tok == "*" || (tok == "(" && isPointerParen(first, j)) {
+			start = j
+			break
+		}
+		if tok == "(" || tok == "[" || tok == ":" {
+			start = j - 1
+			break
+		}
+	}
+	if start == len(first) && len(first) > 1 {
+		last := first[len(first)-1]
+		prev := first[len(first)-2]
+		if isIdentifier(last) && !typeWords[last] && prev != "struct" && prev != "union" && prev != "enum" {
+			start = len(first) - 1
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	base := first[:start]
+	var declarators [][]string
+	if start < len(first) {
+		declarators = append(declarators, first[start:])
+	}
+	for _, part := range parts[1:] {
+		if len(part) > 0 {
+			declarators = append(declarators, part)
+		}
+	}
+	return base, declarators
+}
+
+// splitTokens splits tokens at a separator outside brackets
+func splitTokens(tokens []string, sep string) [][]string {
+	var parts [][]string
+	depth, start := 0, 0
+	for j, tok := range tokens {
+		switch tok {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, tokens[start:j])
+				start = j + 1
+			}
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// isFunctionDeclarator returns true for declarators with a parameter list
+// that are not function pointers
+func isFunctionDeclarator(decl []string) bool {
+	for j, tok := range decl {
+		if tok == "(" {
+			return j > 0 && !isPointerParen(decl, j)
+		}
+	}
+	return false
+}
+
+// isPointerParen returns true if the parenthesis at j opens a function
+// pointer declarator such as "(*cb)" or "(WINAPI *cb)"
+func isPointerParen(tokens []string, j int) bool {
+	for k := j + 1; k < len(tokens); k++ {
+		if _, ok := callConvs[tokens[k]]; !ok {
+			return tokens[k] == "*"
+		}
+	}
+	return false
+}
+
+// dropCallConvs removes calling convention keywords, which only matter to
+// function prototypes
+func dropCallConvs(tokens []string) []string {
+	var out []string
+	for _, tok := range tokens {
+		if _, ok := callConvs[tok]; !ok {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package cdecl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHeader declares the types of the layout tests. The offsets and sizes
+// the tests expect on amd64 are those GCC gives.
+const testHeader = `
+typedef unsigned int u32;
+typedef u32 handle_t;
+typedef handle_t *handle_p;
+struct point { short x, y; };
+typedef struct point point_t;
+union value { char c; double d; int i[3]; };
+struct node {
+	char tag;
+	point_t pos;
+	union value v;
+	struct node *next;
+	int (*cmp)(const void *, const void *);
+	void (*on_event)(struct node *n, int code);
+	char name[13];
+	handle_p h;
+	long count;
+};
+struct flags {
+	unsigned int ready : 1;
+	unsigned int mode : 3;
+	unsigned int level : 4;
+	int value;
+	unsigned short lo : 8, hi : 8;
+	unsigned char a : 5, b : 5;
+};
+struct grid { int cells[4][3]; char label; };
+struct outer { int n; struct { char x; short y; } in; };
+#pragma pack(push, 1)
+struct packed { char a; int b; short c; };
+#pragma pack(pop)
+enum color { RED, GREEN = 5, BLUE };
+int compare(const void *a, const void *b);
+struct node *find(struct node *head, const char *name, ...);
+`
+
+func parseTestHeader(t *testing.T, src string, ptrSize int) *Header {
+	t.Helper()
+	h, err := ParseHeader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := h.Layout(ptrSize); len(warnings) > 0 {
+		t.Fatalf("layout warnings: %v", warnings)
+	}
+	if len(h.Skipped) > 0 {
+		t.Fatalf("skipped declarations: %v", h.Skipped)
+	}
+	return h
+}
+
+func TestHeaderLayout(t *testing.T) {
+	h := parseTestHeader(t, testHeader, 8)
+	tests := []struct {
+		name   string
+		union  bool
+		size   int
+		align  int
+		fields []Field
+	}{
+		{"point", false, 4, 2, []Field{{"x", "short", 0, 0}, {"y", "short", 0, 2}}},
+		{"value", true, 16, 8, []Field{{"c", "char", 0, 0}, {"d", "double", 0, 0}, {"i", "int[3]", 0, 0}}},
+		{"node", false, 80, 8, []Field{
+			{"tag", "char", 0, 0},
+			{"pos", "point_t", 0, 2},
+			{"v", "union value", 0, 8},
+			{"next", "struct node*", 0, 24},
+			{"cmp", "int (*)(const void*, const void*)", 0, 32},
+			{"on_event", "void (*)(struct node* n, int code)", 0, 40},
+			{"name", "char[13]", 0, 48},
+			{"h", "handle_p", 0, 64},
+			{"count", "long", 0, 72},
+		}},
+		{"flags", false, 12, 4, []Field{
+			{"ready", "unsigned int", 1, 0},
+			{"mode", "unsigned int", 3, 0},
+			{"level", "unsigned int", 4, 0},
+			{"value", "int", 0, 4},
+			{"lo", "unsigned short", 8, 8},
+			{"hi", "unsigned short", 8, 8},
+			{"a", "unsigned char", 5, 10},
+			{"b", "unsigned char", 5, 11},
+		}},
+		{"grid", false, 52, 4, []Field{{"cells", "int[4][3]", 0, 0}, {"label", "char", 0, 48}}},
+		{"outer_anon1", false, 4, 2, []Field{{"x", "char", 0, 0}, {"y", "short", 0, 2}}},
+		{"outer", false, 8, 4, []Field{{"n", "int", 0, 0}, {"in", "struct outer_anon1", 0, 4}}},
+		{"packed", false, 7, 1, []Field{{"a", "char", 0, 0}, {"b", "int", 0, 1}, {"c", "short", 0, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := h.Struct(tt.name)
+			if s == nil {
+				t.Fatal("not defined")
+			}
+			if s.Union != tt.union || s.Size != tt.size || s.Align != tt.align {
+				t.Errorf("union %v, size %d, align %d; want %v, %d, %d", s.Union, s.Size, s.Align, tt.union, tt.size, tt.align)
+			}
+			if !reflect.DeepEqual(s.Fields, tt.fields) {
+				t.Errorf("fields\n got %+v\nwant %+v", s.Fields, tt.fields)
+			}
+		})
+	}
+}
+
+// Pointers and the pointer-sized integers shrink on 32-bit targets
+func TestHeaderLayout32(t *testing.T) {
+	h := parseTestHeader(t, `
+typedef int (*callback_t)(int);
+struct list { char c; struct list *next; long n; callback_t cb[2]; size_t len; };
+`, 4)
+	s := h.Struct("list")
+	var offsets []int
+	for _, f := range s.Fields {
+		offsets = append(offsets, f.Offset)
+	}
+	if want := []int{0, 4, 8, 12, 20}; !reflect.DeepEqual(offsets, want) || s.Size != 24 {
+		t.Errorf("offsets %v, size %d; want %v, 24", offsets, s.Size, want)
+	}
+}
+
+// Bit-fields need a name and a positive width no wider than their type
+func TestHeaderBitFieldRejection(t *testing.T) {
+	h, err := ParseHeader("struct z { int zero : 0; int negative : -1; int : 0; int wide : 40; int ok : 2; };")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := h.Layout(8)
+	if len(h.Skipped) != 2 {
+		t.Errorf("skipped %q, want the zero and negative widths", h.Skipped)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "z.wide") {
+		t.Errorf("warnings %q, want one for z.wide", warnings)
+	}
+	s := h.Struct("z")
+	if len(s.Fields) != 2 || s.Fields[0].Bits != 0 || s.Fields[1].Offset != 4 || s.Fields[1].Bits != 2 {
+		t.Errorf("fields %+v, want wide as an int and ok after it", s.Fields)
+	}
+}
+
+func TestHeaderTypedefs(t *testing.T) {
+	h := parseTestHeader(t, testHeader, 8)
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"u32", "unsigned int"},
+		{"handle_t", "unsigned int"},
+		{"handle_p", "unsigned int*"},
+		{"const handle_t[2]", "unsigned int[2]"},
+		{"handle_p*", "unsigned int**"},
+		{"point_t", "struct point"},
+		{"enum color", "int"},
+		{"struct node*", "struct node*"},
+	}
+	for _, tt := range tests {
+		if got := h.Resolve(tt.typ); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.typ, got, tt.want)
+		}
+	}
+
+	// The typedef naming a structure by its tag is no new name
+	if _, ok := h.Typedef("point_t"); !ok {
+		t.Errorf("point_t is not defined")
+	}
+	if s := h.Struct("point_t"); s == nil || s.Name != "point" {
+		t.Errorf("point_t does not name struct point")
+	}
+	if size, align, ok := h.Sizeof("handle_p", 8); !ok || size != 8 || align != 8 {
+		t.Errorf("Sizeof(handle_p) = %d, %d, %v", size, align, ok)
+	}
+}
+
+func TestHeaderEnums(t *testing.T) {
+	h := parseTestHeader(t, testHeader, 8)
+	if len(h.Enums) != 1 {
+		t.Fatalf("got %d enums, want 1", len(h.Enums))
+	}
+	want := []EnumValue{{"RED", 0}, {"GREEN", 5}, {"BLUE", 6}}
+	if e := h.Enums[0]; e.Name != "color" || !reflect.DeepEqual(e.Values, want) {
+		t.Errorf("got %+v, want color %+v", *e, want)
+	}
+}
+
+func TestHeaderFieldAt(t *testing.T) {
+	h := parseTestHeader(t, testHeader, 8)
+	tests := []struct {
+		name   string
+		offset int
+		want   string
+	}{
+		{"node", 0, "tag"},
+		{"node", 2, "pos"},
+		{"node", 4, "pos.y"},
+		{"node", 8, "v"},
+		{"node", 40, "on_event"},
+		{"node", 72, "count"},
+		{"outer", 6, "in.y"},
+		{"point_t", 2, "y"},
+		{"node", 3, ""},
+		{"node", 50, ""},
+		{"missing", 0, ""},
+	}
+	for _, tt := range tests {
+		got, ok := h.FieldAt(tt.name, tt.offset)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("FieldAt(%s, %d) = %q, %v; want %q", tt.name, tt.offset, got, ok, tt.want)
+		}
+	}
+}
+
+func TestHeaderPrototypes(t *testing.T) {
+	h := parseTestHeader(t, testHeader, 8)
+	tests := []string{
+		"int compare(const void* a, const void* b)",
+		"struct node* find(struct node* head, const char* name, ...)",
+	}
+	for _, want := range tests {
+		name := want[strings.LastIndex(want[:strings.Index(want, "(")], " ")+1 : strings.Index(want, "(")]
+		name = strings.TrimLeft(name, "*")
+		p, ok := h.Prototype(name)
+		if !ok {
+			t.Errorf("%s is not declared", name)
+			continue
+		}
+		if got := p.String(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
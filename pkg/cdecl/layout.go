@@ -0,0 +1,74 @@
+package cdecl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// primitiveSizes holds the sizes of fixed-size scalar types. long and the
+// pointer-sized typedefs are handled by Sizeof.
+var primitiveSizes = map[string]int{
+	"char": 1, "signed char": 1, "unsigned char": 1, "bool": 1, "_Bool": 1,
+	"int8_t": 1, "uint8_t": 1, "u8": 1, "BYTE": 1,
+	"short": 2, "unsigned short": 2, "short int": 2, "unsigned short int": 2,
+	"int16_t": 2, "uint16_t": 2, "u16": 2, "WORD": 2, "WCHAR": 2,
+	"int": 4, "unsigned": 4, "unsigned int": 4, "signed int": 4, "float": 4,
+	"int32_t": 4, "uint32_t": 4, "u32": 4, "DWORD": 4, "BOOL": 4, "LONG": 4,
+	"long long": 8, "unsigned long long": 8, "long long int": 8, "double": 8,
+	"int64_t": 8, "uint64_t": 8, "u64": 8, "QWORD": 8, "ULONGLONG": 8,
+}
+
+// pointerSized are the integer types as wide as a pointer (LP64)
+var pointerSized = map[string]bool{
+	"long": true, "unsigned long": true, "long int": true, "unsigned long int": true,
+	"size_t": true, "ssize_t": true, "intptr_t": true, "uintptr_t": true,
+	"ptrdiff_t": true, "off_t": true, "SIZE_T": true, "ULONG_PTR": true,
+	"HANDLE": true, "LPVOID": true, "LPCSTR": true, "LPSTR": true, "LPCWSTR": true, "LPWSTR": true,
+}
+
+// StructLayout reports the size and alignment of a named struct
+type StructLayout func(name string) (size, align int, ok bool)
+
+// Sizeof returns the size and alignment in bytes of a C type on a target
+// with the given pointer size. structs resolves struct types by name and
+// may be nil. The last result is false for unknown types.
+func Sizeof(t string, ptrSize int, structs StructLayout) (int, int, bool) {
+	t = NormalizeType(t)
+
+	// Arrays: element size times count, element alignment
+	if i := strings.LastIndex(t, "["); i >= 0 && strings.HasSuffix(t, "]") {
+		count, err := strconv.Atoi(t[i+1 : len(t)-1])
+		if err != nil || count < 0 {
+			return 0, 0, false
+		}
+		size, align, ok := Sizeof(t[:i], ptrSize, structs)
+		return size * count, align, ok
+	}
+
+	if strings.HasSuffix(t, "*") || strings.Contains(t, "(*)") {
+		return ptrSize, ptrSize, true
+	}
+
+	// Qualifiers do not change the layout
+	var words []string
+	for _, w := range strings.Fields(t) {
+		if w != "const" && w != "volatile" {
+			words = append(words, w)
+		}
+	}
+	t = strings.Join(words, " ")
+
+	if size, ok := primitiveSizes[t]; ok {
+		return size, size, true
+	}
+	if pointerSized[t] {
+		return ptrSize, ptrSize, true
+	}
+	if structs != nil {
+		name := strings.TrimPrefix(strings.TrimPrefix(t, "struct "), "union ")
+		if size, align, ok := structs(name); ok {
+			return size, align, true
+		}
+	}
+	return 0, 0, false
+}
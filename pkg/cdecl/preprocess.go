@@ -0,0 +1,589 @@
+package cdecl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxExpansion bounds nested macro expansion
+const maxExpansion = 32
+
+// packMarker carries "#pragma pack" settings from the preprocessor to the
+// declaration parser as a pseudo-declaration
+const packMarker = "__expeer_pack"
+
+// preprocessor implements the part of the C preprocessor declarations
+// depend on: object-like macros, conditional sections, quoted includes
+// and "#pragma pack". Function-like macros are recorded so that #ifdef
+// sees them, but their uses are left unexpanded. System includes
+// (<stdio.h>) are skipped: expeer knows the standard types.
+type preprocessor struct {
+	defines  map[string]string
+	funcs    map[string]bool // Function-like macros
+	included map[string]bool
+	packs    []int // "#pragma pack(push)" stack
+	out      strings.Builder
+}
+
+func newPreprocessor() *preprocessor {
+	return &preprocessor{
+		defines:  make(map[string]string),
+		funcs:    make(map[string]bool),
+		included: make(map[string]bool),
+	}
+}
+
+// file preprocesses a header file, once per path
+func (pp *preprocessor) file(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	if pp.included[abs] {
+		return nil
+	}
+	pp.included[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return pp.source(string(data), filepath.Dir(path), path)
+}
+
+// condition is the state of one #if/#ifdef section
+type condition struct {
+	active bool // Lines are currently kept
+	taken  bool // A branch of the section has been kept
+	parent bool // The enclosing section is active
+}
+
+// source preprocesses header text. dir resolves quoted includes and name
+// prefixes error messages.
+func (pp *preprocessor) source(src, dir, name string) error {
+	var conds []condition
+	active := func() bool {
+		return len(conds) == 0 || conds[len(conds)-1].active
+	}
+
+	for i, line := range strings.Split(stripComments(src), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			if active() {
+				pp.out.WriteString(pp.expand(line, nil))
+				pp.out.WriteString("\n")
+			}
+			continue
+		}
+
+		directive, rest := splitDirective(trimmed[1:])
+		switch directive {
+		case "if", "ifdef", "ifndef":
+			c := condition{parent: active()}
+			if c.parent {
+				c.active = pp.condition(directive, rest)
+				c.taken = c.active
+			}
+			conds = append(conds, c)
+			continue
+		case "elif", "else":
+			if len(conds) == 0 {
+				return fmt.Errorf("%s:%d: #%s without #if", name, i+1, directive)
+			}
+			c := &conds[len(conds)-1]
+			c.active = c.parent && !c.taken && (directive == "else" || pp.condition("if", rest))
+			c.taken = c.taken || c.active
+			continue
+		case "endif":
+			if len(conds) == 0 {
+				return fmt.Errorf("%s:%d: #endif without #if", name, i+1)
+			}
+			conds = conds[:len(conds)-1]
+			continue
+		}
+		if !active() {
+			continue
+		}
+
+		switch directive {
+		case "define":
+			pp.define(rest)
+		case "undef":
+			delete(pp.defines, rest)
+			delete(pp.funcs, rest)
+		case "include":
+			if strings.HasPrefix(rest, "\"") {
+				path := filepath.Join(dir, strings.Trim(rest, "\""))
+				if err := pp.file(path); err != nil {
+					return fmt.Errorf("%s:%d: %w", name, i+1, err)
+				}
+			}
+		case "pragma":
+			pp.pragma(rest)
+		case "error":
+			return fmt.Errorf("%s:%d: #error %s", name, i+1, rest)
+		}
+	}
+
+	if len(conds) > 0 {
+		return fmt.Errorf("%s: unterminated #if", name)
+	}
+	return nil
+}
+
+// splitDirective splits "define X 1" into the directive and its argument
+func splitDirective(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t(\"<")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// define records a macro definition
+func (pp *preprocessor) define(s string) {
+	end := 0
+	for end < len(s) && isIdentByte(s[end], end > 0) {
+		end++
+	}
+	name := s[:end]
+	if name == "" {
+		return
+	}
+	if end < len(s) && s[end] == '(' {
+		pp.funcs[name] = true
+		return
+	}
+	pp.defines[name] = strings.TrimSpace(s[end:])
+}
+
+// pragma handles "#pragma pack"; other pragmas are ignored
+func (pp *preprocessor) pragma(s string) {
+	name, args := splitDirective(s)
+	if name != "pack" {
+		return
+	}
+	args = strings.Trim(strings.TrimSpace(args), "()")
+	parts := strings.Split(args, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	current := 0
+	if len(pp.packs) > 0 {
+		current = pp.packs[len(pp.packs)-1]
+	}
+	switch {
+	case parts[0] == "push":
+		value := current
+		if len(parts) > 1 {
+			if n, err := strconv.Atoi(parts[len(parts)-1]); err == nil {
+				value = n
+			}
+		}
+		pp.packs = append(pp.packs, value)
+		current = value
+	case parts[0] == "pop":
+		if len(pp.packs) > 0 {
+			pp.packs = pp.packs[:len(pp.packs)-1]
+		}
+		current = 0
+		if len(pp.packs) > 0 {
+			current = pp.packs[len(pp.packs)-1]
+		}
+	case parts[0] == "":
+		pp.packs = nil
+		current = 0
+	default:
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return
+		}
+		if len(pp.packs) > 0 {
+			pp.packs[len(pp.packs)-1] = n
+		} else {
+			pp.packs = []int{n}
+		}
+		current = n
+	}
+	fmt.Fprintf(&pp.out, "%s(%d);\n", packMarker, current)
+}
+
+// condition evaluates the argument of #if, #ifdef or #ifndef
+func (pp *preprocessor) condition(directive, expr string) bool {
+	switch directive {
+	case "ifdef":
+		return pp.defined(expr)
+	case "ifndef":
+		return !pp.defined(expr)
+	}
+
+	// Resolve defined(X) before expanding macros
+	tokens := lex(expr)
+	var resolved []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "defined" {
+			resolved = append(resolved, tokens[i])
+			continue
+		}
+		name := ""
+		if i+1 < len(tokens) && tokens[i+1] == "(" && i+3 < len(tokens) {
+			name = tokens[i+2]
+			i += 3
+		} else if i+1 < len(tokens) {
+			name = tokens[i+1]
+			i++
+		}
+		if pp.defined(name) {
+			resolved = append(resolved, "1")
+		} else {
+			resolved = append(resolved, "0")
+		}
+	}
+
+	value, err := evalExpr(lex(pp.expand(strings.Join(resolved, " "), nil)), func(string) (int64, bool) {
+		// Identifiers left after expansion are 0, as in C
+		return 0, true
+	})
+	return err == nil && value != 0
+}
+
+func (pp *preprocessor) defined(name string) bool {
+	_, ok := pp.defines[strings.TrimSpace(name)]
+	return ok || pp.funcs[strings.TrimSpace(name)]
+}
+
+// expand replaces object-like macros in a line. seen holds the macros
+// being expanded, which are not expanded again.
+func (pp *preprocessor) expand(line string, seen map[string]bool) string {
+	if len(pp.defines) == 0 || len(seen) > maxExpansion {
+		return line
+	}
+	var sb strings.Builder
+	for i := 0; i < len(line); {
+		c := line[i]
+		if c == '"' || c == '\'' {
+			end := skipLiteral(line, i)
+			sb.WriteString(line[i:end])
+			i = end
+			continue
+		}
+		if !isIdentByte(c, false) {
+			sb.WriteByte(c)
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && isIdentByte(line[i], true) {
+			i++
+		}
+		word := line[start:i]
+		value, isMacro := pp.defines[word]
+		if !isMacro || seen[word] {
+			sb.WriteString(word)
+			continue
+		}
+		inner := make(map[string]bool, len(seen)+1)
+		for k := range seen {
+			inner[k] = true
+		}
+		inner[word] = true
+		sb.WriteString(pp.expand(value, inner))
+	}
+	return sb.String()
+}
+
+// stripComments removes comments and joins continued lines, keeping line
+// numbers intact
+func stripComments(src string) string {
+	var sb strings.Builder
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			if i < len(src) {
+				sb.WriteByte('\n')
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			i += 2
+			for i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/') {
+				if src[i] == '\n' {
+					sb.WriteByte('\n')
+				}
+				i++
+			}
+			i++
+			sb.WriteByte(' ')
+		case c == '"' || c == '\'':
+			end := skipLiteral(src, i)
+			sb.WriteString(src[i:end])
+			i = end - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// skipLiteral returns the index just past the string or character
+// literal starting at i
+func skipLiteral(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s) && s[i] != quote && s[i] != '\n'; i++ {
+		if s[i] == '\\' {
+			i++
+		}
+	}
+	if i < len(s) && s[i] == quote {
+		i++
+	}
+	if i > len(s) {
+		i = len(s)
+	}
+	return i
+}
+
+func isIdentByte(c byte, inside bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (inside && c >= '0' && c <= '9')
+}
+
+// lex splits C source into tokens: identifiers, numbers, literals and
+// operators
+func lex(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentByte(c, false) || (c >= '0' && c <= '9'):
+			start := i
+			for i < len(s) && (isIdentByte(s[i], true) || (s[start] >= '0' && s[start] <= '9' && s[i] == '.')) {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		case c == '"' || c == '\'':
+			end := skipLiteral(s, i)
+			tokens = append(tokens, s[i:end])
+			i = end
+		default:
+			op := string(c)
+			for _, multi := range []string{"...", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "->", "::"} {
+				if strings.HasPrefix(s[i:], multi) {
+					op = multi
+					break
+				}
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		}
+	}
+	return tokens
+}
+
+// evalExpr evaluates an integer constant expression. lookup resolves
+// identifiers (enumerators, macros).
+func evalExpr(tokens []string, lookup func(string) (int64, bool)) (int64, error) {
+	e := &exprParser{tokens: tokens, lookup: lookup}
+	v, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	if e.pos < len(e.tokens) {
+		return 0, fmt.Errorf("unexpected %q", e.tokens[e.pos])
+	}
+	return v, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+	lookup func(string) (int64, bool)
+}
+
+// binaryPrecedence ranks the binary operators, loosest first
+var binaryPrecedence = map[string]int{
+	"||": 1, "&&": 2, "|": 3, "^": 4, "&": 5,
+	"==": 6, "!=": 6, "<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8, "+": 9, "-": 9, "*": 10, "/": 10, "%": 10,
+}
+
+func (e *exprParser) binary(minPrec int) (int64, error) {
+	left, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+	for e.pos < len(e.tokens) {
+		op := e.tokens[e.pos]
+		prec, isOp := binaryPrecedence[op]
+		if !isOp || prec <= minPrec {
+			break
+		}
+		e.pos++
+		right, err := e.binary(prec)
+		if err != nil {
+			return 0, err
+		}
+		if left, err = applyBinary(op, left, right); err != nil {
+			return 0, err
+		}
+	}
+	// The conditional operator binds loosest of all
+	if minPrec == 0 && e.pos < len(e.tokens) && e.tokens[e.pos] == "?" {
+		e.pos++
+		then, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if e.pos >= len(e.tokens) || e.tokens[e.pos] != ":" {
+			return 0, fmt.Errorf("missing ':'")
+		}
+		e.pos++
+		otherwise, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if left != 0 {
+			return then, nil
+		}
+		return otherwise, nil
+	}
+	return left, nil
+}
+
+func applyBinary(op string, a, b int64) (int64, error) {
+	boolInt := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return boolInt(a != 0 || b != 0), nil
+	case "&&":
+		return boolInt(a != 0 && b != 0), nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "&":
+		return a & b, nil
+	case "==":
+		return boolInt(a == b), nil
+	case "!=":
+		return boolInt(a != b), nil
+	case "<":
+		return boolInt(a < b), nil
+	case "<=":
+		return boolInt(a <= b), nil
+	case ">":
+		return boolInt(a > b), nil
+	case ">=":
+		return boolInt(a >= b), nil
+	case "<<":
+		return a << uint64(b), nil
+	case ">>":
+		return a >> uint64(b), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", op)
+}
+
+func (e *exprParser) unary() (int64, error) {
+	if e.pos >= len(e.tokens) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	tok := e.tokens[e.pos]
+	e.pos++
+
+	switch tok {
+	case "(":
+		// Casts such as (DWORD)0x10 are skipped
+		if e.pos+1 < len(e.tokens) && e.tokens[e.pos+1] == ")" && isIdentifier(e.tokens[e.pos]) {
+			if _, known := e.lookup(e.tokens[e.pos]); !known || typeWords[e.tokens[e.pos]] {
+				e.pos += 2
+				return e.unary()
+			}
+		}
+		v, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if e.pos >= len(e.tokens) || e.tokens[e.pos] != ")" {
+			return 0, fmt.Errorf("missing ')'")
+		}
+		e.pos++
+		return v, nil
+	case "-":
+		v, err := e.unary()
+		return -v, err
+	case "+":
+		return e.unary()
+	case "~":
+		v, err := e.unary()
+		return ^v, err
+	case "!":
+		v, err := e.unary()
+		if v == 0 {
+			return 1, err
+		}
+		return 0, err
+	}
+
+	if v, ok := parseIntLiteral(tok); ok {
+		return v, nil
+	}
+	if isIdentifier(tok) {
+		if v, ok := e.lookup(tok); ok {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("not a constant: %q", tok)
+}
+
+// parseIntLiteral parses decimal, hex, octal and character constants,
+// ignoring integer suffixes
+func parseIntLiteral(tok string) (int64, bool) {
+	if len(tok) >= 3 && tok[0] == '\'' {
+		if s, err := strconv.Unquote(tok); err == nil && len(s) > 0 {
+			return int64(s[0]), true
+		}
+		return 0, false
+	}
+	if tok == "" || tok[0] < '0' || tok[0] > '9' {
+		return 0, false
+	}
+	tok = strings.TrimRight(tok, "uUlL")
+	v, err := strconv.ParseInt(tok, 0, 64)
+	if err != nil {
+		u, uerr := strconv.ParseUint(tok, 0, 64)
+		if uerr != nil {
+			return 0, false
+		}
+		v = int64(u)
+	}
+	return v, true
+}
@@ -92,11 +92,14 @@ func (bb *BasicBlock) HasMultipleSuccessors() bool {
 
 // Dominates returns true if this block dominates the target block
 func (bb *BasicBlock) Dominates(target *BasicBlock) bool {
+	// The entry block is its own dominator, so guard against revisits
+	seen := make(map[*BasicBlock]bool)
 	current := target
-	for current != nil {
+	for current != nil && !seen[current] {
 		if current == bb {
 			return true
 		}
+		seen[current] = true
 		current = current.DominatedBy
 	}
 	return false
@@ -182,28 +182,28 @@ func connectBlocks(cfg *ControlFlowGraph) {
 }
 
 // computeDominators calculates the dominator tree
-// Uses iterative algorithm
+// Uses the iterative algorithm of Cooper, Harvey and Kennedy over blocks in
+// reverse postorder
 func computeDominators(cfg *ControlFlowGraph) {
 	if cfg.EntryBlock == nil || len(cfg.Blocks) == 0 {
 		return
 	}
 
-	// Initialize: entry dominates itself, all others dominated by all blocks
+	order := reversePostorder(cfg.EntryBlock)
+	rpo := make(map[*BasicBlock]int)
+	for i, block := range order {
+		rpo[block] = i
+	}
+
+	// Entry dominates itself; unreachable blocks keep a nil dominator
 	cfg.EntryBlock.DominatedBy = cfg.EntryBlock
 
 	changed := true
-	maxIterations := len(cfg.Blocks) * len(cfg.Blocks) // Prevent infinite loop
-
-	for changed && maxIterations > 0 {
+	for changed {
 		changed = false
-		maxIterations--
-
-		for _, block := range cfg.Blocks {
-			if block == cfg.EntryBlock {
-				continue
-			}
 
-			// Find intersection of predecessors' dominators
+		for _, block := range order[1:] {
+			// Intersect the dominators of all processed predecessors
 			var newDom *BasicBlock
 			for _, pred := range block.Predecessors {
 				if pred.DominatedBy == nil {
@@ -211,9 +211,9 @@ func computeDominators(cfg *ControlFlowGraph) {
 				}
 
 				if newDom == nil {
-					newDom = pred.DominatedBy
+					newDom = pred
 				} else {
-					newDom = intersectDominators(pred.DominatedBy, newDom)
+					newDom = intersectDominators(pred, newDom, rpo)
 				}
 			}
 
@@ -225,22 +225,40 @@ func computeDominators(cfg *ControlFlowGraph) {
 	}
 }
 
+// reversePostorder returns the blocks reachable from entry in reverse
+// postorder of a depth-first traversal
+func reversePostorder(entry *BasicBlock) []*BasicBlock {
+	var post []*BasicBlock
+	visited := make(map[*BasicBlock]bool)
+
+	var visit func(block *BasicBlock)
+	visit = func(block *BasicBlock) {
+		visited[block] = true
+		for _, succ := range block.Successors {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		post = append(post, block)
+	}
+	visit(entry)
+
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
 // intersectDominators finds the common dominator of two blocks
-func intersectDominators(b1, b2 *BasicBlock) *BasicBlock {
+func intersectDominators(b1, b2 *BasicBlock, rpo map[*BasicBlock]int) *BasicBlock {
 	finger1 := b1
 	finger2 := b2
 
 	for finger1 != finger2 {
-		for finger1.ID > finger2.ID {
-			if finger1.DominatedBy == nil {
-				return finger2
-			}
+		for rpo[finger1] > rpo[finger2] {
 			finger1 = finger1.DominatedBy
 		}
-		for finger2.ID > finger1.ID {
-			if finger2.DominatedBy == nil {
-				return finger1
-			}
+		for rpo[finger2] > rpo[finger1] {
 			finger2 = finger2.DominatedBy
 		}
 	}
@@ -0,0 +1,222 @@
+package cfg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EdgeType classifies a CFG edge for rendering
+type EdgeType int
+
+const (
+	EdgeUnconditional EdgeType = iota
+	EdgeTrue                   // Conditional branch taken
+	EdgeFalse                  // Conditional branch fall-through
+	EdgeBack                   // Edge to a dominating loop header
+)
+
+// ClassifyEdge returns the type of the edge from block to succ
+func ClassifyEdge(block, succ *BasicBlock) EdgeType {
+	if succ.Dominates(block) {
+		return EdgeBack
+	}
+	if block.IsConditionalBranch() {
+		if last := block.GetLastInstruction(); last.BranchTarget == succ.StartAddr {
+			return EdgeTrue
+		}
+		return EdgeFalse
+	}
+	return EdgeUnconditional
+}
+
+// Export renders the CFG in the given format: "dot" or "mermaid"
+func (cfg *ControlFlowGraph) Export(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "dot":
+		return cfg.ExportDOT(), nil
+	case "mermaid", "mmd":
+		return cfg.ExportMermaid(), nil
+	default:
+		return "", fmt.Errorf("unsupported CFG format: %s (use dot or mermaid)", format)
+	}
+}
+
+// regionInfo collects the loop and conditional annotations of each block
+type regionInfo struct {
+	loopHeader map[*BasicBlock]bool
+	inLoop     map[*BasicBlock]bool
+	condition  map[*BasicBlock]string // Condition block -> structure type
+	merge      map[*BasicBlock]bool
+}
+
+func (cfg *ControlFlowGraph) regions() regionInfo {
+	info := regionInfo{
+		loopHeader: make(map[*BasicBlock]bool),
+		inLoop:     make(map[*BasicBlock]bool),
+		condition:  make(map[*BasicBlock]string),
+		merge:      make(map[*BasicBlock]bool),
+	}
+
+	for _, loop := range DetectLoops(cfg) {
+		info.loopHeader[loop.Header] = true
+		for _, b := range loop.Blocks {
+			info.inLoop[b] = true
+		}
+	}
+
+	for _, cond := range DetectConditionals(cfg) {
+		info.condition[cond.Condition] = cond.GetConditionType()
+		if cond.MergePoint != nil {
+			info.merge[cond.MergePoint] = true
+		}
+	}
+
+	return info
+}
+
+// blockTitle returns the first label line of a block
+func (info regionInfo) blockTitle(block *BasicBlock) string {
+	title := fmt.Sprintf("bb%d  0x%x", block.ID, block.StartAddr)
+	if info.loopHeader[block] {
+		title += "  [loop header]"
+	}
+	if kind, ok := info.condition[block]; ok {
+		title += "  [" + kind + "]"
+	}
+	if info.merge[block] {
+		title += "  [merge]"
+	}
+	return title
+}
+
+// ExportDOT renders the CFG in Graphviz DOT format with the disassembly of
+// each block inside its node. True edges are green, false edges red and
+// back edges bold blue; loop blocks are shaded.
+func (cfg *ControlFlowGraph) ExportDOT() string {
+	var sb strings.Builder
+	info := cfg.regions()
+
+	name := "cfg"
+	if cfg.Function != nil {
+		name = cfg.Function.Name
+	}
+
+	sb.WriteString(fmt.Sprintf("digraph %q {\n", name))
+	sb.WriteString("    node [shape=box, fontname=\"monospace\", fontsize=10];\n")
+	sb.WriteString(fmt.Sprintf("    label=%q;\n    labelloc=t;\n", name))
+
+	for _, block := range cfg.Blocks {
+		var label strings.Builder
+		label.WriteString(dotEscape(info.blockTitle(block)))
+		label.WriteString("\\l")
+		for _, inst := range block.Instructions {
+			label.WriteString(dotEscape(fmt.Sprintf("0x%x: %s %s", inst.Address, inst.Mnemonic, inst.Operands)))
+			label.WriteString("\\l")
+		}
+
+		attrs := []string{fmt.Sprintf("label=\"%s\"", label.String())}
+		if info.inLoop[block] {
+			attrs = append(attrs, "style=filled", "fillcolor=lightyellow")
+		}
+		if info.loopHeader[block] {
+			attrs = append(attrs, "penwidth=2")
+		}
+		if _, ok := info.condition[block]; ok {
+			attrs = append(attrs, "color=blue")
+		}
+		if info.merge[block] {
+			attrs = append(attrs, "peripheries=2")
+		}
+		if block.IsEntry {
+			attrs = append(attrs, "xlabel=\"entry\"")
+		}
+
+		sb.WriteString(fmt.Sprintf("    bb%d [%s];\n", block.ID, strings.Join(attrs, ", ")))
+	}
+
+	for _, block := range cfg.Blocks {
+		for _, succ := range block.Successors {
+			var style string
+			switch ClassifyEdge(block, succ) {
+			case EdgeTrue:
+				style = " [color=green, label=\"T\"]"
+			case EdgeFalse:
+				style = " [color=red, label=\"F\"]"
+			case EdgeBack:
+				style = " [color=blue, penwidth=2, label=\"back\"]"
+			}
+			sb.WriteString(fmt.Sprintf("    bb%d -> bb%d%s;\n", block.ID, succ.ID, style))
+		}
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// ExportMermaid renders the CFG as a Mermaid flowchart
+func (cfg *ControlFlowGraph) ExportMermaid() string {
+	var sb strings.Builder
+	info := cfg.regions()
+
+	sb.WriteString("flowchart TD\n")
+
+	for _, block := range cfg.Blocks {
+		lines := []string{mermaidEscape(info.blockTitle(block))}
+		for _, inst := range block.Instructions {
+			lines = append(lines, mermaidEscape(fmt.Sprintf("0x%x: %s %s", inst.Address, inst.Mnemonic, inst.Operands)))
+		}
+		sb.WriteString(fmt.Sprintf("    bb%d[\"%s\"]\n", block.ID, strings.Join(lines, "<br/>")))
+	}
+
+	for _, block := range cfg.Blocks {
+		for _, succ := range block.Successors {
+			switch ClassifyEdge(block, succ) {
+			case EdgeTrue:
+				sb.WriteString(fmt.Sprintf("    bb%d -->|T| bb%d\n", block.ID, succ.ID))
+			case EdgeFalse:
+				sb.WriteString(fmt.Sprintf("    bb%d -->|F| bb%d\n", block.ID, succ.ID))
+			case EdgeBack:
+				sb.WriteString(fmt.Sprintf("    bb%d ==>|back| bb%d\n", block.ID, succ.ID))
+			default:
+				sb.WriteString(fmt.Sprintf("    bb%d --> bb%d\n", block.ID, succ.ID))
+			}
+		}
+	}
+
+	sb.WriteString("    classDef loop fill:#ffffe0\n")
+	sb.WriteString("    classDef header fill:#ffffe0,stroke-width:3px\n")
+	sb.WriteString("    classDef cond stroke:#0000ff\n")
+	sb.WriteString("    classDef merge stroke-dasharray:4\n")
+
+	for _, block := range cfg.Blocks {
+		switch {
+		case info.loopHeader[block]:
+			sb.WriteString(fmt.Sprintf("    class bb%d header\n", block.ID))
+		case info.inLoop[block]:
+			sb.WriteString(fmt.Sprintf("    class bb%d loop\n", block.ID))
+		}
+		if _, ok := info.condition[block]; ok {
+			sb.WriteString(fmt.Sprintf("    class bb%d cond\n", block.ID))
+		}
+		if info.merge[block] {
+			sb.WriteString(fmt.Sprintf("    class bb%d merge\n", block.ID))
+		}
+	}
+
+	return sb.String()
+}
+
+func dotEscape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "{", "\\{")
+	s = strings.ReplaceAll(s, "}", "\\}")
+	return s
+}
+
+func mermaidEscape(s string) string {
+	s = strings.ReplaceAll(s, "\"", "#quot;")
+	s = strings.ReplaceAll(s, "<", "#lt;")
+	s = strings.ReplaceAll(s, ">", "#gt;")
+	return s
+}
@@ -121,8 +121,10 @@ func buildLoopHierarchy(loops []*Loop) {
 				continue
 			}
 
-			// Check if loop i contains loop j
-			if loopContains(loops[i], loops[j]) {
+			// Check if loop i strictly contains loop j. Loops sharing a
+			// header and block set (several back edges) are not nested,
+			// which would otherwise create a parent cycle.
+			if len(loops[i].Blocks) > len(loops[j].Blocks) && loopContains(loops[i], loops[j]) {
 				// loop i is a potential parent of loop j
 				// Only set as parent if no smaller parent exists
 				if loops[j].Parent == nil || len(loops[i].Blocks) < len(loops[j].Parent.Blocks) {
@@ -0,0 +1,98 @@
+package cfg
+
+import (
+	"fmt"
+
+	"expeer/pkg/disasm"
+)
+
+// Shape is the pointer-free form of a control flow graph, for storing it.
+// Blocks cover the function's instructions in order, so each block is
+// recorded by its instruction count; edges and dominators by block index.
+type Shape struct {
+	Sizes      []int   // Instructions in each block
+	Successors [][]int // Successors of each block, in edge order
+	Dominators []int   // Immediate dominator of each block, -1 if unreachable
+}
+
+// Shape returns the storable form of the graph
+func (cfg *ControlFlowGraph) Shape() Shape {
+	index := make(map[*BasicBlock]int, len(cfg.Blocks))
+	for i, block := range cfg.Blocks {
+		index[block] = i
+	}
+
+	s := Shape{
+		Sizes:      make([]int, len(cfg.Blocks)),
+		Successors: make([][]int, len(cfg.Blocks)),
+		Dominators: make([]int, len(cfg.Blocks)),
+	}
+	for i, block := range cfg.Blocks {
+		s.Sizes[i] = len(block.Instructions)
+		for _, succ := range block.Successors {
+			s.Successors[i] = append(s.Successors[i], index[succ])
+		}
+		s.Dominators[i] = -1
+		if block.DominatedBy != nil {
+			s.Dominators[i] = index[block.DominatedBy]
+		}
+	}
+	return s
+}
+
+// FromShape rebuilds the graph of fn stored with Shape. It fails if the
+// shape does not fit the function's instructions.
+func FromShape(fn *disasm.Function, s Shape) (*ControlFlowGraph, error) {
+	n := len(s.Sizes)
+	if len(s.Successors) != n || len(s.Dominators) != n {
+		return nil, fmt.Errorf("malformed graph of %s", fn.Name)
+	}
+
+	cfg := &ControlFlowGraph{
+		Function: fn,
+		BlockMap: make(map[uint64]*BasicBlock, n),
+		Blocks:   make([]*BasicBlock, n),
+	}
+	next := 0
+	for i, size := range s.Sizes {
+		if size <= 0 || next+size > len(fn.Instructions) {
+			return nil, fmt.Errorf("graph of %s does not match its instructions", fn.Name)
+		}
+		insts := fn.Instructions[next : next+size : next+size]
+		cfg.Blocks[i] = &BasicBlock{
+			ID:           i,
+			StartAddr:    insts[0].Address,
+			EndAddr:      insts[size-1].Address,
+			Instructions: insts,
+		}
+		cfg.BlockMap[insts[0].Address] = cfg.Blocks[i]
+		next += size
+	}
+	if next != len(fn.Instructions) {
+		return nil, fmt.Errorf("graph of %s does not match its instructions", fn.Name)
+	}
+
+	for i, block := range cfg.Blocks {
+		for _, succ := range s.Successors[i] {
+			if succ < 0 || succ >= n {
+				return nil, fmt.Errorf("malformed graph of %s", fn.Name)
+			}
+			block.AddSuccessor(cfg.Blocks[succ])
+		}
+		if dom := s.Dominators[i]; dom >= 0 && dom < n {
+			block.DominatedBy = cfg.Blocks[dom]
+		}
+	}
+
+	if n > 0 {
+		cfg.EntryBlock = cfg.Blocks[0]
+		cfg.EntryBlock.IsEntry = true
+	}
+	for _, block := range cfg.Blocks {
+		if block.EndsWithReturn() || len(block.Successors) == 0 {
+			block.IsExit = true
+			cfg.ExitBlocks = append(cfg.ExitBlocks, block)
+		}
+	}
+	return cfg, nil
+}
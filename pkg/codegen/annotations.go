@@ -0,0 +1,208 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cdecl"
+	"expeer/pkg/disasm"
+	"expeer/pkg/project"
+)
+
+// calleeName returns the name to call a call target by: the user's name
+// for an annotated function, the library name of a function left out of
+// the output, otherwise func_<address>. Imported symbols the decompiler
+// resolved are called by their name. sanitize turns names into
+// identifiers of the output language, library the names of omitted
+// library functions.
+func calleeName(analysis *analyzer.Analysis, operand string, sanitize, library func(string) string) string {
+	if !strings.HasPrefix(operand, "0x") {
+		// Memory operands of indirect calls are kept as they are
+		if strings.HasPrefix(operand, "[") {
+			return operand
+		}
+		return sanitize(operand)
+	}
+	addr, err := strconv.ParseUint(operand[2:], 16, 64)
+	if err == nil && analysis.Project != nil {
+		if name, _ := analysis.Project.FunctionName(addr); name != "" {
+			return sanitize(name)
+		}
+	}
+	if name := analysis.Omitted[addr]; err == nil && name != "" && !strings.HasPrefix(name, "sub_") {
+		return library(name)
+	}
+	return fmt.Sprintf("func_%s", operand[2:])
+}
+
+// generateCStructs emits the structures defined in the project
+func generateCStructs(proj *project.Project) string {
+	var sb strings.Builder
+	sb.WriteString("/* User-defined structures */\n")
+	for _, s := range proj.Structs {
+		sb.WriteString(fmt.Sprintf("typedef struct %s {\n", s.Name))
+		for _, f := range s.Fields {
+			decl := cdecl.Param{Name: f.Name, Type: f.Type}
+			sb.WriteString(fmt.Sprintf("    %s;  // +0x%x\n", decl, f.Offset))
+		}
+		sb.WriteString(fmt.Sprintf("} %s;  // size 0x%x\n\n", s.Name, s.Size))
+	}
+	return sb.String()
+}
+
+// generateGoStructs emits the structures defined in the project as Go
+// types, to be placed inside a type block
+func generateGoStructs(analysis *analyzer.Analysis) string {
+	var sb strings.Builder
+	for _, s := range analysis.Project.Structs {
+		sb.WriteString(fmt.Sprintf("\t// %s is a user-defined structure (size 0x%x)\n", s.Name, s.Size))
+		sb.WriteString(fmt.Sprintf("\t%s struct {\n", s.Name))
+		for _, f := range s.Fields {
+			sb.WriteString(fmt.Sprintf("\t\t%s %s // +0x%x\n", f.Name, goType(analysis, f.Type), f.Offset))
+		}
+		sb.WriteString("\t}\n")
+	}
+	return sb.String()
+}
+
+// goType converts a C type to Go, mapping user-defined structures and the
+// types declared in headers to the Go types generated for them
+func goType(analysis *analyzer.Analysis, cType string) string {
+	cType = cdecl.NormalizeType(cType)
+	if strings.Contains(cType, "(*)") {
+		return "uintptr"
+	}
+	if i := strings.Index(cType, "["); i > 0 && strings.HasSuffix(cType, "]") {
+		return cType[i:] + goType(analysis, cType[:i])
+	}
+	base := strings.TrimRight(cType, "*")
+	if name, ok := goNamedType(analysis, strings.TrimPrefix(strings.TrimSpace(base), "const ")); ok {
+		return strings.Repeat("*", len(cType)-len(base)) + name
+	}
+	return convertToGoType(cType, cLongSize(analysis.Binary))
+}
+
+// goNamedType returns the Go name of a structure, enumeration or typedef
+// the user declared
+func goNamedType(analysis *analyzer.Analysis, cType string) (string, bool) {
+	if proj := analysis.Project; proj != nil {
+		if name := strings.TrimPrefix(cType, "struct "); proj.Struct(name) != nil {
+			return name, true
+		}
+	}
+	h := analysis.Header
+	if h == nil {
+		return "", false
+	}
+	for _, prefix := range []string{"struct ", "union "} {
+		if tag, ok := strings.CutPrefix(cType, prefix); ok && h.Struct(tag) != nil {
+			return h.Struct(tag).Name, true
+		}
+	}
+	if tag, ok := strings.CutPrefix(cType, "enum "); ok {
+		for _, e := range h.Enums {
+			if e.Name == tag && tag != "" {
+				return tag, true
+			}
+		}
+		return "int32", true
+	}
+	if _, ok := h.Typedef(cType); ok {
+		return cType, true
+	}
+	return "", false
+}
+
+// functionPrototype returns the prototype of a function: the one the user
+// declared for its address, the one the debug information or the
+// WebAssembly type section gives for it, or the one a header declares for
+// its name
+func functionPrototype(analysis *analyzer.Analysis, addr uint64, name string) (*cdecl.Prototype, bool) {
+	if analysis.Project != nil {
+		if proto, ok := analysis.Project.Prototype(addr); ok {
+			return proto, true
+		}
+	}
+	if analysis.Debug != nil {
+		if fn := analysis.Debug.Function(addr); fn != nil && fn.Prototype != nil {
+			return fn.Prototype, true
+		}
+	}
+	if analysis.Binary.Wasm != nil {
+		if proto, ok := wasmPrototype(analysis.Binary.Wasm, addr); ok {
+			return proto, true
+		}
+	}
+	if analysis.Header != nil && name != "" {
+		if proto, ok := analysis.Header.Prototype(name); ok {
+			return proto, true
+		}
+		// Mach-O and 32-bit Windows symbols have a leading underscore
+		if proto, ok := analysis.Header.Prototype(strings.TrimPrefix(name, "_")); ok {
+			return proto, true
+		}
+	}
+	return nil, false
+}
+
+// functionNotes supplies the decompiler with what is known about a
+// function: the project's annotations, the debug information and the
+// declarations of the headers
+type functionNotes struct {
+	analysis *analyzer.Analysis
+	fn       disasm.Function
+}
+
+// Prototype implements decompiler.Annotations
+func (n functionNotes) Prototype(addr uint64) (*cdecl.Prototype, bool) {
+	name := ""
+	if addr == n.fn.StartAddr {
+		name = n.fn.Name
+	}
+	return functionPrototype(n.analysis, addr, name)
+}
+
+// VariableName implements decompiler.Annotations
+func (n functionNotes) VariableName(fn uint64, name string) (string, bool) {
+	if n.analysis.Project == nil {
+		return "", false
+	}
+	return n.analysis.Project.VariableName(fn, name)
+}
+
+// VariableType implements decompiler.Annotations
+func (n functionNotes) VariableType(fn uint64, name string) (string, bool) {
+	if n.analysis.Project == nil {
+		return "", false
+	}
+	return n.analysis.Project.VariableType(fn, name)
+}
+
+// Field implements decompiler.Annotations
+func (n functionNotes) Field(ptrType string, offset int) (string, bool) {
+	if n.analysis.Project != nil {
+		if name, ok := n.analysis.Project.Field(ptrType, offset); ok {
+			return name, true
+		}
+	}
+	h := n.analysis.Header
+	if h == nil {
+		return "", false
+	}
+	pointee, ok := strings.CutSuffix(h.Resolve(ptrType), "*")
+	if !ok {
+		return "", false
+	}
+	return h.FieldAt(pointee, offset)
+}
+
+// FrameVariable implements decompiler.Annotations
+func (n functionNotes) FrameVariable(fn uint64, offset int64) (string, string, bool) {
+	if n.analysis.Debug == nil {
+		return "", "", false
+	}
+	v, ok := n.analysis.Debug.FrameVariable(fn, offset)
+	return v.Name, v.Type, ok
+}
@@ -0,0 +1,267 @@
+package codegen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/callgraph"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
+	"expeer/pkg/parser"
+	"expeer/pkg/project"
+)
+
+// asmListing holds the cross-references shared by every function of an
+// annotated listing. It is read-only once built, so functions can be
+// rendered concurrently.
+type asmListing struct {
+	analysis *analyzer.Analysis
+	binary   *parser.Binary
+	project  *project.Project // User annotations, may be nil
+	graph    *callgraph.Graph
+	names    map[uint64]string // Function and symbol names by address
+	strings  map[uint64]string // String literals by address
+	callees  map[uint64]string // Call site -> resolved callee name
+	callers  map[uint64][]callgraph.Edge
+	starts   []uint64 // Sorted function start addresses
+	width    int      // Hex digits of an address
+}
+
+// GenerateAsm generates an IDA-style annotated assembly listing: raw bytes,
+// function headers with caller xrefs, loc_ labels for branch targets,
+// call targets resolved to symbol and import names, and string literals
+// as comments on the instructions that reference them
+func GenerateAsm(analysis *analyzer.Analysis, opts Options) string {
+	var sb strings.Builder
+
+	l := newAsmListing(analysis)
+
+	// Header comment
+	sb.WriteString("; ======================================================================\n")
+	sb.WriteString("; Annotated disassembly - Generated by Expeer\n")
+	sb.WriteString(fmt.Sprintf("; Source binary: %s\n", analysis.Binary.FilePath))
+	sb.WriteString(fmt.Sprintf("; Architecture: %s\n", analysis.Binary.Arch))
+	sb.WriteString(fmt.Sprintf("; Format: %s\n", analysis.Binary.Format))
+	if analysis.Binary.EntryPoint != 0 {
+		sb.WriteString(fmt.Sprintf("; Entry point: 0x%x\n", analysis.Binary.EntryPoint))
+	}
+	sb.WriteString(packingWarning(analysis.Packing, "; "))
+	sb.WriteString("; ======================================================================\n\n")
+
+	for _, text := range parallel.Map(opts.emitted(analysis.Functions), opts.Workers, l.function) {
+		sb.WriteString(text)
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func newAsmListing(analysis *analyzer.Analysis) *asmListing {
+	b := analysis.Binary
+	l := &asmListing{
+		analysis: analysis,
+		binary:   b,
+		project:  analysis.Project,
+		graph:    callgraph.Build(b, analysis.Functions, analysis.Main),
+		names:    make(map[uint64]string),
+		strings:  make(map[uint64]string),
+		callees:  make(map[uint64]string),
+		callers:  make(map[uint64][]callgraph.Edge),
+		width:    8,
+	}
+	if strings.HasSuffix(b.Arch, "64") {
+		l.width = 16
+	}
+
+	for _, sym := range b.Symbols {
+		if sym.Address != 0 && sym.Name != "" {
+			if _, exists := l.names[sym.Address]; !exists {
+				l.names[sym.Address] = sym.Name
+			}
+		}
+	}
+	// Function names take precedence: they include signature and RTTI names
+	for _, fn := range analysis.Functions {
+		l.names[fn.StartAddr] = fn.Name
+		l.starts = append(l.starts, fn.StartAddr)
+	}
+	sort.Slice(l.starts, func(i, j int) bool { return l.starts[i] < l.starts[j] })
+
+	for _, str := range analyzer.FindStrings(b, 4) {
+		l.strings[str.Address] = str.Value
+	}
+
+	for _, e := range l.graph.Edges {
+		l.callees[e.Site] = e.To.Name
+		if !e.To.IsImport {
+			l.callers[e.To.Address] = append(l.callers[e.To.Address], e)
+		}
+	}
+
+	return l
+}
+
+// function renders one function: header, xrefs and basic blocks
+func (l *asmListing) function(fn disasm.Function) string {
+	var sb strings.Builder
+
+	sb.WriteString("; ----------------------------------------------------------------------\n")
+	sb.WriteString(fmt.Sprintf("; Function: %s\n", fn.Name))
+	sb.WriteString(fmt.Sprintf("; Address: 0x%x - 0x%x\n", fn.StartAddr, fn.EndAddr))
+	if fn.IsLibrary {
+		sb.WriteString("; Library function\n")
+	} else if fn.Class != "" && fn.Class != disasm.ClassUser {
+		sb.WriteString(fmt.Sprintf("; Class: %s\n", fn.Class))
+	}
+	for _, e := range l.callers[fn.StartAddr] {
+		sb.WriteString(fmt.Sprintf("; XREF: %s (%s)\n", l.location(e.Site), e.Kind))
+	}
+	sb.WriteString("; ----------------------------------------------------------------------\n")
+	sb.WriteString(fmt.Sprintf("%s:\n", fn.Name))
+
+	// Jump sources of every label inside the function
+	jumps := make(map[uint64][]uint64)
+	for _, inst := range fn.Instructions {
+		if inst.Category == disasm.CatJump && l.inFunction(fn, inst.BranchTarget) {
+			jumps[inst.BranchTarget] = append(jumps[inst.BranchTarget], inst.Address)
+		}
+	}
+
+	graph, err := l.analysis.CFG(&fn)
+	if err != nil {
+		for _, inst := range fn.Instructions {
+			sb.WriteString(l.instruction(fn, inst))
+		}
+		return sb.String()
+	}
+
+	for _, block := range graph.Blocks {
+		if sources, ok := jumps[block.StartAddr]; ok && !block.IsEntry {
+			refs := make([]string, len(sources))
+			for i, src := range sources {
+				refs[i] = l.location(src)
+			}
+			sb.WriteString(fmt.Sprintf("\n%s:%s; XREF: %s\n", locLabel(block.StartAddr),
+				strings.Repeat(" ", 10), strings.Join(refs, ", ")))
+		}
+		for _, inst := range block.Instructions {
+			sb.WriteString(l.instruction(fn, inst))
+		}
+	}
+
+	return sb.String()
+}
+
+// instruction renders one listing line:
+// section:address  bytes  mnemonic operands  ; comment
+func (l *asmListing) instruction(fn disasm.Function, inst disasm.Instruction) string {
+	hex := make([]string, len(inst.Bytes))
+	for i, b := range inst.Bytes {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+
+	operands := inst.Operands
+	var comments []string
+	if l.project != nil {
+		if comment, ok := l.project.Comment(inst.Address); ok {
+			comments = append(comments, strings.ReplaceAll(comment, "\n", " "))
+		}
+	}
+
+	switch {
+	case inst.Category == disasm.CatCall:
+		if name, ok := l.callees[inst.Address]; ok {
+			operands = name
+		} else if name, ok := l.names[inst.BranchTarget]; ok && inst.BranchTarget != 0 {
+			operands = name
+		}
+	case inst.Category == disasm.CatJump && inst.BranchTarget != 0:
+		if l.inFunction(fn, inst.BranchTarget) {
+			operands = locLabel(inst.BranchTarget)
+		} else if name, ok := l.callees[inst.Address]; ok {
+			operands = name
+		} else if name, ok := l.names[inst.BranchTarget]; ok {
+			operands = name
+		}
+	case inst.Category == disasm.CatJump:
+		if name, ok := l.callees[inst.Address]; ok {
+			comments = append(comments, name)
+		}
+	}
+
+	for _, ref := range referencedAddresses(inst) {
+		if str, ok := l.strings[ref]; ok {
+			comments = append(comments, strconv.Quote(str))
+		} else if name, ok := l.names[ref]; ok && inst.Category != disasm.CatCall && inst.Category != disasm.CatJump {
+			comments = append(comments, name)
+		}
+	}
+
+	line := fmt.Sprintf("%s:%0*X  %-24s  %-7s %s", l.sectionName(inst.Address), l.width, inst.Address,
+		strings.Join(hex, " "), inst.Mnemonic, operands)
+	if len(comments) > 0 {
+		line = fmt.Sprintf("%-80s ; %s", strings.TrimRight(line, " "), strings.Join(comments, ", "))
+	}
+	return strings.TrimRight(line, " ") + "\n"
+}
+
+// referencedAddresses returns the absolute addresses an instruction refers
+// to: RIP-relative or absolute memory operands and immediate values
+func referencedAddresses(inst disasm.Instruction) []uint64 {
+	var refs []uint64
+	if inst.HasMemoryAccess && inst.MemoryIndex == "" && (inst.MemoryBase == "rip" || inst.MemoryBase == "") {
+		refs = append(refs, uint64(inst.MemoryDisp))
+	}
+	for _, operand := range strings.Split(inst.Operands, ",") {
+		operand = strings.TrimSpace(operand)
+		if !strings.HasPrefix(operand, "0x") {
+			continue
+		}
+		if v, err := strconv.ParseUint(operand[2:], 16, 64); err == nil && v != 0 {
+			refs = append(refs, v)
+		}
+	}
+	return refs
+}
+
+// location formats an address as function+offset
+func (l *asmListing) location(addr uint64) string {
+	node := l.graph.Node(l.functionStart(addr))
+	if node == nil {
+		return fmt.Sprintf("0x%x", addr)
+	}
+	if addr == node.Address {
+		return node.Name
+	}
+	return fmt.Sprintf("%s+0x%x", node.Name, addr-node.Address)
+}
+
+// functionStart returns the start of the function containing addr
+func (l *asmListing) functionStart(addr uint64) uint64 {
+	i := sort.Search(len(l.starts), func(i int) bool { return l.starts[i] > addr })
+	if i == 0 {
+		return 0
+	}
+	return l.starts[i-1]
+}
+
+func (l *asmListing) inFunction(fn disasm.Function, addr uint64) bool {
+	return addr >= fn.StartAddr && addr <= fn.EndAddr
+}
+
+func (l *asmListing) sectionName(addr uint64) string {
+	for _, sec := range l.binary.Sections {
+		if addr >= sec.Address && addr < sec.Address+sec.Size {
+			return sec.Name
+		}
+	}
+	return "seg"
+}
+
+// locLabel returns the IDA-style label of a branch target
+func locLabel(addr uint64) string {
+	return fmt.Sprintf("loc_%X", addr)
+}
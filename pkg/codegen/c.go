@@ -2,16 +2,28 @@ package codegen
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"expeer/pkg/analyzer"
+	"expeer/pkg/cdecl"
 	"expeer/pkg/decompiler"
 	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
+	"expeer/pkg/parser"
+	"expeer/pkg/rtti"
 )
 
 // GenerateC generates C source code from the analysis
 func GenerateC(analysis *analyzer.Analysis) string {
-	var sb strings.Builder
+	code, _ := GenerateCWithMap(analysis, Options{})
+	return code
+}
+
+// GenerateCWithMap generates C source code and the mapping of its lines to
+// instruction addresses
+func GenerateCWithMap(analysis *analyzer.Analysis, opts Options) (string, *SourceMap) {
+	sb := newCodeWriter(opts)
 
 	// Header comment
 	sb.WriteString("/*\n")
@@ -23,6 +35,7 @@ func GenerateC(analysis *analyzer.Analysis) string {
 	sb.WriteString(" *\n")
 	sb.WriteString(" * WARNING: This is a best-guess reconstruction.\n")
 	sb.WriteString(" * The original source code may have been significantly different.\n")
+	sb.WriteString(packingWarning(analysis.Packing, " * "))
 	sb.WriteString(" */\n\n")
 
 	// Standard includes
@@ -47,6 +60,10 @@ func GenerateC(analysis *analyzer.Analysis) string {
 		sb.WriteString("#endif\n\n")
 	}
 
+	if len(analysis.Binary.ImportTable) > 0 {
+		sb.WriteString(generateCImportLibraries(analysis.Binary.ImportTable))
+	}
+
 	// Type definitions
 	sb.WriteString("/* Type definitions */\n")
 	sb.WriteString("typedef unsigned char u8;\n")
@@ -54,10 +71,38 @@ func GenerateC(analysis *analyzer.Analysis) string {
 	sb.WriteString("typedef unsigned int u32;\n")
 	sb.WriteString("typedef unsigned long long u64;\n\n")
 
-	// Forward declarations
-	if len(analysis.Functions) > 0 {
+	if analysis.Project != nil && len(analysis.Project.Structs) > 0 {
+		sb.WriteString(generateCStructs(analysis.Project))
+	}
+
+	if analysis.Header != nil {
+		sb.WriteString(generateCHeaderTypes(analysis.Header))
+	}
+
+	// C++ classes recovered from RTTI
+	if analysis.RTTI != nil {
+		sb.WriteString(generateCClasses(analysis))
+	}
+
+	// Forward declarations of the generated functions and those they call
+	functions := opts.emitted(analysis.Functions)
+	declared := make(map[uint64]bool)
+	for _, fn := range functions {
+		declared[fn.StartAddr] = true
+		for _, target := range fn.Calls {
+			declared[target] = true
+		}
+	}
+	if len(functions) > 0 {
 		sb.WriteString("/* Forward declarations */\n")
 		for _, fn := range analysis.Functions {
+			if !declared[fn.StartAddr] {
+				continue
+			}
+			if proto, ok := functionPrototype(analysis, fn.StartAddr, fn.Name); ok {
+				sb.WriteString(proto.Declare(sanitizeFunctionName(fn.Name)) + ";\n")
+				continue
+			}
 			sb.WriteString(fmt.Sprintf("void %s();\n", sanitizeFunctionName(fn.Name)))
 		}
 		sb.WriteString("\n")
@@ -80,30 +125,47 @@ func GenerateC(analysis *analyzer.Analysis) string {
 
 	// Generate function implementations
 	sb.WriteString("/* Function implementations */\n\n")
-	for _, fn := range analysis.Functions {
-		sb.WriteString(generateCFunction(fn))
-		sb.WriteString("\n")
+	calls := newCallResolver(analysis, opts.Prototypes)
+	exported := exportedFunctions(analysis.Binary)
+	bodies := parallel.Map(functions, opts.Workers, func(fn disasm.Function) *codeWriter {
+		w := newCodeWriter(opts)
+		generateCFunction(w, analysis, fn, calls, exported[fn.StartAddr])
+		w.WriteString("\n")
+		return w
+	})
+	for _, body := range bodies {
+		sb.append(body)
 	}
 
 	// Main function hint
 	sb.WriteString("/*\n")
 	sb.WriteString(" * Entry point (if this is a standalone executable):\n")
-	sb.WriteString(" * The actual main() function would be located at the binary's entry point.\n")
+	if analysis.Main != 0 {
+		sb.WriteString(fmt.Sprintf(" * The startup code calls main() at 0x%x.\n", analysis.Main))
+	} else {
+		sb.WriteString(" * The actual main() function would be located at the binary's entry point.\n")
+	}
 	if analysis.Binary.EntryPoint != 0 {
 		sb.WriteString(fmt.Sprintf(" * Entry point address: 0x%x\n", analysis.Binary.EntryPoint))
 	}
 	sb.WriteString(" */\n")
 
-	return sb.String()
+	return sb.String(), sb.sourceMap(analysis.Binary.FilePath, "c")
 }
 
-func generateCFunction(fn disasm.Function) string {
-	var sb strings.Builder
+// cLineDirective makes the C compiler attribute the code that follows to a
+// line of the original source
+func cLineDirective(indent, file string, line int) string {
+	return fmt.Sprintf("%s#line %d %s\n", indent, line, strconv.Quote(file))
+}
+
+// generateCFunction emits a function definition, marked dllexport when the
+// DLL exports it
+func generateCFunction(sb *codeWriter, analysis *analyzer.Analysis, fn disasm.Function, calls *callResolver, exported bool) {
+	sb.beginFunction(fn, analysis, cLineDirective)
 
 	// Decompile the function
-	decomp := decompiler.Decompile(fn)
-	decompiler.AnalyzeControlFlow(decomp)
-	decompiler.InferTypes(decomp)
+	decomp := decompileFunction(analysis, fn, calls)
 
 	funcName := sanitizeFunctionName(fn.Name)
 
@@ -114,10 +176,16 @@ func generateCFunction(fn disasm.Function) string {
 
 	// Function signature with inferred return type
 	returnType := "void"
-	if decomp.HasReturn {
+	if decomp.Prototype != nil {
+		returnType = decomp.Prototype.Return
+	} else if decomp.HasReturn {
 		returnType = "int" // Default assumption
 	}
 
+	sb.declaration(fn.StartAddr)
+	if exported {
+		sb.WriteString("__declspec(dllexport) ")
+	}
 	sb.WriteString(fmt.Sprintf("%s %s(", returnType, funcName))
 
 	// Add parameters if we detected any
@@ -127,11 +195,16 @@ func generateCFunction(fn disasm.Function) string {
 			if paramCount > 0 {
 				sb.WriteString(", ")
 			}
-			sb.WriteString(fmt.Sprintf("%s %s", v.Type, v.Name))
+			sb.WriteString(cdecl.Param{Name: v.Name, Type: v.Type}.String())
 			paramCount++
 		}
 	}
-	if paramCount == 0 {
+	if decomp.Prototype != nil && decomp.Prototype.Variadic {
+		if paramCount > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("...")
+	} else if paramCount == 0 {
 		sb.WriteString("void")
 	}
 	sb.WriteString(") {\n")
@@ -141,7 +214,7 @@ func generateCFunction(fn disasm.Function) string {
 		sb.WriteString("    /* Local variables */\n")
 		for _, v := range decomp.Variables {
 			if v.IsLocal && !v.IsParam {
-				sb.WriteString(fmt.Sprintf("    %s %s;\n", v.Type, v.Name))
+				sb.WriteString(fmt.Sprintf("    %s;\n", cdecl.Param{Name: v.Name, Type: v.Type}))
 			}
 		}
 		sb.WriteString("\n")
@@ -150,53 +223,51 @@ func generateCFunction(fn disasm.Function) string {
 	// Generate function body from operations
 	if len(decomp.Operations) > 0 {
 		sb.WriteString("    /* Decompiled code */\n")
-		inLoop := false
+		var loops loopNest
 
 		for i, op := range decomp.Operations {
-			indent := "    "
-			if inLoop {
-				indent = "        "
-			}
-
 			// Check for loop start
 			if strings.Contains(op.Comment, "LOOP_START") {
-				sb.WriteString(fmt.Sprintf("    while (1) {  // Loop at 0x%x\n", op.Address))
-				inLoop = true
+				sb.WriteString(fmt.Sprintf("%swhile (1) {  // Loop at 0x%x\n", cIndent(loops.depth()), op.Address))
+				loops.open(op.Address)
 			}
+			indent := cIndent(loops.depth())
 
 			switch op.Type {
 			case decompiler.OpAssign:
 				if op.Dest != "" && op.Src1 != "" {
 					if strings.Contains(op.Src1, "[") {
 						// Memory access
-						sb.WriteString(fmt.Sprintf("%s%s = *(%s);  // %s\n", indent, op.Dest, op.Src1, op.Comment))
+						sb.statement(op.Addresses, fmt.Sprintf("%s%s = *(%s);  // %s\n", indent, op.Dest, op.Src1, op.Comment))
 					} else {
-						sb.WriteString(fmt.Sprintf("%s%s = %s;\n", indent, op.Dest, op.Src1))
+						sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s;\n", indent, op.Dest, op.Src1))
 					}
 				} else if op.Comment != "" {
-					sb.WriteString(fmt.Sprintf("%s// %s\n", indent, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s// %s\n", indent, op.Comment))
 				}
 
 			case decompiler.OpCall:
-				funcCall := op.Src1
-				if strings.HasPrefix(funcCall, "0x") {
-					funcCall = fmt.Sprintf("func_%s", funcCall[2:])
+				switch op.Operator {
+				case "virtual":
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s->%s();  // %s\n", indent, op.Src1, op.Src2, op.Comment))
+					continue
+				case "delete":
+					sb.statement(op.Addresses, fmt.Sprintf("%sdelete %s;  // %s\n", indent, op.Src1, op.Comment))
+					continue
 				}
+				funcCall := calleeName(analysis, op.Src1, sanitizeFunctionName, sanitizeFunctionName)
+				args := strings.Join(op.Args, ", ")
 				if op.Dest != "" && op.Dest != "result" {
-					sb.WriteString(fmt.Sprintf("%s%s = %s();\n", indent, op.Dest, funcCall))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s(%s);\n", indent, op.Dest, funcCall, args))
 				} else {
-					sb.WriteString(fmt.Sprintf("%s%s();  // %s\n", indent, funcCall, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s(%s);  // %s\n", indent, funcCall, args, op.Comment))
 				}
 
 			case decompiler.OpReturn:
-				if inLoop {
-					sb.WriteString("    }\n")
-					inLoop = false
-				}
 				if op.Src1 != "" {
-					sb.WriteString(fmt.Sprintf("%sreturn %s;\n", indent, op.Src1))
+					sb.statement(op.Addresses, fmt.Sprintf("%sreturn %s;\n", indent, op.Src1))
 				} else {
-					sb.WriteString(fmt.Sprintf("%sreturn;\n", indent))
+					sb.statement(op.Addresses, fmt.Sprintf("%sreturn;\n", indent))
 				}
 
 			case decompiler.OpArithmetic:
@@ -211,12 +282,12 @@ func generateCFunction(fn disasm.Function) string {
 					} else if cOp == "div" || cOp == "idiv" {
 						cOp = "/"
 					}
-					sb.WriteString(fmt.Sprintf("%s%s = %s %s %s;\n", indent, op.Dest, op.Src1, cOp, op.Src2))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s %s %s;\n", indent, op.Dest, op.Src1, cOp, op.Src2))
 				}
 
 			case decompiler.OpCompare:
 				// Store comparison for potential if statement
-				sb.WriteString(fmt.Sprintf("%s// compare %s with %s\n", indent, op.Src1, op.Src2))
+				sb.statement(op.Addresses, fmt.Sprintf("%s// compare %s with %s\n", indent, op.Src1, op.Src2))
 
 			case decompiler.OpIf:
 				condition := "condition"
@@ -234,36 +305,190 @@ func generateCFunction(fn disasm.Function) string {
 				if i+1 < len(decomp.Operations) && strings.Contains(op.Src1, "0x") {
 					var target uint64
 					fmt.Sscanf(op.Src1, "0x%x", &target)
-					if target < op.Address && inLoop {
-						// Loop condition
-						sb.WriteString(fmt.Sprintf("%sif (!(%s)) break;\n", indent, condition))
+					if target < op.Address && loops.depth() > 0 {
+						// Loop condition, closing the loop it jumps back to
+						sb.statement(op.Addresses, fmt.Sprintf("%sif (!(%s)) break;\n", indent, condition))
+						for n := loops.closeAt(target); n > 0; n-- {
+							sb.WriteString(cIndent(loops.depth()+n-1) + "}\n")
+						}
 						continue
 					}
 				}
 
-				sb.WriteString(fmt.Sprintf("%sif (%s) {  // %s to %s\n", indent, condition, op.Operator, op.Src1))
-				sb.WriteString(fmt.Sprintf("%s    // Jump target: %s\n", indent, op.Src1))
-				sb.WriteString(fmt.Sprintf("%s}\n", indent))
+				sb.statement(op.Addresses, fmt.Sprintf("%sif (%s) {  // %s to %s\n%s    // Jump target: %s\n%s}\n",
+					indent, condition, op.Operator, op.Src1, indent, op.Src1, indent))
 
 			default:
 				if op.Comment != "" {
-					sb.WriteString(fmt.Sprintf("%s// %s\n", indent, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s// %s\n", indent, op.Comment))
 				}
 			}
 		}
 
-		if inLoop {
-			sb.WriteString("    }\n")
+		for n := loops.depth(); n > 0; n-- {
+			sb.WriteString(cIndent(n-1) + "}\n")
 		}
 	} else {
 		sb.WriteString("    // Empty function or no recognizable operations\n")
 	}
 
 	sb.WriteString("}\n")
+}
+
+// cIndent returns the indentation of statements nested in depth loops
+func cIndent(depth int) string {
+	return strings.Repeat("    ", depth+1)
+}
+
+// generateCClasses emits C++ class declarations for the recovered
+// hierarchy, guarded so the output still compiles as C. The destructor
+// variants a vtable holds are declared once. Methods take the prototype
+// the annotations or debug information give them, else the parameters
+// their symbol demangles to; return types neither records are declared
+// as the incomplete type unknown_t.
+func generateCClasses(analysis *analyzer.Analysis) string {
+	var sb strings.Builder
+
+	symbols := make(map[uint64]string)
+	for _, sym := range analysis.Binary.Symbols {
+		if _, exists := symbols[sym.Address]; !exists && sym.Address != 0 && sym.Name != "" {
+			symbols[sym.Address] = sym.Name
+		}
+	}
+
+	sb.WriteString("/* C++ classes recovered from RTTI */\n")
+	sb.WriteString("#ifdef __cplusplus\n")
+	sb.WriteString("struct unknown_t;  /* Return type not recovered */\n\n")
+	for _, class := range analysis.RTTI.Classes {
+		namespaces, shortName := splitQualifiedName(class.Name)
+		for _, ns := range namespaces {
+			sb.WriteString(fmt.Sprintf("namespace %s {\n", ns))
+		}
+		sb.WriteString(fmt.Sprintf("class %s", shortName))
+		for i, base := range class.Bases {
+			if i == 0 {
+				sb.WriteString(" : ")
+			} else {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("public " + base)
+		}
+		sb.WriteString(" {\n")
+		sb.WriteString("public:\n")
+
+		// Slots holding what the primary base's hold are inherited. The
+		// destructor is declared once for the complete and the deleting
+		// destructor Itanium vtables hold.
+		var base *rtti.Class
+		if len(class.Bases) > 0 {
+			base = analysis.RTTI.Find(class.Bases[0])
+		}
+		declared := false
+		for _, m := range class.Methods {
+			if base != nil && m.Slot < len(base.Methods) && m.Address != 0 && base.Methods[m.Slot].Address == m.Address {
+				continue
+			}
+			if !strings.HasPrefix(m.Name, "~") {
+				sb.WriteString("    " + methodDeclaration(analysis, symbols, m) + "\n")
+			} else if !declared {
+				sb.WriteString(fmt.Sprintf("    virtual ~%s();  // %s\n", shortName, destructorSlots(class)))
+				declared = true
+			}
+		}
+		if class.VTable != 0 {
+			sb.WriteString(fmt.Sprintf("};  // vtable: 0x%x\n", class.VTable))
+		} else {
+			sb.WriteString("};\n")
+		}
+		if len(namespaces) > 0 {
+			sb.WriteString(strings.Repeat("}", len(namespaces)))
+			sb.WriteString(fmt.Sprintf("  // namespace %s\n", strings.Join(namespaces, "::")))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("#endif\n\n")
 
 	return sb.String()
 }
 
+// splitQualifiedName splits a C++ class name into its enclosing
+// namespaces and its own name. Scopes inside template arguments are not
+// split.
+func splitQualifiedName(name string) ([]string, string) {
+	scope := name
+	if i := strings.Index(scope, "<"); i >= 0 {
+		scope = scope[:i]
+	}
+	i := strings.LastIndex(scope, "::")
+	if i < 0 {
+		return nil, name
+	}
+	return strings.Split(name[:i], "::"), name[i+2:]
+}
+
+// destructorSlots describes the vtable slots holding the destructors of a
+// class
+func destructorSlots(class *rtti.Class) string {
+	var slots []string
+	for _, m := range class.Methods {
+		if !strings.HasPrefix(m.Name, "~") {
+			continue
+		}
+		slot := fmt.Sprintf("slot %d", m.Slot)
+		if m.Address != 0 {
+			slot += fmt.Sprintf(": 0x%x", m.Address)
+		}
+		if class.DeletingDestructor(m) {
+			slot += ", deleting"
+		}
+		slots = append(slots, slot)
+	}
+	return strings.Join(slots, "; ")
+}
+
+// methodDeclaration declares a virtual method in its class, with the
+// slot it occupies and what of its signature is not known
+func methodDeclaration(analysis *analyzer.Analysis, symbols map[uint64]string, m rtti.Method) string {
+	ret := "unknown_t"
+	var params []string
+	known := false
+	if m.Pure {
+		// The slot points to the runtime's handler, not to the method
+	} else if proto, ok := functionPrototype(analysis, m.Address, ""); ok {
+		ret = proto.Return
+		protoParams := proto.Params
+		if len(protoParams) > 0 && protoParams[0].Name == "this" {
+			protoParams = protoParams[1:]
+		}
+		for _, p := range protoParams {
+			params = append(params, p.String())
+		}
+		if proto.Variadic {
+			params = append(params, "...")
+		}
+		known = true
+	} else if demangled, ok := rtti.DemangleParams(symbols[m.Address]); ok {
+		params = demangled
+		known = true
+	}
+
+	decl := fmt.Sprintf("virtual %s %s(%s)", ret, m.Name, strings.Join(params, ", "))
+	comment := fmt.Sprintf("slot %d: 0x%x", m.Slot, m.Address)
+	if m.Pure {
+		decl += " = 0"
+	}
+	if m.Pure || m.Address == 0 {
+		comment = fmt.Sprintf("slot %d", m.Slot)
+	}
+	switch {
+	case !known:
+		comment += ", signature unknown"
+	case ret == "unknown_t":
+		comment += ", return type unknown"
+	}
+	return decl + ";  // " + comment
+}
+
 func sanitizeFunctionName(name string) string {
 	// Remove invalid C identifier characters
 	name = strings.ReplaceAll(name, ".", "_")
@@ -282,3 +507,51 @@ func sanitizeFunctionName(name string) string {
 
 	return name
 }
+
+// generateCImportLibraries emits a #pragma comment(lib) for each DLL the
+// image imports from, listing the functions it uses. Delay-loaded DLLs
+// also get the linker option that delays them.
+func generateCImportLibraries(imports []parser.Import) string {
+	var sb strings.Builder
+	sb.WriteString("/* Imported libraries */\n")
+	for _, group := range parser.ImportsByDLL(imports) {
+		dll := group[0].DLL
+		line := "/* " + dll + ":"
+		for i, imp := range group {
+			name := " " + imp.Symbol()
+			if i < len(group)-1 {
+				name += ","
+			}
+			if len(line)+len(name) > 76 {
+				sb.WriteString(line + "\n")
+				line = "  "
+			}
+			line += name
+		}
+		sb.WriteString(line + " */\n")
+
+		lib := strings.TrimSuffix(strings.ToLower(dll), ".dll") + ".lib"
+		sb.WriteString(fmt.Sprintf("#pragma comment(lib, %s)\n", strconv.Quote(lib)))
+		if group[0].Delayed {
+			sb.WriteString(fmt.Sprintf("#pragma comment(linker, %s)\n", strconv.Quote("/DELAYLOAD:"+dll)))
+		}
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// exportedFunctions returns the addresses of the functions a DLL exports.
+// Other formats, and COFF objects, export every global symbol and need no
+// marking.
+func exportedFunctions(b *parser.Binary) map[uint64]bool {
+	exported := make(map[uint64]bool)
+	if b.Format != "PE" || b.Kind == parser.KindObject {
+		return exported
+	}
+	for _, exp := range b.ExportTable {
+		if exp.Forwarder == "" {
+			exported[exp.Address] = true
+		}
+	}
+	return exported
+}
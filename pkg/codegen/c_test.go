@@ -0,0 +1,154 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cdecl"
+	_ "expeer/pkg/disasm" // x86 backend
+	"expeer/pkg/parser"
+)
+
+// analyzeTestdata parses and analyzes a file in testdata
+func analyzeTestdata(t *testing.T, name string) *analyzer.Analysis {
+	t.Helper()
+	return analyzeWorkers(t, name, 1)
+}
+
+// analyzeWorkers analyzes a file in testdata on several workers
+func analyzeWorkers(t *testing.T, name string, workers int) *analyzer.Analysis {
+	t.Helper()
+	binary, err := parser.ParseExecutable("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { binary.Close() })
+	analysis, err := analyzer.Analyze(binary, analyzer.Options{Workers: workers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return analysis
+}
+
+// The code generated must not depend on the number of workers analyzing
+// the binary or generating the functions
+func TestGenerateWorkers(t *testing.T) {
+	generators := map[string]func(*analyzer.Analysis, Options) string{
+		"c": func(a *analyzer.Analysis, opts Options) string {
+			code, m := GenerateCWithMap(a, opts)
+			js, _ := m.JSON()
+			return code + js
+		},
+		"go": func(a *analyzer.Analysis, opts Options) string {
+			code, m := GenerateGoWithMap(a, opts)
+			js, _ := m.JSON()
+			return code + js
+		},
+		"asm": GenerateAsm,
+	}
+	for _, name := range []string{"loops", "virtual"} {
+		serial := analyzeTestdata(t, name)
+		concurrent := analyzeWorkers(t, name, 8)
+		for lang, generate := range generators {
+			want := generate(serial, Options{Workers: 1, Interleave: true})
+			for _, workers := range []int{2, 8} {
+				got := generate(concurrent, Options{Workers: workers, Interleave: true})
+				if got != want {
+					t.Errorf("%s, %s on %d workers: output differs from one worker at byte %d",
+						name, lang, workers, firstDifference(got, want))
+				}
+			}
+		}
+	}
+}
+
+func firstDifference(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
+
+// Nested loops, and loops whose functions return from their body, must
+// still close every block they open
+func TestGenerateBalancedBlocks(t *testing.T) {
+	analysis := analyzeTestdata(t, "loops")
+	for lang, code := range map[string]string{"c": GenerateC(analysis), "go": GenerateGo(analysis)} {
+		open, closed := strings.Count(code, "{"), strings.Count(code, "}")
+		if open != closed {
+			t.Errorf("%s output has %d '{' and %d '}'", lang, open, closed)
+		}
+	}
+}
+
+// Virtual calls name the object this only in methods, and call methods
+// their classes declare, destructor slots included
+func TestGenerateVirtualCalls(t *testing.T) {
+	analysis := analyzeTestdata(t, "virtual")
+	code := GenerateC(analysis)
+
+	classes, body, _ := strings.Cut(code, "#endif")
+	start := strings.Index(body, "/* Function: _Z3useP5Shape\n")
+	if start < 0 {
+		t.Fatal("use is not decompiled")
+	}
+	use, _, _ := strings.Cut(body[start:], "\n}\n")
+	if strings.Contains(use, "this->") {
+		t.Errorf("use, which is no method, calls methods on this:\n%s", use)
+	}
+	if !strings.Contains(use, "delete ") || strings.Contains(use, "vfunc") {
+		t.Errorf("use does not delete the object through its deleting destructor:\n%s", use)
+	}
+
+	// Classes declare the slots they override, and Shape, whose vtable
+	// the binary lacks, those its derived classes tell
+	if strings.Contains(classes, "vtable: 0x0\n") {
+		t.Errorf("unknown vtable address printed:\n%s", classes)
+	}
+	if _, circle, _ := strings.Cut(classes, "class Circle "); strings.Contains(strings.SplitN(circle, "};", 2)[0], "grow(") {
+		t.Errorf("Circle redeclares Shape::grow:\n%s", classes)
+	}
+	if _, shape, _ := strings.Cut(classes, "class Shape "); !strings.Contains(strings.SplitN(shape, "};", 2)[0], "virtual ~Shape();") {
+		t.Errorf("Shape declares no destructor:\n%s", classes)
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		_, call, ok := strings.Cut(line, "->")
+		if !ok || !strings.Contains(line, "// vtable slot") {
+			continue
+		}
+		method, _, _ := strings.Cut(call, "(")
+		if !strings.Contains(classes, " "+method+"(") {
+			t.Errorf("%s is not declared in any class: %s", method, strings.TrimSpace(line))
+		}
+	}
+}
+
+// Structures the header only names by tag stay tagged, so that no name the
+// header leaves free is taken
+func TestGenerateCHeaderTypedefs(t *testing.T) {
+	h, err := cdecl.ParseHeader(`
+struct pt { int x, y; };
+union u { int i; float f; };
+typedef struct rect { struct pt a, b; } rect;
+typedef struct { union u v; } anon_t;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Layout(8)
+	code := generateCHeaderTypes(h)
+	for _, want := range []string{"struct pt;\n", "union u;\n", "typedef struct rect rect;\n", "typedef struct anon_t anon_t;\n"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in:\n%s", want, code)
+		}
+	}
+	for _, unwanted := range []string{"typedef struct pt pt;", "typedef union u u;"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("%q is not in the header:\n%s", unwanted, code)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package codegen
+
+import (
+	"bytes"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/callgraph"
+	"expeer/pkg/cdecl"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parser"
+	"expeer/pkg/prototypes"
+)
+
+// maxStringLiteral bounds the NUL-terminated strings shown as arguments
+const maxStringLiteral = 256
+
+// callee is the resolved target of a call site
+type callee struct {
+	name  string // Imported symbol, empty for local functions
+	proto *cdecl.Prototype
+}
+
+// callResolver tells the decompiler what each call site calls: imports
+// are found through the call graph (PLT stubs, IAT slots), local functions
+// by address. Prototypes come from the user's annotations first, then
+// from the loaded headers and the prototype database by symbol name.
+// WebAssembly modules declare the signature of every function, imports
+// included, so the database does not apply to them. It is read-only once
+// built and shared by concurrent workers.
+type callResolver struct {
+	analysis *analyzer.Analysis
+	sites    map[uint64]callee
+}
+
+// newCallResolver resolves every call site of the analysis. db may be nil
+// for the bundled prototypes.
+func newCallResolver(analysis *analyzer.Analysis, db *prototypes.Database) *callResolver {
+	if db == nil {
+		db = prototypes.Default()
+	}
+	r := &callResolver{analysis: analysis, sites: make(map[uint64]callee)}
+
+	// Header declarations take precedence over the database
+	headers := prototypes.NewDatabase()
+	if analysis.Header != nil {
+		for _, p := range analysis.Header.Prototypes {
+			headers.Add(p)
+		}
+	}
+	lookup := func(name string) (*cdecl.Prototype, bool) {
+		if p, ok := headers.Lookup(name); ok {
+			return p, true
+		}
+		if analysis.Binary.Wasm != nil {
+			return nil, false
+		}
+		return db.Lookup(name)
+	}
+
+	for _, e := range callgraph.Build(analysis.Binary, analysis.Functions, analysis.Main).Edges {
+		if e.Kind == callgraph.EdgeImport && e.To.IsImport {
+			var proto *cdecl.Prototype
+			if analysis.Binary.Wasm != nil {
+				proto, _ = wasmPrototype(analysis.Binary.Wasm, e.To.Address)
+			} else {
+				proto, _ = lookup(e.To.Name)
+			}
+			r.sites[e.Site] = callee{name: e.To.Name, proto: proto}
+		}
+	}
+
+	// Callees may have been filtered out of the functions, so symbols
+	// name them too
+	names := make(map[uint64]string)
+	for _, sym := range analysis.Binary.Symbols {
+		if sym.Address != 0 && sym.Name != "" {
+			names[sym.Address] = sym.Name
+		}
+	}
+	for _, fn := range analysis.Functions {
+		names[fn.StartAddr] = fn.Name
+	}
+
+	for _, fn := range analysis.Functions {
+		for _, inst := range fn.Instructions {
+			if inst.Category != disasm.CatCall || inst.BranchTarget == 0 {
+				continue
+			}
+			if _, isImport := r.sites[inst.Address]; isImport {
+				continue
+			}
+			name := names[inst.BranchTarget]
+			proto, ok := functionPrototype(analysis, inst.BranchTarget, name)
+			if !ok && name != "" {
+				proto, ok = lookup(name)
+			}
+			if ok {
+				r.sites[inst.Address] = callee{proto: proto}
+			}
+		}
+	}
+
+	return r
+}
+
+// wasmCTypes are the C types of WebAssembly value types
+var wasmCTypes = map[string]string{
+	"i32":       "int32_t",
+	"i64":       "int64_t",
+	"f32":       "float",
+	"f64":       "double",
+	"v128":      "v128_t",
+	"funcref":   "void*",
+	"externref": "void*",
+}
+
+// wasmPrototype returns the signature the type section gives the
+// WebAssembly function at addr, imported or defined. Functions with
+// several results return the first.
+func wasmPrototype(module *parser.WasmModule, addr uint64) (*cdecl.Prototype, bool) {
+	fn := module.Function(addr)
+	if fn == nil || int(fn.Type) >= len(module.Types) {
+		return nil, false
+	}
+	sig := module.Types[fn.Type]
+	proto := &cdecl.Prototype{Name: fn.Name, Return: "void"}
+	if len(sig.Results) > 0 {
+		proto.Return = wasmCTypes[sig.Results[0]]
+	}
+	for _, t := range sig.Params {
+		proto.Params = append(proto.Params, cdecl.Param{Type: wasmCTypes[t]})
+	}
+	return proto, true
+}
+
+// Callee implements decompiler.CallResolver
+func (r *callResolver) Callee(site uint64) (string, *cdecl.Prototype, bool) {
+	c, ok := r.sites[site]
+	return c.name, c.proto, ok
+}
+
+// String implements decompiler.CallResolver
+func (r *callResolver) String(addr uint64, size int) (string, bool) {
+	var data []byte
+	if size < 0 {
+		data = r.analysis.Binary.BytesAt(addr, maxStringLiteral)
+		end := bytes.IndexByte(data, 0)
+		if end <= 0 {
+			return "", false
+		}
+		data = data[:end]
+	} else {
+		data = r.analysis.Binary.BytesAt(addr, size)
+		if len(data) != size {
+			return "", false
+		}
+	}
+
+	for _, c := range data {
+		if (c < 0x20 || c >= 0x7f) && c != '\n' && c != '\t' && c != '\r' {
+			return "", false
+		}
+	}
+	return string(data), true
+}
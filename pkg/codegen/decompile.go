@@ -0,0 +1,82 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/decompiler"
+	"expeer/pkg/disasm"
+)
+
+// decompileFunction runs the decompiler passes shared by all backends
+func decompileFunction(analysis *analyzer.Analysis, fn disasm.Function, calls *callResolver) *decompiler.DecompiledFunction {
+	var notes decompiler.Annotations
+	if analysis.Project != nil || analysis.Header != nil || analysis.Debug != nil || analysis.Binary.Wasm != nil {
+		notes = functionNotes{analysis: analysis, fn: fn}
+	}
+
+	abi := decompiler.ABIFor(analysis.Binary.Format, analysis.Binary.Arch)
+	decomp := decompiler.Decompile(fn, abi, notes, calls)
+	decompiler.AnalyzeControlFlow(decomp)
+	decompiler.InferTypes(decomp, notes)
+
+	var resolver decompiler.VirtualMethodResolver
+	if analysis.RTTI != nil {
+		resolver = analysis.RTTI
+	}
+	decompiler.ResolveVirtualCalls(decomp, abi, resolver)
+
+	return decomp
+}
+
+// packingWarning returns the lines of the generated header, each starting
+// with prefix, that warn about a packed or obfuscated binary; none if it
+// looks unpacked
+func packingWarning(packing *analyzer.Packing, prefix string) string {
+	if !packing.Suspicious() {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(prefix, " ") + "\n")
+	switch {
+	case packing.Unpacked:
+		sb.WriteString(fmt.Sprintf("%sNOTE: Unpacked from %s before analysis.\n", prefix, packing.Packer))
+	case packing.Packer != "":
+		sb.WriteString(fmt.Sprintf("%sWARNING: Packed with %s. The code below is mostly its unpacking stub.\n", prefix, packing.Packer))
+	default:
+		sb.WriteString(fmt.Sprintf("%sWARNING: The binary may be packed or obfuscated.\n", prefix))
+	}
+	for _, anomaly := range packing.Anomalies {
+		sb.WriteString(fmt.Sprintf("%s- %s\n", prefix, anomaly))
+	}
+	return sb.String()
+}
+
+// loopNest tracks the loops open while a backend emits a function body,
+// innermost last, so that each loop it opens is closed exactly once: by
+// the backward branch to its start or at the end of the function
+type loopNest []uint64
+
+// open records a loop starting at addr
+func (l *loopNest) open(addr uint64) {
+	*l = append(*l, addr)
+}
+
+// depth returns the number of open loops
+func (l loopNest) depth() int {
+	return len(l)
+}
+
+// closeAt closes the loop starting at target and the loops inside it,
+// returning how many it closed; none if no open loop starts there
+func (l *loopNest) closeAt(target uint64) int {
+	for i := len(*l) - 1; i >= 0; i-- {
+		if (*l)[i] == target {
+			n := len(*l) - i
+			*l = (*l)[:i]
+			return n
+		}
+	}
+	return 0
+}
@@ -7,11 +7,20 @@ import (
 	"expeer/pkg/analyzer"
 	"expeer/pkg/decompiler"
 	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
+	"expeer/pkg/parser"
 )
 
 // GenerateGo generates Go source code from the analysis
 func GenerateGo(analysis *analyzer.Analysis) string {
-	var sb strings.Builder
+	code, _ := GenerateGoWithMap(analysis, Options{})
+	return code
+}
+
+// GenerateGoWithMap generates Go source code and the mapping of its lines to
+// instruction addresses
+func GenerateGoWithMap(analysis *analyzer.Analysis, opts Options) (string, *SourceMap) {
+	sb := newCodeWriter(opts)
 
 	// Package and header comment
 	sb.WriteString("package main\n\n")
@@ -24,6 +33,7 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 	sb.WriteString(" *\n")
 	sb.WriteString(" * WARNING: This is a best-guess reconstruction.\n")
 	sb.WriteString(" * The original source code may have been significantly different.\n")
+	sb.WriteString(packingWarning(analysis.Packing, " * "))
 	if len(analysis.GoIndicators) > 0 {
 		sb.WriteString(" *\n * Go indicators found:\n")
 		for i, indicator := range analysis.GoIndicators {
@@ -84,6 +94,10 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 		sb.WriteString(")\n\n")
 	}
 
+	if analysis.Header != nil && len(analysis.Header.Enums) > 0 {
+		sb.WriteString(generateGoHeaderConstants(analysis.Header))
+	}
+
 	// Type definitions
 	sb.WriteString("// Type definitions\n")
 	sb.WriteString("type (\n")
@@ -91,6 +105,12 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 	sb.WriteString("\tDataStruct struct {\n")
 	sb.WriteString("\t\t// Fields unknown\n")
 	sb.WriteString("\t}\n")
+	if analysis.Project != nil {
+		sb.WriteString(generateGoStructs(analysis))
+	}
+	if analysis.Header != nil {
+		sb.WriteString(generateGoHeaderTypes(analysis))
+	}
 	sb.WriteString(")\n\n")
 
 	// Generate function implementations
@@ -100,8 +120,9 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 	var mainFunc *disasm.Function
 	var otherFuncs []disasm.Function
 
-	for i := range analysis.Functions {
-		fn := &analysis.Functions[i]
+	functions := opts.emitted(analysis.Functions)
+	for i := range functions {
+		fn := &functions[i]
 		if strings.Contains(strings.ToLower(fn.Name), "main.main") {
 			mainFunc = fn
 		} else {
@@ -110,14 +131,20 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 	}
 
 	// Generate other functions first
-	for _, fn := range otherFuncs {
-		sb.WriteString(generateGoFunction(fn))
-		sb.WriteString("\n")
+	calls := newCallResolver(analysis, opts.Prototypes)
+	bodies := parallel.Map(otherFuncs, opts.Workers, func(fn disasm.Function) *codeWriter {
+		w := newCodeWriter(opts)
+		generateGoFunction(w, analysis, fn, calls)
+		w.WriteString("\n")
+		return w
+	})
+	for _, body := range bodies {
+		sb.append(body)
 	}
 
 	// Generate main function last
 	if mainFunc != nil {
-		sb.WriteString(generateGoFunction(*mainFunc))
+		generateGoFunction(sb, analysis, *mainFunc, calls)
 	} else {
 		// Create a placeholder main
 		sb.WriteString("func main() {\n")
@@ -130,16 +157,21 @@ func GenerateGo(analysis *analyzer.Analysis) string {
 		sb.WriteString("}\n")
 	}
 
-	return sb.String()
+	return sb.String(), sb.sourceMap(analysis.Binary.FilePath, "go")
+}
+
+// goLineDirective makes the Go compiler attribute the code that follows to
+// a line of the original source. Line directives only take effect at the
+// start of a line, so the indentation is dropped.
+func goLineDirective(indent, file string, line int) string {
+	return fmt.Sprintf("//line %s:%d\n", file, line)
 }
 
-func generateGoFunction(fn disasm.Function) string {
-	var sb strings.Builder
+func generateGoFunction(sb *codeWriter, analysis *analyzer.Analysis, fn disasm.Function, calls *callResolver) {
+	sb.beginFunction(fn, analysis, goLineDirective)
 
 	// Decompile the function
-	decomp := decompiler.Decompile(fn)
-	decompiler.AnalyzeControlFlow(decomp)
-	decompiler.InferTypes(decomp)
+	decomp := decompileFunction(analysis, fn, calls)
 
 	funcName := sanitizeGoFunctionName(fn.Name)
 
@@ -149,6 +181,7 @@ func generateGoFunction(fn disasm.Function) string {
 	sb.WriteString(fmt.Sprintf("// Instructions: %d\n", len(fn.Instructions)))
 
 	// Function signature with parameters and return type
+	sb.declaration(fn.StartAddr)
 	sb.WriteString(fmt.Sprintf("func %s(", funcName))
 
 	// Add parameters if we detected any
@@ -158,16 +191,25 @@ func generateGoFunction(fn disasm.Function) string {
 			if paramCount > 0 {
 				sb.WriteString(", ")
 			}
-			goType := convertToGoType(v.Type)
-			sb.WriteString(fmt.Sprintf("%s %s", v.Name, goType))
+			sb.WriteString(fmt.Sprintf("%s %s", v.Name, goType(analysis, v.Type)))
 			paramCount++
 		}
 	}
+	if decomp.Prototype != nil && decomp.Prototype.Variadic {
+		if paramCount > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("args ...interface{}")
+	}
 
 	sb.WriteString(")")
 
 	// Add return type if function returns
-	if decomp.HasReturn {
+	if decomp.Prototype != nil {
+		if decomp.Prototype.Return != "void" {
+			sb.WriteString(" " + goType(analysis, decomp.Prototype.Return))
+		}
+	} else if decomp.HasReturn {
 		sb.WriteString(" int") // Default assumption
 	}
 
@@ -178,8 +220,7 @@ func generateGoFunction(fn disasm.Function) string {
 		sb.WriteString("\t// Local variables\n")
 		for _, v := range decomp.Variables {
 			if v.IsLocal && !v.IsParam {
-				goType := convertToGoType(v.Type)
-				sb.WriteString(fmt.Sprintf("\tvar %s %s\n", v.Name, goType))
+				sb.WriteString(fmt.Sprintf("\tvar %s %s\n", v.Name, goType(analysis, v.Type)))
 			}
 		}
 		sb.WriteString("\n")
@@ -188,54 +229,52 @@ func generateGoFunction(fn disasm.Function) string {
 	// Generate function body from operations
 	if len(decomp.Operations) > 0 {
 		sb.WriteString("\t// Decompiled code\n")
-		inLoop := false
+		var loops loopNest
 
 		for i, op := range decomp.Operations {
-			indent := "\t"
-			if inLoop {
-				indent = "\t\t"
-			}
-
 			// Check for loop start
 			if strings.Contains(op.Comment, "LOOP_START") {
-				sb.WriteString(fmt.Sprintf("\tfor {  // Loop at 0x%x\n", op.Address))
-				inLoop = true
+				sb.WriteString(fmt.Sprintf("%sfor {  // Loop at 0x%x\n", goIndent(loops.depth()), op.Address))
+				loops.open(op.Address)
 			}
+			indent := goIndent(loops.depth())
 
 			switch op.Type {
 			case decompiler.OpAssign:
 				if op.Dest != "" && op.Src1 != "" {
 					if strings.Contains(op.Src1, "[") {
 						// Memory access - use unsafe pointer in comments
-						sb.WriteString(fmt.Sprintf("%s%s = /* *(%s) */ 0  // %s\n", indent, op.Dest, op.Src1, op.Comment))
+						sb.statement(op.Addresses, fmt.Sprintf("%s%s = /* *(%s) */ 0  // %s\n", indent, op.Dest, op.Src1, op.Comment))
 					} else {
 						// Try to parse numeric values
-						sb.WriteString(fmt.Sprintf("%s%s = %s\n", indent, op.Dest, op.Src1))
+						sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s\n", indent, op.Dest, op.Src1))
 					}
 				} else if op.Comment != "" {
-					sb.WriteString(fmt.Sprintf("%s// %s\n", indent, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s// %s\n", indent, op.Comment))
 				}
 
 			case decompiler.OpCall:
-				funcCall := op.Src1
-				if strings.HasPrefix(funcCall, "0x") {
-					funcCall = fmt.Sprintf("func_%s", funcCall[2:])
+				switch op.Operator {
+				case "virtual":
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s.%s()  // %s\n", indent, op.Src1, op.Src2, op.Comment))
+					continue
+				case "delete":
+					sb.statement(op.Addresses, fmt.Sprintf("%s// delete %s: %s\n", indent, op.Src1, op.Comment))
+					continue
 				}
+				funcCall := calleeName(analysis, op.Src1, sanitizeGoFunctionName, goLibraryName)
+				args := strings.Join(op.Args, ", ")
 				if op.Dest != "" && op.Dest != "result" {
-					sb.WriteString(fmt.Sprintf("%s%s = %s()\n", indent, op.Dest, funcCall))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s(%s)\n", indent, op.Dest, funcCall, args))
 				} else {
-					sb.WriteString(fmt.Sprintf("%s%s()  // %s\n", indent, funcCall, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s(%s)  // %s\n", indent, funcCall, args, op.Comment))
 				}
 
 			case decompiler.OpReturn:
-				if inLoop {
-					sb.WriteString("\t}\n")
-					inLoop = false
-				}
 				if op.Src1 != "" {
-					sb.WriteString(fmt.Sprintf("%sreturn %s\n", indent, op.Src1))
+					sb.statement(op.Addresses, fmt.Sprintf("%sreturn %s\n", indent, op.Src1))
 				} else {
-					sb.WriteString(fmt.Sprintf("%sreturn\n", indent))
+					sb.statement(op.Addresses, fmt.Sprintf("%sreturn\n", indent))
 				}
 
 			case decompiler.OpArithmetic:
@@ -250,12 +289,12 @@ func generateGoFunction(fn disasm.Function) string {
 					} else if goOp == "div" || goOp == "idiv" {
 						goOp = "/"
 					}
-					sb.WriteString(fmt.Sprintf("%s%s = %s %s %s\n", indent, op.Dest, op.Src1, goOp, op.Src2))
+					sb.statement(op.Addresses, fmt.Sprintf("%s%s = %s %s %s\n", indent, op.Dest, op.Src1, goOp, op.Src2))
 				}
 
 			case decompiler.OpCompare:
 				// Store comparison for potential if statement
-				sb.WriteString(fmt.Sprintf("%s// compare %s with %s\n", indent, op.Src1, op.Src2))
+				sb.statement(op.Addresses, fmt.Sprintf("%s// compare %s with %s\n", indent, op.Src1, op.Src2))
 
 			case decompiler.OpIf:
 				condition := "condition"
@@ -273,49 +312,113 @@ func generateGoFunction(fn disasm.Function) string {
 				if i+1 < len(decomp.Operations) && strings.Contains(op.Src1, "0x") {
 					var target uint64
 					fmt.Sscanf(op.Src1, "0x%x", &target)
-					if target < op.Address && inLoop {
-						// Loop condition
-						sb.WriteString(fmt.Sprintf("%sif !(%s) {\n", indent, condition))
-						sb.WriteString(fmt.Sprintf("%s\tbreak\n", indent))
-						sb.WriteString(fmt.Sprintf("%s}\n", indent))
+					if target < op.Address && loops.depth() > 0 {
+						// Loop condition, closing the loop it jumps back to
+						sb.statement(op.Addresses, fmt.Sprintf("%sif !(%s) {\n%s\tbreak\n%s}\n", indent, condition, indent, indent))
+						for n := loops.closeAt(target); n > 0; n-- {
+							sb.WriteString(goIndent(loops.depth()+n-1) + "}\n")
+						}
 						continue
 					}
 				}
 
-				sb.WriteString(fmt.Sprintf("%sif %s {  // %s to %s\n", indent, condition, op.Operator, op.Src1))
-				sb.WriteString(fmt.Sprintf("%s\t// Jump target: %s\n", indent, op.Src1))
-				sb.WriteString(fmt.Sprintf("%s}\n", indent))
+				sb.statement(op.Addresses, fmt.Sprintf("%sif %s {  // %s to %s\n%s\t// Jump target: %s\n%s}\n",
+					indent, condition, op.Operator, op.Src1, indent, op.Src1, indent))
 
 			default:
 				if op.Comment != "" {
-					sb.WriteString(fmt.Sprintf("%s// %s\n", indent, op.Comment))
+					sb.statement(op.Addresses, fmt.Sprintf("%s// %s\n", indent, op.Comment))
 				}
 			}
 		}
 
-		if inLoop {
-			sb.WriteString("\t}\n")
+		for n := loops.depth(); n > 0; n-- {
+			sb.WriteString(goIndent(n-1) + "}\n")
 		}
 	} else {
 		sb.WriteString("\t// Empty function or no recognizable operations\n")
 	}
 
 	sb.WriteString("}\n")
+}
 
-	return sb.String()
+// goIndent returns the indentation of statements nested in depth loops
+func goIndent(depth int) string {
+	return strings.Repeat("\t", depth+1)
 }
 
-func convertToGoType(cType string) string {
+// convertToGoType converts a C type to the Go type of the same size on a
+// target whose long is longSize bytes
+func convertToGoType(cType string, longSize int) string {
 	switch cType {
-	case "int":
-		return "int"
 	case "void*":
 		return "uintptr"
-	case "char*":
+	case "char*", "const char*":
 		return "string"
-	default:
-		return "interface{}"
 	}
+
+	scalar := strings.TrimPrefix(cType, "const ")
+	switch scalar {
+	case "long", "long int", "signed long":
+		if longSize == 4 {
+			return "int32"
+		}
+		return "int64"
+	case "unsigned long", "unsigned long int":
+		if longSize == 4 {
+			return "uint32"
+		}
+		return "uint64"
+	}
+	if goType, ok := goScalarTypes[scalar]; ok {
+		return goType
+	}
+	if i := strings.Index(cType, "["); i > 0 && strings.HasSuffix(cType, "]") {
+		return cType[i:] + convertToGoType(cType[:i], longSize)
+	}
+	if base, ok := strings.CutSuffix(cType, "*"); ok {
+		if elem := convertToGoType(base, longSize); elem != "interface{}" {
+			return "*" + elem
+		}
+		return "uintptr"
+	}
+	return "interface{}"
+}
+
+// goScalarTypes maps the C scalar types whose size does not depend on the
+// target to Go. The pointer-sized ones map to Go's, which match them on
+// every target.
+var goScalarTypes = map[string]string{
+	"char": "int8", "signed char": "int8", "int8_t": "int8",
+	"unsigned char": "uint8", "uint8_t": "uint8", "u8": "uint8", "BYTE": "uint8",
+	"short": "int16", "int16_t": "int16",
+	"unsigned short": "uint16", "uint16_t": "uint16", "u16": "uint16", "WORD": "uint16",
+	"int": "int32", "signed int": "int32", "int32_t": "int32", "LONG": "int32", "BOOL": "int32",
+	"unsigned": "uint32", "unsigned int": "uint32", "uint32_t": "uint32", "u32": "uint32", "DWORD": "uint32",
+	"long long": "int64", "int64_t": "int64",
+	"unsigned long long": "uint64", "uint64_t": "uint64", "u64": "uint64",
+	"size_t": "uintptr", "uintptr_t": "uintptr", "intptr_t": "int", "ssize_t": "int",
+	"float": "float32", "double": "float64", "bool": "bool", "_Bool": "bool",
+}
+
+// cLongSize returns the size of C's long on the target of a binary: 4
+// under the LLP64 model of 64-bit Windows and on 32-bit targets, else 8
+func cLongSize(b *parser.Binary) int {
+	if b.Format == "PE" || b.PointerSize() == 4 {
+		return 4
+	}
+	return 8
+}
+
+// goLibraryName returns the call of a library function qualified by its
+// package name, such as fmt.Println or http.Get for net/http.Get
+func goLibraryName(name string) string {
+	pkg := analyzer.GoPackage(name)
+	if pkg == "" {
+		return sanitizeGoFunctionName(name)
+	}
+	fn := strings.TrimSuffix(name[len(pkg)+1:], ".abi0") // Assembly called through its ABI wrapper
+	return pkg[strings.LastIndexByte(pkg, '/')+1:] + "." + sanitizeGoFunctionName(fn)
 }
 
 func sanitizeGoFunctionName(name string) string {
@@ -0,0 +1,44 @@
+package codegen
+
+import "testing"
+
+// Go types have the sizes of the C types they stand for, which the
+// offsets of structure fields assume
+func TestConvertToGoType(t *testing.T) {
+	tests := []struct {
+		cType    string
+		longSize int
+		want     string
+	}{
+		{"int", 8, "int32"},
+		{"unsigned int", 8, "uint32"},
+		{"long", 8, "int64"},
+		{"long", 4, "int32"},
+		{"const unsigned long", 8, "uint64"},
+		{"unsigned long", 4, "uint32"},
+		{"long[4]", 4, "[4]int32"},
+		{"long*", 8, "*int64"},
+		{"long long", 4, "int64"},
+		{"ssize_t", 4, "int"},
+		{"const char*", 8, "string"},
+		{"struct unknown*", 8, "uintptr"},
+	}
+	for _, tt := range tests {
+		if got := convertToGoType(tt.cType, tt.longSize); got != tt.want {
+			t.Errorf("convertToGoType(%q, %d) = %s, want %s", tt.cType, tt.longSize, got, tt.want)
+		}
+	}
+}
+
+// long is as wide as a pointer except under 64-bit Windows' LLP64 model
+func TestCLongSize(t *testing.T) {
+	analysis := analyzeTestdata(t, "loops")
+	if got := cLongSize(analysis.Binary); got != 8 {
+		t.Errorf("long of an amd64 ELF binary is %d bytes, want 8", got)
+	}
+	pe := *analysis.Binary
+	pe.Format = "PE"
+	if got := cLongSize(&pe); got != 4 {
+		t.Errorf("long of an amd64 PE image is %d bytes, want 4", got)
+	}
+}
@@ -0,0 +1,136 @@
+package codegen
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cdecl"
+)
+
+// generateCHeaderTypes emits the types declared in the loaded headers:
+// every structure is declared first so that typedefs and members can
+// refer to it, then enumerations, typedefs and structure bodies follow in
+// header order. Structures are named by their tag alone only where the
+// header typedefs them so.
+func generateCHeaderTypes(h *cdecl.Header) string {
+	var sb strings.Builder
+	sb.WriteString("/* Types from headers */\n")
+	for _, s := range h.Structs {
+		tag := aggregateKeyword(s) + " " + s.Name
+		if t, ok := h.Typedef(s.Name); ok && t == tag {
+			sb.WriteString(fmt.Sprintf("typedef %s %s;\n", tag, s.Name))
+		} else {
+			sb.WriteString(tag + ";\n")
+		}
+	}
+	sb.WriteString("\n")
+
+	for _, e := range h.Enums {
+		if e.Name != "" {
+			sb.WriteString(fmt.Sprintf("enum %s {\n", e.Name))
+		} else {
+			sb.WriteString("enum {\n")
+		}
+		for _, v := range e.Values {
+			sb.WriteString(fmt.Sprintf("    %s = %d,\n", v.Name, v.Value))
+		}
+		sb.WriteString("};\n\n")
+	}
+
+	for _, t := range h.Typedefs {
+		sb.WriteString(fmt.Sprintf("typedef %s;\n", cdecl.Param{Name: t.Name, Type: t.Type}))
+	}
+	if len(h.Typedefs) > 0 {
+		sb.WriteString("\n")
+	}
+
+	for _, s := range h.Structs {
+		sb.WriteString(fmt.Sprintf("%s %s {\n", aggregateKeyword(s), s.Name))
+		for _, f := range s.Fields {
+			decl := cdecl.Param{Name: f.Name, Type: f.Type}.String()
+			if f.Bits > 0 {
+				decl += fmt.Sprintf(" : %d", f.Bits)
+			}
+			sb.WriteString(fmt.Sprintf("    %s;  // +0x%x\n", decl, f.Offset))
+		}
+		sb.WriteString(fmt.Sprintf("};  // size 0x%x\n\n", s.Size))
+	}
+	return sb.String()
+}
+
+// generateGoHeaderTypes emits the types declared in the loaded headers as
+// Go types, to be placed inside a type block. Unions, which Go lacks,
+// become byte arrays of their size.
+func generateGoHeaderTypes(analysis *analyzer.Analysis) string {
+	h := analysis.Header
+	var sb strings.Builder
+	for _, s := range h.Structs {
+		if s.Union {
+			var members []string
+			for _, f := range s.Fields {
+				members = append(members, goFieldName(f.Name)+" "+goType(analysis, f.Type))
+			}
+			sb.WriteString(fmt.Sprintf("\t// %s is a union of %s\n", s.Name, strings.Join(members, ", ")))
+			sb.WriteString(fmt.Sprintf("\t%s [%d]byte\n", s.Name, s.Size))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\t// %s is declared in a header (size 0x%x)\n", s.Name, s.Size))
+		sb.WriteString(fmt.Sprintf("\t%s struct {\n", s.Name))
+		for _, f := range s.Fields {
+			offset := fmt.Sprintf("+0x%x", f.Offset)
+			if f.Bits > 0 {
+				offset += fmt.Sprintf(", %d-bit", f.Bits)
+			}
+			sb.WriteString(fmt.Sprintf("\t\t%s %s // %s\n", goFieldName(f.Name), goType(analysis, f.Type), offset))
+		}
+		sb.WriteString("\t}\n")
+	}
+	for _, e := range h.Enums {
+		if e.Name != "" {
+			sb.WriteString(fmt.Sprintf("\t%s int32\n", e.Name))
+		}
+	}
+	for _, t := range h.Typedefs {
+		// Typedefs naming an enumeration by its tag need no alias
+		if target := goType(analysis, t.Type); target != t.Name {
+			sb.WriteString(fmt.Sprintf("\t%s = %s\n", t.Name, target))
+		}
+	}
+	return sb.String()
+}
+
+// generateGoHeaderConstants emits the enumeration constants of the loaded
+// headers
+func generateGoHeaderConstants(h *cdecl.Header) string {
+	var sb strings.Builder
+	sb.WriteString("// Enumerations from headers\n")
+	sb.WriteString("const (\n")
+	for _, e := range h.Enums {
+		typ := ""
+		if e.Name != "" {
+			typ = " " + e.Name
+		}
+		for _, v := range e.Values {
+			sb.WriteString(fmt.Sprintf("\t%s%s = %d\n", v.Name, typ, v.Value))
+		}
+	}
+	sb.WriteString(")\n\n")
+	return sb.String()
+}
+
+func aggregateKeyword(s *cdecl.Struct) string {
+	if s.Union {
+		return "union"
+	}
+	return "struct"
+}
+
+// goFieldName turns a C member name into a Go identifier
+func goFieldName(name string) string {
+	if token.IsKeyword(name) {
+		return name + "_"
+	}
+	return name
+}
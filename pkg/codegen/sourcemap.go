@@ -0,0 +1,192 @@
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/debuginfo"
+	"expeer/pkg/disasm"
+	"expeer/pkg/project"
+	"expeer/pkg/prototypes"
+)
+
+// SourceMapVersion is the version of the side-car mapping format
+const SourceMapVersion = 1
+
+// Options controls code generation
+type Options struct {
+	Interleave bool                 // Print the disassembly of each statement below it as comments
+	Workers    int                  // Functions generated concurrently, 0 for one per CPU
+	Prototypes *prototypes.Database // Prototypes applied at call sites, nil for the bundled ones
+	Only       map[uint64]bool      // Start addresses of the functions to emit, nil for all
+}
+
+// emitted returns the functions to generate code for. The others still
+// name call targets and cross-references.
+func (o Options) emitted(functions []disasm.Function) []disasm.Function {
+	if o.Only == nil {
+		return functions
+	}
+	var selected []disasm.Function
+	for _, fn := range functions {
+		if o.Only[fn.StartAddr] {
+			selected = append(selected, fn)
+		}
+	}
+	return selected
+}
+
+// SourceMap maps lines of generated code back to the addresses of the
+// instructions that produced them
+type SourceMap struct {
+	Version  int              `json:"version"`
+	Binary   string           `json:"binary"`
+	Language string           `json:"language"`
+	Lines    map[int][]string `json:"lines"` // 1-based line -> instruction addresses
+}
+
+// JSON renders the source map as indented JSON
+func (m *SourceMap) JSON() (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// codeWriter accumulates generated code and records which instructions
+// produced each line written through statement
+type codeWriter struct {
+	strings.Builder
+	opts  Options
+	line  int // Number of complete lines written
+	lines map[int][]uint64
+	insts map[uint64]disasm.Instruction // Instructions of the current function
+	notes *project.Project              // Source of user comments, may be nil
+
+	// Source positions from debug information, written with directive
+	debug     *debuginfo.Info
+	directive func(indent, file string, line int) string
+	file      string // Source position of the last statement
+	fileLine  int
+}
+
+func newCodeWriter(opts Options) *codeWriter {
+	return &codeWriter{
+		opts:  opts,
+		lines: make(map[int][]uint64),
+		insts: make(map[uint64]disasm.Instruction),
+	}
+}
+
+// WriteString writes s, keeping track of the current line
+func (w *codeWriter) WriteString(s string) (int, error) {
+	w.line += strings.Count(s, "\n")
+	return w.Builder.WriteString(s)
+}
+
+// append writes the code of another writer, shifting its line mapping
+func (w *codeWriter) append(other *codeWriter) {
+	for line, addrs := range other.lines {
+		w.lines[w.line+line] = addrs
+	}
+	w.WriteString(other.String())
+}
+
+// beginFunction makes the instructions of fn available to interleaving,
+// and the user's comments and the source positions of the debug
+// information of analysis to statements. directive renders a source
+// position in the output language.
+func (w *codeWriter) beginFunction(fn disasm.Function, analysis *analyzer.Analysis, directive func(indent, file string, line int) string) {
+	w.notes = analysis.Project
+	w.debug = analysis.Debug
+	w.directive = directive
+	w.file, w.fileLine = "", 0
+	w.insts = make(map[uint64]disasm.Instruction, len(fn.Instructions))
+	for _, inst := range fn.Instructions {
+		w.insts[inst.Address] = inst
+	}
+}
+
+// declaration writes the source position of the function at addr, to be
+// followed by its signature
+func (w *codeWriter) declaration(addr uint64) {
+	if w.debug == nil {
+		return
+	}
+	if fn := w.debug.Function(addr); fn != nil && fn.File != "" && fn.Line > 0 {
+		w.sourcePosition("", fn.File, fn.Line)
+	}
+}
+
+// sourcePosition writes a directive for a source position unless it is
+// the current one
+func (w *codeWriter) sourcePosition(indent, file string, line int) {
+	if file == w.file && line == w.fileLine {
+		return
+	}
+	w.file, w.fileLine = file, line
+	w.WriteString(w.directive(indent, file, line))
+}
+
+// statement writes the text of one statement, mapping each of its lines
+// to addrs, preceded by its source position when it changes and the
+// user's comments on addrs, and followed by their disassembly in
+// interleave mode
+func (w *codeWriter) statement(addrs []uint64, text string) {
+	indent := text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+	if w.debug != nil {
+		for _, addr := range addrs {
+			if file, line, ok := w.debug.LineAt(addr); ok {
+				w.sourcePosition(indent, file, line)
+				break
+			}
+		}
+	}
+	first := w.line + 1
+	if w.notes != nil {
+		for _, addr := range addrs {
+			if comment, ok := w.notes.Comment(addr); ok {
+				for _, line := range strings.Split(comment, "\n") {
+					w.WriteString(fmt.Sprintf("%s// %s\n", indent, line))
+				}
+			}
+		}
+	}
+	w.WriteString(text)
+	for line := first; line <= w.line; line++ {
+		w.lines[line] = addrs
+	}
+
+	if !w.opts.Interleave {
+		return
+	}
+	for _, addr := range addrs {
+		inst, ok := w.insts[addr]
+		if !ok {
+			continue
+		}
+		asm := strings.TrimSpace(inst.Mnemonic + " " + inst.Operands)
+		w.WriteString(fmt.Sprintf("%s//   0x%x: %s\n", indent, inst.Address, asm))
+	}
+}
+
+// sourceMap returns the mapping of every line written through statement
+func (w *codeWriter) sourceMap(binary, language string) *SourceMap {
+	m := &SourceMap{
+		Version:  SourceMapVersion,
+		Binary:   binary,
+		Language: language,
+		Lines:    make(map[int][]string),
+	}
+	for line, addrs := range w.lines {
+		refs := make([]string, len(addrs))
+		for i, addr := range addrs {
+			refs[i] = fmt.Sprintf("0x%x", addr)
+		}
+		m.Lines[line] = refs
+	}
+	return m
+}
@@ -0,0 +1,41 @@
+/* Virtual calls through objects of two classes, from a method and from a
+ * function taking the object as argument:
+ *
+ *   g++ -O1 -o virtual virtual.cpp
+ */
+#include <cstdio>
+
+struct Shape {
+	virtual ~Shape() {}
+	virtual int area() = 0;
+	virtual void grow(int) {}
+};
+
+struct Square : Shape {
+	int side;
+	Square(int s) : side(s) {}
+	~Square() { puts("square"); }
+	int area() { return side * side; }
+	void grow(int n) { side += n; area(); }
+};
+
+struct Circle : Shape {
+	int radius;
+	Circle(int r) : radius(r) {}
+	~Circle() { puts("circle"); }
+	int area() { return 3 * radius * radius; }
+};
+
+__attribute__((noinline)) int use(Shape *p)
+{
+	p->grow(2);
+	int a = p->area();
+	delete p;
+	return a;
+}
+
+int main(int argc, char **argv)
+{
+	Shape *p = argc > 1 ? (Shape *)new Square(argc) : (Shape *)new Circle(argc);
+	return use(p);
+}
@@ -0,0 +1,568 @@
+package debuginfo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"expeer/pkg/cdecl"
+)
+
+// CodeView type records (leaves) used by PDB type streams
+const (
+	lfModifier  = 0x1001
+	lfPointer   = 0x1002
+	lfProcedure = 0x1008
+	lfMFunction = 0x1009
+	lfArgList   = 0x1201
+	lfFieldList = 0x1203
+	lfBitfield  = 0x1205
+	lfBClass    = 0x1400
+	lfVBClass   = 0x1401
+	lfIVBClass  = 0x1402
+	lfIndex     = 0x1404
+	lfVFuncTab  = 0x1409
+	lfEnumerate = 0x1502
+	lfArray     = 0x1503
+	lfClass     = 0x1504
+	lfStructure = 0x1505
+	lfUnion     = 0x1506
+	lfEnum      = 0x1507
+	lfMember    = 0x150d
+	lfStMember  = 0x150e
+	lfMethod    = 0x150f
+	lfNestType  = 0x1510
+	lfOneMethod = 0x1511
+	lfInterface = 0x1519
+	lfFuncID    = 0x1601
+	lfMFuncID   = 0x1602
+)
+
+const (
+	propForwardRef  = 0x80  // Aggregate declared, defined by another record
+	propUniqueName  = 0x200 // Aggregate record carries a decorated name
+	firstTypeIndex  = 0x1000
+	tpiHeaderLength = 56
+)
+
+var le = binary.LittleEndian
+
+// typeTable holds the records of a TPI or IPI stream, by type index
+type typeTable struct {
+	first   uint32
+	records [][]byte // Kind followed by the record's fields
+}
+
+// parseTypeStream splits a TPI or IPI stream into its records
+func parseTypeStream(data []byte) (*typeTable, error) {
+	if len(data) < tpiHeaderLength {
+		return nil, fmt.Errorf("type stream too short")
+	}
+	headerSize := int(le.Uint32(data[4:]))
+	end := headerSize + int(le.Uint32(data[16:]))
+	if headerSize < tpiHeaderLength || end > len(data) {
+		return nil, fmt.Errorf("invalid type stream header")
+	}
+	t := &typeTable{first: le.Uint32(data[8:])}
+	for off := headerSize; off+4 <= end; {
+		n := int(le.Uint16(data[off:]))
+		if n < 2 || off+2+n > end {
+			return nil, fmt.Errorf("truncated type record at 0x%x", off)
+		}
+		t.records = append(t.records, data[off+2:off+2+n])
+		off += 2 + n
+	}
+	return t, nil
+}
+
+// record returns the kind and fields of a type record
+func (t *typeTable) record(index uint32) (uint16, []byte) {
+	if t == nil || index < t.first || index-t.first >= uint32(len(t.records)) {
+		return 0, nil
+	}
+	r := t.records[index-t.first]
+	return le.Uint16(r), r[2:]
+}
+
+// u16, u32 and cstring read record fields, yielding zero values past the
+// end of a truncated record
+func u16(b []byte, off int) uint16 {
+	if off < 0 || off+2 > len(b) {
+		return 0
+	}
+	return le.Uint16(b[off:])
+}
+
+func u32(b []byte, off int) uint32 {
+	if off < 0 || off+4 > len(b) {
+		return 0
+	}
+	return le.Uint32(b[off:])
+}
+
+func cstring(b []byte, off int) (string, int) {
+	if off < 0 || off >= len(b) {
+		return "", len(b)
+	}
+	end := off
+	for end < len(b) && b[end] != 0 {
+		end++
+	}
+	return string(b[off:end]), end + 1
+}
+
+// numeric reads a numeric leaf: values below 0x8000 are stored inline,
+// larger ones follow a leaf giving their size
+func numeric(b []byte, off int) (int64, int) {
+	v := u16(b, off)
+	if v < 0x8000 {
+		return int64(v), off + 2
+	}
+	off += 2
+	switch v {
+	case 0x8000: // LF_CHAR
+		if off < len(b) {
+			return int64(int8(b[off])), off + 1
+		}
+	case 0x8001: // LF_SHORT
+		return int64(int16(u16(b, off))), off + 2
+	case 0x8002: // LF_USHORT
+		return int64(u16(b, off)), off + 2
+	case 0x8003: // LF_LONG
+		return int64(int32(u32(b, off))), off + 4
+	case 0x8004: // LF_ULONG
+		return int64(u32(b, off)), off + 4
+	case 0x8009, 0x800a: // LF_QUADWORD, LF_UQUADWORD
+		return int64(uint64(u32(b, off)) | uint64(u32(b, off+4))<<32), off + 8
+	}
+	return 0, len(b)
+}
+
+// simpleTypes names the basic types encoded in type indices below 0x1000
+var simpleTypes = map[uint32]struct {
+	name string
+	size int
+}{
+	0x03: {"void", 0},
+	0x08: {"long", 4}, // HRESULT
+	0x10: {"signed char", 1},
+	0x11: {"short", 2},
+	0x12: {"long", 4},
+	0x13: {"long long", 8},
+	0x20: {"unsigned char", 1},
+	0x21: {"unsigned short", 2},
+	0x22: {"unsigned long", 4},
+	0x23: {"unsigned long long", 8},
+	0x30: {"bool", 1},
+	0x40: {"float", 4},
+	0x41: {"double", 8},
+	0x42: {"long double", 10},
+	0x68: {"signed char", 1},
+	0x69: {"unsigned char", 1},
+	0x70: {"char", 1},
+	0x71: {"wchar_t", 2},
+	0x72: {"short", 2},
+	0x73: {"unsigned short", 2},
+	0x74: {"int", 4},
+	0x75: {"unsigned int", 4},
+	0x76: {"long long", 8},
+	0x77: {"unsigned long long", 8},
+	0x7a: {"char16_t", 2},
+	0x7b: {"char32_t", 4},
+	0x7c: {"char8_t", 1},
+}
+
+// cvConverter turns CodeView type records into C types, registering the
+// aggregates they use
+type cvConverter struct {
+	tpi     *typeTable
+	types   *cdecl.Header
+	ptrSize int
+
+	names map[uint32]string // Type index -> C spelling
+	defs  map[string]uint32 // Aggregate name -> index of its definition
+	anon  int
+}
+
+func newCVConverter(tpi *typeTable, ptrSize int) *cvConverter {
+	c := &cvConverter{
+		tpi:     tpi,
+		types:   cdecl.NewHeader(),
+		ptrSize: ptrSize,
+		names:   make(map[uint32]string),
+		defs:    make(map[string]uint32),
+	}
+	// Forward references are resolved by name to the defining record
+	for i := range tpi.records {
+		index := tpi.first + uint32(i)
+		kind, r := tpi.record(index)
+		if !isAggregate(kind) {
+			continue
+		}
+		name, unique, props := aggregateName(kind, r)
+		if props&propForwardRef != 0 {
+			continue
+		}
+		if unique != "" {
+			c.defs[unique] = index
+		}
+		if _, dup := c.defs[name]; !dup {
+			c.defs[name] = index
+		}
+	}
+	return c
+}
+
+func isAggregate(kind uint16) bool {
+	switch kind {
+	case lfClass, lfStructure, lfInterface, lfUnion, lfEnum:
+		return true
+	}
+	return false
+}
+
+// aggregateName returns the name, decorated name and properties of a
+// struct, class, union or enum record
+func aggregateName(kind uint16, r []byte) (string, string, uint16) {
+	props := u16(r, 2)
+	off := 0
+	switch kind {
+	case lfClass, lfStructure, lfInterface:
+		_, off = numeric(r, 16)
+	case lfUnion:
+		_, off = numeric(r, 8)
+	case lfEnum:
+		off = 12
+	}
+	name, next := cstring(r, off)
+	unique := ""
+	if props&propUniqueName != 0 {
+		unique, _ = cstring(r, next)
+	}
+	return name, unique, props
+}
+
+// typeName returns the C spelling of a type index
+func (c *cvConverter) typeName(index uint32) string {
+	if index < firstTypeIndex {
+		base, ok := simpleTypes[index&0xff]
+		if !ok {
+			base.name = "void"
+		}
+		if (index>>8)&0xf != 0 {
+			return base.name + "*"
+		}
+		return base.name
+	}
+	if s, ok := c.names[index]; ok {
+		return s
+	}
+
+	kind, r := c.tpi.record(index)
+	s := "void"
+	switch kind {
+	case lfModifier:
+		inner := c.typeName(u32(r, 0))
+		mods := u16(r, 4)
+		// Qualified pointers keep only the pointer type
+		if !strings.HasSuffix(inner, "*") && !strings.Contains(inner, "(*)") {
+			if mods&2 != 0 {
+				inner = "volatile " + inner
+			}
+			if mods&1 != 0 {
+				inner = "const " + inner
+			}
+		}
+		s = inner
+	case lfPointer:
+		pointee := u32(r, 0)
+		if pk, pr := c.tpi.record(pointee); pk == lfProcedure || pk == lfMFunction {
+			s = c.funcPointer(pk, pr)
+		} else {
+			s = c.typeName(pointee) + "*"
+		}
+	case lfArray:
+		elem := c.typeName(u32(r, 0))
+		size, _ := numeric(r, 8)
+		count := int64(0)
+		if elemSize := c.sizeOf(u32(r, 0)); elemSize > 0 {
+			count = size / int64(elemSize)
+		}
+		// Inner dimensions of a multidimensional array come last
+		if i := strings.Index(elem, "["); i >= 0 {
+			s = fmt.Sprintf("%s[%d]%s", elem[:i], count, elem[i:])
+		} else {
+			s = fmt.Sprintf("%s[%d]", elem, count)
+		}
+	case lfBitfield:
+		s = c.typeName(u32(r, 0))
+	case lfClass, lfStructure, lfInterface, lfUnion:
+		return c.aggregate(index, kind, r)
+	case lfEnum:
+		return c.enum(index, r)
+	}
+	c.names[index] = s
+	return s
+}
+
+// sizeOf returns the size of a type in bytes, 0 if unknown
+func (c *cvConverter) sizeOf(index uint32) int {
+	if index < firstTypeIndex {
+		if (index>>8)&0xf != 0 {
+			return c.ptrSize
+		}
+		return simpleTypes[index&0xff].size
+	}
+	kind, r := c.tpi.record(index)
+	switch kind {
+	case lfModifier, lfBitfield:
+		return c.sizeOf(u32(r, 0))
+	case lfPointer:
+		return c.ptrSize
+	case lfArray:
+		size, _ := numeric(r, 8)
+		return int(size)
+	case lfClass, lfStructure, lfInterface, lfUnion:
+		if def, ok := c.definition(kind, r); ok && def != index {
+			dk, dr := c.tpi.record(def)
+			kind, r = dk, dr
+		}
+		offset := 16
+		if kind == lfUnion {
+			offset = 8
+		}
+		size, _ := numeric(r, offset)
+		return int(size)
+	case lfEnum:
+		return c.sizeOf(u32(r, 4))
+	}
+	return 0
+}
+
+// definition returns the index of the record defining a forward-declared
+// aggregate
+func (c *cvConverter) definition(kind uint16, r []byte) (uint32, bool) {
+	name, unique, props := aggregateName(kind, r)
+	if props&propForwardRef == 0 {
+		return 0, false
+	}
+	if def, ok := c.defs[unique]; ok && unique != "" {
+		return def, true
+	}
+	def, ok := c.defs[name]
+	return def, ok
+}
+
+// funcPointer renders a pointer to a procedure type
+func (c *cvConverter) funcPointer(kind uint16, r []byte) string {
+	ret, args, _ := c.signature(kind, r)
+	var params []string
+	for _, a := range args {
+		params = append(params, a.Type)
+	}
+	if len(params) == 0 {
+		params = []string{"void"}
+	}
+	return fmt.Sprintf("%s (*)(%s)", ret, strings.Join(params, ", "))
+}
+
+// signature returns the return type and parameters of a procedure or
+// member function type. Member functions take this first. The last
+// result reports a variadic function.
+func (c *cvConverter) signature(kind uint16, r []byte) (string, []cdecl.Param, bool) {
+	ret := c.typeName(u32(r, 0))
+	argList := u32(r, 8)
+	var params []cdecl.Param
+	if kind == lfMFunction {
+		if this := u32(r, 8); this != 0 {
+			params = append(params, cdecl.Param{Name: "this", Type: c.typeName(this)})
+		}
+		argList = u32(r, 16)
+	}
+
+	variadic := false
+	if ak, ar := c.tpi.record(argList); ak == lfArgList {
+		count := int(u32(ar, 0))
+		for i := 0; i < count; i++ {
+			arg := u32(ar, 4+4*i)
+			// A trailing "no type" marks a variable argument list
+			if arg == 0 && i == count-1 {
+				variadic = true
+				break
+			}
+			params = append(params, cdecl.Param{Type: c.typeName(arg)})
+		}
+	}
+	if len(params) == 1 && params[0].Type == "void" && params[0].Name == "" {
+		params = nil
+	}
+	return ret, params, variadic
+}
+
+// callConv names the calling convention of a procedure type, empty for
+// the default
+func callConv(kind uint16, r []byte) string {
+	conv := byte(0)
+	switch kind {
+	case lfProcedure:
+		if len(r) > 4 {
+			conv = r[4]
+		}
+	case lfMFunction:
+		if len(r) > 12 {
+			conv = r[12]
+		}
+	}
+	switch conv {
+	case 0x04:
+		return "__fastcall"
+	case 0x07:
+		return "__stdcall"
+	case 0x0b:
+		return "__thiscall"
+	case 0x18:
+		return "__vectorcall"
+	}
+	return ""
+}
+
+// aggregate registers a struct, class or union and returns its type
+func (c *cvConverter) aggregate(index uint32, kind uint16, r []byte) string {
+	if def, ok := c.definition(kind, r); ok {
+		s := c.typeName(def)
+		c.names[index] = s
+		return s
+	}
+
+	keyword := "struct "
+	if kind == lfUnion {
+		keyword = "union "
+	}
+	name, _, props := aggregateName(kind, r)
+	name = c.identifier(name)
+	c.names[index] = keyword + name
+	if props&propForwardRef != 0 {
+		// Declared but never defined
+		return keyword + name
+	}
+
+	fieldList := u32(r, 4)
+	sizeOffset := 16
+	if kind == lfUnion {
+		sizeOffset = 8
+	}
+	size, _ := numeric(r, sizeOffset)
+	s := &cdecl.Struct{Name: name, Union: kind == lfUnion, Size: int(size)}
+	c.fields(fieldList, s)
+	c.types.AddStruct(s)
+	return keyword + name
+}
+
+// fields adds the data members and base classes of a field list
+func (c *cvConverter) fields(index uint32, s *cdecl.Struct) {
+	kind, r := c.tpi.record(index)
+	if kind != lfFieldList {
+		return
+	}
+	for off := 0; off+2 <= len(r); {
+		member := u16(r, off)
+		off += 2
+		switch member {
+		case lfMember:
+			// Attributes, type, offset and name follow
+			typ := u32(r, off+2)
+			offset, next := numeric(r, off+6)
+			name, next := cstring(r, next)
+			field := cdecl.Field{Name: c.identifier(name), Type: c.typeName(typ), Offset: int(offset)}
+			if bk, br := c.tpi.record(typ); bk == lfBitfield && len(br) > 4 {
+				field.Bits = int(br[4])
+			}
+			s.Fields = append(s.Fields, field)
+			off = next
+		case lfBClass:
+			typ := u32(r, off+2)
+			offset, next := numeric(r, off+6)
+			base := c.typeName(typ)
+			s.Fields = append(s.Fields, cdecl.Field{Name: baseFieldName(base), Type: base, Offset: int(offset)})
+			off = next
+		case lfVBClass, lfIVBClass:
+			_, next := numeric(r, off+10)
+			_, off = numeric(r, next)
+		case lfStMember:
+			_, off = cstring(r, off+6)
+		case lfMethod:
+			_, off = cstring(r, off+6)
+		case lfOneMethod:
+			next := off + 6
+			// Introducing virtual methods record their vtable offset
+			if kind := (u16(r, off) >> 2) & 7; kind == 4 || kind == 6 {
+				next += 4
+			}
+			_, off = cstring(r, next)
+		case lfNestType:
+			_, off = cstring(r, off+6)
+		case lfVFuncTab:
+			off += 6
+		case lfIndex:
+			// The list continues in another record
+			c.fields(u32(r, off+2), s)
+			return
+		case lfEnumerate:
+			_, next := numeric(r, off+2)
+			_, off = cstring(r, next)
+		default:
+			return
+		}
+		// Members are padded to four bytes with LF_PAD bytes
+		for off < len(r) && r[off] >= 0xf0 {
+			off++
+		}
+	}
+}
+
+// baseFieldName names the member holding a base class
+func baseFieldName(base string) string {
+	base = strings.TrimPrefix(strings.TrimPrefix(base, "struct "), "union ")
+	return "base_" + base
+}
+
+// enum registers an enumeration and returns its type
+func (c *cvConverter) enum(index uint32, r []byte) string {
+	if def, ok := c.definition(lfEnum, r); ok {
+		s := c.typeName(def)
+		c.names[index] = s
+		return s
+	}
+	name, _, props := aggregateName(lfEnum, r)
+	name = c.identifier(name)
+	s := "enum " + name
+	c.names[index] = s
+	if props&propForwardRef != 0 {
+		return s
+	}
+
+	e := &cdecl.Enum{Name: name}
+	if kind, fr := c.tpi.record(u32(r, 8)); kind == lfFieldList {
+		for off := 0; off+2 <= len(fr) && u16(fr, off) == lfEnumerate; {
+			value, next := numeric(fr, off+4)
+			valueName, next := cstring(fr, next)
+			e.Values = append(e.Values, cdecl.EnumValue{Name: cIdent(valueName), Value: value})
+			off = next
+			for off < len(fr) && fr[off] >= 0xf0 {
+				off++
+			}
+		}
+	}
+	c.types.AddEnum(e)
+	return s
+}
+
+// identifier turns an aggregate name into a C identifier, naming the
+// anonymous ones ("<unnamed-tag>", "__unnamed") anonN
+func (c *cvConverter) identifier(name string) string {
+	if name == "" || strings.HasPrefix(name, "<") || strings.HasPrefix(name, "__unnamed") {
+		c.anon++
+		return fmt.Sprintf("anon%d", c.anon)
+	}
+	return cIdent(name)
+}
@@ -0,0 +1,173 @@
+// Package debuginfo reads the debug information compilers leave in or next
+// to a binary: function boundaries, prototypes, variable locations, types
+// and source lines
+package debuginfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"sort"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/parser"
+)
+
+// Info is the debug information of a binary, independent of its format
+type Info struct {
+	Format    string // "DWARF" or "PDB"
+	Path      string // File the information was read from, empty if the binary itself
+	Functions []*Function
+	Types     *cdecl.Header // Types reachable from the functions
+	Lines     []Line        // Sorted by address
+
+	byAddr map[uint64]*Function
+}
+
+// Function is a function described by the debug information
+type Function struct {
+	Name      string // Source name
+	Linkage   string // Symbol name, if it differs from the source name
+	Low, High uint64 // Address range [Low, High), High is 0 if unknown
+	Prototype *cdecl.Prototype
+	Params    []Variable
+	Locals    []Variable
+	File      string // Declaring source file
+	Line      int
+}
+
+// Variable is a parameter or local variable
+type Variable struct {
+	Name string
+	Type string
+	// Offset from the frame pointer (rbp, ebp) of the variable's stack
+	// slot, valid when Frame is set
+	Offset int64
+	Frame  bool
+}
+
+// Line maps an address to a source line. Line is 0 at the end of a
+// sequence of instructions.
+type Line struct {
+	Address uint64
+	File    string
+	Line    int
+}
+
+// Load reads the debug information of a binary. It looks in the binary
+// first, then in the separate files toolchains split it into: .dSYM
+// bundles for Mach-O, .gnu_debuglink or build-id files for ELF, and PDBs
+// for PE. Separate files are looked for next to the binary, where the
+// binary says they are, and in the directories of symbolPath. The result
+// is nil without error if there is none.
+func Load(b *parser.Binary, symbolPath []string) (*Info, error) {
+	switch b.Format {
+	case "ELF", "Mach-O":
+		return loadDWARF(b, symbolPath)
+	case "PE":
+		return loadPDB(b, symbolPath)
+	}
+	return nil, nil
+}
+
+// index sorts the functions and lines and builds the address lookup
+func (info *Info) index() {
+	sort.Slice(info.Functions, func(i, j int) bool {
+		return info.Functions[i].Low < info.Functions[j].Low
+	})
+	sort.SliceStable(info.Lines, func(i, j int) bool {
+		return info.Lines[i].Address < info.Lines[j].Address
+	})
+	info.byAddr = make(map[uint64]*Function, len(info.Functions))
+	for _, fn := range info.Functions {
+		if _, dup := info.byAddr[fn.Low]; !dup {
+			info.byAddr[fn.Low] = fn
+		}
+	}
+}
+
+// infoData is the stored form of an Info, without its lookups
+type infoData Info
+
+// GobEncode stores the debug information, for caches
+func (info *Info) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode((*infoData)(info))
+	return buf.Bytes(), err
+}
+
+// GobDecode restores debug information stored with GobEncode
+func (info *Info) GobDecode(data []byte) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode((*infoData)(info)); err != nil {
+		return err
+	}
+	info.index()
+	return nil
+}
+
+// Function returns the function starting at addr
+func (info *Info) Function(addr uint64) *Function {
+	return info.byAddr[addr]
+}
+
+// Extent returns the end of the function starting at addr, if known
+func (info *Info) Extent(addr uint64) (uint64, bool) {
+	fn := info.Function(addr)
+	if fn == nil || fn.High <= fn.Low {
+		return 0, false
+	}
+	return fn.High, true
+}
+
+// SymbolName returns the name the function is known by in symbol tables
+func (fn *Function) SymbolName() string {
+	if fn.Linkage != "" {
+		return fn.Linkage
+	}
+	return fn.Name
+}
+
+// LineAt returns the source position of the instruction at addr
+func (info *Info) LineAt(addr uint64) (string, int, bool) {
+	i := sort.Search(len(info.Lines), func(i int) bool { return info.Lines[i].Address > addr })
+	if i == 0 {
+		return "", 0, false
+	}
+	l := info.Lines[i-1]
+	if l.Line == 0 {
+		return "", 0, false
+	}
+	return l.File, l.Line, true
+}
+
+// FrameVariable returns the variable of the function at fn stored at
+// offset from the frame pointer
+func (info *Info) FrameVariable(fn uint64, offset int64) (Variable, bool) {
+	f := info.Function(fn)
+	if f == nil {
+		return Variable{}, false
+	}
+	for _, vars := range [][]Variable{f.Params, f.Locals} {
+		for _, v := range vars {
+			if v.Frame && v.Offset == offset {
+				return v, true
+			}
+		}
+	}
+	return Variable{}, false
+}
+
+// Digest identifies the separate debug file the information came from, so
+// that caches can tell when it changes. It is empty for information
+// stored in the binary.
+func (info *Info) Digest() string {
+	if info == nil || info.Path == "" {
+		return ""
+	}
+	st, err := os.Stat(info.Path)
+	if err != nil {
+		return info.Path
+	}
+	return fmt.Sprintf("%s:%d:%d", info.Path, st.Size(), st.ModTime().UnixNano())
+}
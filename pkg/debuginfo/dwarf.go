@@ -0,0 +1,688 @@
+package debuginfo
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/parser"
+)
+
+// DW_LANG_Go, whose types are not C types
+const langGo = 0x16
+
+// DWARF expression opcodes used in frame bases and variable locations
+const (
+	opReg0         = 0x50
+	opBreg0        = 0x70
+	opFbreg        = 0x91
+	opCallFrameCFA = 0x9c
+)
+
+const (
+	debugDirectory  = "/usr/lib/debug" // Where distributions install separate debug files
+	buildIDNoteType = 3                // NT_GNU_BUILD_ID
+)
+
+// loadDWARF reads the DWARF of an ELF or Mach-O binary, from the binary or
+// from its separate debug file
+func loadDWARF(b *parser.Binary, symbolPath []string) (*Info, error) {
+	d, path, closer, err := openDWARF(b, symbolPath)
+	if closer != nil {
+		defer closer.Close()
+	}
+	if d == nil || err != nil {
+		return nil, err
+	}
+
+	ptrSize := b.PointerSize()
+	info := &Info{Format: "DWARF", Path: path, Types: cdecl.NewHeader()}
+	c := &dwarfConverter{
+		d:        d,
+		info:     info,
+		ptrSize:  ptrSize,
+		fpReg:    framePointerRegister(b.Arch),
+		structs:  make(map[*dwarf.StructType]string),
+		typedefs: make(map[*dwarf.TypedefType]bool),
+		enums:    make(map[string]bool),
+	}
+	if err := c.read(); err != nil {
+		return nil, fmt.Errorf("failed to read DWARF: %w", err)
+	}
+
+	// Alignments are not recorded; the widest member decides
+	for _, s := range info.Types.Structs {
+		s.Align = 1
+		for _, f := range s.Fields {
+			if _, align, ok := info.Types.Sizeof(f.Type, ptrSize); ok && align > s.Align {
+				s.Align = align
+			}
+		}
+	}
+
+	info.index()
+	return info, nil
+}
+
+// openDWARF finds the DWARF of a binary. path is empty when it is stored
+// in the binary itself; closer, if not nil, releases the separate file.
+func openDWARF(b *parser.Binary, symbolPath []string) (d *dwarf.Data, path string, closer io.Closer, err error) {
+	switch b.Format {
+	case "ELF":
+		f, err := elf.NewFile(bytes.NewReader(b.RawData))
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if hasELFDebugInfo(f) {
+			d, err := f.DWARF()
+			return d, "", nil, err
+		}
+		for _, candidate := range elfDebugFiles(b.FilePath, f, symbolPath) {
+			df, err := elf.Open(candidate)
+			if err != nil {
+				continue
+			}
+			if !hasELFDebugInfo(df) {
+				df.Close()
+				continue
+			}
+			d, err := df.DWARF()
+			return d, candidate, df, err
+		}
+
+	case "Mach-O":
+		f, err := macho.NewFile(bytes.NewReader(b.RawData))
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if f.Section("__debug_info") != nil {
+			d, err := f.DWARF()
+			return d, "", nil, err
+		}
+		base := filepath.Base(b.FilePath)
+		for _, dir := range append([]string{filepath.Dir(b.FilePath)}, symbolPath...) {
+			candidate := filepath.Join(dir, base+".dSYM", "Contents", "Resources", "DWARF", base)
+			if df, closer := openDSYM(candidate, f); df != nil {
+				d, err := df.DWARF()
+				return d, candidate, closer, err
+			}
+		}
+	}
+	return nil, "", nil, nil
+}
+
+func hasELFDebugInfo(f *elf.File) bool {
+	for _, sec := range f.Sections {
+		if (sec.Name == ".debug_info" || sec.Name == ".zdebug_info") && sec.Type != elf.SHT_NOBITS {
+			return true
+		}
+	}
+	return false
+}
+
+// elfDebugFiles returns the separate debug files that may belong to an ELF
+// binary: the build-id file, then the .gnu_debuglink file in the places
+// GDB looks, each also in the directories of symbolPath. Debug links whose
+// CRC does not match are left out.
+func elfDebugFiles(path string, f *elf.File, symbolPath []string) []string {
+	var candidates []string
+	debugDirs := append([]string{debugDirectory}, symbolPath...)
+
+	if sec := f.Section(".note.gnu.build-id"); sec != nil {
+		if data, err := sec.Data(); err == nil && len(data) >= 16 {
+			nameSize := f.ByteOrder.Uint32(data[0:])
+			descSize := f.ByteOrder.Uint32(data[4:])
+			noteType := f.ByteOrder.Uint32(data[8:])
+			start := 12 + (nameSize+3)&^3
+			if noteType == buildIDNoteType && descSize >= 2 && uint64(start)+uint64(descSize) <= uint64(len(data)) {
+				id := hex.EncodeToString(data[start : start+descSize])
+				for _, dir := range debugDirs {
+					candidates = append(candidates, filepath.Join(dir, ".build-id", id[:2], id[2:]+".debug"))
+				}
+			}
+		}
+	}
+
+	sec := f.Section(".gnu_debuglink")
+	if sec == nil {
+		return candidates
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return candidates
+	}
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 {
+		return candidates
+	}
+	name := string(data[:end])
+	crcOffset := (end + 4) &^ 3
+	if crcOffset+4 > len(data) {
+		return candidates
+	}
+	crc := f.ByteOrder.Uint32(data[crcOffset:])
+
+	dir := filepath.Dir(path)
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	self, _ := filepath.Abs(path)
+	links := []string{
+		filepath.Join(dir, name),
+		filepath.Join(dir, ".debug", name),
+		filepath.Join(debugDirectory, abs, name),
+	}
+	for _, extra := range symbolPath {
+		links = append(links, filepath.Join(extra, name))
+	}
+	for _, candidate := range links {
+		if full, _ := filepath.Abs(candidate); full == self {
+			continue
+		}
+		contents, err := os.ReadFile(candidate)
+		if err == nil && crc32.ChecksumIEEE(contents) == crc {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// openDSYM opens the DWARF file of a .dSYM bundle, picking the slice of a
+// universal file that matches the binary. Files whose UUID differs from
+// the binary's belong to another build and are ignored.
+func openDSYM(path string, binary *macho.File) (*macho.File, io.Closer) {
+	var f *macho.File
+	var closer io.Closer
+	if thin, err := macho.Open(path); err == nil {
+		f, closer = thin, thin
+	} else if fat, err := macho.OpenFat(path); err == nil {
+		for _, arch := range fat.Arches {
+			if arch.Cpu == binary.Cpu {
+				f = arch.File
+				break
+			}
+		}
+		if f == nil {
+			fat.Close()
+			return nil, nil
+		}
+		closer = fat
+	} else {
+		return nil, nil
+	}
+
+	if want, have := machoUUID(binary), machoUUID(f); want != nil && have != nil && !bytes.Equal(want, have) {
+		closer.Close()
+		return nil, nil
+	}
+	return f, closer
+}
+
+// machoUUID returns the LC_UUID of a Mach-O file, or nil
+func machoUUID(f *macho.File) []byte {
+	const lcUUID = 0x1b
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) >= 24 && f.ByteOrder.Uint32(raw) == lcUUID {
+			return raw[8:24]
+		}
+	}
+	return nil
+}
+
+// framePointerRegister returns the DWARF number of the frame pointer
+// register, or -1 if frame-relative locations are not supported
+func framePointerRegister(arch string) int {
+	switch arch {
+	case "x86_64":
+		return 6 // rbp
+	case "x86":
+		return 5 // ebp
+	}
+	return -1
+}
+
+// dwarfConverter turns DWARF entries into an Info
+type dwarfConverter struct {
+	d       *dwarf.Data
+	info    *Info
+	ptrSize int
+	fpReg   int
+
+	lang  int64
+	files []*dwarf.LineFile // File table of the current compilation unit
+
+	structs  map[*dwarf.StructType]string
+	typedefs map[*dwarf.TypedefType]bool
+	enums    map[string]bool
+	anon     int
+}
+
+// read walks every compilation unit, collecting line tables and the
+// functions with code
+func (c *dwarfConverter) read() error {
+	r := c.d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+
+		switch e.Tag {
+		case dwarf.TagCompileUnit, dwarf.TagPartialUnit:
+			c.lang, _ = e.Val(dwarf.AttrLanguage).(int64)
+			c.lines(e)
+		case dwarf.TagNamespace, dwarf.TagModule:
+			// Functions may be nested in namespaces
+		case dwarf.TagSubprogram:
+			c.subprogram(r, e)
+		default:
+			if e.Children {
+				r.SkipChildren()
+			}
+		}
+	}
+}
+
+// lines reads the line table of a compilation unit
+func (c *dwarfConverter) lines(cu *dwarf.Entry) {
+	c.files = nil
+	lr, err := c.d.LineReader(cu)
+	if err != nil || lr == nil {
+		return
+	}
+	c.files = lr.Files()
+
+	var entry dwarf.LineEntry
+	for lr.Next(&entry) == nil {
+		if entry.EndSequence {
+			c.info.Lines = append(c.info.Lines, Line{Address: entry.Address})
+			continue
+		}
+		if entry.File == nil {
+			continue
+		}
+		// Consecutive entries for the same line add nothing
+		if n := len(c.info.Lines); n > 0 {
+			last := c.info.Lines[n-1]
+			if last.Line == entry.Line && last.File == entry.File.Name && last.Address <= entry.Address {
+				continue
+			}
+		}
+		c.info.Lines = append(c.info.Lines, Line{Address: entry.Address, File: entry.File.Name, Line: entry.Line})
+	}
+}
+
+// origin follows the specification or abstract origin of an entry, where
+// out-of-line definitions keep their name and type
+func (c *dwarfConverter) origin(e *dwarf.Entry) *dwarf.Entry {
+	for range 4 {
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			off, ok = e.Val(dwarf.AttrSpecification).(dwarf.Offset)
+		}
+		if !ok {
+			return e
+		}
+		r := c.d.Reader()
+		r.Seek(off)
+		next, err := r.Next()
+		if err != nil || next == nil {
+			return e
+		}
+		e = next
+	}
+	return e
+}
+
+// attr returns an attribute of an entry or of its origin
+func (c *dwarfConverter) attr(e *dwarf.Entry, a dwarf.Attr) interface{} {
+	if v := e.Val(a); v != nil {
+		return v
+	}
+	return c.origin(e).Val(a)
+}
+
+// subprogram reads a function definition and its parameters and locals
+func (c *dwarfConverter) subprogram(r *dwarf.Reader, e *dwarf.Entry) {
+	ranges, err := c.d.Ranges(e)
+	if err != nil || len(ranges) == 0 {
+		// Declarations and inlined-only functions have no code
+		if e.Children {
+			r.SkipChildren()
+		}
+		return
+	}
+
+	fn := &Function{Low: ranges[0][0], High: ranges[0][1]}
+	if low, ok := e.Val(dwarf.AttrLowpc).(uint64); ok {
+		for _, rng := range ranges {
+			if rng[0] == low {
+				fn.Low, fn.High = rng[0], rng[1]
+			}
+		}
+	}
+	fn.Name, _ = c.attr(e, dwarf.AttrName).(string)
+	fn.Linkage, _ = c.attr(e, dwarf.AttrLinkageName).(string)
+	if fn.Linkage == fn.Name {
+		fn.Linkage = ""
+	}
+	if idx, ok := c.attr(e, dwarf.AttrDeclFile).(int64); ok && idx >= 0 && int(idx) < len(c.files) && c.files[idx] != nil {
+		fn.File = c.files[idx].Name
+	}
+	if line, ok := c.attr(e, dwarf.AttrDeclLine).(int64); ok {
+		fn.Line = int(line)
+	}
+
+	isGo := c.lang == langGo
+	frameBase, hasFrame := c.frameBase(e)
+	variadic := false
+
+	if e.Children {
+		depth := 1
+		for depth > 0 {
+			child, err := r.Next()
+			if err != nil || child == nil {
+				break
+			}
+			if child.Tag == 0 {
+				depth--
+				continue
+			}
+			switch child.Tag {
+			case dwarf.TagFormalParameter:
+				if depth == 1 {
+					fn.Params = append(fn.Params, c.variable(child, frameBase, hasFrame, isGo))
+				}
+			case dwarf.TagVariable:
+				if v := c.variable(child, frameBase, hasFrame, isGo); v.Name != "" {
+					fn.Locals = append(fn.Locals, v)
+				}
+			case dwarf.TagUnspecifiedParameters:
+				variadic = variadic || depth == 1
+			}
+			if child.Children {
+				if child.Tag == dwarf.TagLexDwarfBlock {
+					depth++
+				} else {
+					r.SkipChildren()
+				}
+			}
+		}
+	}
+
+	// Go functions keep their names and lines; their types are not C
+	if !isGo && fn.Name != "" {
+		proto := &cdecl.Prototype{Name: fn.Name, Return: "void", Variadic: variadic}
+		if off, ok := c.attr(e, dwarf.AttrType).(dwarf.Offset); ok {
+			proto.Return = c.typeAt(off)
+		}
+		for _, p := range fn.Params {
+			proto.Params = append(proto.Params, cdecl.Param{Name: p.Name, Type: p.Type})
+		}
+		fn.Prototype = proto
+	}
+
+	c.info.Functions = append(c.info.Functions, fn)
+}
+
+// frameBase returns the offset of a function's frame base from the frame
+// pointer. Only frame bases that are the frame pointer itself or the
+// canonical frame address of a frame-pointer function are understood.
+func (c *dwarfConverter) frameBase(e *dwarf.Entry) (int64, bool) {
+	expr, ok := e.Val(dwarf.AttrFrameBase).([]byte)
+	if !ok || len(expr) == 0 || c.fpReg < 0 {
+		return 0, false
+	}
+	switch {
+	case expr[0] == opCallFrameCFA:
+		// The return address and the saved frame pointer lie between the
+		// CFA and the frame pointer
+		return int64(2 * c.ptrSize), true
+	case int(expr[0]) == opReg0+c.fpReg:
+		return 0, true
+	case int(expr[0]) == opBreg0+c.fpReg:
+		off, _ := sleb128(expr[1:])
+		return off, true
+	}
+	return 0, false
+}
+
+// variable reads a parameter or local variable
+func (c *dwarfConverter) variable(e *dwarf.Entry, frameBase int64, hasFrame, isGo bool) Variable {
+	v := Variable{}
+	v.Name, _ = c.attr(e, dwarf.AttrName).(string)
+	if off, ok := c.attr(e, dwarf.AttrType).(dwarf.Offset); ok && !isGo {
+		v.Type = c.typeAt(off)
+	}
+
+	expr, ok := e.Val(dwarf.AttrLocation).([]byte)
+	if !ok || len(expr) == 0 {
+		return v
+	}
+	switch {
+	case expr[0] == opFbreg && hasFrame:
+		off, n := sleb128(expr[1:])
+		if n > 0 {
+			v.Offset, v.Frame = off+frameBase, true
+		}
+	case c.fpReg >= 0 && int(expr[0]) == opBreg0+c.fpReg:
+		off, n := sleb128(expr[1:])
+		if n > 0 {
+			v.Offset, v.Frame = off, true
+		}
+	}
+	return v
+}
+
+// sleb128 decodes a signed LEB128 number, returning it and its length
+func sleb128(b []byte) (int64, int) {
+	var result int64
+	var shift uint
+	for i, c := range b {
+		result |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// typeAt returns the C spelling of the type at a DWARF offset
+func (c *dwarfConverter) typeAt(off dwarf.Offset) string {
+	t, err := c.d.Type(off)
+	if err != nil {
+		return "void"
+	}
+	return c.typeName(t)
+}
+
+// basicTypeNames maps the base type names compilers emit to the spelling
+// the rest of expeer uses
+var basicTypeNames = map[string]string{
+	"long int":               "long",
+	"long unsigned int":      "unsigned long",
+	"short int":              "short",
+	"short unsigned int":     "unsigned short",
+	"long long int":          "long long",
+	"long long unsigned int": "unsigned long long",
+	"sizetype":               "unsigned long",
+}
+
+// typeName renders a DWARF type in C, registering the structures,
+// enumerations and typedefs it uses
+func (c *dwarfConverter) typeName(t dwarf.Type) string {
+	switch t := t.(type) {
+	case nil:
+		return "void"
+	case *dwarf.QualType:
+		inner := c.typeName(t.Type)
+		// Qualified pointers keep only the pointer type
+		if t.Qual == "restrict" || strings.HasSuffix(inner, "*") || strings.Contains(inner, "(*)") {
+			return inner
+		}
+		return t.Qual + " " + inner
+	case *dwarf.PtrType:
+		if ft, ok := t.Type.(*dwarf.FuncType); ok {
+			return c.funcPointer(ft)
+		}
+		return c.typeName(t.Type) + "*"
+	case *dwarf.StructType:
+		return c.structure(t, "")
+	case *dwarf.EnumType:
+		return c.enum(t, "")
+	case *dwarf.TypedefType:
+		name := cIdent(t.Name)
+		if !c.typedefs[t] {
+			c.typedefs[t] = true
+			// Anonymous aggregates are named after their typedef, as
+			// cdecl names those of headers
+			typ := ""
+			switch target := t.Type.(type) {
+			case *dwarf.StructType:
+				typ = c.structure(target, name)
+			case *dwarf.EnumType:
+				typ = c.enum(target, name)
+			default:
+				typ = c.typeName(target)
+			}
+			c.info.Types.AddTypedef(name, typ)
+		}
+		return name
+	case *dwarf.ArrayType:
+		elem := c.typeName(t.Type)
+		count := t.Count
+		if count < 0 {
+			count = 0
+		}
+		// Inner dimensions of a multidimensional array come last
+		if i := strings.Index(elem, "["); i >= 0 {
+			return fmt.Sprintf("%s[%d]%s", elem[:i], count, elem[i:])
+		}
+		return fmt.Sprintf("%s[%d]", elem, count)
+	case *dwarf.VoidType, *dwarf.UnspecifiedType, *dwarf.FuncType:
+		return "void"
+	}
+
+	name := t.Common().Name
+	if canonical, ok := basicTypeNames[name]; ok {
+		return canonical
+	}
+	if name == "" {
+		return "void"
+	}
+	return name
+}
+
+// funcPointer renders a pointer to a function type
+func (c *dwarfConverter) funcPointer(ft *dwarf.FuncType) string {
+	var params []string
+	for _, p := range ft.ParamType {
+		if _, ok := p.(*dwarf.DotDotDotType); ok {
+			params = append(params, "...")
+			continue
+		}
+		params = append(params, c.typeName(p))
+	}
+	if len(params) == 0 {
+		params = []string{"void"}
+	}
+	return fmt.Sprintf("%s (*)(%s)", c.typeName(ft.ReturnType), strings.Join(params, ", "))
+}
+
+// structure registers a struct or union and returns its type. Anonymous
+// ones are named typedefName if given.
+func (c *dwarfConverter) structure(t *dwarf.StructType, typedefName string) string {
+	keyword := "struct "
+	if t.Kind == "union" {
+		keyword = "union "
+	}
+	if name, ok := c.structs[t]; ok {
+		return keyword + name
+	}
+	name := cIdent(t.StructName)
+	if name == "" {
+		name = typedefName
+	}
+	if name == "" {
+		c.anon++
+		name = fmt.Sprintf("anon%d", c.anon)
+	}
+	c.structs[t] = name
+	if t.Incomplete {
+		return keyword + name
+	}
+
+	s := &cdecl.Struct{Name: name, Union: t.Kind == "union", Size: int(t.ByteSize)}
+	for i, f := range t.Field {
+		field := cdecl.Field{Name: cIdent(f.Name), Type: c.typeName(f.Type), Offset: int(f.ByteOffset), Bits: int(f.BitSize)}
+		if field.Name == "" {
+			field.Name = fmt.Sprintf("field%d", i)
+		}
+		// DWARF 4 bit-fields give their position in bits only
+		if f.BitSize > 0 && f.DataBitOffset > 0 {
+			if size := f.Type.Size(); size > 0 {
+				field.Offset = int(f.DataBitOffset/(size*8)) * int(size)
+			}
+		}
+		s.Fields = append(s.Fields, field)
+	}
+	c.info.Types.AddStruct(s)
+	return keyword + name
+}
+
+// enum registers an enumeration and returns its type. Anonymous ones are
+// named typedefName if given.
+func (c *dwarfConverter) enum(t *dwarf.EnumType, typedefName string) string {
+	name := cIdent(t.EnumName)
+	if name == "" {
+		name = typedefName
+	}
+	key := name
+	if key == "" && len(t.Val) > 0 {
+		key = "." + t.Val[0].Name
+	}
+	if !c.enums[key] {
+		c.enums[key] = true
+		e := &cdecl.Enum{Name: name}
+		for _, v := range t.Val {
+			e.Values = append(e.Values, cdecl.EnumValue{Name: v.Name, Value: v.Val})
+		}
+		c.info.Types.AddEnum(e)
+	}
+	if name == "" {
+		return "int"
+	}
+	return "enum " + name
+}
+
+// cIdent turns a type name, possibly a C++ one such as "std::pair<int,
+// int>", into a C identifier
+func cIdent(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else if r != ' ' {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package debuginfo
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/parser"
+)
+
+func TestLoadDWARF(t *testing.T) {
+	binary, err := parser.ParseExecutable("testdata/dwarf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Load(binary, nil)
+	if err != nil || info == nil {
+		t.Fatalf("Load() = %v, %v", info, err)
+	}
+	if info.Format != "DWARF" || info.Path != "" {
+		t.Errorf("Format, Path = %q, %q, want DWARF in the binary", info.Format, info.Path)
+	}
+
+	// Addresses and frame offsets as objdump -d shows them
+	tests := []struct {
+		name      string
+		low       uint64
+		prototype cdecl.Prototype
+		line      int
+		vars      map[string]int64
+	}{
+		{
+			name: "scale", low: 0x1139, line: 12,
+			prototype: cdecl.Prototype{Name: "scale", Return: "long", Params: []cdecl.Param{
+				{Name: "p", Type: "struct point*"}, {Name: "factor", Type: "int"},
+			}},
+			vars: map[string]int64{"p": -0x18, "factor": -0x1c, "area": -0x8, "i": -0xc},
+		},
+		{
+			name: "total", low: 0x117d, line: 21,
+			prototype: cdecl.Prototype{Name: "total", Return: "int", Params: []cdecl.Param{
+				{Name: "count", Type: "int"},
+			}, Variadic: true},
+			vars: map[string]int64{"count": -0xd4, "ap": -0xd0, "sum": -0xb4},
+		},
+		{
+			name: "main", line: 32,
+			prototype: cdecl.Prototype{Name: "main", Return: "int"},
+		},
+	}
+	for _, tt := range tests {
+		var fn *Function
+		for _, f := range info.Functions {
+			if f.Name == tt.name {
+				fn = f
+			}
+		}
+		if fn == nil {
+			t.Errorf("%s: not found", tt.name)
+			continue
+		}
+		if tt.low != 0 && fn.Low != tt.low {
+			t.Errorf("%s: Low = 0x%x, want 0x%x", tt.name, fn.Low, tt.low)
+		}
+		if fn.High <= fn.Low {
+			t.Errorf("%s: High = 0x%x, want past 0x%x", tt.name, fn.High, fn.Low)
+		}
+		if filepath.Base(fn.File) != "dwarf.c" || fn.Line != tt.line {
+			t.Errorf("%s: declared at %s:%d, want dwarf.c:%d", tt.name, fn.File, fn.Line, tt.line)
+		}
+		if fn.Prototype == nil || !reflect.DeepEqual(*fn.Prototype, tt.prototype) {
+			t.Errorf("%s: Prototype = %+v, want %+v", tt.name, fn.Prototype, tt.prototype)
+		}
+		for name, offset := range tt.vars {
+			v, ok := info.FrameVariable(fn.Low, offset)
+			if !ok || v.Name != name {
+				t.Errorf("%s: FrameVariable(%d) = %q, %v, want %q", tt.name, offset, v.Name, ok, name)
+			}
+		}
+		if _, ok := info.FrameVariable(fn.Low, 8); ok {
+			t.Errorf("%s: FrameVariable(8) found a variable at the return address", tt.name)
+		}
+	}
+
+	if file, line, ok := info.LineAt(0x1139); !ok || filepath.Base(file) != "dwarf.c" || line != 12 {
+		t.Errorf("LineAt(0x1139) = %s:%d, %v, want dwarf.c:12", file, line, ok)
+	}
+	if s := info.Types.Struct("point"); s == nil || len(s.Fields) != 2 {
+		t.Errorf("Struct(point) = %+v, want x and y", s)
+	}
+}
@@ -0,0 +1,512 @@
+package debuginfo
+
+import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/parser"
+)
+
+// PDB streams at fixed indices
+const (
+	pdbInfoStream = 1
+	pdbTPIStream  = 2
+	pdbDBIStream  = 3
+	pdbIPIStream  = 4
+)
+
+// CodeView symbol records read from module and public symbol streams
+const (
+	sEnd              = 0x0006
+	sThunk32          = 0x1102
+	sBlock32          = 0x1103
+	sBPRel32          = 0x110b
+	sPub32            = 0x110e
+	sLProc32          = 0x110f
+	sGProc32          = 0x1110
+	sRegRel32         = 0x1111
+	sSepCode          = 0x1132
+	sLocal            = 0x113e
+	sLProc32ID        = 0x1146
+	sGProc32ID        = 0x1147
+	sInlineSite       = 0x114d
+	sInlineSiteEnd    = 0x114e
+	sProcIDEnd        = 0x114f
+	cvPubFunction     = 0x2 // S_PUB32 flag of functions
+	cvLocalIsParam    = 0x1 // S_LOCAL flag of parameters
+	cvRegEBP          = 22
+	cvRegRBP          = 334
+	codeViewRSDS      = "RSDS"
+	debugTypeCodeView = 2
+)
+
+// msfMagic starts every multi-stream file, the container of PDBs
+var msfMagic = []byte("Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00")
+
+// pdbReference is the CodeView record of a PE image naming its PDB
+type pdbReference struct {
+	guid [16]byte
+	age  uint32
+	path string
+}
+
+// loadPDB reads the PDB of a PE image, found through its CodeView debug
+// record next to the image, at the recorded path or in symbolPath
+func loadPDB(b *parser.Binary, symbolPath []string) (*Info, error) {
+	ref, err := codeViewReference(b.RawData)
+	if ref == nil || err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range pdbCandidates(b.FilePath, ref, symbolPath) {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		// Files of the same name that are not this image's PDB are skipped
+		m, err := openMSF(data)
+		if err != nil || !m.matches(ref) {
+			continue
+		}
+		info, err := readPDB(m, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", candidate, err)
+		}
+		info.Path = candidate
+		info.index()
+		return info, nil
+	}
+	return nil, nil
+}
+
+// codeViewReference reads the RSDS record of the debug directory, nil if
+// the image has none
+func codeViewReference(data []byte) (*pdbReference, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var dir pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_DEBUG {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_DEBUG {
+			dir = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_DEBUG]
+		}
+	}
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	// The directory lies in a section; its entries point into the file
+	var entries []byte
+	for _, sec := range f.Sections {
+		if dir.VirtualAddress >= sec.VirtualAddress && dir.VirtualAddress < sec.VirtualAddress+sec.VirtualSize {
+			start := uint64(sec.Offset) + uint64(dir.VirtualAddress-sec.VirtualAddress)
+			if end := start + uint64(dir.Size); end <= uint64(len(data)) {
+				entries = data[start:end]
+			}
+			break
+		}
+	}
+
+	const entrySize = 28
+	for off := 0; off+entrySize <= len(entries); off += entrySize {
+		if le.Uint32(entries[off+12:]) != debugTypeCodeView {
+			continue
+		}
+		size := uint64(le.Uint32(entries[off+16:]))
+		start := uint64(le.Uint32(entries[off+24:]))
+		if size < 24 || start+size > uint64(len(data)) {
+			continue
+		}
+		rec := data[start : start+size]
+		if string(rec[:4]) != codeViewRSDS {
+			continue
+		}
+		ref := &pdbReference{age: le.Uint32(rec[20:])}
+		copy(ref.guid[:], rec[4:20])
+		ref.path, _ = cstring(rec, 24)
+		return ref, nil
+	}
+	return nil, nil
+}
+
+// pdbCandidates lists where the PDB may be: at its recorded path, next to
+// the image, and in each symbol directory, either directly or in the
+// <name>/<GUID><age>/<name> layout of symbol stores
+func pdbCandidates(imagePath string, ref *pdbReference, symbolPath []string) []string {
+	name := ref.path
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath)) + ".pdb"
+	}
+	g := ref.guid
+	key := fmt.Sprintf("%08X%04X%04X%X%X", le.Uint32(g[0:]), le.Uint16(g[4:]), le.Uint16(g[6:]), g[8:16], ref.age)
+
+	candidates := []string{ref.path, filepath.Join(filepath.Dir(imagePath), name)}
+	for _, dir := range symbolPath {
+		candidates = append(candidates, filepath.Join(dir, name), filepath.Join(dir, name, key, name))
+	}
+	return candidates
+}
+
+// msfFile is a multi-stream file: streams stored as lists of fixed-size
+// blocks, listed by a directory
+type msfFile struct {
+	data      []byte
+	blockSize int
+	sizes     []uint32
+	blocks    [][]uint32
+}
+
+func openMSF(data []byte) (*msfFile, error) {
+	if len(data) < 56 || !bytes.Equal(data[:len(msfMagic)], msfMagic) {
+		return nil, fmt.Errorf("not a PDB 7.0 file")
+	}
+	m := &msfFile{data: data, blockSize: int(le.Uint32(data[32:]))}
+	if m.blockSize < 512 || m.blockSize&(m.blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d", m.blockSize)
+	}
+	dirSize := int(le.Uint32(data[44:]))
+	blockMap := int(le.Uint32(data[52:])) * m.blockSize
+
+	// The block map lists the blocks of the directory
+	dirBlocks := (dirSize + m.blockSize - 1) / m.blockSize
+	if blockMap+4*dirBlocks > len(data) {
+		return nil, fmt.Errorf("invalid stream directory")
+	}
+	var dirBlockList []uint32
+	for i := 0; i < dirBlocks; i++ {
+		dirBlockList = append(dirBlockList, le.Uint32(data[blockMap+4*i:]))
+	}
+	dir, err := m.read(dirBlockList, uint32(dirSize))
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(u32(dir, 0))
+	if 4+4*count > len(dir) {
+		return nil, fmt.Errorf("invalid stream directory")
+	}
+	off := 4 + 4*count
+	for i := 0; i < count; i++ {
+		size := u32(dir, 4+4*i)
+		if size == 0xffffffff {
+			size = 0 // Deleted stream
+		}
+		n := (int(size) + m.blockSize - 1) / m.blockSize
+		if off+4*n > len(dir) {
+			return nil, fmt.Errorf("invalid stream directory")
+		}
+		list := make([]uint32, n)
+		for j := range list {
+			list[j] = le.Uint32(dir[off+4*j:])
+		}
+		off += 4 * n
+		m.sizes = append(m.sizes, size)
+		m.blocks = append(m.blocks, list)
+	}
+	return m, nil
+}
+
+// read concatenates blocks, keeping size bytes
+func (m *msfFile) read(blocks []uint32, size uint32) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for _, blk := range blocks {
+		start := int(blk) * m.blockSize
+		if start+m.blockSize > len(m.data) {
+			// The last block of a file may be short
+			if start >= len(m.data) {
+				return nil, fmt.Errorf("block %d out of range", blk)
+			}
+			out = append(out, m.data[start:]...)
+			continue
+		}
+		out = append(out, m.data[start:start+m.blockSize]...)
+	}
+	if len(out) < int(size) {
+		return nil, fmt.Errorf("truncated stream")
+	}
+	return out[:size], nil
+}
+
+// stream returns the contents of a stream, nil if it does not exist
+func (m *msfFile) stream(i int) ([]byte, error) {
+	if i < 0 || i >= len(m.sizes) || m.sizes[i] == 0 {
+		return nil, nil
+	}
+	return m.read(m.blocks[i], m.sizes[i])
+}
+
+// matches reports whether the PDB is the one the image was linked with
+func (m *msfFile) matches(ref *pdbReference) bool {
+	info, err := m.stream(pdbInfoStream)
+	if err != nil || len(info) < 28 {
+		return false
+	}
+	return bytes.Equal(info[12:28], ref.guid[:]) && le.Uint32(info[8:]) >= ref.age
+}
+
+// readPDB reads the functions and their types from the module and public
+// symbols of a PDB
+func readPDB(m *msfFile, b *parser.Binary) (*Info, error) {
+	ptrSize := b.PointerSize()
+	tpiData, err := m.stream(pdbTPIStream)
+	if err != nil {
+		return nil, err
+	}
+	tpi, err := parseTypeStream(tpiData)
+	if err != nil {
+		return nil, fmt.Errorf("TPI stream: %w", err)
+	}
+	var ipi *typeTable
+	if ipiData, err := m.stream(pdbIPIStream); err == nil && ipiData != nil {
+		ipi, _ = parseTypeStream(ipiData)
+	}
+
+	dbi, err := m.stream(pdbDBIStream)
+	if err != nil {
+		return nil, err
+	}
+	if len(dbi) < 64 {
+		return nil, fmt.Errorf("DBI stream too short")
+	}
+
+	r := &pdbReader{
+		binary: b,
+		tpi:    tpi,
+		ipi:    ipi,
+		types:  newCVConverter(tpi, ptrSize),
+		info:   &Info{Format: "PDB"},
+		fpReg:  cvRegRBP,
+	}
+	if b.Arch == "x86" {
+		r.fpReg = cvRegEBP
+	}
+
+	// Module records follow the header, each naming its symbol stream
+	modInfo := int(le.Uint32(dbi[24:]))
+	if 64+modInfo > len(dbi) {
+		return nil, fmt.Errorf("invalid DBI module list")
+	}
+	mods := dbi[64 : 64+modInfo]
+	for off := 0; off+64 <= len(mods); {
+		stream := le.Uint16(mods[off+34:])
+		symSize := le.Uint32(mods[off+36:])
+		_, next := cstring(mods, off+64) // Module name
+		_, next = cstring(mods, next)    // Object file
+		off = (next + 3) &^ 3
+
+		if stream == 0xffff || symSize < 4 {
+			continue
+		}
+		data, err := m.stream(int(stream))
+		if err != nil || uint32(len(data)) < symSize {
+			continue
+		}
+		// Symbols follow a 4-byte signature
+		r.moduleSymbols(data[4:symSize])
+	}
+
+	// Public symbols name the functions modules do not describe
+	if stream := le.Uint16(dbi[20:]); stream != 0xffff {
+		if data, err := m.stream(int(stream)); err == nil {
+			r.publicSymbols(data)
+		}
+	}
+
+	r.info.Types = r.types.types
+	return r.info, nil
+}
+
+// pdbReader collects functions from PDB symbol streams
+type pdbReader struct {
+	binary   *parser.Binary
+	tpi, ipi *typeTable
+	types    *cvConverter
+	info     *Info
+	fpReg    uint16
+}
+
+// address converts a section:offset pair to an address of the image
+func (r *pdbReader) address(segment uint16, offset uint32) (uint64, bool) {
+	if segment == 0 || int(segment) > len(r.binary.Sections) {
+		return 0, false
+	}
+	return r.binary.Sections[segment-1].Address + uint64(offset), true
+}
+
+// moduleSymbols reads the procedures of a module and their variables
+func (r *pdbReader) moduleSymbols(data []byte) {
+	var fn *Function
+	var procType uint32
+	var procTypeID bool
+	var vars []Variable
+	var params []bool // Whether each of vars is known to be a parameter
+	depth, inline := 0, 0
+
+	for off := 0; off+4 <= len(data); {
+		n := int(le.Uint16(data[off:]))
+		if n < 2 || off+2+n > len(data) {
+			return
+		}
+		kind := le.Uint16(data[off+2:])
+		rec := data[off+4 : off+2+n]
+		off += 2 + n
+
+		switch kind {
+		case sGProc32, sLProc32, sGProc32ID, sLProc32ID:
+			if fn != nil {
+				depth++
+				continue
+			}
+			offset, segment := u32(rec, 28), u16(rec, 32)
+			low, ok := r.address(segment, offset)
+			if !ok {
+				continue
+			}
+			name, _ := cstring(rec, 35)
+			fn = &Function{Name: name, Low: low, High: low + uint64(u32(rec, 12))}
+			procType = u32(rec, 24)
+			procTypeID = kind == sGProc32ID || kind == sLProc32ID
+			vars, params = nil, nil
+			depth, inline = 1, 0
+
+		case sBlock32, sThunk32, sSepCode:
+			if fn != nil {
+				depth++
+			}
+		case sInlineSite:
+			if fn != nil {
+				depth++
+				inline++
+			}
+
+		case sEnd, sProcIDEnd, sInlineSiteEnd:
+			if fn == nil {
+				continue
+			}
+			if kind == sInlineSiteEnd && inline > 0 {
+				inline--
+			}
+			depth--
+			if depth == 0 {
+				r.finish(fn, procType, procTypeID, vars, params)
+				fn = nil
+			}
+
+		case sRegRel32, sBPRel32, sLocal:
+			if fn == nil || inline > 0 {
+				continue
+			}
+			v, isParam := r.variable(kind, rec)
+			vars = append(vars, v)
+			params = append(params, isParam)
+		}
+	}
+}
+
+// variable reads a variable record of a procedure and whether it is
+// known to be a parameter
+func (r *pdbReader) variable(kind uint16, rec []byte) (Variable, bool) {
+	switch kind {
+	case sRegRel32:
+		name, _ := cstring(rec, 10)
+		v := Variable{Name: name, Type: r.types.typeName(u32(rec, 4))}
+		if u16(rec, 8) == r.fpReg {
+			v.Offset, v.Frame = int64(int32(u32(rec, 0))), true
+		}
+		return v, false
+	case sBPRel32:
+		name, _ := cstring(rec, 8)
+		return Variable{Name: name, Type: r.types.typeName(u32(rec, 4)), Offset: int64(int32(u32(rec, 0))), Frame: true}, false
+	default: // S_LOCAL, whose location follows in S_DEFRANGE records
+		name, _ := cstring(rec, 6)
+		return Variable{Name: name, Type: r.types.typeName(u32(rec, 0))}, u16(rec, 4)&cvLocalIsParam != 0
+	}
+}
+
+// finish completes a procedure with its prototype. Compilers list the
+// parameters first; when no record marks them, the first variables of
+// the procedure are taken as its parameters.
+func (r *pdbReader) finish(fn *Function, typeIndex uint32, isID bool, vars []Variable, params []bool) {
+	if isID {
+		// Procedure IDs name the type through the IPI stream
+		switch kind, rec := r.ipi.record(typeIndex); kind {
+		case lfFuncID, lfMFuncID:
+			typeIndex = u32(rec, 4)
+		default:
+			typeIndex = 0
+		}
+	}
+
+	kind, rec := r.tpi.record(typeIndex)
+	if kind == lfProcedure || kind == lfMFunction {
+		ret, args, variadic := r.types.signature(kind, rec)
+		proto := &cdecl.Prototype{Name: fn.Name, Return: ret, Params: args, Variadic: variadic}
+		if r.binary.Arch == "x86" {
+			proto.CallConv = callConv(kind, rec)
+		}
+
+		marked := false
+		for _, p := range params {
+			marked = marked || p
+		}
+		for i, v := range vars {
+			isParam := params[i]
+			if !marked {
+				isParam = len(fn.Params) < len(args)
+			}
+			if isParam && len(fn.Params) < len(args) {
+				proto.Params[len(fn.Params)].Name = v.Name
+				fn.Params = append(fn.Params, v)
+			} else if v.Name != "" {
+				fn.Locals = append(fn.Locals, v)
+			}
+		}
+		fn.Prototype = proto
+	} else {
+		fn.Locals = vars
+	}
+	r.info.Functions = append(r.info.Functions, fn)
+}
+
+// publicSymbols adds the public functions not described by a module,
+// which have a name but no known extent
+func (r *pdbReader) publicSymbols(data []byte) {
+	known := make(map[uint64]bool, len(r.info.Functions))
+	for _, fn := range r.info.Functions {
+		known[fn.Low] = true
+	}
+	for off := 0; off+4 <= len(data); {
+		n := int(le.Uint16(data[off:]))
+		if n < 2 || off+2+n > len(data) {
+			return
+		}
+		kind := le.Uint16(data[off+2:])
+		rec := data[off+4 : off+2+n]
+		off += 2 + n
+
+		if kind != sPub32 || u32(rec, 0)&cvPubFunction == 0 {
+			continue
+		}
+		addr, ok := r.address(u16(rec, 8), u32(rec, 4))
+		if !ok || known[addr] {
+			continue
+		}
+		name, _ := cstring(rec, 10)
+		known[addr] = true
+		r.info.Functions = append(r.info.Functions, &Function{Name: name, Low: addr})
+	}
+}
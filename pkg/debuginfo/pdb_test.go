@@ -0,0 +1,241 @@
+package debuginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/parser"
+)
+
+// The PDB of the tests is built here, as no toolchain at hand writes one.
+// It describes the functions of this source:
+//
+//	int sum(int a, int b) { int total; { int i; } ... }     // .text+0x10
+//	int log_message(char *format, ...) { int n; ... }       // .text+0x40
+//	void helper(void);                                      // .text+0x80, public only
+
+var testGUID = [16]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00}
+
+const testAge = 2
+
+// record appends a CodeView record of kind made of fields: integers in
+// their own width and strings null-terminated
+func record(buf *bytes.Buffer, kind uint16, fields ...interface{}) {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, kind)
+	for _, f := range fields {
+		if s, ok := f.(string); ok {
+			body.WriteString(s)
+			body.WriteByte(0)
+			continue
+		}
+		binary.Write(&body, binary.LittleEndian, f)
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(body.Len()))
+	buf.Write(body.Bytes())
+}
+
+// testPDB returns the streams of the test PDB, by index
+func testPDB() [][]byte {
+	var info bytes.Buffer
+	binary.Write(&info, binary.LittleEndian, []uint32{20000404, 0, testAge})
+	info.Write(testGUID[:])
+
+	var types bytes.Buffer
+	record(&types, lfArgList, uint32(2), uint32(0x74), uint32(0x74))                         // 0x1000
+	record(&types, lfProcedure, uint32(0x74), uint8(0), uint8(0), uint16(2), uint32(0x1000)) // 0x1001
+	record(&types, lfArgList, uint32(2), uint32(0x0670), uint32(0))                          // 0x1002
+	record(&types, lfProcedure, uint32(0x74), uint8(0), uint8(0), uint16(1), uint32(0x1002)) // 0x1003
+	tpi := make([]byte, tpiHeaderLength)
+	le.PutUint32(tpi[4:], tpiHeaderLength)
+	le.PutUint32(tpi[8:], firstTypeIndex)
+	le.PutUint32(tpi[12:], firstTypeIndex+4)
+	le.PutUint32(tpi[16:], uint32(types.Len()))
+	tpi = append(tpi, types.Bytes()...)
+
+	const rsp = 335
+	var syms bytes.Buffer
+	binary.Write(&syms, binary.LittleEndian, uint32(4)) // CV_SIGNATURE_C13
+	record(&syms, sGProc32, uint32(0), uint32(0), uint32(0), uint32(0x20), uint32(0), uint32(0), uint32(0x1001), uint32(0x10), uint16(1), uint8(0), "sum")
+	record(&syms, sRegRel32, uint32(16), uint32(0x74), uint16(cvRegRBP), "a")
+	record(&syms, sRegRel32, uint32(24), uint32(0x74), uint16(cvRegRBP), "b")
+	record(&syms, sRegRel32, uint32(0xfffffffc), uint32(0x74), uint16(cvRegRBP), "total")
+	record(&syms, sBlock32, uint32(0), uint32(0), uint32(8), uint32(0x18), uint16(1), "")
+	record(&syms, sRegRel32, uint32(0xfffffff8), uint32(0x74), uint16(cvRegRBP), "i")
+	record(&syms, sEnd)
+	record(&syms, sEnd)
+	record(&syms, sGProc32, uint32(0), uint32(0), uint32(0), uint32(0x30), uint32(0), uint32(0), uint32(0x1003), uint32(0x40), uint16(1), uint8(0), "log_message")
+	record(&syms, sLocal, uint32(0x0670), uint16(cvLocalIsParam), "format")
+	record(&syms, sRegRel32, uint32(0x20), uint32(0x74), uint16(rsp), "n")
+	record(&syms, sEnd)
+
+	mod := make([]byte, 64)
+	le.PutUint16(mod[34:], 5) // Symbol stream
+	le.PutUint32(mod[36:], uint32(syms.Len()))
+	mod = append(mod, "sum.obj\x00sum.obj\x00"...)
+	for len(mod)%4 != 0 {
+		mod = append(mod, 0)
+	}
+	dbi := make([]byte, 64)
+	le.PutUint32(dbi[0:], 0xffffffff)
+	le.PutUint16(dbi[20:], 6) // Symbol record stream
+	le.PutUint32(dbi[24:], uint32(len(mod)))
+	dbi = append(dbi, mod...)
+
+	var publics bytes.Buffer
+	record(&publics, sPub32, uint32(cvPubFunction), uint32(0x10), uint16(1), "sum")
+	record(&publics, sPub32, uint32(cvPubFunction), uint32(0x80), uint16(1), "helper")
+	record(&publics, sPub32, uint32(0), uint32(0x8), uint16(2), "counter")
+
+	return [][]byte{nil, info.Bytes(), tpi, dbi, nil, syms.Bytes(), publics.Bytes()}
+}
+
+// buildMSF lays out streams in a multi-stream file of 512-byte blocks:
+// the superblock, the two free block maps, the streams, the stream
+// directory and the block map listing the directory's blocks
+func buildMSF(streams [][]byte) []byte {
+	const blockSize = 512
+	blocks := [][]byte{make([]byte, blockSize), make([]byte, blockSize), make([]byte, blockSize)}
+	place := func(data []byte) []uint32 {
+		var list []uint32
+		for off := 0; off < len(data); off += blockSize {
+			blk := make([]byte, blockSize)
+			copy(blk, data[off:])
+			list = append(list, uint32(len(blocks)))
+			blocks = append(blocks, blk)
+		}
+		return list
+	}
+
+	dir := binary.LittleEndian.AppendUint32(nil, uint32(len(streams)))
+	var lists [][]uint32
+	for _, s := range streams {
+		dir = binary.LittleEndian.AppendUint32(dir, uint32(len(s)))
+		lists = append(lists, place(s))
+	}
+	for _, list := range lists {
+		for _, blk := range list {
+			dir = binary.LittleEndian.AppendUint32(dir, blk)
+		}
+	}
+	var blockMap []byte
+	for _, blk := range place(dir) {
+		blockMap = binary.LittleEndian.AppendUint32(blockMap, blk)
+	}
+	blockMapAddr := place(blockMap)[0]
+
+	super := blocks[0]
+	copy(super, msfMagic)
+	le.PutUint32(super[32:], blockSize)
+	le.PutUint32(super[36:], 1)
+	le.PutUint32(super[40:], uint32(len(blocks)))
+	le.PutUint32(super[44:], uint32(len(dir)))
+	le.PutUint32(super[52:], blockMapAddr)
+	return bytes.Join(blocks, nil)
+}
+
+func TestReadPDB(t *testing.T) {
+	m, err := openMSF(buildMSF(testPDB()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := &pdbReference{guid: testGUID, age: testAge}
+	if !m.matches(ref) {
+		t.Errorf("matches(own GUID and age) = false")
+	}
+	if other := (&pdbReference{guid: [16]byte{1}, age: testAge}); m.matches(other) {
+		t.Errorf("matches(other GUID) = true")
+	}
+	if newer := (&pdbReference{guid: testGUID, age: testAge + 1}); m.matches(newer) {
+		t.Errorf("matches(newer age) = true")
+	}
+
+	b := &parser.Binary{Format: "PE", Arch: "x86_64", Sections: []parser.Section{
+		{Name: ".text", Address: 0x140001000},
+		{Name: ".data", Address: 0x140003000},
+	}}
+	info, err := readPDB(m, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.index()
+
+	tests := []struct {
+		name      string
+		low, high uint64
+		prototype *cdecl.Prototype
+		frame     map[int64]string
+		locals    []string
+	}{
+		{
+			name: "sum", low: 0x140001010, high: 0x140001030,
+			prototype: &cdecl.Prototype{Name: "sum", Return: "int", Params: []cdecl.Param{
+				{Name: "a", Type: "int"}, {Name: "b", Type: "int"},
+			}},
+			frame:  map[int64]string{16: "a", 24: "b", -4: "total", -8: "i"},
+			locals: []string{"total", "i"},
+		},
+		{
+			name: "log_message", low: 0x140001040, high: 0x140001070,
+			prototype: &cdecl.Prototype{Name: "log_message", Return: "int", Params: []cdecl.Param{
+				{Name: "format", Type: "char*"},
+			}, Variadic: true},
+			// n lies at rsp+0x20, which the frame pointer does not locate
+			locals: []string{"n"},
+		},
+		{name: "helper", low: 0x140001080},
+	}
+	if len(info.Functions) != len(tests) {
+		t.Errorf("%d functions, want %d", len(info.Functions), len(tests))
+	}
+	for _, tt := range tests {
+		fn := info.Function(tt.low)
+		if fn == nil || fn.Name != tt.name {
+			t.Errorf("Function(0x%x) = %+v, want %s", tt.low, fn, tt.name)
+			continue
+		}
+		if fn.High != tt.high {
+			t.Errorf("%s: High = 0x%x, want 0x%x", tt.name, fn.High, tt.high)
+		}
+		if !reflect.DeepEqual(fn.Prototype, tt.prototype) {
+			t.Errorf("%s: Prototype = %+v, want %+v", tt.name, fn.Prototype, tt.prototype)
+		}
+		var locals []string
+		for _, v := range fn.Locals {
+			locals = append(locals, v.Name)
+		}
+		if !reflect.DeepEqual(locals, tt.locals) {
+			t.Errorf("%s: Locals = %v, want %v", tt.name, locals, tt.locals)
+		}
+		for offset, name := range tt.frame {
+			if v, ok := info.FrameVariable(tt.low, offset); !ok || v.Name != name || v.Type != "int" {
+				t.Errorf("%s: FrameVariable(%d) = %+v, %v, want int %s", tt.name, offset, v, ok, name)
+			}
+		}
+	}
+	if _, ok := info.FrameVariable(0x140001040, 0x20); ok {
+		t.Errorf("log_message: FrameVariable(0x20) found the rsp-relative n")
+	}
+}
+
+func TestOpenMSFRejects(t *testing.T) {
+	valid := buildMSF(testPDB())
+	tests := map[string][]byte{
+		"not a PDB":  []byte("Microsoft C/C++ program database 2.00\r\n\x1aJG\x00\x00"),
+		"truncated":  valid[:40],
+		"block size": append(append(append([]byte{}, valid[:32]...), 0x00, 0x03, 0x00, 0x00), valid[36:]...),
+	}
+	cut := append([]byte{}, valid...)
+	le.PutUint32(cut[52:], uint32(len(valid)/512+10)) // Block map past the end
+	tests["block map"] = cut
+
+	for name, data := range tests {
+		if _, err := openMSF(data); err == nil {
+			t.Errorf("%s: openMSF() succeeded", name)
+		}
+	}
+}
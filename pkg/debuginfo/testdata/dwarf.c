@@ -0,0 +1,36 @@
+/* A program with DWARF, the fixture of the debug information tests:
+ *
+ *   gcc -g -O0 -fno-omit-frame-pointer -o dwarf dwarf.c
+ */
+#include <stdarg.h>
+#include <stdio.h>
+
+struct point {
+	int x, y;
+};
+
+long scale(struct point *p, int factor) {
+	long area = (long)p->x * p->y;
+	int i;
+	for (i = 0; i < factor; i++) {
+		area *= 2;
+	}
+	return area;
+}
+
+int total(int count, ...) {
+	va_list ap;
+	int sum = 0;
+	va_start(ap, count);
+	while (count-- > 0) {
+		sum += va_arg(ap, int);
+	}
+	va_end(ap);
+	return sum;
+}
+
+int main(void) {
+	struct point p = {3, 4};
+	printf("%ld %d\n", scale(&p, 2), total(3, 1, 2, 3));
+	return 0;
+}
@@ -0,0 +1,54 @@
+package decompiler
+
+import (
+	"strings"
+
+	"expeer/pkg/disasm"
+)
+
+// ABI describes how a platform passes arguments to functions
+type ABI = disasm.ABI
+
+// ABIFor returns the default calling convention of a binary format and
+// architecture, as its backend defines it
+func ABIFor(format, arch string) ABI {
+	return disasm.ABIFor(format, arch)
+}
+
+// registerAliases returns the names of a register and of its parts
+func registerAliases(reg string) []string {
+	return disasm.RegisterParts(reg)
+}
+
+// canonicalRegister returns the full register a register name is part
+// of, and false if it is not a general purpose register
+func canonicalRegister(reg string) (string, bool) {
+	return disasm.FullRegister(reg)
+}
+
+// goInterfaces are named Go types known to be interfaces
+var goInterfaces = map[string]bool{
+	"any": true, "error": true, "io.Reader": true, "io.Writer": true,
+	"io.ReadWriter": true, "io.Closer": true, "net.Conn": true,
+	"net.Listener": true, "http.Handler": true, "cipher.Block": true,
+	"os.FileInfo": true, "fs.FileInfo": true, "fmt.Stringer": true,
+	"context.Context": true,
+}
+
+// goRegisterSlots returns how many integer registers a Go value of the
+// given type occupies under ABIInternal: strings and interfaces take two
+// words, slices three, floating-point values none (they travel in XMM
+// registers). Other named types are assumed to fit a single word.
+func goRegisterSlots(t string) int {
+	switch {
+	case t == "string" || goInterfaces[t] || strings.HasPrefix(t, "interface"):
+		return 2
+	case strings.HasPrefix(t, "[]"):
+		return 3
+	case t == "float32" || t == "float64" || t == "complex64" || t == "complex128":
+		return 0
+	case t == "time.Time":
+		return 3
+	}
+	return 1
+}
@@ -0,0 +1,362 @@
+package decompiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/cdecl"
+	"expeer/pkg/disasm"
+)
+
+// CallResolver identifies the functions reached by call instructions
+type CallResolver interface {
+	// Callee returns the prototype of the function called at site, if
+	// known, and the symbol to call it by when it lies outside the binary.
+	// name is empty for local functions, which are called by address.
+	Callee(site uint64) (name string, proto *cdecl.Prototype, ok bool)
+	// String returns the text of size bytes stored at addr, or of the
+	// NUL-terminated string there if size is negative. It fails for data
+	// that is not text.
+	String(addr uint64, size int) (string, bool)
+}
+
+// argTracker follows the values loaded into argument registers and pushed
+// on the stack since the last call, so that a call can be given its
+// arguments
+type argTracker struct {
+	calls  CallResolver
+	values map[string]string // 64-bit register -> expression it holds
+	addrs  map[string]uint64 // 64-bit register -> address loaded by lea
+	pushed []string          // Expressions pushed since the last call
+
+	// field renders a memory operand as a structure member access or a
+	// variable in the frame
+	field func(inst disasm.Instruction, operand string) (string, bool)
+}
+
+func newArgTracker(calls CallResolver) *argTracker {
+	return &argTracker{
+		calls:  calls,
+		values: make(map[string]string),
+		addrs:  make(map[string]uint64),
+	}
+}
+
+// update records the effect of an instruction on the tracked values.
+// regMap holds the variables the decompiler assigned to registers,
+// including any assigned by this instruction.
+func (t *argTracker) update(inst disasm.Instruction, regMap map[string]string) {
+	dest, src, twoOperands := splitOperands(inst.Operands)
+
+	switch inst.Mnemonic {
+	case "call":
+		// Arguments are consumed and caller-saved registers clobbered
+		t.values = make(map[string]string)
+		t.addrs = make(map[string]uint64)
+		t.pushed = nil
+		return
+
+	case "push":
+		t.pushed = append(t.pushed, t.operandValue(inst, inst.Operands, "", regMap))
+		return
+
+	case "mov", "movzx", "movsx", "movsxd":
+		if twoOperands {
+			if _, ok := canonicalRegister(dest); ok {
+				t.set(dest, t.operandValue(inst, src, dest, regMap))
+				return
+			}
+		}
+
+	case "lea":
+		if twoOperands {
+			if _, ok := canonicalRegister(dest); ok {
+				if inst.MemoryBase == "rip" || (inst.MemoryBase == "" && inst.MemoryIndex == "") {
+					t.setAddress(dest, uint64(inst.MemoryDisp))
+				} else if access, ok := t.memoryAccess(inst, src); ok {
+					t.set(dest, "&"+access)
+				} else {
+					t.set(dest, strings.Trim(src, "[]"))
+				}
+				return
+			}
+		}
+
+	case "xor":
+		if twoOperands && dest == src {
+			t.set(dest, "0")
+			return
+		}
+
+	case "cmp", "test":
+		return
+	}
+
+	// Any other write leaves the register holding an unknown value
+	if dest != "" {
+		t.set(dest, "")
+	}
+	for _, reg := range inst.RegsWritten {
+		t.set(reg, "")
+	}
+}
+
+// set records the expression a register holds; an empty expression
+// forgets it
+func (t *argTracker) set(reg, expr string) {
+	full, ok := canonicalRegister(reg)
+	if !ok {
+		return
+	}
+	delete(t.addrs, full)
+	if expr == "" {
+		delete(t.values, full)
+		return
+	}
+	t.values[full] = expr
+}
+
+// setAddress records an address loaded into a register, as a string
+// literal if one is stored there
+func (t *argTracker) setAddress(reg string, addr uint64) {
+	expr := fmt.Sprintf("0x%x", addr)
+	if s, ok := t.calls.String(addr, -1); ok {
+		expr = quoteString(s)
+	}
+	t.set(reg, expr)
+	full, _ := canonicalRegister(reg)
+	t.addrs[full] = addr
+}
+
+// operandValue returns the expression for a source operand: a tracked
+// register value, the variable the decompiler assigned, or the operand
+// itself. dest is the register being loaded, if any.
+func (t *argTracker) operandValue(inst disasm.Instruction, operand, dest string, regMap map[string]string) string {
+	if full, ok := canonicalRegister(operand); ok {
+		if v, ok := t.values[full]; ok {
+			return v
+		}
+		return regMap[operand]
+	}
+	if strings.HasPrefix(operand, "[") {
+		if access, ok := t.memoryAccess(inst, operand); ok {
+			return access
+		}
+		if inst.MemoryBase == "rip" {
+			return fmt.Sprintf("*(0x%x)", uint64(inst.MemoryDisp))
+		}
+		// Loads were given a variable by the decompiler
+		if v, ok := regMap[dest]; ok && dest != "" {
+			return v
+		}
+		return "*(" + strings.Trim(operand, "[]") + ")"
+	}
+	return operand
+}
+
+func (t *argTracker) memoryAccess(inst disasm.Instruction, operand string) (string, bool) {
+	if t.field == nil {
+		return "", false
+	}
+	return t.field(inst, operand)
+}
+
+// argument returns the expression passed in an argument slot: the n-th
+// argument register, or the n-th stack argument for stack conventions
+func (t *argTracker) argument(abi ABI, slot int) (string, bool) {
+	if len(abi.IntArgs) == 0 {
+		if slot < len(t.pushed) {
+			return t.pushed[len(t.pushed)-1-slot], true
+		}
+		return "", false
+	}
+	if slot < len(abi.IntArgs) {
+		v, ok := t.values[abi.IntArgs[slot]]
+		return v, ok
+	}
+	return "", false
+}
+
+// applyCall names the callee of a call operation and gives it the
+// arguments its prototype declares. Variables passed as arguments take
+// the type of the parameter, and arguments with no known value are named
+// after it.
+func (t *argTracker) applyCall(df *DecompiledFunction, op *Operation, inst disasm.Instruction, abi ABI) {
+	name, proto, ok := t.calls.Callee(inst.Address)
+	if !ok {
+		return
+	}
+	if name != "" {
+		op.Src1 = name
+		op.Comment = "import"
+	}
+	if proto == nil {
+		return
+	}
+
+	isGo := proto.CallConv == cdecl.CallConvGo
+	if isGo && len(abi.IntArgs) > 0 {
+		abi = disasm.ABIGo
+	}
+
+	op.Args = []string{}
+	slot := 0
+	format := ""
+	for i, param := range proto.Params {
+		slots := 1
+		if isGo {
+			slots = goRegisterSlots(param.Type)
+		}
+
+		value, known := "", false
+		if slots > 0 {
+			value, known = t.argument(abi, slot)
+			if known && isGo && param.Type == "string" {
+				value = t.goString(abi, slot, value)
+			}
+		}
+		slot += slots
+
+		if !known {
+			value = param.Name
+			if value == "" {
+				value = fmt.Sprintf("arg%d", i)
+			}
+		} else {
+			typeVariable(df, value, param.Type)
+		}
+		if isFormatParam(param) && strings.HasPrefix(value, "\"") {
+			format, _ = strconv.Unquote(value)
+		}
+		op.Args = append(op.Args, value)
+	}
+
+	// Variadic arguments are counted from a literal format string. Those
+	// holding an untracked value, such as the result of a previous call,
+	// are shown as the register passing them.
+	if proto.Variadic && format != "" {
+		for n := countFormatArgs(format); n > 0; n-- {
+			value, known := t.argument(abi, slot)
+			if !known {
+				if slot >= len(abi.IntArgs) {
+					break
+				}
+				value = abi.IntArgs[slot]
+			}
+			slot++
+			op.Args = append(op.Args, value)
+		}
+	}
+
+	if isGo {
+		op.Comment = goSignature(proto)
+	} else {
+		op.Comment = proto.String()
+	}
+}
+
+// goString returns a Go string argument, passed as a pointer and a length
+// in two consecutive slots, as a literal when both are known
+func (t *argTracker) goString(abi ABI, slot int, ptr string) string {
+	if slot+1 >= len(abi.IntArgs) {
+		return ptr
+	}
+	addr, isAddr := t.addrs[abi.IntArgs[slot]]
+	if !isAddr {
+		return ptr
+	}
+	// Go strings are not NUL-terminated, only the length delimits them
+	length, err := strconv.ParseUint(t.values[abi.IntArgs[slot+1]], 0, 16)
+	if err == nil {
+		if s, ok := t.calls.String(addr, int(length)); ok {
+			return quoteString(s)
+		}
+	}
+	return fmt.Sprintf("0x%x", addr)
+}
+
+// typeVariable gives a variable the type of the parameter it is passed
+// as, unless it already has a type
+func typeVariable(df *DecompiledFunction, name, typ string) {
+	for i := range df.Variables {
+		if df.Variables[i].Name == name && df.Variables[i].Type == "" {
+			df.Variables[i].Type = typ
+		}
+	}
+}
+
+// isFormatParam returns true for printf-style format string parameters
+func isFormatParam(p cdecl.Param) bool {
+	name := strings.ToLower(p.Name)
+	return (name == "format" || name == "fmt") && (strings.Contains(p.Type, "char") || p.Type == "string")
+}
+
+// countFormatArgs returns the number of arguments a printf format string
+// consumes
+func countFormatArgs(format string) int {
+	count := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		// Flags, width, precision and length modifiers
+		for ; i < len(format) && strings.IndexByte("-+ #0123456789.*hlLqjzt'", format[i]) >= 0; i++ {
+			if format[i] == '*' {
+				count++
+			}
+		}
+		if i < len(format) && strings.IndexByte("diouxXeEfFgGaAcspnCSv", format[i]) >= 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// goSignature renders a Go prototype in Go syntax
+func goSignature(p *cdecl.Prototype) string {
+	params := make([]string, len(p.Params))
+	for i, param := range p.Params {
+		params[i] = strings.TrimSpace(param.Name + " " + param.Type)
+	}
+	sig := fmt.Sprintf("func %s(%s)", p.Name, strings.Join(params, ", "))
+	if p.Return != "void" {
+		sig += " " + p.Return
+	}
+	return sig
+}
+
+// quoteString renders a string as a literal valid in both C and Go
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c == '\r':
+			sb.WriteString("\\r")
+		case c < 0x20 || c >= 0x7f:
+			sb.WriteString(fmt.Sprintf("\\x%02x", c))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// splitOperands splits "dest, src" into its operands
+func splitOperands(operands string) (string, string, bool) {
+	dest, src, found := strings.Cut(operands, ",")
+	return strings.TrimSpace(dest), strings.TrimSpace(src), found
+}
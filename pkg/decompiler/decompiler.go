@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"expeer/pkg/cdecl"
 	"expeer/pkg/disasm"
 )
 
@@ -23,13 +24,15 @@ const (
 
 // Operation represents a high-level operation
 type Operation struct {
-	Type     OpType
-	Dest     string
-	Src1     string
-	Src2     string
-	Operator string
-	Address  uint64
-	Comment  string
+	Type      OpType
+	Dest      string
+	Src1      string
+	Src2      string
+	Operator  string
+	Address   uint64
+	Addresses []uint64 // Instructions folded into this operation, including skipped ones before it
+	Comment   string
+	Args      []string // Arguments of a call with a known prototype, nil otherwise
 }
 
 // Variable represents a detected variable
@@ -45,14 +48,36 @@ type Variable struct {
 // DecompiledFunction contains high-level representation
 type DecompiledFunction struct {
 	Function   disasm.Function
+	Prototype  *cdecl.Prototype // User-declared prototype, nil if unknown
 	Variables  []Variable
 	Operations []Operation
 	LocalVars  int
 	HasReturn  bool
 }
 
-// Decompile converts assembly instructions to high-level operations
-func Decompile(fn disasm.Function) *DecompiledFunction {
+// Annotations supplies user knowledge about functions that takes
+// precedence over what the decompiler infers. Functions are identified by
+// their start address, variables by the name the decompiler gives them.
+type Annotations interface {
+	Prototype(fn uint64) (*cdecl.Prototype, bool)
+	VariableName(fn uint64, name string) (string, bool)
+	VariableType(fn uint64, name string) (string, bool)
+	// Field names the member at offset of the structure that a pointer
+	// of type ptrType points to
+	Field(ptrType string, offset int) (string, bool)
+	// FrameVariable names the variable of the function at fn stored at
+	// offset from the frame pointer, as debug information describes it
+	FrameVariable(fn uint64, offset int64) (name, typ string, ok bool)
+}
+
+// Decompile converts assembly instructions to high-level operations.
+// Arguments of a function with an annotated prototype are named after its
+// parameters, in the registers abi passes them in. Calls to functions
+// whose prototype calls knows are given their arguments, memory reached
+// through a pointer to a structure notes knows is shown as a member
+// access, and stack slots notes knows are the variables stored there.
+// notes and calls may be nil.
+func Decompile(fn disasm.Function, abi ABI, notes Annotations, calls CallResolver) *DecompiledFunction {
 	df := &DecompiledFunction{
 		Function: fn,
 	}
@@ -61,8 +86,126 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 	regMap := make(map[string]string) // register -> variable name
 	varCount := 0
 
+	// newVar names the next inferred variable, as renamed by the user
+	newVar := func() string {
+		name := fmt.Sprintf("var%d", varCount)
+		varCount++
+		if notes != nil {
+			if userName, ok := notes.VariableName(fn.StartAddr, name); ok {
+				return userName
+			}
+		}
+		return name
+	}
+
+	if notes != nil {
+		if proto, ok := notes.Prototype(fn.StartAddr); ok {
+			df.Prototype = proto
+			for i, param := range proto.Params {
+				v := Variable{Name: param.Name, Type: param.Type, IsParam: true}
+				if v.Name == "" {
+					v.Name = fmt.Sprintf("arg%d", i)
+				}
+				if i < len(abi.IntArgs) {
+					v.Register = abi.IntArgs[i]
+					for _, alias := range registerAliases(v.Register) {
+						regMap[alias] = v.Name
+					}
+				}
+				df.Variables = append(df.Variables, v)
+			}
+		}
+	}
+
+	// Addresses of instructions not yet attached to an operation
+	var pending []uint64
+
+	// Stack slots holding a typed variable, so that reloading the slot
+	// yields a variable of the same type
+	slots := make(map[string]string)
+
+	// typeOf returns the type of a variable, if known at this point
+	typeOf := func(name string) string {
+		for _, v := range df.Variables {
+			if v.Name == name && v.Type != "" {
+				return v.Type
+			}
+		}
+		if notes != nil {
+			if typ, ok := notes.VariableType(fn.StartAddr, name); ok {
+				return typ
+			}
+		}
+		return ""
+	}
+
+	// field renders a memory operand through a variable pointing to a
+	// known structure as a member access, such as "w->origin.x"
+	field := func(inst disasm.Instruction, operand string) (string, bool) {
+		if notes == nil || !strings.HasPrefix(operand, "[") || inst.MemoryIndex != "" {
+			return "", false
+		}
+		base, ok := regMap[inst.MemoryBase]
+		if !ok {
+			return "", false
+		}
+		typ := typeOf(base)
+		if typ == "" {
+			return "", false
+		}
+		name, ok := notes.Field(typ, int(inst.MemoryDisp))
+		if !ok {
+			return "", false
+		}
+		return base + "->" + name, true
+	}
+
+	// frameVariable returns the source variable in the frame slot a memory
+	// operand addresses, declaring it on first use
+	frameVariable := func(inst disasm.Instruction, operand string) (string, bool) {
+		if notes == nil || !strings.HasPrefix(operand, "[") || inst.MemoryIndex != "" ||
+			(inst.MemoryBase != "rbp" && inst.MemoryBase != "ebp") {
+			return "", false
+		}
+		name, typ, ok := notes.FrameVariable(fn.StartAddr, inst.MemoryDisp)
+		if !ok || name == "" {
+			return "", false
+		}
+		for _, v := range df.Variables {
+			if v.Name == name {
+				return name, true
+			}
+		}
+		df.Variables = append(df.Variables, Variable{Name: name, Type: typ, IsLocal: true})
+		return name, true
+	}
+
+	// operand renders a memory operand as the member or variable it
+	// accesses, when known
+	operand := func(inst disasm.Instruction, operand string) string {
+		if access, ok := field(inst, operand); ok {
+			return access
+		}
+		if name, ok := frameVariable(inst, operand); ok {
+			return name
+		}
+		return operand
+	}
+
+	var args *argTracker
+	if calls != nil {
+		args = newArgTracker(calls)
+		args.field = func(inst disasm.Instruction, operand string) (string, bool) {
+			if access, ok := field(inst, operand); ok {
+				return access, true
+			}
+			return frameVariable(inst, operand)
+		}
+	}
+
 	for i, inst := range fn.Instructions {
 		op := Operation{Address: inst.Address}
+		pending = append(pending, inst.Address)
 
 		switch inst.Mnemonic {
 		case "push":
@@ -89,13 +232,23 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 				src := strings.TrimSpace(parts[1])
 
 				// Check if this is a local variable access
-				if strings.Contains(src, "rbp") || strings.Contains(src, "rsp") {
+				if name, ok := frameVariable(inst, src); ok {
+					regMap[dest] = name
+					op.Dest = name
+					op.Src1 = src
+				} else if name, ok := frameVariable(inst, dest); ok {
+					op.Dest = name
+					op.Src1 = src
+					if varName, ok := regMap[src]; ok {
+						op.Src1 = varName
+					}
+				} else if strings.Contains(src, "rbp") || strings.Contains(src, "rsp") {
 					// Local variable or parameter
-					varName := fmt.Sprintf("var%d", varCount)
-					varCount++
+					varName := newVar()
 					regMap[dest] = varName
 					df.Variables = append(df.Variables, Variable{
 						Name:     varName,
+						Type:     typeOf(slots[src]),
 						Register: dest,
 						IsLocal:  true,
 					})
@@ -106,20 +259,22 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 					if varName, ok := regMap[src]; ok {
 						op.Dest = "local"
 						op.Src1 = varName
+						slots[dest] = varName
+					} else {
+						delete(slots, dest)
 					}
 				} else {
 					// Register to register
 					if srcVar, ok := regMap[src]; ok {
 						destVar := srcVar
 						regMap[dest] = destVar
-						op.Dest = dest
+						op.Dest = operand(inst, dest)
 						op.Src1 = srcVar
 					} else {
-						varName := fmt.Sprintf("var%d", varCount)
-						varCount++
+						varName := newVar()
 						regMap[dest] = varName
 						op.Dest = varName
-						op.Src1 = src
+						op.Src1 = operand(inst, src)
 					}
 				}
 			}
@@ -132,8 +287,19 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 			if strings.HasPrefix(inst.Operands, "0x") {
 				op.Comment = fmt.Sprintf("call to %s", inst.Operands)
 			}
+			if args != nil {
+				args.applyCall(df, &op, inst, abi)
+			}
+
+		case "end":
+			// WebAssembly: the end of a block is no statement, the end
+			// of the body returns
+			if inst.Category != disasm.CatReturn {
+				continue
+			}
+			fallthrough
 
-		case "ret":
+		case "ret", "return":
 			op.Type = OpReturn
 			df.HasReturn = true
 			if retVar, ok := regMap["rax"]; ok {
@@ -150,11 +316,11 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 				if varName, ok := regMap[dest]; ok {
 					op.Dest = varName
 					op.Src1 = varName
-					op.Src2 = src
+					op.Src2 = operand(inst, src)
 				} else {
-					op.Dest = dest
-					op.Src1 = dest
-					op.Src2 = src
+					op.Dest = operand(inst, dest)
+					op.Src1 = op.Dest
+					op.Src2 = operand(inst, src)
 				}
 			}
 
@@ -162,11 +328,11 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 			op.Type = OpCompare
 			parts := strings.Split(inst.Operands, ",")
 			if len(parts) == 2 {
-				op.Src1 = strings.TrimSpace(parts[0])
-				op.Src2 = strings.TrimSpace(parts[1])
+				op.Src1 = operand(inst, strings.TrimSpace(parts[0]))
+				op.Src2 = operand(inst, strings.TrimSpace(parts[1]))
 			}
 
-		case "jmp", "je", "jne", "jg", "jge", "jl", "jle", "ja", "jb", "jbe", "jae":
+		case "jmp", "je", "jne", "jg", "jge", "jl", "jle", "ja", "jb", "jbe", "jae", "br", "br_if":
 			op.Type = OpIf
 			op.Operator = inst.Mnemonic
 			op.Src1 = inst.Operands
@@ -177,11 +343,23 @@ func Decompile(fn disasm.Function) *DecompiledFunction {
 			op.Comment = fmt.Sprintf("%s %s", inst.Mnemonic, inst.Operands)
 		}
 
+		if args != nil {
+			args.update(inst, regMap)
+		}
+
 		if op.Type != 0 || op.Comment != "" {
+			op.Addresses = pending
+			pending = nil
 			df.Operations = append(df.Operations, op)
 		}
 	}
 
+	// Trailing skipped instructions (epilogue) belong to the last operation
+	if len(pending) > 0 && len(df.Operations) > 0 {
+		last := &df.Operations[len(df.Operations)-1]
+		last.Addresses = append(last.Addresses, pending...)
+	}
+
 	df.LocalVars = varCount
 
 	return df
@@ -217,11 +395,23 @@ func AnalyzeControlFlow(df *DecompiledFunction) {
 	}
 }
 
-// InferTypes attempts to infer variable types
-func InferTypes(df *DecompiledFunction) {
+// InferTypes attempts to infer variable types. Types given by the user,
+// declared parameter types and types taken from the prototypes of the
+// functions a variable is passed to are kept. notes may be nil.
+func InferTypes(df *DecompiledFunction, notes Annotations) {
 	for i := range df.Variables {
 		v := &df.Variables[i]
 
+		if notes != nil {
+			if typ, ok := notes.VariableType(df.Function.StartAddr, v.Name); ok {
+				v.Type = typ
+				continue
+			}
+		}
+		if v.Type != "" {
+			continue
+		}
+
 		// Default to int for now
 		v.Type = "int"
 
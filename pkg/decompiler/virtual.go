@@ -0,0 +1,163 @@
+package decompiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VirtualMethodResolver names the method reached through a vtable slot,
+// delete for the destructor that also frees the object
+type VirtualMethodResolver interface {
+	ResolveVirtual(caller uint64, slotOffset int64) (string, bool)
+	// MethodName names the virtual method at addr, if it is one
+	MethodName(addr uint64) (string, bool)
+}
+
+// ResolveVirtualCalls rewrites indirect calls through a vtable pointer
+// (mov rax, [rdi] ; call [rax+0x10]) as method calls on the object, and
+// calls through its deleting destructor as deletes of it. The object is this when a method calls through its own first argument,
+// the parameter or register holding it otherwise. The resolver may be
+// nil, in which case slots are named vfuncN.
+func ResolveVirtualCalls(df *DecompiledFunction, abi ABI, resolver VirtualMethodResolver) {
+	vptrs := make(map[string]string) // register -> object its vptr was loaded from
+	written := make(map[string]bool) // full registers overwritten since entry
+
+	// The first argument holds this: under thiscall, ecx
+	thisReg := "ecx"
+	if len(abi.IntArgs) > 0 {
+		thisReg = abi.IntArgs[0]
+	}
+	thisReg = fullRegister(thisReg)
+	isMethod := df.Prototype != nil && len(df.Prototype.Params) > 0 && df.Prototype.Params[0].Name == "this"
+	if resolver != nil {
+		if _, ok := resolver.MethodName(df.Function.StartAddr); ok {
+			isMethod = true
+		}
+	}
+
+	// object names what a register holds: this or a parameter while it
+	// still holds the argument, else the register itself
+	object := func(reg string) string {
+		full := fullRegister(reg)
+		if written[full] {
+			return reg
+		}
+		if isMethod && full == thisReg {
+			return "this"
+		}
+		for _, v := range df.Variables {
+			if v.IsParam && v.Register != "" && fullRegister(v.Register) == full {
+				return v.Name
+			}
+		}
+		return reg
+	}
+
+	// Calls clobber the argument registers and the scratch ones
+	clobbered := append([]string{"rax", "rcx", "rdx", "r10", "r11"}, abi.IntArgs...)
+
+	for _, inst := range df.Function.Instructions {
+		switch inst.Mnemonic {
+		case "mov":
+			parts := strings.Split(inst.Operands, ",")
+			if len(parts) != 2 {
+				continue
+			}
+			dest := strings.TrimSpace(parts[0])
+			src := strings.TrimSpace(parts[1])
+			delete(vptrs, dest)
+
+			// A load from offset 0 of an object is a vptr load
+			if base, disp, ok := parseMemOperand(src); ok && disp == 0 && !strings.HasPrefix(dest, "[") {
+				vptrs[dest] = object(base)
+			}
+			if !strings.HasPrefix(dest, "[") {
+				written[fullRegister(dest)] = true
+			}
+
+		case "call":
+			for _, reg := range clobbered {
+				written[fullRegister(reg)] = true
+			}
+			base, disp, ok := parseMemOperand(inst.Operands)
+			if !ok {
+				continue
+			}
+			receiver, isVptr := vptrs[base]
+			if !isVptr {
+				continue
+			}
+
+			ptrSize := int64(8)
+			if strings.HasPrefix(base, "e") {
+				ptrSize = 4
+			}
+			slot := disp / ptrSize
+
+			method := fmt.Sprintf("vfunc%d", slot)
+			if resolver != nil {
+				if name, ok := resolver.ResolveVirtual(df.Function.StartAddr, disp); ok {
+					method = name
+				}
+			}
+
+			for i := range df.Operations {
+				op := &df.Operations[i]
+				if op.Address == inst.Address && op.Type == OpCall {
+					op.Operator = "virtual"
+					if method == "delete" {
+						op.Operator = "delete"
+					}
+					op.Src1 = receiver
+					op.Src2 = method
+					op.Comment = fmt.Sprintf("vtable slot %d", slot)
+				}
+			}
+
+			// The call clobbers the caller-saved vptr register
+			delete(vptrs, base)
+
+		default:
+			for _, reg := range inst.RegsWritten {
+				delete(vptrs, reg)
+				written[fullRegister(reg)] = true
+			}
+		}
+	}
+}
+
+// parseMemOperand splits a "[reg+0xNN]" operand into base register and
+// displacement
+func parseMemOperand(operand string) (string, int64, bool) {
+	if !strings.HasPrefix(operand, "[") || !strings.HasSuffix(operand, "]") {
+		return "", 0, false
+	}
+	inner := operand[1 : len(operand)-1]
+	if strings.ContainsAny(inner, "*-") {
+		return "", 0, false
+	}
+
+	base, dispStr, hasDisp := strings.Cut(inner, "+")
+	if base == "" || base == "rip" || strings.HasPrefix(base, "0x") {
+		return "", 0, false
+	}
+	if !hasDisp {
+		return base, 0, true
+	}
+
+	disp, err := strconv.ParseInt(strings.TrimPrefix(dispStr, "0x"), 16, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return base, disp, true
+}
+
+// fullRegister returns the register a register name is part of, or the
+// name itself if it is not a general purpose register
+func fullRegister(reg string) string {
+	if full, ok := canonicalRegister(reg); ok {
+		return full
+	}
+	return reg
+}
@@ -0,0 +1,137 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"expeer/pkg/parser"
+)
+
+// Arch is an instruction set backend. Backends register themselves with
+// RegisterArch, typically from an init function, and are found by the
+// architecture name the parser gives a binary.
+type Arch struct {
+	Name        string // As in parser.Binary.Arch
+	PointerSize int    // In bytes
+
+	// Decode decodes the instruction at the start of data, located at
+	// addr, and returns its size: 0 if data holds no valid instruction
+	Decode func(data []byte, addr uint64) (Instruction, int)
+
+	// Disassemble decodes a whole code section, for instruction sets that
+	// cannot be decoded linearly from its first byte. If nil, the section
+	// is decoded instruction by instruction with Decode.
+	Disassemble func(section *Section, binary *parser.Binary) []Instruction
+
+	// Registers lists the general purpose registers, each as its name
+	// followed by the names of its parts, widest first
+	Registers [][]string
+
+	// ABI returns the default calling convention of binaries of a format.
+	// If nil, every argument is assumed to be passed on the stack.
+	ABI func(format string) ABI
+
+	// Prologue reports whether instructions[i] sets up a stack frame,
+	// suggesting that a function starts there. It may be nil.
+	Prologue func(instructions []Instruction, i int) bool
+}
+
+// ABI describes how a platform passes arguments to functions
+type ABI struct {
+	Name    string
+	IntArgs []string // Integer and pointer argument registers, in order
+}
+
+// ABIStack passes every argument on the stack (32-bit cdecl/stdcall)
+var ABIStack = ABI{Name: "stack"}
+
+var (
+	archMu sync.RWMutex
+	arches = make(map[string]*Arch)
+
+	// registers and registerParts merge the register sets of every
+	// backend: part -> full register, and full register -> its parts
+	registers     = make(map[string]string)
+	registerParts = make(map[string][]string)
+)
+
+// RegisterArch adds an instruction set backend. It panics if the name is
+// already taken or the backend can decode nothing.
+func RegisterArch(arch Arch) {
+	if arch.Name == "" || (arch.Decode == nil && arch.Disassemble == nil) {
+		panic("disasm: RegisterArch needs a name and a decoder")
+	}
+	if arch.PointerSize <= 0 {
+		panic(fmt.Sprintf("disasm: architecture %s needs a pointer size", arch.Name))
+	}
+	archMu.Lock()
+	defer archMu.Unlock()
+	if _, ok := arches[arch.Name]; ok {
+		panic(fmt.Sprintf("disasm: architecture %s registered twice", arch.Name))
+	}
+	arches[arch.Name] = &arch
+	parser.SetPointerSize(arch.Name, arch.PointerSize)
+	for _, parts := range arch.Registers {
+		full := parts[0]
+		if _, ok := registerParts[full]; ok {
+			continue // Shared with an earlier backend, like x86 and x86_64
+		}
+		registerParts[full] = parts
+		for _, part := range parts {
+			if _, ok := registers[part]; !ok {
+				registers[part] = full
+			}
+		}
+	}
+}
+
+// LookupArch returns the backend of an architecture, and false if none is
+// registered
+func LookupArch(name string) (*Arch, bool) {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	arch, ok := arches[name]
+	return arch, ok
+}
+
+// Arches returns the names of the registered architectures
+func Arches() []string {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	names := make([]string, 0, len(arches))
+	for name := range arches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ABIFor returns the default calling convention of a binary format and
+// architecture
+func ABIFor(format, arch string) ABI {
+	if a, ok := LookupArch(arch); ok && a.ABI != nil {
+		return a.ABI(format)
+	}
+	return ABIStack
+}
+
+// FullRegister returns the general purpose register a register name is
+// part of, and false if no backend knows it as one
+func FullRegister(reg string) (string, bool) {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	full, ok := registers[reg]
+	return full, ok
+}
+
+// RegisterParts returns the names of a general purpose register and of
+// each of its parts, widest first
+func RegisterParts(full string) []string {
+	archMu.RLock()
+	defer archMu.RUnlock()
+	if parts, ok := registerParts[full]; ok {
+		return parts
+	}
+	return []string{full}
+}
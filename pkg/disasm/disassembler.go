@@ -15,44 +15,45 @@ type Function struct {
 	EndAddr      uint64
 	Instructions []Instruction
 	Calls        []uint64 // Addresses of called functions
+	IsLibrary    bool     // Matched a library function signature
+	Class        FunctionClass
 }
 
-// DisassembleSection disassembles a code section
-// Prefers Capstone if available, falls back to simple decoder
-func DisassembleSection(section *parser.Section, arch string) ([]Instruction, error) {
-	// Convert parser.Section to disasm.Section
-	disasmSection := &Section{
-		Name:    section.Name,
-		Address: section.Address,
-		Size:    section.Size,
-		Data:    section.Data,
-		Flags:   section.Flags,
+// FunctionClass tells whose code a function is
+type FunctionClass string
+
+const (
+	ClassUser       FunctionClass = "user"        // The program's own code
+	ClassRuntime    FunctionClass = "runtime"     // Startup code and runtime the compiler links in
+	ClassStdlib     FunctionClass = "stdlib"      // The language's standard library
+	ClassThirdParty FunctionClass = "third-party" // Packages the program depends on
+)
+
+// DisassembleSection disassembles a code section of a binary with the
+// backend registered for its architecture.
+// Prefers Capstone if available, falls back to the backend's decoder
+func DisassembleSection(section *parser.Section, binary *parser.Binary) ([]Instruction, error) {
+	arch, ok := LookupArch(binary.Arch)
+	if !ok {
+		return nil, fmt.Errorf("unsupported architecture: %s (supported: %s)", binary.Arch, strings.Join(Arches(), ", "))
+	}
+	if arch.Disassemble != nil {
+		return arch.Disassemble(section, binary), nil
 	}
 
 	// Try Capstone first
-	instructions, err := DisassembleSectionWithCapstone(disasmSection, arch)
+	instructions, err := DisassembleSectionWithCapstone(section, arch.Name)
 	if err == nil && len(instructions) > 0 {
 		return instructions, nil
 	}
 
-	// Fallback to simple decoder if Capstone fails
-	if arch != "x86_64" && arch != "x86" {
-		return nil, fmt.Errorf("unsupported architecture: %s (currently only x86/x86_64 supported)", arch)
-	}
-
 	var fallbackInstructions []Instruction
 	data := section.Data
 	baseAddr := section.Address
 	offset := 0
 
-	// Enhanced pattern-based disassembly (fallback)
 	for offset < len(data) {
-		// Try enhanced decoder first
-		inst, size := EnhancedDecodeInstruction(data[offset:], baseAddr+uint64(offset), arch)
-		if size == 0 {
-			// Try old simple decoder as last resort
-			inst, size = decodeInstruction(data[offset:], baseAddr+uint64(offset), arch)
-		}
+		inst, size := arch.Decode(data[offset:], baseAddr+uint64(offset))
 		if size == 0 {
 			offset++
 			continue
@@ -223,30 +224,129 @@ func regName(n byte, arch string) string {
 	return fmt.Sprintf("r%d", n)
 }
 
-// FindFunctions attempts to identify function boundaries
-func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Function {
+// FindFunctions attempts to identify function boundaries in the code of
+// an architecture
+func FindFunctions(instructions []Instruction, symbols []parser.Symbol, arch string) []Function {
+	return FindFunctionsWithHints(instructions, symbols, arch, nil)
+}
+
+// Hints carries user annotations and debug information that override the
+// heuristics of FindFunctions
+type Hints interface {
+	// FunctionName reports whether a function is known to start at addr,
+	// and its name ("" keeps the symbol or generated name)
+	FunctionName(addr uint64) (string, bool)
+	// FunctionEnd returns the end (exclusive) of the known function
+	// starting at addr, if its extent is known
+	FunctionEnd(addr uint64) (uint64, bool)
+	IsCode(addr uint64) bool // Never treated as padding or data
+	IsData(addr uint64) bool // Never part of a function
+}
+
+// FindFunctionsWithHints is FindFunctions honouring hints: known functions
+// always start a function and take the hinted name, functions of known
+// extent end there rather than at their first return and contain no other
+// starts, and instructions in data regions are left out. hints may be nil.
+// Symbols with a size are functions of known extent too, unless hints
+// give another.
+// Besides symbols, returns and prologues, the targets of direct calls
+// within the instructions start functions, which finds the helpers of
+// stripped, optimized code that have no prologue.
+func FindFunctionsWithHints(instructions []Instruction, symbols []parser.Symbol, arch string, hints Hints) []Function {
 	var functions []Function
 
+	var prologue func([]Instruction, int) bool
+	if a, ok := LookupArch(arch); ok {
+		prologue = a.Prologue
+	}
+
 	// Create function map from symbols - these are reliable entry points
 	symbolMap := make(map[uint64]string)
 	symbolAddrs := make(map[uint64]bool)
+	symbolEnds := make(map[uint64]uint64)
 	for _, sym := range symbols {
 		if sym.Name != "" {
 			symbolMap[sym.Address] = sym.Name
 			symbolAddrs[sym.Address] = true
+			if end := sym.Address + sym.Size; sym.Size > 0 && end > symbolEnds[sym.Address] {
+				symbolEnds[sym.Address] = end
+			}
+		}
+	}
+
+	// functionEnd returns the end (exclusive) of the function starting at
+	// addr, if hints or its symbol give its extent
+	functionEnd := func(addr uint64) (uint64, bool) {
+		if hints != nil {
+			if end, ok := hints.FunctionEnd(addr); ok {
+				return end, true
+			}
+		}
+		end, ok := symbolEnds[addr]
+		return end, ok
+	}
+
+	// Direct call targets among the instructions, except calls to the
+	// next instruction that only push their address
+	addrs := make(map[uint64]bool, len(instructions))
+	for _, inst := range instructions {
+		addrs[inst.Address] = true
+	}
+	callTargets := make(map[uint64]bool)
+	for _, inst := range instructions {
+		if inst.Category == CatCall && inst.BranchTarget != 0 && addrs[inst.BranchTarget] &&
+			inst.BranchTarget != inst.Address+uint64(inst.Size) {
+			callTargets[inst.BranchTarget] = true
+		}
+	}
+
+	// The next known function start after each instruction, which bounds
+	// how far a cold path may reach
+	bounds := make([]uint64, len(instructions))
+	bound := ^uint64(0)
+	for i := len(instructions) - 1; i >= 0; i-- {
+		bounds[i] = bound
+		addr := instructions[i].Address
+		if symbolAddrs[addr] {
+			bound = addr
+		} else if hints != nil {
+			if _, ok := hints.FunctionName(addr); ok {
+				bound = addr
+			}
 		}
 	}
 
 	// Find function boundaries using multiple heuristics
 	var currentFunc *Function
 	funcStarts := make(map[uint64]bool)
+	var knownEnd uint64 // End of the function of known extent being scanned
+	var reach uint64    // Furthest forward conditional branch since the last start
 
 	// First pass: mark probable function starts
 	for i, inst := range instructions {
 		isStart := false
+		prevReach := reach
+		reach = coldPathReach(reach, inst, bounds[i])
+
+		if hints != nil {
+			if hints.IsData(inst.Address) {
+				continue
+			}
+			if _, ok := hints.FunctionName(inst.Address); ok {
+				funcStarts[inst.Address] = true
+				reach = coldPathReach(0, inst, bounds[i])
+				if end, ok := functionEnd(inst.Address); ok {
+					knownEnd = end
+				}
+				continue
+			}
+		}
+		if inst.Address < knownEnd {
+			continue
+		}
 
 		// Skip padding and data sections
-		if isPaddingOrData(instructions, i) {
+		if (hints == nil || !hints.IsCode(inst.Address)) && isPaddingOrData(instructions, i) {
 			continue
 		}
 
@@ -255,8 +355,9 @@ func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Functi
 			isStart = true
 		}
 
-		// 2. Instruction after RET is likely a new function
-		if i > 0 && instructions[i-1].Mnemonic == "ret" {
+		// 2. Instruction after RET, and the padding after it, is likely a
+		// new function, unless a branch before the return jumps past it
+		if afterReturn(instructions, i) && inst.Address > prevReach {
 			// Skip padding/nops after return
 			if inst.Mnemonic != "nop" && inst.Mnemonic != "int" &&
 			   !strings.HasPrefix(inst.Mnemonic, "unk_") {
@@ -264,37 +365,43 @@ func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Functi
 			}
 		}
 
-		// 3. Traditional prologue: push rbp/ebp
-		if inst.Mnemonic == "push" && (inst.Operands == "rbp" || inst.Operands == "ebp") {
-			// Verify this looks like real code (not in padding area)
-			if i+1 < len(instructions) && !isPaddingSequence(instructions, i, 5) {
-				isStart = true
-			}
-		}
-
-		// 4. Modern frame setup: sub rsp, imm
-		if inst.Mnemonic == "sub" && strings.Contains(inst.Operands, "rsp") {
-			// Check if previous instruction could be function start
-			if i == 0 || instructions[i-1].Category == CatReturn {
-				isStart = true
-			}
+		// 3. Frame setup the architecture recognises as a prologue, unless
+		// a branch before it jumps past it: compilers move the frame setup
+		// after an early-out test (if (done) return;)
+		if prologue != nil && inst.Address > prevReach && prologue(instructions, i) {
+			isStart = true
 		}
 
-		// 5. Common function entry patterns
-		if inst.Mnemonic == "mov" && strings.Contains(inst.Operands, "rsp") {
-			// mov reg, rsp often starts functions
-			if i == 0 || (i > 0 && instructions[i-1].Category == CatReturn) {
-				isStart = true
-			}
+		// 4. Targets of direct calls
+		if callTargets[inst.Address] {
+			isStart = true
 		}
 
 		if isStart {
 			funcStarts[inst.Address] = true
+			reach = coldPathReach(0, inst, bounds[i])
+			if end, ok := functionEnd(inst.Address); ok {
+				knownEnd = end
+			}
 		}
 	}
 
 	// Second pass: create functions
+	var currentEnd uint64 // End of currentFunc, 0 if found by its return
+	reach = 0
 	for i, inst := range instructions {
+		// Data regions end the current function
+		if hints != nil && hints.IsData(inst.Address) {
+			if currentFunc != nil {
+				if n := len(currentFunc.Instructions); n > 0 {
+					currentFunc.EndAddr = currentFunc.Instructions[n-1].Address
+					functions = append(functions, *currentFunc)
+				}
+				currentFunc = nil
+			}
+			continue
+		}
+
 		// Start new function at marked addresses
 		if funcStarts[inst.Address] {
 			if currentFunc != nil {
@@ -305,6 +412,11 @@ func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Functi
 			}
 
 			name := symbolMap[inst.Address]
+			if hints != nil {
+				if userName, _ := hints.FunctionName(inst.Address); userName != "" {
+					name = userName
+				}
+			}
 			if name == "" {
 				name = fmt.Sprintf("sub_%x", inst.Address)
 			}
@@ -313,18 +425,29 @@ func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Functi
 				Name:      name,
 				StartAddr: inst.Address,
 			}
+			currentEnd = 0
+			reach = 0
+			if end, ok := functionEnd(inst.Address); ok {
+				currentEnd = end
+			}
+		} else if currentFunc != nil && currentEnd != 0 && inst.Address >= currentEnd {
+			// Past the end of a function of known extent
+			currentFunc.EndAddr = currentFunc.Instructions[len(currentFunc.Instructions)-1].Address
+			functions = append(functions, *currentFunc)
+			currentFunc = nil
 		}
 
 		if currentFunc != nil {
 			currentFunc.Instructions = append(currentFunc.Instructions, inst)
+			reach = coldPathReach(reach, inst, bounds[i])
 
-			// Track function calls
-			if inst.Mnemonic == "call" {
-				currentFunc.Calls = append(currentFunc.Calls, inst.Address)
+			// Track direct call targets
+			if inst.Mnemonic == "call" && inst.BranchTarget != 0 {
+				currentFunc.Calls = append(currentFunc.Calls, inst.BranchTarget)
 			}
 
-			// Function epilogue: ret
-			if inst.Mnemonic == "ret" {
+			// Function epilogue: ret, unless more of the function follows
+			if inst.Mnemonic == "ret" && inst.Address+uint64(inst.Size) >= currentEnd && inst.Address >= reach {
 				currentFunc.EndAddr = inst.Address
 				if len(currentFunc.Instructions) > 0 {
 					functions = append(functions, *currentFunc)
@@ -345,6 +468,37 @@ func FindFunctions(instructions []Instruction, symbols []parser.Symbol) []Functi
 	return functions
 }
 
+// maxColdPath bounds how far past a function's return a conditional
+// branch may lead and still be taken to stay within the function
+const maxColdPath = 0x100
+
+// coldPathReach returns reach extended to the target of a conditional
+// branch forward over a short distance. Compilers move unlikely paths
+// after the return, so a function continues up to its furthest such
+// target. Targets at or past bound, the next known function start, are
+// jumps to another function rather than cold paths.
+func coldPathReach(reach uint64, inst Instruction, bound uint64) uint64 {
+	if inst.IsConditional && inst.BranchTarget > inst.Address && inst.BranchTarget-inst.Address <= maxColdPath &&
+		inst.BranchTarget < bound && inst.BranchTarget > reach {
+		return inst.BranchTarget
+	}
+	return reach
+}
+
+// afterReturn reports whether instruction i follows a return, possibly
+// with padding between them
+func afterReturn(instructions []Instruction, i int) bool {
+	for i--; i >= 0; i-- {
+		switch inst := instructions[i]; {
+		case inst.Mnemonic == "ret":
+			return true
+		case inst.Mnemonic != "nop" && (inst.Mnemonic != "int" || inst.Operands != "3"):
+			return false
+		}
+	}
+	return false
+}
+
 // isPaddingOrData detects if an instruction is likely padding or data
 func isPaddingOrData(instructions []Instruction, index int) bool {
 	if index >= len(instructions) {
@@ -0,0 +1,86 @@
+package disasm
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"expeer/pkg/parser"
+)
+
+// decodeAll decodes hex-encoded x86-64 code at addr
+func decodeAll(t *testing.T, code string, addr uint64) []Instruction {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(strings.Fields(code), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var instructions []Instruction
+	for offset := 0; offset < len(data); {
+		inst, size := EnhancedDecodeInstruction(data[offset:], addr+uint64(offset), "x86_64")
+		if size == 0 {
+			t.Fatalf("cannot decode at 0x%x", addr+uint64(offset))
+		}
+		instructions = append(instructions, inst)
+		offset += size
+	}
+	return instructions
+}
+
+// gcc's __do_global_dtors_aux: the frame setup follows an early-out test
+// whose return comes after the main body
+const globalDtorsAux = `
+f3 0f 1e fa
+80 3d 1d 2f 00 00 00
+75 2b
+55
+48 83 3d da 2e 00 00 00
+48 89 e5
+74 0c
+48 8b 3d fe 2e 00 00
+e8 29 ff ff ff
+e8 64 ff ff ff
+c6 05 f5 2e 00 00 01
+5d
+c3
+0f 1f 00
+c3
+0f 1f 80 00 00 00 00`
+
+func TestFindFunctionsPrologueAfterBranch(t *testing.T) {
+	instructions := decodeAll(t, globalDtorsAux, 0x10f0)
+	symbols := []parser.Symbol{{Name: "__do_global_dtors_aux", Address: 0x10f0}}
+
+	functions := FindFunctions(instructions, symbols, "x86_64")
+	if len(functions) != 1 {
+		for _, fn := range functions {
+			t.Logf("%s @ 0x%x", fn.Name, fn.StartAddr)
+		}
+		t.Fatalf("got %d functions, want 1", len(functions))
+	}
+	if fn := functions[0]; fn.Name != "__do_global_dtors_aux" || fn.EndAddr != 0x1128 {
+		t.Errorf("got %s ending at 0x%x, want __do_global_dtors_aux ending at 0x1128", fn.Name, fn.EndAddr)
+	}
+}
+
+func TestFindFunctionsSymbolSize(t *testing.T) {
+	// f: push rbp; ret; push rbp; mov rbp, rsp; ret, all one symbol,
+	// followed by g
+	instructions := decodeAll(t, "55 c3 55 48 89 e5 c3 55 c3", 0x1000)
+	symbols := []parser.Symbol{
+		{Name: "f", Address: 0x1000, Size: 7},
+		{Name: "g", Address: 0x1007, Size: 2},
+	}
+
+	functions := FindFunctions(instructions, symbols, "x86_64")
+	var got []string
+	for _, fn := range functions {
+		got = append(got, fn.Name)
+	}
+	if strings.Join(got, ",") != "f,g" {
+		t.Fatalf("got functions %v, want [f g]", got)
+	}
+	if len(functions[0].Instructions) != 5 {
+		t.Errorf("f has %d instructions, want 5", len(functions[0].Instructions))
+	}
+}
@@ -1,5 +1,7 @@
 package disasm
 
+import "expeer/pkg/parser"
+
 // InstructionCategory represents the category of an instruction
 type InstructionCategory int
 
@@ -111,11 +113,6 @@ func (i *Instruction) GetOperandCount() int {
 	return count
 }
 
-// Section represents a section in a binary (moved from parser to avoid circular dependency)
-type Section struct {
-	Name    string
-	Address uint64
-	Size    uint64
-	Data    []byte
-	Flags   uint32
-}
+// Section is the parser's section, so disassembly reads the file mapping
+// directly instead of a copy
+type Section = parser.Section
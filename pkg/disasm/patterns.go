@@ -3,6 +3,7 @@ package disasm
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 )
 
 // EnhancedDecodeInstruction provides significantly improved x86/x64 decoding
@@ -21,15 +22,25 @@ func EnhancedDecodeInstruction(data []byte, addr uint64, arch string) (Instructi
 
 	// Handle prefixes
 	rexW := false
+	rep := byte(0) // F2 or F3, which also select scalar SSE forms
+	px := operandPrefixes{addr64: is64bit}
 	for offset < len(data) && offset < 4 {
 		switch data[offset] {
 		case 0xF0: // LOCK prefix
 			offset++
 		case 0xF2: // REPNE/REPNZ prefix
+			rep = data[offset]
 			offset++
 		case 0xF3: // REP/REPE/REPZ prefix
+			rep = data[offset]
 			offset++
-		case 0x2E, 0x36, 0x3E, 0x26, 0x64, 0x65: // Segment overrides
+		case 0x2E, 0x36, 0x3E, 0x26: // Segment overrides, ignored in 64-bit mode
+			offset++
+		case 0x64: // FS segment override
+			px.segment = "fs"
+			offset++
+		case 0x65: // GS segment override
+			px.segment = "gs"
 			offset++
 		case 0x66: // Operand size override
 			offset++
@@ -39,6 +50,7 @@ func EnhancedDecodeInstruction(data []byte, addr uint64, arch string) (Instructi
 			// Check for REX prefix (0x40-0x4F in 64-bit mode)
 			if is64bit && data[offset] >= 0x40 && data[offset] <= 0x4F {
 				rexW = (data[offset] & 0x08) != 0
+				px.rex = data[offset]
 				offset++
 			}
 			goto prefixes_done
@@ -53,17 +65,20 @@ prefixes_done:
 	opcode := data[offset]
 	offset++
 
+	// REX.B extends the register encoded in the opcode byte
+	rexB := int(px.rex&0x01) << 3
+
 	switch opcode {
 	// Push/Pop instructions
 	case 0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57: // PUSH r64
 		inst.Mnemonic = "push"
-		inst.Operands = regName64(int(opcode-0x50), rexW)
+		inst.Operands = regName64(int(opcode-0x50)|rexB, is64bit)
 		inst.Category = CatStack
 		inst.RegsRead = []string{inst.Operands}
 
 	case 0x58, 0x59, 0x5A, 0x5B, 0x5C, 0x5D, 0x5E, 0x5F: // POP r64
 		inst.Mnemonic = "pop"
-		inst.Operands = regName64(int(opcode-0x58), rexW)
+		inst.Operands = regName64(int(opcode-0x58)|rexB, is64bit)
 		inst.Category = CatStack
 		inst.RegsWritten = []string{inst.Operands}
 
@@ -77,7 +92,8 @@ prefixes_done:
 		inst.Mnemonic = "mov"
 		inst.Category = CatDataTransfer
 		// Decode ModR/M
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -93,15 +109,24 @@ prefixes_done:
 		inst.Category = CatDataTransfer
 		offset++
 
-	case 0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBE, 0xBF: // MOV r32/r64, imm32
-		if offset+4 > len(data) {
+	case 0xB8, 0xB9, 0xBA, 0xBB, 0xBC, 0xBD, 0xBE, 0xBF: // MOV r32, imm32 / MOV r64, imm64
+		size := 4
+		if rexW {
+			size = 8
+		}
+		if offset+size > len(data) {
 			return Instruction{}, 0
 		}
-		imm := binary.LittleEndian.Uint32(data[offset : offset+4])
+		var imm uint64
+		if rexW {
+			imm = binary.LittleEndian.Uint64(data[offset : offset+8])
+		} else {
+			imm = uint64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		}
 		inst.Mnemonic = "mov"
-		inst.Operands = fmt.Sprintf("%s, 0x%x", regName64(int(opcode-0xB8), rexW), imm)
+		inst.Operands = fmt.Sprintf("%s, 0x%x", regName64(int(opcode-0xB8)|rexB, rexW), imm)
 		inst.Category = CatDataTransfer
-		offset += 4
+		offset += size
 
 	// Arithmetic instructions
 	case 0x01, 0x03: // ADD
@@ -112,7 +137,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "add"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -127,7 +153,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "sub"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -143,7 +170,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "and"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -158,7 +186,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "or"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -173,7 +202,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "xor"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -189,7 +219,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "cmp"
 		inst.Category = CatCompare
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if opcode&0x02 != 0 {
 			inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 		} else {
@@ -204,7 +235,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "test"
 		inst.Category = CatCompare
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// Jumps
@@ -281,9 +313,16 @@ prefixes_done:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "set" + jccMnemonic(opcode2-0x90)[1:] // setcc
 			inst.Category = CatDataTransfer
-			offset++
+			dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			if modrm>>6 == 3 {
+				dest = regNameNarrow(int(modrm&0x7)|rexB, 8, px.rex != 0)
+			}
+			inst.Operands = dest
 
 		// CMOVcc - Conditional move
 		case 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47,
@@ -291,86 +330,120 @@ prefixes_done:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "cmov" + jccMnemonic(opcode2-0x40)[1:]
 			inst.Category = CatDataTransfer
-			offset++
+			src, dest, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
-		// MOVZX - Move with zero extend
-		case 0xB6, 0xB7:
+		// MOVZX/MOVSX - Move with zero or sign extend
+		case 0xB6, 0xB7, 0xBE, 0xBF:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
-			inst.Mnemonic = "movzx"
-			inst.Category = CatDataTransfer
+			modrm := data[offset]
 			offset++
-
-		// MOVSX - Move with sign extend
-		case 0xBE, 0xBF:
-			if offset >= len(data) {
-				return Instruction{}, 0
+			if opcode2 < 0xBE {
+				inst.Mnemonic = "movzx"
+			} else {
+				inst.Mnemonic = "movsx"
 			}
-			inst.Mnemonic = "movsx"
 			inst.Category = CatDataTransfer
-			offset++
+			src, dest, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			if modrm>>6 == 3 {
+				// The source is the low byte or word of a register
+				bits := 8
+				if opcode2&0x01 != 0 {
+					bits = 16
+				}
+				src = regNameNarrow(int(modrm&0x7)|rexB, bits, px.rex != 0)
+			}
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// BSF/BSR - Bit scan
 		case 0xBC, 0xBD:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			if opcode2 == 0xBC {
 				inst.Mnemonic = "bsf"
 			} else {
 				inst.Mnemonic = "bsr"
 			}
 			inst.Category = CatLogical
-			offset++
+			src, dest, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// BT/BTS/BTR/BTC - Bit test
-		case 0xA3: // BT
-			inst.Mnemonic = "bt"
-			inst.Category = CatLogical
-		case 0xAB: // BTS
-			inst.Mnemonic = "bts"
-			inst.Category = CatLogical
-		case 0xB3: // BTR
-			inst.Mnemonic = "btr"
-			inst.Category = CatLogical
-		case 0xBB: // BTC
-			inst.Mnemonic = "btc"
+		case 0xA3, 0xAB, 0xB3, 0xBB:
+			if offset >= len(data) {
+				return Instruction{}, 0
+			}
+			modrm := data[offset]
+			offset++
+			switch opcode2 {
+			case 0xA3:
+				inst.Mnemonic = "bt"
+			case 0xAB:
+				inst.Mnemonic = "bts"
+			case 0xB3:
+				inst.Mnemonic = "btr"
+			case 0xBB:
+				inst.Mnemonic = "btc"
+			}
 			inst.Category = CatLogical
+			dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// IMUL - Extended multiply
 		case 0xAF:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "imul"
 			inst.Category = CatArithmetic
-			offset++
+			src, dest, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// XADD - Exchange and add
-		case 0xC0, 0xC1:
+		// CMPXCHG - Compare and exchange
+		case 0xC0, 0xC1, 0xB0, 0xB1:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
-			inst.Mnemonic = "xadd"
-			inst.Category = CatArithmetic
+			modrm := data[offset]
 			offset++
-
-		// CMPXCHG - Compare and exchange
-		case 0xB0, 0xB1:
-			if offset >= len(data) {
-				return Instruction{}, 0
+			if opcode2 >= 0xC0 {
+				inst.Mnemonic = "xadd"
+			} else {
+				inst.Mnemonic = "cmpxchg"
 			}
-			inst.Mnemonic = "cmpxchg"
 			inst.Category = CatArithmetic
-			offset++
+			dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			if opcode2&0x01 == 0 {
+				// Byte forms
+				src = regNameNarrow(int((modrm>>3)&0x7)|int(px.rex&0x04)<<1, 8, px.rex != 0)
+				if modrm>>6 == 3 {
+					dest = regNameNarrow(int(modrm&0x7)|rexB, 8, px.rex != 0)
+				}
+			}
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// BSWAP - Byte swap
 		case 0xC8, 0xC9, 0xCA, 0xCB, 0xCC, 0xCD, 0xCE, 0xCF:
 			inst.Mnemonic = "bswap"
-			inst.Operands = regName64(int(opcode2-0xC8), rexW)
+			inst.Operands = regName64(int(opcode2-0xC8)|rexB, rexW)
 			inst.Category = CatDataTransfer
 
 		// MOVD/MOVQ - Move to/from MMX/SSE
@@ -378,117 +451,233 @@ prefixes_done:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "movd"
+			if rexW {
+				inst.Mnemonic = "movq"
+			}
 			inst.Category = CatDataTransfer
-			offset++
+			rm, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			reg := xmmName(int((modrm>>3)&0x7) | int(px.rex&0x04)<<1)
+			if opcode2 == 0x6E {
+				inst.Operands = fmt.Sprintf("%s, %s", reg, rm)
+			} else {
+				inst.Operands = fmt.Sprintf("%s, %s", rm, reg)
+			}
 
-		// MOVUPS/MOVAPS - Move unaligned/aligned packed single
+		// MOVUPS/MOVAPS - Move unaligned/aligned packed single, and
+		// MOVSS/MOVSD with an F3/F2 prefix
 		case 0x10, 0x11, 0x28, 0x29:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
-			if opcode2 == 0x10 || opcode2 == 0x11 {
-				inst.Mnemonic = "movups"
-			} else {
+			modrm := data[offset]
+			offset++
+			switch {
+			case opcode2 >= 0x28:
 				inst.Mnemonic = "movaps"
+			case rep == 0xF3:
+				inst.Mnemonic = "movss"
+			case rep == 0xF2:
+				inst.Mnemonic = "movsd"
+			default:
+				inst.Mnemonic = "movups"
 			}
 			inst.Category = CatDataTransfer
-			offset++
+			rm, reg, n := decodeModRMXMM(&inst, modrm, data[offset:], px)
+			offset += n
+			if opcode2&0x01 == 0 {
+				inst.Operands = fmt.Sprintf("%s, %s", reg, rm)
+			} else {
+				inst.Operands = fmt.Sprintf("%s, %s", rm, reg)
+			}
 
 		// XORPS/XORPD - XOR packed single/double
 		case 0x57:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "xorps"
 			inst.Category = CatLogical
-			offset++
+			rm, reg, n := decodeModRMXMM(&inst, modrm, data[offset:], px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", reg, rm)
 
 		// ADDSD/ADDSS/SUBSD/SUBSS - SSE arithmetic
 		case 0x58, 0x59, 0x5C, 0x5D, 0x5E, 0x5F:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			switch opcode2 {
 			case 0x58:
-				inst.Mnemonic = "addps"
+				inst.Mnemonic = "add"
 			case 0x59:
-				inst.Mnemonic = "mulps"
+				inst.Mnemonic = "mul"
 			case 0x5C:
-				inst.Mnemonic = "subps"
+				inst.Mnemonic = "sub"
 			case 0x5D:
-				inst.Mnemonic = "minps"
+				inst.Mnemonic = "min"
 			case 0x5E:
-				inst.Mnemonic = "divps"
+				inst.Mnemonic = "div"
 			case 0x5F:
-				inst.Mnemonic = "maxps"
+				inst.Mnemonic = "max"
+			}
+			switch rep {
+			case 0xF3:
+				inst.Mnemonic += "ss"
+			case 0xF2:
+				inst.Mnemonic += "sd"
+			default:
+				inst.Mnemonic += "ps"
 			}
 			inst.Category = CatArithmetic
-			offset++
+			rm, reg, n := decodeModRMXMM(&inst, modrm, data[offset:], px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", reg, rm)
 
 		// PCMPEQ - Packed compare equal
 		case 0x74, 0x75, 0x76:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
-			inst.Mnemonic = "pcmpeq"
-			inst.Category = CatCompare
+			modrm := data[offset]
 			offset++
+			inst.Mnemonic = "pcmpeq" + []string{"b", "w", "d"}[opcode2-0x74]
+			inst.Category = CatCompare
+			rm, reg, n := decodeModRMXMM(&inst, modrm, data[offset:], px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", reg, rm)
 
 		// MOVNTI - Move non-temporal integer
 		case 0xC3:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "movnti"
 			inst.Category = CatDataTransfer
-			offset++
+			dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 		// PREFETCH - Prefetch
 		case 0x18:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
+			modrm := data[offset]
+			offset++
 			inst.Mnemonic = "prefetch"
 			inst.Category = CatOther
-			offset++
+			target, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = target
 
 		// UD2 - Undefined instruction (intentional)
 		case 0x0B:
 			inst.Mnemonic = "ud2"
 			inst.Category = CatInterrupt
 
-		// LFENCE/MFENCE/SFENCE - Memory barriers
+		// LFENCE/MFENCE/SFENCE - Memory barriers, and the state
+		// save/restore forms that take a memory operand
 		case 0xAE:
 			if offset >= len(data) {
 				return Instruction{}, 0
 			}
 			modrm := data[offset]
+			offset++
 			reg := (modrm >> 3) & 0x7
-			switch reg {
-			case 5:
-				inst.Mnemonic = "lfence"
-			case 6:
-				inst.Mnemonic = "mfence"
-			case 7:
-				inst.Mnemonic = "sfence"
-			default:
-				inst.Mnemonic = "0f_ae"
-			}
 			inst.Category = CatOther
-			offset++
+			if modrm>>6 == 3 {
+				switch reg {
+				case 5:
+					inst.Mnemonic = "lfence"
+				case 6:
+					inst.Mnemonic = "mfence"
+				case 7:
+					inst.Mnemonic = "sfence"
+				default:
+					inst.Mnemonic = "0f_ae"
+				}
+				break
+			}
+			inst.Mnemonic = []string{"fxsave", "fxrstor", "ldmxcsr", "stmxcsr",
+				"xsave", "xrstor", "xsaveopt", "clflush"}[reg]
+			target, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = target
+
+		// ENDBR64/ENDBR32 (F3 0F 1E FA/FB) and the other hint NOPs
+		case 0x1E:
+			if offset >= len(data) {
+				return Instruction{}, 0
+			}
+			modrm := data[offset]
+			inst.Mnemonic = "nop"
+			if rep == 0xF3 && (modrm == 0xFA || modrm == 0xFB) {
+				inst.Mnemonic = "endbr64"
+				if modrm == 0xFB {
+					inst.Mnemonic = "endbr32"
+				}
+				offset++
+			} else {
+				var operand Instruction
+				offset++
+				_, _, n := decodeModRMDetailed(&operand, modrm, data[offset:], false, px)
+				offset += n
+			}
+			inst.Category = CatNop
 
 		// NOP variants (multi-byte)
 		case 0x1F, 0x0D:
 			inst.Mnemonic = "nop"
 			inst.Category = CatNop
 			if offset < len(data) {
-				offset++ // Skip ModRM
+				// Consume the whole memory operand: compilers pad with
+				// forms like nopw %cs:0x0(%rax,%rax,1). A scratch
+				// instruction keeps the operand out of memory tracking.
+				var operand Instruction
+				modrm := data[offset]
+				offset++
+				_, _, n := decodeModRMDetailed(&operand, modrm, data[offset:], false, px)
+				offset += n
 			}
 
 		default:
 			inst.Mnemonic = fmt.Sprintf("0f_%02x", opcode2)
 			inst.Category = CatUnknown
+			// Consume the operand bytes all the same, so the next
+			// instruction starts in the right place
+			hasModRM, imm := twoByteOperands(opcode2)
+			if opcode2 == 0x38 || opcode2 == 0x3A {
+				// Three-byte opcodes
+				if offset >= len(data) {
+					return Instruction{}, 0
+				}
+				inst.Mnemonic = fmt.Sprintf("0f_%02x_%02x", opcode2, data[offset])
+				offset++
+			}
+			if hasModRM {
+				if offset >= len(data) {
+					return Instruction{}, 0
+				}
+				var operand Instruction
+				modrm := data[offset]
+				offset++
+				_, _, n := decodeModRMDetailed(&operand, modrm, data[offset:], rexW, px)
+				offset += n
+			}
+			if offset+imm > len(data) {
+				return Instruction{}, 0
+			}
+			offset += imm
 		}
 
 	// Call
@@ -522,16 +711,30 @@ prefixes_done:
 				inst.Mnemonic = "dec"
 			}
 			inst.Category = CatArithmetic
+			dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
+			inst.Operands = dest
 		case 2: // CALL r/m
 			inst.Mnemonic = "call"
 			inst.Category = CatCall
 			inst.FallsThrough = true
+			offset += decodeIndirectTarget(&inst, modrm, data[offset:], px)
 		case 4: // JMP r/m
 			inst.Mnemonic = "jmp"
 			inst.Category = CatJump
 			inst.IsBranch = true
+			offset += decodeIndirectTarget(&inst, modrm, data[offset:], px)
+		case 6: // PUSH r/m
+			inst.Mnemonic = "push"
+			inst.Category = CatStack
+			src, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], is64bit, px)
+			offset += n
+			inst.Operands = src
 		default:
 			inst.Mnemonic = "ff_op"
+			var operand Instruction
+			_, _, n := decodeModRMDetailed(&operand, modrm, data[offset:], rexW, px)
+			offset += n
 		}
 
 	// Return
@@ -558,8 +761,9 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "lea"
 		inst.Category = CatDataTransfer
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
-		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
+		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// NOP
 	case 0x90:
@@ -626,13 +830,14 @@ prefixes_done:
 		modrm := data[offset]
 		offset++
 		inst.Mnemonic = "xchg"
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 		inst.Category = CatDataTransfer
 
 	case 0x91, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97: // XCHG rAX, r
 		inst.Mnemonic = "xchg"
-		inst.Operands = fmt.Sprintf("%s, %s", regName64(0, rexW), regName64(int(opcode-0x90), rexW))
+		inst.Operands = fmt.Sprintf("%s, %s", regName64(0, rexW), regName64(int(opcode-0x90)|rexB, rexW))
 		inst.Category = CatDataTransfer
 
 	// LAHF/SAHF
@@ -813,7 +1018,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "add"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// ADD r8, r/m8
@@ -825,7 +1031,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "add"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// OR r/m8, r8
@@ -837,7 +1044,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "or"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// OR r8, r/m8
@@ -849,7 +1057,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "or"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// ADC r/m8, r8 / ADC r/m, r
@@ -861,7 +1070,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "adc"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// ADC r8, r/m8 / ADC r, r/m
@@ -873,7 +1083,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "adc"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// SBB r/m8, r8 / SBB r/m, r
@@ -885,7 +1096,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "sbb"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// SBB r8, r/m8 / SBB r, r/m
@@ -897,7 +1109,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "sbb"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// AND r/m8, r8
@@ -909,7 +1122,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "and"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// AND r8, r/m8
@@ -921,7 +1135,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "and"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// SUB r/m8, r8
@@ -933,7 +1148,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "sub"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// SUB r8, r/m8
@@ -945,7 +1161,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "sub"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// XOR r/m8, r8
@@ -957,7 +1174,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "xor"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// XOR r8, r/m8
@@ -969,7 +1187,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "xor"
 		inst.Category = CatLogical
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// CMP r/m8, r8
@@ -981,7 +1200,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "cmp"
 		inst.Category = CatCompare
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// CMP r8, r/m8
@@ -993,7 +1213,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "cmp"
 		inst.Category = CatCompare
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", src, dest)
 
 	// Group 1: Immediate arithmetic/logical operations
@@ -1037,7 +1258,8 @@ prefixes_done:
 		}
 
 		// Decode r/m
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 
 		// Get immediate value
 		var imm uint32
@@ -1226,7 +1448,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "test"
 		inst.Category = CatCompare
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// MOV with immediate
@@ -1238,7 +1461,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "mov"
 		inst.Category = CatDataTransfer
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if offset >= len(data) {
 			return Instruction{}, 0
 		}
@@ -1254,7 +1478,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "mov"
 		inst.Category = CatDataTransfer
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if offset+4 > len(data) {
 			return Instruction{}, 0
 		}
@@ -1327,7 +1552,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "imul"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if offset+4 > len(data) {
 			return Instruction{}, 0
 		}
@@ -1343,7 +1569,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "imul"
 		inst.Category = CatArithmetic
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		if offset >= len(data) {
 			return Instruction{}, 0
 		}
@@ -1367,7 +1594,8 @@ prefixes_done:
 			inst.Mnemonic = "fe_op"
 		}
 		inst.Category = CatArithmetic
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = dest
 
 	// Loop instructions
@@ -1441,7 +1669,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "bound"
 		inst.Category = CatOther
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	case 0x63: // ARPL (16-bit) or MOVSXD (64-bit)
@@ -1456,7 +1685,8 @@ prefixes_done:
 			inst.Mnemonic = "arpl"
 		}
 		inst.Category = CatDataTransfer
-		dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 
 	// String I/O instructions
@@ -1487,7 +1717,8 @@ prefixes_done:
 		inst.Category = CatDataTransfer
 		sreg := (modrm >> 3) & 0x7
 		sregs := []string{"es", "cs", "ss", "ds", "fs", "gs", "seg6", "seg7"}
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", dest, sregs[sreg])
 
 	case 0x8E: // MOV Sreg, r/m
@@ -1500,7 +1731,8 @@ prefixes_done:
 		inst.Category = CatDataTransfer
 		sreg := (modrm >> 3) & 0x7
 		sregs := []string{"es", "cs", "ss", "ds", "fs", "gs", "seg6", "seg7"}
-		_, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+		_, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = fmt.Sprintf("%s, %s", sregs[sreg], src)
 
 	case 0x8F: // POP r/m
@@ -1511,7 +1743,8 @@ prefixes_done:
 		offset++
 		inst.Mnemonic = "pop"
 		inst.Category = CatStack
-		dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+		dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+		offset += n
 		inst.Operands = dest
 
 	// TEST AL, imm8
@@ -1541,7 +1774,7 @@ prefixes_done:
 		}
 		moffs := binary.LittleEndian.Uint32(data[offset : offset+4])
 		inst.Mnemonic = "mov"
-		inst.Operands = fmt.Sprintf("al, [0x%x]", moffs)
+		inst.Operands = fmt.Sprintf("al, %s[0x%x]", px.segmentPrefix(), moffs)
 		inst.Category = CatDataTransfer
 		offset += 4
 
@@ -1551,7 +1784,7 @@ prefixes_done:
 		}
 		moffs := binary.LittleEndian.Uint32(data[offset : offset+4])
 		inst.Mnemonic = "mov"
-		inst.Operands = fmt.Sprintf("eax, [0x%x]", moffs)
+		inst.Operands = fmt.Sprintf("eax, %s[0x%x]", px.segmentPrefix(), moffs)
 		inst.Category = CatDataTransfer
 		offset += 4
 
@@ -1561,7 +1794,7 @@ prefixes_done:
 		}
 		moffs := binary.LittleEndian.Uint32(data[offset : offset+4])
 		inst.Mnemonic = "mov"
-		inst.Operands = fmt.Sprintf("[0x%x], al", moffs)
+		inst.Operands = fmt.Sprintf("%s[0x%x], al", px.segmentPrefix(), moffs)
 		inst.Category = CatDataTransfer
 		offset += 4
 
@@ -1571,7 +1804,7 @@ prefixes_done:
 		}
 		moffs := binary.LittleEndian.Uint32(data[offset : offset+4])
 		inst.Mnemonic = "mov"
-		inst.Operands = fmt.Sprintf("[0x%x], eax", moffs)
+		inst.Operands = fmt.Sprintf("%s[0x%x], eax", px.segmentPrefix(), moffs)
 		inst.Category = CatDataTransfer
 		offset += 4
 
@@ -1740,7 +1973,8 @@ prefixes_done:
 				offset++
 				inst.Mnemonic = "les"
 				inst.Category = CatDataTransfer
-				dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+				dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+				offset += n
 				inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 			}
 		}
@@ -1761,7 +1995,8 @@ prefixes_done:
 				offset++
 				inst.Mnemonic = "lds"
 				inst.Category = CatDataTransfer
-				dest, src := decodeModRMDetailed(modrm, data[offset:], rexW)
+				dest, src, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+				offset += n
 				inst.Operands = fmt.Sprintf("%s, %s", dest, src)
 			}
 		}
@@ -1813,7 +2048,8 @@ prefixes_done:
 			}
 		} else {
 			inst.Mnemonic = "fld"
-			dest, _ := decodeModRMDetailed(modrm, data[offset:], rexW)
+			dest, _, n := decodeModRMDetailed(&inst, modrm, data[offset:], rexW, px)
+			offset += n
 			inst.Operands = dest
 		}
 		inst.Category = CatOther
@@ -1893,6 +2129,10 @@ prefixes_done:
 	}
 
 	inst.Size = offset
+	if inst.MemoryBase == "rip" {
+		// Relative to the end of the instruction
+		inst.MemoryDisp += int64(addr) + int64(inst.Size)
+	}
 	if inst.Size > 0 && inst.Size <= len(data) {
 		inst.Bytes = data[:inst.Size]
 	}
@@ -1923,29 +2163,180 @@ func regName64(n int, is64 bool) string {
 	return fmt.Sprintf("r%dd", n)
 }
 
-func decodeModRMDetailed(modrm byte, data []byte, is64 bool) (string, string) {
+// regNameNarrow names the 8- or 16-bit part of register n. Without a REX
+// prefix, byte registers 4-7 are ah, ch, dh and bh rather than spl, bpl,
+// sil and dil
+func regNameNarrow(n int, bits int, rex bool) string {
+	if bits == 8 {
+		if n < 4 || (n < 8 && !rex) {
+			return regName8(n)
+		}
+		if n < 8 {
+			return []string{"spl", "bpl", "sil", "dil"}[n-4]
+		}
+		return fmt.Sprintf("r%db", n)
+	}
+	if n < 8 {
+		return []string{"ax", "cx", "dx", "bx", "sp", "bp", "si", "di"}[n]
+	}
+	return fmt.Sprintf("r%dw", n)
+}
+
+// xmmName names SSE register n
+func xmmName(n int) string {
+	return fmt.Sprintf("xmm%d", n)
+}
+
+// operandPrefixes are the prefixes that change how ModR/M operands
+// decode
+type operandPrefixes struct {
+	rex     byte   // REX prefix, 0 if none: R, X and B extend reg, index and base
+	segment string // fs or gs override, "" if none
+	addr64  bool   // 64-bit addressing
+}
+
+// segmentPrefix returns the segment override to print before a memory
+// operand, such as "fs:"
+func (p operandPrefixes) segmentPrefix() string {
+	if p.segment == "" {
+		return ""
+	}
+	return p.segment + ":"
+}
+
+// decodeModRMDetailed decodes a ModR/M operand pair, including SIB and
+// displacement bytes, and fills in the memory access fields of inst.
+// Returns the r/m operand, the reg operand and the number of bytes consumed
+// after the ModR/M byte. RIP-relative displacements are resolved to an
+// absolute address once the instruction length is known.
+func decodeModRMDetailed(inst *Instruction, modrm byte, data []byte, is64 bool, px operandPrefixes) (string, string, int) {
 	mod := (modrm >> 6) & 0x3
-	reg := int((modrm >> 3) & 0x7)
+	reg := int((modrm>>3)&0x7) | int(px.rex&0x04)<<1
 	rm := int(modrm & 0x7)
+	rexB := int(px.rex&0x01) << 3
+	addr64 := px.addr64
 
 	regStr := regName64(reg, is64)
-	rmStr := regName64(rm, is64)
+	if mod == 3 {
+		return regName64(rm|rexB, is64), regStr, 0
+	}
 
+	consumed := 0
+	base := regName64(rm|rexB, addr64)
+	index := ""
+	scale := 1
+
+	if rm == 4 { // SIB byte follows
+		if len(data) < 1 {
+			return "?", regStr, 0
+		}
+		sib := data[0]
+		consumed++
+		scale = 1 << ((sib >> 6) & 0x3)
+		if idx := int((sib>>3)&0x7) | int(px.rex&0x02)<<2; idx != 4 {
+			index = regName64(idx, addr64)
+		}
+		base = regName64(int(sib&0x7)|rexB, addr64)
+		if sib&0x7 == 5 && mod == 0 {
+			base = ""
+			mod = 2 // disp32 with no base
+		}
+	} else if rm == 5 && mod == 0 {
+		base = ""
+		if addr64 {
+			base = "rip"
+		}
+		mod = 2
+	}
+
+	var disp int64
 	switch mod {
-	case 0:
-		return fmt.Sprintf("[%s]", rmStr), regStr
 	case 1:
-		return fmt.Sprintf("[%s+0x%x]", rmStr, data[0]), regStr
+		if len(data) < consumed+1 {
+			return "?", regStr, consumed
+		}
+		disp = int64(int8(data[consumed]))
+		consumed++
 	case 2:
-		if len(data) >= 4 {
-			disp := binary.LittleEndian.Uint32(data[:4])
-			return fmt.Sprintf("[%s+0x%x]", rmStr, disp), regStr
+		if len(data) < consumed+4 {
+			return "?", regStr, consumed
 		}
-		return fmt.Sprintf("[%s+disp32]", rmStr), regStr
-	case 3:
-		return rmStr, regStr
+		disp = int64(int32(binary.LittleEndian.Uint32(data[consumed : consumed+4])))
+		consumed += 4
+	}
+
+	inst.HasMemoryAccess = true
+	inst.MemoryBase = base
+	inst.MemoryIndex = index
+	inst.MemoryScale = scale
+	inst.MemoryDisp = disp
+
+	var sb strings.Builder
+	sb.WriteString(px.segmentPrefix())
+	sb.WriteString("[")
+	sb.WriteString(base)
+	if index != "" {
+		if base != "" {
+			sb.WriteString("+")
+		}
+		sb.WriteString(fmt.Sprintf("%s*%d", index, scale))
+	}
+	switch {
+	case base == "" && index == "":
+		sb.WriteString(fmt.Sprintf("0x%x", uint32(disp)))
+	case disp < 0:
+		sb.WriteString(fmt.Sprintf("-0x%x", -disp))
+	case disp > 0:
+		sb.WriteString(fmt.Sprintf("+0x%x", disp))
+	}
+	sb.WriteString("]")
+
+	return sb.String(), regStr, consumed
+}
+
+// decodeModRMXMM decodes a ModR/M operand pair of an SSE instruction,
+// whose register operands are xmm registers rather than general purpose
+// ones. Returns the r/m operand, the reg operand and the number of bytes
+// consumed after the ModR/M byte.
+func decodeModRMXMM(inst *Instruction, modrm byte, data []byte, px operandPrefixes) (string, string, int) {
+	rm, _, consumed := decodeModRMDetailed(inst, modrm, data, false, px)
+	if modrm>>6 == 3 {
+		rm = xmmName(int(modrm&0x7) | int(px.rex&0x01)<<3)
+	}
+	return rm, xmmName(int((modrm>>3)&0x7) | int(px.rex&0x04)<<1), consumed
+}
+
+// decodeIndirectTarget decodes the r/m operand of an indirect CALL/JMP and
+// sets it as the instruction's operand. Returns the number of bytes
+// consumed after the ModR/M byte.
+func decodeIndirectTarget(inst *Instruction, modrm byte, data []byte, px operandPrefixes) int {
+	target, _, consumed := decodeModRMDetailed(inst, modrm, data, px.addr64, px)
+	inst.Operands = target
+	switch {
+	case !inst.HasMemoryAccess:
+		inst.RegsRead = []string{target}
+	case inst.MemoryBase != "":
+		inst.RegsRead = []string{inst.MemoryBase}
+	}
+	return consumed
+}
+
+// twoByteOperands tells whether a two-byte opcode 0F xx takes a ModR/M
+// operand and how many immediate bytes follow it. For 0F 38 and 0F 3A,
+// it describes what follows the third opcode byte.
+func twoByteOperands(opcode2 byte) (bool, int) {
+	switch {
+	case opcode2 >= 0x70 && opcode2 <= 0x73, opcode2 == 0xA4, opcode2 == 0xAC,
+		opcode2 == 0xBA, opcode2 == 0xC2, opcode2 >= 0xC4 && opcode2 <= 0xC6, opcode2 == 0x3A:
+		return true, 1
+	case opcode2 <= 0x03, opcode2 == 0x0D, opcode2 >= 0x10 && opcode2 <= 0x2F,
+		opcode2 >= 0x40 && opcode2 <= 0x6F, opcode2 >= 0x74 && opcode2 <= 0x76,
+		opcode2 >= 0x78 && opcode2 <= 0x7F, opcode2 >= 0x90 && opcode2 <= 0x9F,
+		opcode2 == 0xA3, opcode2 == 0xA5, opcode2 >= 0xAB && opcode2 <= 0xC1,
+		opcode2 == 0xC3, opcode2 == 0xC7, opcode2 >= 0xD0, opcode2 == 0x38:
+		return true, 0
 	}
-	return "?", "?"
+	return false, 0
 }
 
 func jccMnemonic(opcode byte) string {
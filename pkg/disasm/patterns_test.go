@@ -0,0 +1,96 @@
+package disasm
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestEnhancedDecodeInstruction(t *testing.T) {
+	tests := []struct {
+		code string
+		arch string
+		want string
+	}{
+		// REX.R and REX.B select r8-r15
+		{"4c 8d 25 f9 0f 00 00", "x86_64", "lea r12, [rip+0xff9]"},
+		{"41 54", "x86_64", "push r12"},
+		{"41 5e", "x86_64", "pop r14"},
+		{"49 89 c4", "x86_64", "mov r12, rax"},
+		{"4d 8b 46 08", "x86_64", "mov r8, [r14+0x8]"},
+		{"49 3b 66 10", "x86_64", "cmp rsp, [r14+0x10]"},
+		{"4a 8b 04 e0", "x86_64", "mov rax, [rax+r12*8]"},
+		{"41 ff d5", "x86_64", "call r13"},
+		{"41 b8 2a 00 00 00", "x86_64", "mov r8d, 0x2a"},
+		{"48 b8 88 77 66 55 44 33 22 11", "x86_64", "mov rax, 0x1122334455667788"},
+
+		// fs and gs segment overrides
+		{"64 48 8b 04 25 28 00 00 00", "x86_64", "mov rax, fs:[0x28]"},
+		{"65 48 8b 0c 25 30 00 00 00", "x86_64", "mov rcx, gs:[0x30]"},
+		{"64 a1 00 00 00 00", "x86", "mov eax, fs:[0x0]"},
+
+		// Two-byte opcodes consume SIB and displacement bytes
+		{"0f b6 47 25", "x86_64", "movzx eax, [rdi+0x25]"},
+		{"0f b6 04 0a", "x86_64", "movzx eax, [rdx+rcx*1]"},
+		{"0f b7 87 00 01 00 00", "x86_64", "movzx eax, [rdi+0x100]"},
+		{"0f b6 c0", "x86_64", "movzx eax, al"},
+		{"48 0f be 44 24 08", "x86_64", "movsx rax, [rsp+0x8]"},
+		{"0f bf 8a 00 10 00 00", "x86_64", "movsx ecx, [rdx+0x1000]"},
+		{"48 0f 4c 44 24 10", "x86_64", "cmovl rax, [rsp+0x10]"},
+		{"0f 45 82 00 10 00 00", "x86_64", "cmovne eax, [rdx+0x1000]"},
+		{"0f 44 c1", "x86_64", "cmove eax, ecx"},
+		{"44 0f 11 7a 10", "x86_64", "movups [rdx+0x10], xmm15"},
+		{"0f 10 04 24", "x86_64", "movups xmm0, [rsp]"},
+		{"0f 28 81 00 02 00 00", "x86_64", "movaps xmm0, [rcx+0x200]"},
+		{"f3 0f 10 45 fc", "x86_64", "movss xmm0, [rbp-0x4]"},
+		{"0f af 44 24 04", "x86_64", "imul eax, [rsp+0x4]"},
+		{"0f af 47 08", "x86_64", "imul eax, [rdi+0x8]"},
+		{"48 0f af 87 00 01 00 00", "x86_64", "imul rax, [rdi+0x100]"},
+		{"0f 94 c0", "x86_64", "sete al"},
+		{"0f 9f 44 24 08", "x86_64", "setg [rsp+0x8]"},
+		{"0f 95 85 00 01 00 00", "x86_64", "setne [rbp+0x100]"},
+		{"0f bc 44 24 08", "x86_64", "bsf eax, [rsp+0x8]"},
+		{"0f bd 47 08", "x86_64", "bsr eax, [rdi+0x8]"},
+		{"48 0f bd 87 00 01 00 00", "x86_64", "bsr rax, [rdi+0x100]"},
+		{"f0 0f c1 44 24 08", "x86_64", "xadd [rsp+0x8], eax"},
+		{"f0 0f c1 47 08", "x86_64", "xadd [rdi+0x8], eax"},
+		{"f0 48 0f c1 87 00 01 00 00", "x86_64", "xadd [rdi+0x100], rax"},
+		{"f0 48 0f b1 0c 24", "x86_64", "cmpxchg [rsp], rcx"},
+		{"f0 0f b1 57 08", "x86_64", "cmpxchg [rdi+0x8], edx"},
+		{"f0 0f b1 97 00 01 00 00", "x86_64", "cmpxchg [rdi+0x100], edx"},
+		{"f2 0f 58 44 24 08", "x86_64", "addsd xmm0, [rsp+0x8]"},
+		{"0f 57 c0", "x86_64", "xorps xmm0, xmm0"},
+		{"66 0f 7e 45 f8", "x86_64", "movd [rbp-0x8], xmm0"},
+		{"66 0f 7e 85 00 01 00 00", "x86_64", "movd [rbp+0x100], xmm0"},
+		{"66 0f 6e 04 24", "x86_64", "movd xmm0, [rsp]"},
+		{"66 48 0f 6e c7", "x86_64", "movq xmm0, rdi"},
+		{"f3 0f 1e fa", "x86_64", "endbr64"},
+		{"0f b6 44 24 08", "x86", "movzx eax, [esp+0x8]"},
+
+		// No REX: the classic registers
+		{"48 8d 05 f9 0f 00 00", "x86_64", "lea rax, [rip+0xff9]"},
+		{"8b 45 fc", "x86", "mov eax, [ebp-0x4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			code, err := hex.DecodeString(strings.ReplaceAll(tt.code, " ", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			inst, size := EnhancedDecodeInstruction(code, 0x1000, tt.arch)
+			if size != len(code) {
+				t.Errorf("size %d, want %d", size, len(code))
+			}
+			got := inst.Mnemonic
+			if inst.Operands != "" {
+				got += " " + inst.Operands
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if inst.MemoryBase == "rip" && inst.MemoryDisp != 0x2000 {
+				t.Errorf("RIP-relative address 0x%x, want 0x2000", inst.MemoryDisp)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+// Immediate operands of WebAssembly instructions
+const (
+	wasmNone     = iota
+	wasmBlock    // Block type
+	wasmLabel    // Label index of br/br_if
+	wasmTable    // br_table label vector
+	wasmFunc     // Function index
+	wasmIndirect // Type index and table index of call_indirect
+	wasmIndex    // Local, global, table or other index
+	wasmMemArg   // Alignment and offset
+	wasmI32      // Signed LEB128 constants
+	wasmI64
+	wasmF32
+	wasmF64
+	wasmSelect  // Vector of value types
+	wasmRefType // Reference type byte
+	wasmByte    // Reserved byte of memory.size/grow
+)
+
+// wasmOp describes an opcode
+type wasmOp struct {
+	name     string
+	imm      int
+	category InstructionCategory
+}
+
+// wasmOps maps the single-byte opcodes to their instruction
+var wasmOps = func() map[byte]wasmOp {
+	ops := map[byte]wasmOp{
+		0x00: {"unreachable", wasmNone, CatInterrupt},
+		0x01: {"nop", wasmNone, CatNop},
+		0x02: {"block", wasmBlock, CatOther},
+		0x03: {"loop", wasmBlock, CatOther},
+		0x04: {"if", wasmBlock, CatJump},
+		0x05: {"else", wasmNone, CatJump},
+		0x0b: {"end", wasmNone, CatOther},
+		0x0c: {"br", wasmLabel, CatJump},
+		0x0d: {"br_if", wasmLabel, CatJump},
+		0x0e: {"br_table", wasmTable, CatJump},
+		0x0f: {"return", wasmNone, CatReturn},
+		0x10: {"call", wasmFunc, CatCall},
+		0x11: {"call_indirect", wasmIndirect, CatCall},
+		0x12: {"return_call", wasmFunc, CatJump},
+		0x13: {"return_call_indirect", wasmIndirect, CatJump},
+		0x1a: {"drop", wasmNone, CatStack},
+		0x1b: {"select", wasmNone, CatOther},
+		0x1c: {"select", wasmSelect, CatOther},
+		0x20: {"local.get", wasmIndex, CatDataTransfer},
+		0x21: {"local.set", wasmIndex, CatDataTransfer},
+		0x22: {"local.tee", wasmIndex, CatDataTransfer},
+		0x23: {"global.get", wasmIndex, CatDataTransfer},
+		0x24: {"global.set", wasmIndex, CatDataTransfer},
+		0x25: {"table.get", wasmIndex, CatDataTransfer},
+		0x26: {"table.set", wasmIndex, CatDataTransfer},
+		0x3f: {"memory.size", wasmByte, CatOther},
+		0x40: {"memory.grow", wasmByte, CatOther},
+		0x41: {"i32.const", wasmI32, CatDataTransfer},
+		0x42: {"i64.const", wasmI64, CatDataTransfer},
+		0x43: {"f32.const", wasmF32, CatDataTransfer},
+		0x44: {"f64.const", wasmF64, CatDataTransfer},
+		0xd0: {"ref.null", wasmRefType, CatDataTransfer},
+		0xd1: {"ref.is_null", wasmNone, CatCompare},
+		0xd2: {"ref.func", wasmIndex, CatDataTransfer},
+	}
+
+	memory := strings.Fields(`i32.load i64.load f32.load f64.load
+		i32.load8_s i32.load8_u i32.load16_s i32.load16_u
+		i64.load8_s i64.load8_u i64.load16_s i64.load16_u i64.load32_s i64.load32_u
+		i32.store i64.store f32.store f64.store
+		i32.store8 i32.store16 i64.store8 i64.store16 i64.store32`)
+	for i, name := range memory {
+		ops[byte(0x28+i)] = wasmOp{name, wasmMemArg, CatDataTransfer}
+	}
+
+	numeric := strings.Fields(`i32.eqz i32.eq i32.ne i32.lt_s i32.lt_u i32.gt_s i32.gt_u i32.le_s i32.le_u i32.ge_s i32.ge_u
+		i64.eqz i64.eq i64.ne i64.lt_s i64.lt_u i64.gt_s i64.gt_u i64.le_s i64.le_u i64.ge_s i64.ge_u
+		f32.eq f32.ne f32.lt f32.gt f32.le f32.ge
+		f64.eq f64.ne f64.lt f64.gt f64.le f64.ge
+		i32.clz i32.ctz i32.popcnt i32.add i32.sub i32.mul i32.div_s i32.div_u i32.rem_s i32.rem_u
+		i32.and i32.or i32.xor i32.shl i32.shr_s i32.shr_u i32.rotl i32.rotr
+		i64.clz i64.ctz i64.popcnt i64.add i64.sub i64.mul i64.div_s i64.div_u i64.rem_s i64.rem_u
+		i64.and i64.or i64.xor i64.shl i64.shr_s i64.shr_u i64.rotl i64.rotr
+		f32.abs f32.neg f32.ceil f32.floor f32.trunc f32.nearest f32.sqrt
+		f32.add f32.sub f32.mul f32.div f32.min f32.max f32.copysign
+		f64.abs f64.neg f64.ceil f64.floor f64.trunc f64.nearest f64.sqrt
+		f64.add f64.sub f64.mul f64.div f64.min f64.max f64.copysign
+		i32.wrap_i64 i32.trunc_f32_s i32.trunc_f32_u i32.trunc_f64_s i32.trunc_f64_u
+		i64.extend_i32_s i64.extend_i32_u i64.trunc_f32_s i64.trunc_f32_u i64.trunc_f64_s i64.trunc_f64_u
+		f32.convert_i32_s f32.convert_i32_u f32.convert_i64_s f32.convert_i64_u f32.demote_f64
+		f64.convert_i32_s f64.convert_i32_u f64.convert_i64_s f64.convert_i64_u f64.promote_f32
+		i32.reinterpret_f32 i64.reinterpret_f64 f32.reinterpret_i32 f64.reinterpret_i64
+		i32.extend8_s i32.extend16_s i64.extend8_s i64.extend16_s i64.extend32_s`)
+	for i, name := range numeric {
+		category := CatArithmetic
+		op := name[strings.IndexByte(name, '.')+1:]
+		switch {
+		case op == "eqz" || op == "eq" || op == "ne" || op == "lt" || op == "gt" || op == "le" || op == "ge" ||
+			strings.HasPrefix(op, "lt_") || strings.HasPrefix(op, "gt_") ||
+			strings.HasPrefix(op, "le_") || strings.HasPrefix(op, "ge_"):
+			category = CatCompare
+		case op == "and" || op == "or" || op == "xor" || strings.HasPrefix(op, "sh") || strings.HasPrefix(op, "rot"):
+			category = CatLogical
+		}
+		ops[byte(0x45+i)] = wasmOp{name, wasmNone, category}
+	}
+	return ops
+}()
+
+// wasmPrefixedOps maps the 0xfc-prefixed opcodes to their instruction;
+// their immediates are listed in wasmPrefixedImmediates
+var wasmPrefixedOps = strings.Fields(`i32.trunc_sat_f32_s i32.trunc_sat_f32_u i32.trunc_sat_f64_s i32.trunc_sat_f64_u
+	i64.trunc_sat_f32_s i64.trunc_sat_f32_u i64.trunc_sat_f64_s i64.trunc_sat_f64_u
+	memory.init data.drop memory.copy memory.fill
+	table.init elem.drop table.copy table.grow table.size table.fill`)
+
+// wasmPrefixedImmediates gives the number of index immediates of each
+// 0xfc-prefixed opcode
+var wasmPrefixedImmediates = []int{0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 2, 1, 2, 1, 2, 1, 1, 1}
+
+// wasmDecoder reads the immediates of an instruction
+type wasmDecoder struct {
+	data []byte
+	off  int
+	bad  bool
+}
+
+func (d *wasmDecoder) byte() byte {
+	if d.off >= len(d.data) {
+		d.bad = true
+		return 0
+	}
+	b := d.data[d.off]
+	d.off++
+	return b
+}
+
+func (d *wasmDecoder) u32() uint32 {
+	var v uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b := d.byte()
+		v |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	d.bad = true
+	return 0
+}
+
+// signed reads a signed LEB128 integer of at most bits bits
+func (d *wasmDecoder) signed(bits uint) int64 {
+	var v int64
+	var shift uint
+	for {
+		b := d.byte()
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+		if shift >= bits || d.bad {
+			d.bad = true
+			return 0
+		}
+	}
+}
+
+func (d *wasmDecoder) fixed(n int) []byte {
+	if d.off+n > len(d.data) {
+		d.bad = true
+		return make([]byte, n)
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+// wasmFrame is an open block, loop or if of a function body
+type wasmFrame struct {
+	loop     bool
+	start    uint64       // Address of the loop instruction
+	cond     int          // Index of the if instruction until its else, -1 otherwise
+	branches []wasmBranch // Branches to the end of the block
+}
+
+// wasmBranch is a branch whose target is not known until its block ends:
+// the BranchTarget of an instruction (entry -1) or an entry of a br_table
+type wasmBranch struct {
+	inst  int
+	entry int
+}
+
+func init() {
+	// WebAssembly keeps its values on an operand stack and in locals: it
+	// has no registers, and arguments are locals of the callee
+	RegisterArch(Arch{
+		Name:        "wasm",
+		PointerSize: 4, // wasm32: linear memory addresses are i32
+		Disassemble: func(section *Section, binary *parser.Binary) []Instruction {
+			if binary.Wasm == nil {
+				return nil // Function bodies are only known from a module
+			}
+			// WebAssembly calls name functions by index
+			return DisassembleWasm(section, binary.Wasm)
+		},
+	})
+}
+
+// DisassembleWasm decodes the bodies of the functions of a WebAssembly
+// module that lie in section, its code section. Branches target the end
+// instruction of their block, or the loop instruction of loops; calls
+// target the function's address, which for imports is their slot.
+// Whatever follows an opcode of an unsupported extension, such as SIMD,
+// is left as one unknown instruction.
+func DisassembleWasm(section *parser.Section, module *parser.WasmModule) []Instruction {
+	var instructions []Instruction
+	end := section.Address + uint64(len(section.Data))
+	for i := range module.Functions {
+		fn := &module.Functions[i]
+		if fn.IsImport() || fn.Address < section.Address || fn.End > end {
+			continue
+		}
+		body := section.Data[fn.Address-section.Address : fn.End-section.Address]
+		instructions = append(instructions, decodeWasmBody(body, fn.Address, module)...)
+	}
+	return instructions
+}
+
+// decodeWasmBody decodes the instructions of one function body, resolving
+// the labels of its branches
+func decodeWasmBody(body []byte, addr uint64, module *parser.WasmModule) []Instruction {
+	var code []Instruction
+	tables := make(map[int][]uint64)   // br_table index -> its targets
+	frames := []*wasmFrame{{cond: -1}} // The body itself is the outermost block
+
+	// label returns where branching to a label goes, recording branches
+	// to block ends for patching
+	label := func(depth uint32, inst, entry int) uint64 {
+		if int(depth) >= len(frames) {
+			return 0
+		}
+		f := frames[len(frames)-1-int(depth)]
+		if f.loop {
+			return f.start
+		}
+		f.branches = append(f.branches, wasmBranch{inst, entry})
+		return 0
+	}
+
+	d := &wasmDecoder{data: body}
+	for d.off < len(body) && len(frames) > 0 {
+		start := d.off
+		inst := Instruction{Address: addr + uint64(start)}
+		index := len(code)
+
+		opcode := d.byte()
+		op, ok := wasmOps[opcode]
+		if sub := uint32(0); opcode == 0xfc {
+			if sub = d.u32(); int(sub) < len(wasmPrefixedOps) {
+				op, ok = wasmOp{wasmPrefixedOps[sub], wasmNone, CatOther}, true
+				if sub < 8 {
+					op.category = CatArithmetic
+				}
+				var args []string
+				for n := wasmPrefixedImmediates[sub]; n > 0; n-- {
+					args = append(args, strconv.FormatUint(uint64(d.u32()), 10))
+				}
+				inst.Operands = strings.Join(args, " ")
+			}
+		}
+		if !ok || d.bad {
+			// SIMD, atomics and other extensions: their length is unknown
+			inst.Mnemonic = fmt.Sprintf("unk_%02x", opcode)
+			inst.Category = CatUnknown
+			inst.Size = len(body) - start
+			inst.Bytes = body[start:]
+			code = append(code, inst)
+			break
+		}
+		inst.Mnemonic = op.name
+		inst.Category = op.category
+
+		switch op.imm {
+		case wasmBlock:
+			switch t := d.byte(); {
+			case t == 0x40:
+			case t >= 0x6f:
+				inst.Operands = parser.WasmValueType(t)
+			default:
+				d.off--
+				inst.Operands = fmt.Sprintf("type %d", d.signed(33))
+			}
+			switch opcode {
+			case 0x02:
+				frames = append(frames, &wasmFrame{cond: -1})
+			case 0x03:
+				frames = append(frames, &wasmFrame{loop: true, start: inst.Address, cond: -1})
+			case 0x04:
+				// Falls into the then branch, jumps past it when false
+				inst.IsConditional = true
+				inst.IsBranch = true
+				inst.FallsThrough = true
+				frames = append(frames, &wasmFrame{cond: index})
+			}
+
+		case wasmLabel:
+			inst.IsBranch = true
+			inst.BranchTarget = label(d.u32(), index, -1)
+			if opcode == 0x0d {
+				inst.IsConditional = true
+				inst.FallsThrough = true
+			}
+
+		case wasmTable:
+			inst.IsBranch = true
+			n := d.u32()
+			if uint64(n) > uint64(len(body)) {
+				d.bad = true
+				break
+			}
+			// The last entry is the default target
+			targets := make([]uint64, n+1)
+			for i := range targets {
+				targets[i] = label(d.u32(), index, i)
+			}
+			tables[index] = targets
+			inst.BranchTarget = targets[n]
+
+		case wasmFunc:
+			fn := d.u32()
+			if int(fn) < len(module.Functions) {
+				inst.BranchTarget = module.Functions[fn].Address
+				inst.Operands = fmt.Sprintf("0x%x", inst.BranchTarget)
+			} else {
+				inst.Operands = fmt.Sprintf("func %d", fn)
+			}
+			inst.IsBranch = true
+			inst.FallsThrough = opcode == 0x10
+
+		case wasmIndirect:
+			typ := d.u32()
+			if table := d.u32(); table != 0 {
+				inst.Operands = fmt.Sprintf("type %d table %d", typ, table)
+			} else {
+				inst.Operands = fmt.Sprintf("type %d", typ)
+			}
+			inst.FallsThrough = opcode == 0x11
+
+		case wasmIndex:
+			inst.Operands = strconv.FormatUint(uint64(d.u32()), 10)
+
+		case wasmMemArg:
+			align := d.u32()
+			offset := d.u32()
+			inst.HasMemoryAccess = true
+			inst.MemoryDisp = int64(offset)
+			var args []string
+			if offset != 0 {
+				args = append(args, fmt.Sprintf("offset=%d", offset))
+			}
+			if align < 32 {
+				args = append(args, fmt.Sprintf("align=%d", 1<<align))
+			}
+			inst.Operands = strings.Join(args, " ")
+
+		case wasmI32:
+			inst.Operands = strconv.FormatInt(int64(int32(d.signed(32))), 10)
+		case wasmI64:
+			inst.Operands = strconv.FormatInt(d.signed(64), 10)
+		case wasmF32:
+			f := math.Float32frombits(binary.LittleEndian.Uint32(d.fixed(4)))
+			inst.Operands = strconv.FormatFloat(float64(f), 'g', -1, 32)
+		case wasmF64:
+			f := math.Float64frombits(binary.LittleEndian.Uint64(d.fixed(8)))
+			inst.Operands = strconv.FormatFloat(f, 'g', -1, 64)
+
+		case wasmSelect:
+			var types []string
+			for n := d.u32(); n > 0 && !d.bad; n-- {
+				types = append(types, parser.WasmValueType(d.byte()))
+			}
+			inst.Operands = strings.Join(types, " ")
+
+		case wasmRefType:
+			inst.Operands = parser.WasmValueType(d.byte())
+
+		case wasmByte:
+			d.byte()
+		}
+
+		switch opcode {
+		case 0x05: // else: the then branch jumps to the end
+			f := frames[len(frames)-1]
+			inst.IsBranch = true
+			if f.cond >= 0 {
+				// The false branch of the if starts after the else
+				code[f.cond].BranchTarget = addr + uint64(d.off)
+				f.cond = -1
+			}
+			f.branches = append(f.branches, wasmBranch{index, -1})
+
+		case 0x0b: // end: resolve the branches to the block
+			f := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if f.cond >= 0 {
+				// An if without else skips to its end when false
+				code[f.cond].BranchTarget = inst.Address
+			}
+			for _, b := range f.branches {
+				if b.entry < 0 {
+					code[b.inst].BranchTarget = inst.Address
+					continue
+				}
+				targets := tables[b.inst]
+				targets[b.entry] = inst.Address
+				if b.entry == len(targets)-1 {
+					code[b.inst].BranchTarget = inst.Address
+				}
+			}
+			if len(frames) == 0 {
+				// The end of the body returns
+				inst.Category = CatReturn
+			}
+		}
+
+		if d.bad {
+			// Truncated: the rest of the body is unknown
+			inst = Instruction{
+				Address:  addr + uint64(start),
+				Mnemonic: "unk",
+				Category: CatUnknown,
+				Size:     len(body) - start,
+				Bytes:    body[start:],
+			}
+			code = append(code, inst)
+			break
+		}
+		inst.Size = d.off - start
+		inst.Bytes = body[start:d.off]
+		code = append(code, inst)
+	}
+
+	// Branch operands name their target once labels are resolved
+	for i := range code {
+		inst := &code[i]
+		switch inst.Mnemonic {
+		case "br", "br_if", "else":
+			inst.Operands = fmt.Sprintf("0x%x", inst.BranchTarget)
+		case "if":
+			inst.Operands = strings.TrimSpace(fmt.Sprintf("%s 0x%x", inst.Operands, inst.BranchTarget))
+		case "br_table":
+			targets := make([]string, len(tables[i]))
+			for j, t := range tables[i] {
+				targets[j] = fmt.Sprintf("0x%x", t)
+			}
+			inst.Operands = strings.Join(targets, " ")
+		}
+	}
+	return code
+}
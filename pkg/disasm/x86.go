@@ -0,0 +1,89 @@
+package disasm
+
+import "strings"
+
+var (
+	// ABISysV is the System V AMD64 convention (Linux, macOS, BSD)
+	ABISysV = ABI{Name: "sysv", IntArgs: []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}}
+	// ABIWin64 is the Microsoft x64 convention
+	ABIWin64 = ABI{Name: "win64", IntArgs: []string{"rcx", "rdx", "r8", "r9"}}
+	// ABIGo is the register-based Go convention on AMD64 (ABIInternal)
+	ABIGo = ABI{Name: "go", IntArgs: []string{"rax", "rbx", "rcx", "rdi", "rsi", "r8", "r9", "r10", "r11"}}
+)
+
+func init() {
+	// 32-bit code uses the low halves of the same registers, so both
+	// backends share the 64-bit names
+	RegisterArch(Arch{
+		Name:        "x86",
+		PointerSize: 4,
+		Decode:      x86Decoder("x86"),
+		Registers:   x86Registers(),
+		Prologue:    x86Prologue,
+	})
+	RegisterArch(Arch{
+		Name:        "x86_64",
+		PointerSize: 8,
+		Decode:      x86Decoder("x86_64"),
+		Registers:   x86Registers(),
+		ABI: func(format string) ABI {
+			if format == "PE" {
+				return ABIWin64
+			}
+			return ABISysV
+		},
+		Prologue: x86Prologue,
+	})
+}
+
+// x86Decoder returns the decoder of an x86 mode: the enhanced decoder,
+// falling back to the simple one for what it does not know
+func x86Decoder(arch string) func([]byte, uint64) (Instruction, int) {
+	return func(data []byte, addr uint64) (Instruction, int) {
+		inst, size := EnhancedDecodeInstruction(data, addr, arch)
+		if size == 0 {
+			inst, size = decodeInstruction(data, addr, arch)
+		}
+		return inst, size
+	}
+}
+
+// x86Registers lists the 64-bit general purpose registers with their 32-,
+// 16- and 8-bit parts
+func x86Registers() [][]string {
+	var regs [][]string
+	for _, r := range []string{"a", "b", "c", "d"} {
+		regs = append(regs, []string{"r" + r + "x", "e" + r + "x", r + "x", r + "l"})
+	}
+	for _, r := range []string{"si", "di"} {
+		regs = append(regs, []string{"r" + r, "e" + r, r, r + "l"})
+	}
+	for _, r := range []string{"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"} {
+		regs = append(regs, []string{r, r + "d", r + "w", r + "b"})
+	}
+	return regs
+}
+
+// x86Prologue recognises the usual ways x86 functions set up their frame:
+// push rbp/ebp, or sub/mov involving rsp right after a return
+func x86Prologue(instructions []Instruction, i int) bool {
+	inst := instructions[i]
+
+	// Traditional prologue: push rbp/ebp, unless it follows the saves of
+	// other callee-saved registers in the same prologue
+	if inst.Mnemonic == "push" && (inst.Operands == "rbp" || inst.Operands == "ebp") &&
+		(i == 0 || instructions[i-1].Mnemonic != "push") {
+		// Verify this looks like real code (not in padding area)
+		if i+1 < len(instructions) && !isPaddingSequence(instructions, i, 5) {
+			return true
+		}
+	}
+
+	// Modern frame setup: sub rsp, imm, or mov reg, rsp
+	if (inst.Mnemonic == "sub" || inst.Mnemonic == "mov") && strings.Contains(inst.Operands, "rsp") {
+		if i == 0 || instructions[i-1].Category == CatReturn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+// Package parallel runs independent per-item work on a bounded pool of
+// goroutines. Results are always returned in input order so that output
+// built from them is deterministic regardless of scheduling.
+package parallel
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Workers returns the pool size for a requested worker count: n if
+// positive, otherwise the number of usable CPUs
+func Workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// For calls fn(i) for every i in [0, count) using at most workers
+// goroutines (0 means one per CPU) and returns when all calls are done.
+// fn must only write to state owned by index i.
+func For(count, workers int, fn func(i int)) {
+	workers = Workers(workers)
+	if workers > count {
+		workers = count
+	}
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// Map applies fn to every item using at most workers goroutines and
+// returns the results in input order
+func Map[T, R any](items []T, workers int, fn func(T) R) []R {
+	results := make([]R, len(items))
+	For(len(items), workers, func(i int) {
+		results[i] = fn(items[i])
+	})
+	return results
+}
@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ArchiveMember represents one file stored in a static library
+type ArchiveMember struct {
+	Name string
+	Data []byte
+}
+
+// IsArchive returns true if data starts with the ar(1) magic used by Unix
+// static libraries and COFF import/static .lib files
+func IsArchive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("!<arch>\n"))
+}
+
+// ReadArchive reads all members of an ar archive from disk
+func ReadArchive(path string) ([]ArchiveMember, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return ParseArchive(data)
+}
+
+// ParseArchive splits an ar archive into its members. GNU (// long name
+// table), BSD (#1/len) and COFF (/ and // linker members) variants are
+// supported; symbol index members are skipped.
+func ParseArchive(data []byte) ([]ArchiveMember, error) {
+	if !IsArchive(data) {
+		return nil, fmt.Errorf("not an ar archive")
+	}
+
+	var members []ArchiveMember
+	var longNames []byte
+	offset := 8
+
+	for offset+60 <= len(data) {
+		header := data[offset : offset+60]
+		if header[58] != '`' || header[59] != '\n' {
+			return members, fmt.Errorf("corrupt archive header at offset 0x%x", offset)
+		}
+
+		name := strings.TrimRight(string(header[0:16]), " ")
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil || size < 0 {
+			return members, fmt.Errorf("bad member size at offset 0x%x", offset)
+		}
+
+		offset += 60
+		if offset+size > len(data) {
+			return members, fmt.Errorf("truncated archive member %q", name)
+		}
+		body := data[offset : offset+size]
+
+		// Members are 2-byte aligned
+		offset += size
+		if offset%2 != 0 {
+			offset++
+		}
+
+		switch {
+		case name == "/" || name == "/SYM64/" || name == "__.SYMDEF" || name == "__.SYMDEF SORTED":
+			continue // Symbol index
+		case name == "//":
+			longNames = body
+			continue
+		case strings.HasPrefix(name, "#1/"): // BSD: name follows the header
+			n, err := strconv.Atoi(name[3:])
+			if err != nil || n > len(body) {
+				continue
+			}
+			name = strings.TrimRight(string(body[:n]), "\x00")
+			body = body[n:]
+			if strings.HasPrefix(name, "__.SYMDEF") {
+				continue
+			}
+		case strings.HasPrefix(name, "/"): // GNU/COFF: offset into long name table
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n >= len(longNames) {
+				continue
+			}
+			end := bytes.IndexAny(longNames[n:], "/\n\x00")
+			if end < 0 {
+				end = len(longNames) - n
+			}
+			name = string(longNames[n : n+end])
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		members = append(members, ArchiveMember{Name: name, Data: body})
+	}
+
+	return members, nil
+}
+
+// Members lists the object files in the static library at path, in
+// archive order. It returns nil if path is not an ar archive. A member
+// sharing its name with earlier ones is listed with its position among
+// them, as in foo.o#2, which selects it in an "archive(member)" path.
+func Members(path string) ([]string, error) {
+	data, unmap, err := mapTarget(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer unmap()
+	if !IsArchive(data) {
+		return nil, nil
+	}
+
+	members, err := ParseArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	seen := make(map[string]int)
+	for _, m := range members {
+		seen[m.Name]++
+		if !isObject(m.Data) {
+			continue
+		}
+		name := m.Name
+		if n := seen[m.Name]; n > 1 {
+			name += "#" + strconv.Itoa(n)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// isObject returns true if data starts like an ELF, Mach-O or COFF file.
+// COFF import libraries also hold short import descriptions, which are not.
+func isObject(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return true
+	case bytes.HasPrefix(data, []byte{0xce, 0xfa, 0xed, 0xfe}), bytes.HasPrefix(data, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return true
+	}
+	return isCOFFObject(data)
+}
+
+// mapTarget maps the file at path. A path of the form "archive(member)"
+// naming no file selects a member of a static library, as in nm and ld,
+// and "archive(member#n)" the nth of the members of that name.
+func mapTarget(path string) ([]byte, func() error, error) {
+	data, unmap, err := mapFile(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return data, unmap, err
+	}
+	open := strings.LastIndexByte(path, '(')
+	if open <= 0 || !strings.HasSuffix(path, ")") {
+		return nil, nil, err
+	}
+	archive, name := path[:open], path[open+1:len(path)-1]
+
+	data, unmap, err = mapFile(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := ParseArchive(data)
+	if err != nil {
+		unmap()
+		return nil, nil, fmt.Errorf("%s: %w", archive, err)
+	}
+	index := 1
+	if i := strings.LastIndexByte(name, '#'); i > 0 {
+		if n, err := strconv.Atoi(name[i+1:]); err == nil && n > 0 {
+			name, index = name[:i], n
+		}
+	}
+	for _, m := range members {
+		if m.Name == name {
+			if index--; index == 0 {
+				return m.Data, unmap, nil
+			}
+		}
+	}
+	unmap()
+	return nil, nil, fmt.Errorf("no member %s in %s", path[open+1:len(path)-1], archive)
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeArchive writes a GNU ar archive holding the given members, in order.
+func writeArchive(t *testing.T, members []ArchiveMember) string {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, m := range members {
+		fmt.Fprintf(&buf, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", m.Name+"/", "0", "0", "0", "644", len(m.Data))
+		buf.Write(m.Data)
+		if len(m.Data)%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	path := filepath.Join(t.TempDir(), "lib.a")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Members of the same name, as ar q leaves them, are listed and selected
+// by their position among that name.
+func TestArchiveDuplicateMembers(t *testing.T) {
+	path := writeArchive(t, []ArchiveMember{
+		{Name: "foo.o", Data: []byte("\x7fELF first")},
+		{Name: "bar.o", Data: []byte("\x7fELF bar")},
+		{Name: "foo.o", Data: []byte("\x7fELF second")},
+	})
+
+	names, err := Members(path)
+	if err != nil {
+		t.Fatalf("Members: %v", err)
+	}
+	if want := []string{"foo.o", "bar.o", "foo.o#2"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Members = %q, want %q", names, want)
+	}
+
+	for member, want := range map[string]string{
+		"foo.o":   "\x7fELF first",
+		"foo.o#1": "\x7fELF first",
+		"foo.o#2": "\x7fELF second",
+		"bar.o":   "\x7fELF bar",
+	} {
+		data, unmap, err := mapTarget(path + "(" + member + ")")
+		if err != nil {
+			t.Errorf("%s: %v", member, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", member, data, want)
+		}
+		unmap()
+	}
+
+	if _, _, err := mapTarget(path + "(foo.o#3)"); err == nil {
+		t.Errorf("foo.o#3: selected a member that does not exist")
+	}
+}
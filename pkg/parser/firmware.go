@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Formats of images without headers, whose architecture the user gives
+const (
+	FormatRaw      = "raw"
+	FormatIntelHex = "Intel HEX"
+	FormatSRecord  = "S-record"
+)
+
+// ErrNoArch is returned for images that do not record their architecture
+// when none was selected
+var ErrNoArch = errors.New("architecture not recorded: select one")
+
+// imageSectionFlags marks the sections of images without section headers
+// as code, like ELF SHF_ALLOC|SHF_EXECINSTR: nothing tells code from data
+const imageSectionFlags = uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR)
+
+// entrySymbol names the entry point of images, which have no symbols, so
+// that a function starts there
+func entrySymbol(addr uint64) Symbol {
+	return Symbol{Name: "entry", Address: addr, Type: "ENTRY"}
+}
+
+// ParseRaw loads a file without any headers, such as a firmware dump or a
+// boot sector, as one section of arch code at address base. Execution is
+// assumed to start at base.
+func ParseRaw(path, arch string, base uint64) (*Binary, error) {
+	if arch == "" {
+		return nil, fmt.Errorf("raw image: %w", ErrNoArch)
+	}
+	data, unmap, err := mapTarget(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) == 0 {
+		unmap()
+		return nil, fmt.Errorf("empty raw image")
+	}
+
+	return &Binary{
+		Format:     FormatRaw,
+		Arch:       arch,
+		Kind:       KindExecutable,
+		EntryPoint: base,
+		Symbols:    []Symbol{entrySymbol(base)},
+		Sections: []Section{{
+			Name:    "raw",
+			Address: base,
+			Size:    uint64(len(data)),
+			Data:    data,
+			Flags:   imageSectionFlags,
+		}},
+		RawData:  data,
+		FilePath: path,
+		unmap:    unmap,
+	}, nil
+}
+
+// imageChunk is the data of one record of a HEX or S-record image
+type imageChunk struct {
+	addr uint64
+	data []byte
+}
+
+// imageSections merges the records of an image into one section per
+// contiguous range of addresses
+func imageSections(chunks []imageChunk) ([]Section, error) {
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].addr < chunks[j].addr })
+
+	var sections []Section
+	for _, c := range chunks {
+		if len(c.data) == 0 {
+			continue
+		}
+		if n := len(sections); n > 0 {
+			last := &sections[n-1]
+			end := last.Address + last.Size
+			if c.addr < end {
+				return nil, fmt.Errorf("overlapping records at 0x%x", c.addr)
+			}
+			if c.addr == end {
+				last.Data = append(last.Data, c.data...)
+				last.Size += uint64(len(c.data))
+				continue
+			}
+		}
+		sections = append(sections, Section{
+			Address: c.addr,
+			Size:    uint64(len(c.data)),
+			Data:    append([]byte(nil), c.data...),
+			Flags:   imageSectionFlags,
+		})
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("image holds no data")
+	}
+	for i := range sections {
+		sections[i].Name = fmt.Sprintf("seg%d", i)
+	}
+	return sections, nil
+}
+
+// newImage returns the binary of a HEX or S-record image. Its architecture
+// is left to the caller. Without a start address, execution is assumed to
+// start at the lowest address.
+func newImage(path string, data []byte, format string, chunks []imageChunk, entry uint64, hasEntry bool) (*Binary, error) {
+	sections, err := imageSections(chunks)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format, err)
+	}
+	if !hasEntry {
+		entry = sections[0].Address
+	}
+	binary := &Binary{
+		Format:     format,
+		Kind:       KindExecutable,
+		EntryPoint: entry,
+		Sections:   sections,
+		RawData:    data,
+		FilePath:   path,
+	}
+	if binary.BytesAt(entry, 1) != nil {
+		binary.Symbols = []Symbol{entrySymbol(entry)}
+	}
+	return binary, nil
+}
+
+// textRecords returns the non-empty lines of a text image
+func textRecords(data []byte) [][]byte {
+	var records [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			records = append(records, line)
+		}
+	}
+	return records
+}
+
+// isHexRecord returns true if line is start followed by an even number of
+// at least min hex digits
+func isHexRecord(line []byte, start string, min int) bool {
+	if !bytes.HasPrefix(line, []byte(start)) {
+		return false
+	}
+	digits := line[len(start):]
+	if len(digits) < min || len(digits)%2 != 0 {
+		return false
+	}
+	for _, c := range digits {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// firstLine returns the first line of data, without surrounding space
+func firstLine(data []byte) []byte {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return bytes.TrimSpace(data)
+}
+
+// isIntelHex returns true if data starts with an Intel HEX record
+func isIntelHex(data []byte) bool {
+	return isHexRecord(firstLine(data), ":", 10)
+}
+
+// parseIntelHex loads an Intel HEX image. Extended segment (02) and linear
+// (04) address records set the upper address bits of the data records
+// that follow; the start address comes from a start segment (03) or start
+// linear (05) address record.
+func parseIntelHex(path string, data []byte) (*Binary, error) {
+	var chunks []imageChunk
+	var upper, entry uint64
+	hasEntry := false
+
+records:
+	for i, line := range textRecords(data) {
+		if !isHexRecord(line, ":", 10) {
+			return nil, fmt.Errorf("Intel HEX line %d: malformed record", i+1)
+		}
+		rec, _ := hex.DecodeString(string(line[1:]))
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		n := int(rec[0])
+		if len(rec) != 5+n {
+			return nil, fmt.Errorf("Intel HEX line %d: record length %d does not match its data", i+1, n)
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("Intel HEX line %d: bad checksum", i+1)
+		}
+		addr := uint64(rec[1])<<8 | uint64(rec[2])
+		payload := rec[4 : 4+n]
+
+		switch typ := rec[3]; typ {
+		case 0x00: // Data
+			chunks = append(chunks, imageChunk{upper + addr, payload})
+		case 0x01: // End of file
+			break records
+		case 0x02: // Extended segment address
+			if n != 2 {
+				return nil, fmt.Errorf("Intel HEX line %d: bad segment address record", i+1)
+			}
+			upper = (uint64(payload[0])<<8 | uint64(payload[1])) << 4
+		case 0x03: // Start segment address, CS:IP
+			if n != 4 {
+				return nil, fmt.Errorf("Intel HEX line %d: bad start address record", i+1)
+			}
+			cs := uint64(payload[0])<<8 | uint64(payload[1])
+			ip := uint64(payload[2])<<8 | uint64(payload[3])
+			entry, hasEntry = cs<<4+ip, true
+		case 0x04: // Extended linear address
+			if n != 2 {
+				return nil, fmt.Errorf("Intel HEX line %d: bad linear address record", i+1)
+			}
+			upper = (uint64(payload[0])<<8 | uint64(payload[1])) << 16
+		case 0x05: // Start linear address
+			if n != 4 {
+				return nil, fmt.Errorf("Intel HEX line %d: bad start address record", i+1)
+			}
+			entry = uint64(payload[0])<<24 | uint64(payload[1])<<16 | uint64(payload[2])<<8 | uint64(payload[3])
+			hasEntry = true
+		default:
+			return nil, fmt.Errorf("Intel HEX line %d: unknown record type %02x", i+1, typ)
+		}
+	}
+
+	return newImage(path, data, FormatIntelHex, chunks, entry, hasEntry)
+}
+
+// isSRecord returns true if data starts with a Motorola S-record
+func isSRecord(data []byte) bool {
+	line := firstLine(data)
+	return len(line) >= 2 && line[0] == 'S' && '0' <= line[1] && line[1] <= '9' &&
+		isHexRecord(line[2:], "", 6)
+}
+
+// sRecordAddressSize returns the size of the address of a record type:
+// 2 bytes for S0/S1/S5/S9, 3 for S2/S6/S8, 4 for S3/S7
+func sRecordAddressSize(typ byte) int {
+	switch typ {
+	case '2', '6', '8':
+		return 3
+	case '3', '7':
+		return 4
+	default:
+		return 2
+	}
+}
+
+// parseSRecord loads a Motorola S-record image. S1/S2/S3 records hold data
+// at 16-, 24- and 32-bit addresses, S7/S8/S9 the start address; header
+// (S0) and count (S5/S6) records are skipped.
+func parseSRecord(path string, data []byte) (*Binary, error) {
+	var chunks []imageChunk
+	var entry uint64
+	hasEntry := false
+
+	for i, line := range textRecords(data) {
+		if len(line) < 2 || line[0] != 'S' || !isHexRecord(line[2:], "", 6) {
+			return nil, fmt.Errorf("S-record line %d: malformed record", i+1)
+		}
+		typ := line[1]
+		rec, _ := hex.DecodeString(string(line[2:]))
+		n := int(rec[0])
+		if len(rec) != 1+n {
+			return nil, fmt.Errorf("S-record line %d: record length %d does not match its data", i+1, n)
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0xff {
+			return nil, fmt.Errorf("S-record line %d: bad checksum", i+1)
+		}
+
+		size := sRecordAddressSize(typ)
+		if n < size+1 {
+			return nil, fmt.Errorf("S-record line %d: record too short", i+1)
+		}
+		var addr uint64
+		for _, b := range rec[1 : 1+size] {
+			addr = addr<<8 | uint64(b)
+		}
+		payload := rec[1+size : n]
+
+		switch typ {
+		case '1', '2', '3':
+			chunks = append(chunks, imageChunk{addr, payload})
+		case '7', '8', '9':
+			entry, hasEntry = addr, true
+		case '0', '5', '6':
+		default:
+			return nil, fmt.Errorf("S-record line %d: unknown record type S%c", i+1, typ)
+		}
+	}
+
+	return newImage(path, data, FormatSRecord, chunks, entry, hasEntry)
+}
@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// imageLayout is the address and size of each section of an image
+type imageLayout struct {
+	addr, size uint64
+}
+
+func checkImage(t *testing.T, b *Binary, format string, entry uint64, layout []imageLayout) {
+	t.Helper()
+	if b.Format != format || b.Kind != KindExecutable || b.Arch != "" {
+		t.Errorf("Format, Kind, Arch = %s, %v, %q, want %s executable of no architecture", b.Format, b.Kind, b.Arch, format)
+	}
+	if b.EntryPoint != entry {
+		t.Errorf("EntryPoint = 0x%x, want 0x%x", b.EntryPoint, entry)
+	}
+	if len(b.Sections) != len(layout) {
+		t.Fatalf("%d sections, want %d", len(b.Sections), len(layout))
+	}
+	for i, sec := range b.Sections {
+		if sec.Address != layout[i].addr || sec.Size != layout[i].size || uint64(len(sec.Data)) != sec.Size {
+			t.Errorf("section %s at 0x%x size 0x%x holding %d bytes, want 0x%x size 0x%x",
+				sec.Name, sec.Address, sec.Size, len(sec.Data), layout[i].addr, layout[i].size)
+		}
+	}
+}
+
+func TestParseIntelHex(t *testing.T) {
+	image := strings.Join([]string{
+		":020000040800F2", // Extended linear address 0x0800
+		":10000000000102030405060708090A0B0C0D0E0F78", // 0x08000000
+		":04001000DEADBEEFB4",                         // 0x08000010, contiguous
+		":040100009090C30018",                         // 0x08000100
+		":0400000508000004EB",                         // Start linear address
+		":00000001FF",
+		":0400000000000000FC", // Past the end of file record
+	}, "\r\n")
+	b, err := parseData("firmware.hex", []byte(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, b, FormatIntelHex, 0x08000004, []imageLayout{{0x08000000, 0x14}, {0x08000100, 4}})
+	if got := b.BytesAt(0x08000010, 4); string(got) != "\xde\xad\xbe\xef" {
+		t.Errorf("BytesAt(0x08000010) = % x, want de ad be ef", got)
+	}
+	if len(b.Symbols) != 1 || b.Symbols[0].Address != 0x08000004 {
+		t.Errorf("Symbols = %v, want the entry point", b.Symbols)
+	}
+
+	// The architecture is the user's to give
+	if _, err := parseArch("firmware.hex", []byte(image), ""); !errors.Is(err, ErrNoArch) {
+		t.Errorf("parseArch() = %v, want ErrNoArch", err)
+	}
+	if b, err := parseArch("firmware.hex", []byte(image), "arm"); err != nil || b.Arch != "arm" {
+		t.Errorf("parseArch(arm) = %v, want an arm image", err)
+	}
+
+	// Segment addresses, and a start address outside the data
+	b, err = parseData("real.hex", []byte(":020000021000EC\n:020000000102FB\n:0400000310000020C9\n:00000001FF\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, b, FormatIntelHex, 0x10020, []imageLayout{{0x10000, 2}})
+	if len(b.Symbols) != 0 {
+		t.Errorf("Symbols = %v for an entry point without code", b.Symbols)
+	}
+
+	// Without a start address, execution starts at the lowest address
+	b, err = parseData("nostart.hex", []byte(":040100009090C30018\n:020000000102FB\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, b, FormatIntelHex, 0, []imageLayout{{0, 2}, {0x100, 4}})
+}
+
+func TestParseIntelHexErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad checksum":     ":020000000102FC",
+		"truncated record": ":020000000102",
+		"odd digits":       ":02000000010",
+		"unknown type":     ":00000006FA",
+		"overlapping":      ":020000000102FB\n:020000000102FB",
+		"no data":          ":00000001FF",
+	}
+	for name, image := range tests {
+		if b, err := parseIntelHex("bad.hex", []byte(":020000040800F2\n"+image+"\n")); err == nil {
+			t.Errorf("%s: parsed %d sections, want an error", name, len(b.Sections))
+		}
+	}
+}
+
+func TestParseSRecord(t *testing.T) {
+	image := strings.Join([]string{
+		"S00700007465737438",         // Header "test"
+		"S10B10000001020304050607C8", // 0x1000
+		"S1051008AABB7D",             // 0x1008, contiguous
+		"S20802000001020304EB",       // 0x20000
+		"S3078000000005066D",         // 0x80000000
+		"S5030003F9",                 // Count
+		"S9031004E8",                 // Start address
+	}, "\n")
+	b, err := parseData("firmware.s19", []byte(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, b, FormatSRecord, 0x1004, []imageLayout{{0x1000, 10}, {0x20000, 4}, {0x80000000, 2}})
+	if got := b.BytesAt(0x1008, 2); string(got) != "\xaa\xbb" {
+		t.Errorf("BytesAt(0x1008) = % x, want aa bb", got)
+	}
+
+	b, err = parseData("firmware.s37", []byte("S3078000000005066D\nS705800000007A\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, b, FormatSRecord, 0x80000000, []imageLayout{{0x80000000, 2}})
+}
+
+func TestParseSRecordErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad checksum":     "S1051008AABB7E",
+		"truncated record": "S1051008AABB",
+		"odd digits":       "S1051008AABB7",
+		"short address":    "S2030000FC",
+		"unknown type":     "S4030000FC",
+		"overlapping":      "S1051008AABB7D\nS1051008AABB7D",
+		"no data":          "S9031004E8",
+	}
+	for name, image := range tests {
+		if b, err := parseSRecord("bad.s19", []byte("S00700007465737438\n"+image+"\n")); err == nil {
+			t.Errorf("%s: parsed %d sections, want an error", name, len(b.Sections))
+		}
+	}
+}
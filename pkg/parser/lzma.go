@@ -0,0 +1,282 @@
+package parser
+
+// rangeDecoder is the binary arithmetic decoder of LZMA
+type rangeDecoder struct {
+	src  []byte
+	pos  int
+	rng  uint32
+	code uint32
+	err  error
+}
+
+const (
+	lzmaProbBits = 11
+	lzmaProbInit = 1 << lzmaProbBits / 2
+	lzmaTopValue = 1 << 24
+)
+
+func newRangeDecoder(src []byte) *rangeDecoder {
+	d := &rangeDecoder{src: src, rng: 0xffffffff}
+	if len(src) < 5 || src[0] != 0 {
+		d.err = errCorrupt
+		return d
+	}
+	for i := 1; i < 5; i++ {
+		d.code = d.code<<8 | uint32(src[i])
+	}
+	d.pos = 5
+	return d
+}
+
+func (d *rangeDecoder) normalize() {
+	if d.rng < lzmaTopValue {
+		d.rng <<= 8
+		d.code <<= 8
+		if d.pos < len(d.src) {
+			d.code |= uint32(d.src[d.pos])
+			d.pos++
+		} else {
+			d.err = errCorrupt
+		}
+	}
+}
+
+// bit decodes a bit with the adaptive probability *p
+func (d *rangeDecoder) bit(p *uint16) uint32 {
+	bound := (d.rng >> lzmaProbBits) * uint32(*p)
+	var b uint32
+	if d.code < bound {
+		*p += (1<<lzmaProbBits - *p) >> 5
+		d.rng = bound
+	} else {
+		*p -= *p >> 5
+		d.code -= bound
+		d.rng -= bound
+		b = 1
+	}
+	d.normalize()
+	return b
+}
+
+// direct decodes n bits of equal probability
+func (d *rangeDecoder) direct(n int) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - (d.code >> 31)
+		d.code += d.rng & t
+		res = res<<1 + t + 1
+		d.normalize()
+	}
+	return res
+}
+
+// bitTree decodes a number of len(probs) bits MSB first
+func (d *rangeDecoder) bitTree(probs []uint16) uint32 {
+	m := uint32(1)
+	for m < uint32(len(probs)) {
+		m = m<<1 + d.bit(&probs[m])
+	}
+	return m - uint32(len(probs))
+}
+
+// reverseBitTree decodes a number of numBits bits LSB first
+func (d *rangeDecoder) reverseBitTree(probs []uint16, numBits int) uint32 {
+	m, sym := uint32(1), uint32(0)
+	for i := 0; i < numBits; i++ {
+		b := d.bit(&probs[m])
+		m = m<<1 + b
+		sym |= b << i
+	}
+	return sym
+}
+
+func newProbs(n int) []uint16 {
+	p := make([]uint16, n)
+	for i := range p {
+		p[i] = lzmaProbInit
+	}
+	return p
+}
+
+// lzmaLength decodes match lengths
+type lzmaLength struct {
+	choice, choice2 uint16
+	low, mid        [][]uint16 // Per position state
+	high            []uint16
+}
+
+func newLZMALength() *lzmaLength {
+	l := &lzmaLength{choice: lzmaProbInit, choice2: lzmaProbInit, high: newProbs(256)}
+	for i := 0; i < 16; i++ {
+		l.low = append(l.low, newProbs(8))
+		l.mid = append(l.mid, newProbs(8))
+	}
+	return l
+}
+
+func (l *lzmaLength) decode(d *rangeDecoder, posState uint32) uint32 {
+	if d.bit(&l.choice) == 0 {
+		return d.bitTree(l.low[posState])
+	}
+	if d.bit(&l.choice2) == 0 {
+		return 8 + d.bitTree(l.mid[posState])
+	}
+	return 16 + d.bitTree(l.high)
+}
+
+// unLZMA decodes a raw LZMA stream with literal context bits lc, literal
+// position bits lp and position bits pb into size bytes. The whole output
+// serves as dictionary.
+func unLZMA(src []byte, size int, lc, lp, pb uint) ([]byte, error) {
+	if lc > 8 || lp > 4 || pb > 4 {
+		return nil, errCorrupt
+	}
+	d := newRangeDecoder(src)
+	out := make([]byte, 0, size)
+
+	const states = 12
+	literals := newProbs(0x300 << (lc + lp))
+	var isMatch, isRep0Long [states << 4]uint16
+	var isRep, isRepG0, isRepG1, isRepG2 [states]uint16
+	for _, p := range [][]uint16{isMatch[:], isRep0Long[:], isRep[:], isRepG0[:], isRepG1[:], isRepG2[:]} {
+		for i := range p {
+			p[i] = lzmaProbInit
+		}
+	}
+	var posSlot [4][]uint16
+	for i := range posSlot {
+		posSlot[i] = newProbs(64)
+	}
+	posDecoders := newProbs(115)
+	align := newProbs(16)
+	lenDecoder, repLenDecoder := newLZMALength(), newLZMALength()
+
+	var rep0, rep1, rep2, rep3 uint32
+	state := uint32(0)
+	pbMask, lpMask := uint32(1)<<pb-1, uint32(1)<<lp-1
+
+	for len(out) < size && d.err == nil {
+		posState := uint32(len(out)) & pbMask
+		if d.bit(&isMatch[state<<4+posState]) == 0 {
+			// Literal, coded against the match byte after a match
+			var prev uint32
+			if len(out) > 0 {
+				prev = uint32(out[len(out)-1])
+			}
+			probs := literals[0x300*((uint32(len(out))&lpMask)<<lc+prev>>(8-lc)):]
+			sym := uint32(1)
+			if state >= 7 && int(rep0) < len(out) {
+				match := uint32(out[len(out)-int(rep0)-1])
+				for sym < 0x100 {
+					matchBit := match >> 7 & 1
+					match <<= 1
+					b := d.bit(&probs[(1+matchBit)<<8+sym])
+					sym = sym<<1 | b
+					if matchBit != b {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym<<1 | d.bit(&probs[sym])
+			}
+			out = append(out, byte(sym))
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if d.bit(&isRep[state]) != 0 {
+			if len(out) == 0 {
+				return nil, errCorrupt
+			}
+			if d.bit(&isRepG0[state]) == 0 {
+				if d.bit(&isRep0Long[state<<4+posState]) == 0 {
+					// Short rep: one byte at rep0
+					if int(rep0) >= len(out) {
+						return nil, errCorrupt
+					}
+					if state < 7 {
+						state = 9
+					} else {
+						state = 11
+					}
+					out = append(out, out[len(out)-int(rep0)-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.bit(&isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if d.bit(&isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			length = repLenDecoder.decode(d, posState)
+			if state < 7 {
+				state = 8
+			} else {
+				state = 11
+			}
+		} else {
+			rep3, rep2, rep1 = rep2, rep1, rep0
+			length = lenDecoder.decode(d, posState)
+			if state < 7 {
+				state = 7
+			} else {
+				state = 10
+			}
+
+			lenState := length
+			if lenState > 3 {
+				lenState = 3
+			}
+			slot := d.bitTree(posSlot[lenState])
+			if slot < 4 {
+				rep0 = slot
+			} else {
+				numBits := int(slot>>1 - 1)
+				rep0 = (2 | slot&1) << numBits
+				if slot < 14 {
+					rep0 += d.reverseBitTree(posDecoders[rep0-slot:], numBits)
+				} else {
+					rep0 += d.direct(numBits-4) << 4
+					rep0 += d.reverseBitTree(align, 4)
+				}
+				if rep0 == 0xffffffff {
+					break // End marker
+				}
+			}
+		}
+
+		length += 2
+		if int(rep0) >= len(out) || len(out)+int(length) > size {
+			return nil, errCorrupt
+		}
+		from := len(out) - int(rep0) - 1
+		for i := 0; i < int(length); i++ {
+			out = append(out, out[from+i])
+		}
+	}
+	if d.err != nil || len(out) != size {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
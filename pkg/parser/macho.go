@@ -0,0 +1,747 @@
+package parser
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"sort"
+)
+
+// Load commands read from the raw command list; debug/macho decodes few
+// of them
+const (
+	lcUnixThread        = 0x5
+	lcLoadDylib         = 0xc
+	lcLazyLoadDylib     = 0x20
+	lcDyldInfo          = 0x22
+	lcFunctionStarts    = 0x26
+	lcLoadWeakDylib     = 0x80000018
+	lcReexportDylib     = 0x8000001f
+	lcDyldInfoOnly      = 0x80000022
+	lcLoadUpwardDylib   = 0x80000023
+	lcMain              = 0x80000028
+	lcDyldExportsTrie   = 0x80000033
+	lcDyldChainedFixups = 0x80000034
+)
+
+// Section types holding pointers to imported symbols, indexed through the
+// indirect symbol table
+const (
+	machoNonLazySymbolPointers = 0x6
+	machoLazySymbolPointers    = 0x7
+)
+
+// machoImage reads what the dynamic loader uses from the load commands of
+// a Mach-O image: entry point, libraries, binds, rebases and exports
+type machoImage struct {
+	f         *macho.File
+	data      []byte
+	order     binary.ByteOrder
+	ptrSize   int
+	segments  []*macho.Segment // In load command order, as numbered by dyld opcodes
+	base      uint64           // Address of the Mach header, the start of __TEXT
+	libraries []string         // Dylibs in load command order, as numbered by bind ordinals
+
+	mainOffset     uint64 // File offset of main (LC_MAIN), 0 if none
+	thread         []byte // Initial thread state (LC_UNIXTHREAD)
+	functionStarts []byte
+	rebases        []byte
+	binds          [][]byte // Bind, weak bind and lazy bind opcodes
+	exportTrie     []byte
+	chainedFixups  []byte
+}
+
+func newMachOImage(f *macho.File, data []byte) *machoImage {
+	img := &machoImage{f: f, data: data, order: f.ByteOrder, ptrSize: 4}
+	if f.Magic == macho.Magic64 {
+		img.ptrSize = 8
+	}
+
+	for _, l := range f.Loads {
+		if seg, ok := l.(*macho.Segment); ok {
+			img.segments = append(img.segments, seg)
+			if seg.Offset == 0 && seg.Filesz > 0 {
+				img.base = seg.Addr
+			}
+			continue
+		}
+
+		raw := l.Raw()
+		if len(raw) < 8 {
+			continue
+		}
+		u32 := func(off int) uint32 {
+			if off+4 > len(raw) {
+				return 0
+			}
+			return img.order.Uint32(raw[off:])
+		}
+		// linkedit_data_command: dataoff, datasize
+		linkedit := func(off int) []byte {
+			return fileRange(data, uint64(u32(off)), uint64(u32(off+4)))
+		}
+
+		switch u32(0) {
+		case lcMain:
+			if len(raw) >= 16 {
+				img.mainOffset = img.order.Uint64(raw[8:])
+			}
+		case lcUnixThread:
+			img.thread = raw[8:]
+		case lcFunctionStarts:
+			img.functionStarts = linkedit(8)
+		case lcDyldInfo, lcDyldInfoOnly:
+			img.rebases = linkedit(8)
+			img.binds = [][]byte{linkedit(16), linkedit(24), linkedit(32)}
+			img.exportTrie = linkedit(40)
+		case lcDyldExportsTrie:
+			img.exportTrie = linkedit(8)
+		case lcDyldChainedFixups:
+			img.chainedFixups = linkedit(8)
+		case lcLoadDylib, lcLoadWeakDylib, lcReexportDylib, lcLazyLoadDylib, lcLoadUpwardDylib:
+			// dylib_command: the name is at an offset in the command
+			name := ""
+			if off := int(u32(8)); off < len(raw) {
+				name = string(raw[off:])
+				if i := bytes.IndexByte(raw[off:], 0); i >= 0 {
+					name = string(raw[off : off+i])
+				}
+			}
+			img.libraries = append(img.libraries, name)
+		}
+	}
+	return img
+}
+
+// fileAddress converts a file offset to the address it is loaded at
+func (img *machoImage) fileAddress(off uint64) (uint64, bool) {
+	for _, seg := range img.segments {
+		if off >= seg.Offset && off < seg.Offset+seg.Filesz {
+			return seg.Addr + off - seg.Offset, true
+		}
+	}
+	return 0, false
+}
+
+// entryPoint returns the address of main (LC_MAIN) or the initial program
+// counter of the thread state (LC_UNIXTHREAD)
+func (img *machoImage) entryPoint() uint64 {
+	if img.mainOffset != 0 {
+		addr, _ := img.fileAddress(img.mainOffset)
+		return addr
+	}
+
+	// Flavor and index of the program counter in each architecture's
+	// thread state, made of 32- or 64-bit registers
+	var flavor uint32
+	var pc int
+	switch img.f.Cpu {
+	case macho.Cpu386:
+		flavor, pc = 1, 10 // x86_THREAD_STATE32: eip
+	case macho.CpuAmd64:
+		flavor, pc = 4, 16 // x86_THREAD_STATE64: rip
+	case macho.CpuArm:
+		flavor, pc = 1, 15 // ARM_THREAD_STATE: r15
+	case macho.CpuArm64:
+		flavor, pc = 6, 32 // ARM_THREAD_STATE64: pc
+	default:
+		return 0
+	}
+	state := img.thread
+	for len(state) >= 8 {
+		f := img.order.Uint32(state)
+		count := int(img.order.Uint32(state[4:])) // In 32-bit words
+		regs := state[8:]
+		if count*4 > len(regs) {
+			break
+		}
+		if f == flavor {
+			if img.ptrSize == 8 && (pc+1)*8 <= count*4 {
+				return img.order.Uint64(regs[pc*8:])
+			}
+			if img.ptrSize == 4 && (pc+1)*4 <= count*4 {
+				return uint64(img.order.Uint32(regs[pc*4:]))
+			}
+		}
+		state = regs[count*4:]
+	}
+	return 0
+}
+
+// functionStartAddresses decodes LC_FUNCTION_STARTS: ULEB128 deltas
+// between consecutive functions, the first from the Mach header
+func (img *machoImage) functionStartAddresses() []uint64 {
+	var starts []uint64
+	r := &lebReader{b: img.functionStarts}
+	addr := img.base
+	for r.more() {
+		delta := r.uleb()
+		if delta == 0 || r.bad {
+			break
+		}
+		addr += delta
+		starts = append(starts, addr)
+	}
+	return starts
+}
+
+// segmentAddress returns the address of an offset in the segment with
+// the given load command index
+func (img *machoImage) segmentAddress(seg int, off uint64) (uint64, bool) {
+	if seg < 0 || seg >= len(img.segments) {
+		return 0, false
+	}
+	return img.segments[seg].Addr + off, true
+}
+
+// Bind and rebase opcodes (the high nibble; the low one is an immediate)
+const (
+	bindDone                    = 0x00
+	bindSetDylibOrdinalImm      = 0x10
+	bindSetDylibOrdinalULEB     = 0x20
+	bindSetDylibSpecialImm      = 0x30
+	bindSetSymbolTrailingFlags  = 0x40
+	bindSetTypeImm              = 0x50
+	bindSetAddendSLEB           = 0x60
+	bindSetSegmentAndOffsetULEB = 0x70
+	bindAddAddrULEB             = 0x80
+	bindDoBind                  = 0x90
+	bindDoBindAddAddrULEB       = 0xa0
+	bindDoBindAddAddrImmScaled  = 0xb0
+	bindDoBindULEBTimesSkipping = 0xc0
+	rebaseSetTypeImm            = 0x10
+	rebaseSetSegmentAndOffset   = 0x20
+	rebaseAddAddrULEB           = 0x30
+	rebaseAddAddrImmScaled      = 0x40
+	rebaseDoRebaseImmTimes      = 0x50
+	rebaseDoRebaseULEBTimes     = 0x60
+	rebaseDoRebaseAddAddrULEB   = 0x70
+	rebaseDoRebaseTimesSkipping = 0x80
+)
+
+// bindRelocations runs the bind, weak bind and lazy bind opcodes of
+// LC_DYLD_INFO. Each bound slot becomes a relocation naming the symbol
+// it is bound to. Lazy binds end each entry with a done opcode, so done
+// only stops at the end of the opcodes.
+func (img *machoImage) bindRelocations() []Relocation {
+	var relocs []Relocation
+	ptr := uint64(img.ptrSize)
+	for _, opcodes := range img.binds {
+		r := &lebReader{b: opcodes}
+		var (
+			symbol string
+			typ    uint32
+			addend int64
+			seg    = -1
+			off    uint64
+		)
+		bind := func() {
+			if addr, ok := img.segmentAddress(seg, off); ok && symbol != "" {
+				relocs = append(relocs, Relocation{Address: addr, Type: typ, Symbol: symbol, Addend: addend})
+			}
+		}
+		for r.more() && !r.bad {
+			b := r.byte()
+			imm := uint64(b & 0x0f)
+			switch b & 0xf0 {
+			case bindDone, bindSetDylibOrdinalImm, bindSetDylibSpecialImm:
+			case bindSetDylibOrdinalULEB:
+				r.uleb()
+			case bindSetSymbolTrailingFlags:
+				symbol = r.cstring()
+			case bindSetTypeImm:
+				typ = uint32(imm)
+			case bindSetAddendSLEB:
+				addend = r.sleb()
+			case bindSetSegmentAndOffsetULEB:
+				seg, off = int(imm), r.uleb()
+			case bindAddAddrULEB:
+				off += r.uleb()
+			case bindDoBind:
+				bind()
+				off += ptr
+			case bindDoBindAddAddrULEB:
+				bind()
+				off += r.uleb() + ptr
+			case bindDoBindAddAddrImmScaled:
+				bind()
+				off += imm*ptr + ptr
+			case bindDoBindULEBTimesSkipping:
+				count, skip := r.uleb(), r.uleb()
+				for i := uint64(0); i < count && !r.bad; i++ {
+					bind()
+					off += skip + ptr
+				}
+			default:
+				// Threaded binds (arm64e) and unknown opcodes
+				r.bad = true
+			}
+		}
+	}
+	return relocs
+}
+
+// rebaseRelocations runs the rebase opcodes of LC_DYLD_INFO, which list
+// the slots holding pointers into the image
+func (img *machoImage) rebaseRelocations() []Relocation {
+	var relocs []Relocation
+	ptr := uint64(img.ptrSize)
+	r := &lebReader{b: img.rebases}
+	var typ uint32
+	seg, off := -1, uint64(0)
+	rebase := func() {
+		if addr, ok := img.segmentAddress(seg, off); ok {
+			relocs = append(relocs, Relocation{Address: addr, Type: typ})
+		}
+	}
+	for r.more() && !r.bad {
+		b := r.byte()
+		imm := uint64(b & 0x0f)
+		switch b & 0xf0 {
+		case bindDone:
+			return relocs
+		case rebaseSetTypeImm:
+			typ = uint32(imm)
+		case rebaseSetSegmentAndOffset:
+			seg, off = int(imm), r.uleb()
+		case rebaseAddAddrULEB:
+			off += r.uleb()
+		case rebaseAddAddrImmScaled:
+			off += imm * ptr
+		case rebaseDoRebaseImmTimes:
+			for i := uint64(0); i < imm; i++ {
+				rebase()
+				off += ptr
+			}
+		case rebaseDoRebaseULEBTimes:
+			for n, i := r.uleb(), uint64(0); i < n && !r.bad; i++ {
+				rebase()
+				off += ptr
+			}
+		case rebaseDoRebaseAddAddrULEB:
+			rebase()
+			off += r.uleb() + ptr
+		case rebaseDoRebaseTimesSkipping:
+			count, skip := r.uleb(), r.uleb()
+			for i := uint64(0); i < count && !r.bad; i++ {
+				rebase()
+				off += skip + ptr
+			}
+		default:
+			r.bad = true
+		}
+	}
+	return relocs
+}
+
+// Chained pointer formats
+const (
+	chainedPtrARM64E           = 1
+	chainedPtr64               = 2
+	chainedPtr32               = 3
+	chainedPtr64Offset         = 6
+	chainedPtrARM64EUserland   = 9
+	chainedPtrARM64EUserland24 = 12
+)
+
+// chainedFixupRelocations walks the pointer chains of
+// LC_DYLD_CHAINED_FIXUPS. Each slot in a chain is either a bind, an index
+// into the import table, or a rebase whose target is encoded in the slot
+// itself and is given as the addend.
+func (img *machoImage) chainedFixupRelocations() []Relocation {
+	fx := img.chainedFixups
+	if len(fx) < 28 {
+		return nil
+	}
+	le := binary.LittleEndian
+	startsOff := le.Uint32(fx[4:])
+	importsOff := le.Uint32(fx[8:])
+	symbolsOff := le.Uint32(fx[12:])
+	importsCount := le.Uint32(fx[16:])
+	importsFormat := le.Uint32(fx[20:])
+
+	// Imports: library ordinal, name offset and addend
+	type chainedImport struct {
+		name   string
+		addend int64
+	}
+	name := func(off uint64) string {
+		b := fileRange(fx, uint64(symbolsOff)+off, uint64(len(fx)))
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	}
+	imports := make([]chainedImport, 0, importsCount)
+	for i := uint32(0); i < importsCount; i++ {
+		var imp chainedImport
+		switch importsFormat {
+		case 1: // DYLD_CHAINED_IMPORT
+			b := fileRange(fx, uint64(importsOff)+uint64(i)*4, 4)
+			if len(b) < 4 {
+				return nil
+			}
+			imp.name = name(uint64(le.Uint32(b) >> 9))
+		case 2: // DYLD_CHAINED_IMPORT_ADDEND
+			b := fileRange(fx, uint64(importsOff)+uint64(i)*8, 8)
+			if len(b) < 8 {
+				return nil
+			}
+			imp.name = name(uint64(le.Uint32(b) >> 9))
+			imp.addend = int64(int32(le.Uint32(b[4:])))
+		case 3: // DYLD_CHAINED_IMPORT_ADDEND64
+			b := fileRange(fx, uint64(importsOff)+uint64(i)*16, 16)
+			if len(b) < 16 {
+				return nil
+			}
+			imp.name = name(le.Uint64(b) >> 32)
+			imp.addend = int64(le.Uint64(b[8:]))
+		default:
+			return nil
+		}
+		imports = append(imports, imp)
+	}
+
+	// dyld_chained_starts_in_image: one entry per segment
+	starts := fileRange(fx, uint64(startsOff), uint64(len(fx)))
+	if len(starts) < 4 {
+		return nil
+	}
+	var relocs []Relocation
+	segCount := int(le.Uint32(starts))
+	for s := 0; s < segCount && s < len(img.segments); s++ {
+		if 4+4*s+4 > len(starts) {
+			break
+		}
+		infoOff := le.Uint32(starts[4+4*s:])
+		if infoOff == 0 {
+			continue
+		}
+		// dyld_chained_starts_in_segment
+		info := fileRange(starts, uint64(infoOff), uint64(len(starts)))
+		if len(info) < 22 {
+			continue
+		}
+		pageSize := uint64(le.Uint16(info[4:]))
+		format := le.Uint16(info[6:])
+		pageCount := int(le.Uint16(info[20:]))
+		seg := img.segments[s]
+		for p := 0; p < pageCount && 22+2*p+2 <= len(info); p++ {
+			start := le.Uint16(info[22+2*p:])
+			if start == 0xffff { // DYLD_CHAINED_PTR_START_NONE
+				continue
+			}
+			off := uint64(p)*pageSize + uint64(start&0x7fff)
+			relocs = img.walkChain(relocs, seg, off, format, func(i int) (string, int64, bool) {
+				if i >= len(imports) {
+					return "", 0, false
+				}
+				return imports[i].name, imports[i].addend, true
+			})
+		}
+	}
+	return relocs
+}
+
+// walkChain follows one chain of fixups starting at off in seg. lookup
+// returns the symbol and addend of an import.
+func (img *machoImage) walkChain(relocs []Relocation, seg *macho.Segment, off uint64, format uint16, lookup func(int) (string, int64, bool)) []Relocation {
+	le := binary.LittleEndian
+	for steps := 0; off < seg.Filesz && steps < 1<<20; steps++ {
+		addr := seg.Addr + off
+		slot := fileRange(img.data, seg.Offset+off, 8)
+
+		var raw uint64
+		if format == chainedPtr32 {
+			if len(slot) < 4 {
+				break
+			}
+			raw = uint64(le.Uint32(slot))
+		} else {
+			if len(slot) < 8 {
+				break
+			}
+			raw = le.Uint64(slot)
+		}
+
+		var next, stride uint64
+		r := Relocation{Address: addr}
+		switch format {
+		case chainedPtr64, chainedPtr64Offset:
+			next, stride = raw>>51&0xfff, 4
+			if raw>>63 != 0 {
+				name, addend, ok := lookup(int(raw & 0xffffff))
+				if !ok {
+					return relocs
+				}
+				r.Symbol, r.Addend = name, addend+int64(raw>>24&0xff)
+			} else {
+				target := raw&(1<<36-1) | (raw>>36&0xff)<<56
+				if format == chainedPtr64Offset {
+					target += img.base
+				}
+				r.Addend = int64(target)
+			}
+		case chainedPtrARM64E, chainedPtrARM64EUserland, chainedPtrARM64EUserland24:
+			next, stride = raw>>51&0x7ff, 8
+			auth, bind := raw>>63 != 0, raw>>62&1 != 0
+			if bind {
+				ordinal := raw & 0xffff
+				if format == chainedPtrARM64EUserland24 {
+					ordinal = raw & 0xffffff
+				}
+				name, addend, ok := lookup(int(ordinal))
+				if !ok {
+					return relocs
+				}
+				if !auth {
+					// 19-bit signed addend
+					addend += int64(raw>>32&0x7ffff) << 45 >> 45
+				}
+				r.Symbol, r.Addend = name, addend
+			} else {
+				target := raw & (1<<32 - 1)
+				if !auth {
+					target = raw&(1<<43-1) | (raw>>43&0xff)<<56
+				}
+				if auth || format != chainedPtrARM64E {
+					target += img.base
+				}
+				r.Addend = int64(target)
+			}
+		case chainedPtr32:
+			next, stride = raw>>26&0x1f, 4
+			if raw>>31 != 0 {
+				name, addend, ok := lookup(int(raw & 0xfffff))
+				if !ok {
+					return relocs
+				}
+				r.Symbol, r.Addend = name, addend+int64(raw>>20&0x3f)
+			} else {
+				r.Addend = int64(raw & 0x3ffffff)
+			}
+		default:
+			return relocs
+		}
+		relocs = append(relocs, r)
+
+		if next == 0 {
+			break
+		}
+		off += next * stride
+	}
+	return relocs
+}
+
+// indirectRelocations names the slots of the symbol pointer sections
+// through the indirect symbol table, for images without dyld information
+func (img *machoImage) indirectRelocations() []Relocation {
+	if img.f.Dysymtab == nil || img.f.Symtab == nil {
+		return nil
+	}
+	const (
+		indirectLocal = 0x80000000
+		indirectAbs   = 0x40000000
+	)
+	var relocs []Relocation
+	for _, seg := range img.segments {
+		for _, sec := range img.sections(seg) {
+			if typ := sec.flags & 0xff; typ != machoNonLazySymbolPointers && typ != machoLazySymbolPointers {
+				continue
+			}
+			for i := uint64(0); i*uint64(img.ptrSize) < sec.size; i++ {
+				idx := uint64(sec.reserved1) + i
+				if idx >= uint64(len(img.f.Dysymtab.IndirectSyms)) {
+					break
+				}
+				sym := img.f.Dysymtab.IndirectSyms[idx]
+				if sym&(indirectLocal|indirectAbs) != 0 || int(sym) >= len(img.f.Symtab.Syms) {
+					continue
+				}
+				relocs = append(relocs, Relocation{
+					Address: sec.addr + i*uint64(img.ptrSize),
+					Symbol:  img.f.Symtab.Syms[sym].Name,
+				})
+			}
+		}
+	}
+	return relocs
+}
+
+// machoSection is the part of a section header debug/macho drops
+type machoSection struct {
+	addr, size uint64
+	flags      uint32
+	reserved1  uint32 // First indirect symbol of pointer and stub sections
+}
+
+// sections decodes the section headers following a segment command
+func (img *machoImage) sections(seg *macho.Segment) []machoSection {
+	raw := seg.Raw()
+	headerSize, sectionSize := 56, 68
+	if img.ptrSize == 8 {
+		headerSize, sectionSize = 72, 80
+	}
+	var sections []machoSection
+	for i := 0; i < int(seg.Nsect); i++ {
+		off := headerSize + i*sectionSize
+		if off+sectionSize > len(raw) {
+			break
+		}
+		s := raw[off+32:]
+		var sec machoSection
+		if img.ptrSize == 8 {
+			sec.addr, sec.size = img.order.Uint64(s), img.order.Uint64(s[8:])
+			s = s[16:]
+		} else {
+			sec.addr, sec.size = uint64(img.order.Uint32(s)), uint64(img.order.Uint32(s[4:]))
+			s = s[8:]
+		}
+		// offset, align, reloff, nreloc, flags, reserved1
+		sec.flags = img.order.Uint32(s[16:])
+		sec.reserved1 = img.order.Uint32(s[20:])
+		sections = append(sections, sec)
+	}
+	return sections
+}
+
+// Export trie flags
+const (
+	exportKindMask        = 0x03
+	exportReexport        = 0x08
+	exportStubAndResolver = 0x10
+	exportKindAbsolute    = 0x02
+)
+
+// exports walks the export trie: each node may carry the export spelled
+// by the edge labels leading to it, then lists its children
+func (img *machoImage) exports() []Export {
+	trie := img.exportTrie
+	var exports []Export
+	visited := make(map[uint64]bool)
+	var walk func(off uint64, prefix string)
+	walk = func(off uint64, prefix string) {
+		if off >= uint64(len(trie)) || visited[off] {
+			return
+		}
+		visited[off] = true
+		r := &lebReader{b: trie, off: int(off)}
+		if size := r.uleb(); size > 0 {
+			next := r.off + int(size)
+			flags := r.uleb()
+			exp := Export{Name: prefix}
+			switch {
+			case flags&exportReexport != 0:
+				ordinal := r.uleb()
+				target := r.cstring()
+				if target == "" {
+					target = prefix
+				}
+				lib := ""
+				if ordinal > 0 && int(ordinal) <= len(img.libraries) {
+					lib = img.libraries[ordinal-1]
+				}
+				exp.Forwarder = lib + "." + target
+			case flags&exportKindMask == exportKindAbsolute:
+				exp.Address = r.uleb()
+			default:
+				exp.Address = img.base + r.uleb()
+				if flags&exportStubAndResolver != 0 {
+					r.uleb()
+				}
+			}
+			if !r.bad {
+				exports = append(exports, exp)
+			}
+			r.off = next
+		}
+		children := int(r.byte())
+		for i := 0; i < children && !r.bad; i++ {
+			label := r.cstring()
+			child := r.uleb()
+			if r.bad {
+				return
+			}
+			walk(child, prefix+label)
+		}
+	}
+	if len(trie) > 0 {
+		walk(0, "")
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].Name < exports[j].Name })
+	return exports
+}
+
+// lebReader reads the byte streams of dyld opcodes and tries. Reading
+// past the end sets bad and returns zero values.
+type lebReader struct {
+	b   []byte
+	off int
+	bad bool
+}
+
+func (r *lebReader) more() bool {
+	return r.off < len(r.b)
+}
+
+func (r *lebReader) byte() byte {
+	if r.off >= len(r.b) {
+		r.bad = true
+		return 0
+	}
+	c := r.b[r.off]
+	r.off++
+	return c
+}
+
+func (r *lebReader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		c := r.byte()
+		if r.bad {
+			return 0
+		}
+		if shift < 64 {
+			v |= uint64(c&0x7f) << shift
+		}
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (r *lebReader) sleb() int64 {
+	var v int64
+	var shift uint
+	for {
+		c := r.byte()
+		if r.bad {
+			return 0
+		}
+		if shift < 64 {
+			v |= int64(c&0x7f) << shift
+		}
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *lebReader) cstring() string {
+	if r.off > len(r.b) {
+		r.bad = true
+		return ""
+	}
+	i := bytes.IndexByte(r.b[r.off:], 0)
+	if i < 0 {
+		r.bad = true
+		return ""
+	}
+	s := string(r.b[r.off : r.off+i])
+	r.off += i + 1
+	return s
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// machoSections are the sections testdata/macho/macho.py lays out below
+// a base address
+func machoSections(base uint64) []Section {
+	return []Section{
+		{Name: "__text", Address: base + 0xf00, Size: 0x10},
+		{Name: "__cstring", Address: base + 0xf10, Size: 6},
+		{Name: "__data", Address: base + 0x1000, Size: 8},
+		{Name: "__bss", Address: base + 0x1008, Size: 0x10},
+	}
+}
+
+func checkMachO(t *testing.T, b *Binary, arch string, ptrSize int, base uint64) {
+	t.Helper()
+	if b.Format != "Mach-O" || b.Kind != KindExecutable || b.Arch != arch {
+		t.Errorf("Format, Kind, Arch = %s, %v, %s, want a Mach-O %s executable", b.Format, b.Kind, b.Arch, arch)
+	}
+	if got := b.PointerSize(); got != ptrSize {
+		t.Errorf("PointerSize() = %d, want %d", got, ptrSize)
+	}
+	if b.EntryPoint != base+0xf00 {
+		t.Errorf("EntryPoint = 0x%x, want main at 0x%x", b.EntryPoint, base+0xf00)
+	}
+
+	want := machoSections(base)
+	if len(b.Sections) != len(want) {
+		t.Fatalf("%d sections, want %d", len(b.Sections), len(want))
+	}
+	for i, sec := range b.Sections {
+		if sec.Name != want[i].Name || sec.Address != want[i].Address || sec.Size != want[i].Size {
+			t.Errorf("section %d = %s at 0x%x size 0x%x, want %s at 0x%x size 0x%x", i,
+				sec.Name, sec.Address, sec.Size, want[i].Name, want[i].Address, want[i].Size)
+		}
+	}
+	if got := string(b.Sections[1].Data); got != "hello\x00" {
+		t.Errorf("__cstring = %q, want \"hello\\x00\"", got)
+	}
+	if b.Sections[3].Data != nil {
+		t.Errorf("__bss has %d bytes of file data, want none", len(b.Sections[3].Data))
+	}
+
+	if starts := []uint64{base + 0xf00, base + 0xf08}; !reflect.DeepEqual(b.FunctionStarts, starts) {
+		t.Errorf("FunctionStarts = %#x, want %#x", b.FunctionStarts, starts)
+	}
+	if libs := []string{"/usr/lib/libSystem.B.dylib"}; !reflect.DeepEqual(b.Libraries, libs) {
+		t.Errorf("Libraries = %q, want %q", b.Libraries, libs)
+	}
+	found := false
+	for _, sym := range b.Symbols {
+		found = found || sym.Name == "_main" && sym.Address == base+0xf00
+	}
+	if !found {
+		t.Errorf("no _main symbol at 0x%x in %v", base+0xf00, b.Symbols)
+	}
+}
+
+func TestParseMachO(t *testing.T) {
+	b, err := ParseExecutable(filepath.Join("testdata", "macho", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if b.Slice != nil {
+		t.Errorf("Slice = %+v for a thin file", b.Slice)
+	}
+	checkMachO(t, b, "x86_64", 8, 0x100000000)
+}
+
+func TestParseUniversalMachO(t *testing.T) {
+	path := filepath.Join("testdata", "macho", "hello.fat")
+	slices, err := Slices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Slice{{Arch: "x86", Offset: 0x1000, Size: 0x2050}, {Arch: "arm64", Offset: 0x4000, Size: 0x205c}}
+	if !reflect.DeepEqual(slices, want) {
+		t.Errorf("Slices() = %+v, want %+v", slices, want)
+	}
+
+	var universal *UniversalError
+	if _, err := ParseExecutable(path); !errors.As(err, &universal) || len(universal.Slices) != 2 {
+		t.Errorf("ParseExecutable() = %v, want a UniversalError listing both slices", err)
+	}
+	if _, err := ParseArch(path, "x86_64"); err == nil {
+		t.Errorf("ParseArch(x86_64) succeeded without an x86_64 slice")
+	}
+
+	tests := []struct {
+		arch    string
+		ptrSize int
+		base    uint64
+	}{
+		{"x86", 4, 0x1000},        // Started by LC_UNIXTHREAD
+		{"arm64", 8, 0x100000000}, // Started by LC_MAIN
+	}
+	for i, tt := range tests {
+		t.Run(tt.arch, func(t *testing.T) {
+			b, err := ParseArch(path, tt.arch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			if b.Slice == nil || *b.Slice != want[i] {
+				t.Errorf("Slice = %+v, want %+v", b.Slice, want[i])
+			}
+			checkMachO(t, b, tt.arch, tt.ptrSize, tt.base)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errCorrupt is returned for compressed data that does not decode to the
+// expected size
+var errCorrupt = errors.New("corrupt compressed data")
+
+// nrvReader reads the bit stream of the NRV algorithms in their LE32
+// flavour: flag bits come MSB first from 32-bit little-endian words
+// interleaved with the literal and offset bytes
+type nrvReader struct {
+	src  []byte
+	pos  int
+	bits uint32
+	n    int // Bits left in bits
+	err  error
+}
+
+func (r *nrvReader) bit() uint32 {
+	if r.n == 0 {
+		if r.pos+4 > len(r.src) {
+			r.err = errCorrupt
+			return 0
+		}
+		r.bits = binary.LittleEndian.Uint32(r.src[r.pos:])
+		r.pos += 4
+		r.n = 32
+	}
+	r.n--
+	return r.bits >> r.n & 1
+}
+
+func (r *nrvReader) byte() uint32 {
+	if r.pos >= len(r.src) {
+		r.err = errCorrupt
+		return 0
+	}
+	b := r.src[r.pos]
+	r.pos++
+	return uint32(b)
+}
+
+// nrvVariant selects how match offsets and lengths are encoded
+type nrvVariant int
+
+const (
+	nrv2b nrvVariant = iota
+	nrv2d
+	nrv2e
+)
+
+// unNRV decompresses NRV2B, NRV2D or NRV2E data into size bytes
+func unNRV(src []byte, size int, variant nrvVariant) ([]byte, error) {
+	r := &nrvReader{src: src}
+	dst := make([]byte, 0, size)
+	lastOff := uint32(1)
+
+	for r.err == nil {
+		// Literals
+		for r.bit() == 1 && r.err == nil {
+			if len(dst) >= size {
+				return nil, errCorrupt
+			}
+			dst = append(dst, byte(r.byte()))
+		}
+
+		// Match offset
+		off := uint32(1)
+		for r.err == nil {
+			off = off*2 + r.bit()
+			if r.bit() == 1 {
+				break
+			}
+			if variant != nrv2b {
+				off = (off-1)*2 + r.bit()
+			}
+		}
+		var length uint32
+		if off == 2 {
+			off = lastOff
+			if variant != nrv2b {
+				length = r.bit()
+			}
+		} else {
+			off = (off-3)*256 + r.byte()
+			if off == 0xffffffff {
+				break // End of stream
+			}
+			if variant != nrv2b {
+				length = (off ^ 0xffffffff) & 1
+				off >>= 1
+			}
+			off++
+			lastOff = off
+		}
+
+		// Match length
+		switch variant {
+		case nrv2b, nrv2d:
+			if variant == nrv2b {
+				length = r.bit()
+			}
+			length = length*2 + r.bit()
+			if length == 0 {
+				length = 1
+				for r.err == nil {
+					length = length*2 + r.bit()
+					if r.bit() == 1 {
+						break
+					}
+				}
+				length += 2
+			}
+		case nrv2e:
+			switch {
+			case length != 0:
+				length = 1 + r.bit()
+			case r.bit() == 1:
+				length = 3 + r.bit()
+			default:
+				length = 1
+				for r.err == nil {
+					length = length*2 + r.bit()
+					if r.bit() == 1 {
+						break
+					}
+				}
+				length += 3
+			}
+		}
+		if variant == nrv2b && off > 0xd00 || variant != nrv2b && off > 0x500 {
+			length++
+		}
+
+		if r.err != nil || int(off) > len(dst) || len(dst)+int(length)+1 > size {
+			return nil, errCorrupt
+		}
+		from := len(dst) - int(off)
+		for i := 0; i <= int(length); i++ {
+			dst = append(dst, dst[from+i])
+		}
+	}
+	if r.err != nil || len(dst) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
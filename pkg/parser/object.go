@@ -0,0 +1,636 @@
+package parser
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Kinds of binaries
+const (
+	KindExecutable    = "executable"
+	KindSharedLibrary = "shared library"
+	KindObject        = "object"
+)
+
+// objectBase is the address the sections of relocatable object files are
+// laid out from. Object files leave addresses to the linker: expeer places
+// their sections one after the other, gives every undefined symbol a slot
+// in an "extern" section and applies the relocations, so that calls and
+// references within the object point where they would in a linked image
+// and calls to other objects reach the slot naming their symbol.
+const objectBase = 0x10000
+
+// Relocations as the object linker applies them
+const (
+	relocAbsolute = iota // S + A
+	relocPCRel           // S + A - P
+	relocGOT             // G + A - P, G a slot holding S
+)
+
+// objectLinker lays out and relocates the sections of an object file. Only
+// x86 and x86-64 code is patched; other architectures get the layout and
+// absolute pointers only.
+type objectLinker struct {
+	binary  *Binary
+	order   binary.ByteOrder
+	ptrSize int
+	next    uint64 // Address of the next free byte
+
+	externBase uint64            // Address of the extern section, 0 until the first slot
+	slots      []uint64          // Target of each extern slot, 0 for undefined symbols
+	externs    map[string]uint64 // Undefined symbol -> its slot
+	got        map[uint64]uint64 // Defined address -> slot holding it
+	patched    map[int]bool      // Sections whose data was copied for patching
+}
+
+func newObjectLinker(b *Binary, order binary.ByteOrder) *objectLinker {
+	ld := &objectLinker{
+		binary:  b,
+		order:   order,
+		ptrSize: b.PointerSize(),
+		next:    objectBase,
+		externs: make(map[string]uint64),
+		got:     make(map[uint64]uint64),
+		patched: make(map[int]bool),
+	}
+	return ld
+}
+
+// place allocates size bytes aligned to align and returns their address
+func (ld *objectLinker) place(size, align uint64) uint64 {
+	if align > 1 {
+		ld.next = (ld.next + align - 1) &^ (align - 1)
+	}
+	addr := ld.next
+	ld.next += size
+	return addr
+}
+
+// slot allocates a pointer slot in the extern section holding target
+func (ld *objectLinker) slot(target uint64) uint64 {
+	if ld.externBase == 0 {
+		ld.externBase = ld.place(0, 16)
+	}
+	addr := ld.externBase + uint64(len(ld.slots)*ld.ptrSize)
+	ld.slots = append(ld.slots, target)
+	return addr
+}
+
+// extern returns the slot standing for an undefined symbol
+func (ld *objectLinker) extern(name string) uint64 {
+	if addr, ok := ld.externs[name]; ok {
+		return addr
+	}
+	addr := ld.slot(0)
+	ld.externs[name] = addr
+	ld.binary.Relocations = append(ld.binary.Relocations, Relocation{Address: addr, Symbol: name})
+	return addr
+}
+
+// isExtern returns true if addr is the slot of an undefined symbol
+func (ld *objectLinker) isExtern(addr uint64) bool {
+	return ld.externBase != 0 && addr >= ld.externBase && addr < ld.externBase+uint64(len(ld.slots)*ld.ptrSize) &&
+		ld.slots[(addr-ld.externBase)/uint64(ld.ptrSize)] == 0
+}
+
+// gotSlot returns a slot holding target, the way the linker builds a GOT
+// entry. Undefined symbols are their own slot.
+func (ld *objectLinker) gotSlot(target uint64) uint64 {
+	if ld.isExtern(target) {
+		return target
+	}
+	if addr, ok := ld.got[target]; ok {
+		return addr
+	}
+	addr := ld.slot(target)
+	ld.got[target] = addr
+	ld.binary.Relocations = append(ld.binary.Relocations, Relocation{Address: addr, Addend: int64(target)})
+	return addr
+}
+
+// field returns the bytes of section sec at off, copying the section data
+// first if it is still the read-only file mapping
+func (ld *objectLinker) field(sec int, off uint64, size int, write bool) []byte {
+	s := &ld.binary.Sections[sec]
+	if off+uint64(size) > uint64(len(s.Data)) || off+uint64(size) < off {
+		return nil
+	}
+	if write && !ld.patched[sec] {
+		s.Data = append([]byte(nil), s.Data...)
+		ld.patched[sec] = true
+	}
+	return s.Data[off : off+uint64(size)]
+}
+
+// read returns the value stored at off in section sec, sign-extended: the
+// implicit addend of REL, COFF and Mach-O relocations
+func (ld *objectLinker) read(sec int, off uint64, size int) int64 {
+	b := ld.field(sec, off, size, false)
+	switch {
+	case b == nil:
+		return 0
+	case size == 8:
+		return int64(ld.order.Uint64(b))
+	default:
+		return int64(int32(ld.order.Uint32(b)))
+	}
+}
+
+// apply patches a size-byte field at off in section sec with a relocation
+// against target (S). symbol names target if it is undefined.
+func (ld *objectLinker) apply(sec int, off uint64, size, kind int, typ uint32, target uint64, addend int64, symbol string) {
+	b := ld.field(sec, off, size, true)
+	if b == nil {
+		return
+	}
+	s := &ld.binary.Sections[sec]
+	p := s.Address + off
+
+	var value uint64
+	switch kind {
+	case relocAbsolute:
+		value = target + uint64(addend)
+		if size == ld.ptrSize {
+			// Pointers in data, e.g. vtables and function tables
+			r := Relocation{Address: p, Type: typ, Addend: int64(value), Section: s.Name}
+			if ld.isExtern(target) {
+				r.Symbol = symbol
+			}
+			ld.binary.Relocations = append(ld.binary.Relocations, r)
+		}
+	case relocPCRel:
+		value = target + uint64(addend) - p
+	case relocGOT:
+		value = ld.gotSlot(target) + uint64(addend) - p
+	}
+
+	if size == 8 {
+		ld.order.PutUint64(b, value)
+	} else {
+		ld.order.PutUint32(b, uint32(value))
+	}
+}
+
+// finish adds the extern section holding the symbol slots
+func (ld *objectLinker) finish() {
+	if ld.externBase == 0 {
+		return
+	}
+	data := make([]byte, len(ld.slots)*ld.ptrSize)
+	for i, target := range ld.slots {
+		if ld.ptrSize == 8 {
+			ld.order.PutUint64(data[i*8:], target)
+		} else {
+			ld.order.PutUint32(data[i*4:], uint32(target))
+		}
+	}
+	ld.binary.Sections = append(ld.binary.Sections, Section{
+		Name:    "extern",
+		Address: ld.externBase,
+		Size:    uint64(len(data)),
+		Data:    data,
+	})
+}
+
+// export records a global definition of an object file
+func (ld *objectLinker) export(name string, addr uint64) {
+	ld.binary.Exports = append(ld.binary.Exports, name)
+	ld.binary.ExportTable = append(ld.binary.ExportTable, Export{Name: name, Address: addr})
+}
+
+// parseELFObject links a relocatable ELF object. Relocation and symbol
+// tables are consumed here and not listed as sections.
+func parseELFObject(binary *Binary, f *elf.File) (*Binary, error) {
+	binary.Kind = KindObject
+	ld := newObjectLinker(binary, f.ByteOrder)
+
+	index := make([]int, len(f.Sections)) // ELF section -> Binary section, -1 if none
+	for i, sec := range f.Sections {
+		index[i] = -1
+		switch sec.Type {
+		case elf.SHT_REL, elf.SHT_RELA, elf.SHT_SYMTAB, elf.SHT_STRTAB, elf.SHT_GROUP:
+			continue
+		}
+		section := elfSection(binary.RawData, sec)
+		if sec.Flags&elf.SHF_ALLOC != 0 {
+			section.Address = ld.place(sec.Size, sec.Addralign)
+		}
+		index[i] = len(binary.Sections)
+		binary.Sections = append(binary.Sections, section)
+	}
+	address := func(shndx elf.SectionIndex) uint64 {
+		if int(shndx) >= len(index) || index[shndx] < 0 {
+			return 0
+		}
+		return binary.Sections[index[shndx]].Address
+	}
+
+	// Symbol values are offsets in their section
+	syms, _ := f.Symbols()
+	addrs := make([]uint64, len(syms))
+	for i, sym := range syms {
+		switch sym.Section {
+		case elf.SHN_UNDEF, elf.SHN_COMMON:
+			if sym.Name != "" {
+				addrs[i] = ld.extern(sym.Name)
+			}
+		case elf.SHN_ABS:
+			addrs[i] = sym.Value
+		default:
+			addrs[i] = address(sym.Section) + sym.Value
+		}
+
+		typ := elf.ST_TYPE(sym.Info)
+		if sym.Name == "" || typ == elf.STT_FILE || typ == elf.STT_SECTION {
+			continue
+		}
+		binary.Symbols = append(binary.Symbols, Symbol{
+			Name:    sym.Name,
+			Address: addrs[i],
+			Size:    sym.Size,
+			Type:    fmt.Sprintf("ELF_SYM_%d", sym.Info),
+		})
+		if bind := elf.ST_BIND(sym.Info); (bind == elf.STB_GLOBAL || bind == elf.STB_WEAK) &&
+			sym.Section != elf.SHN_UNDEF && sym.Section != elf.SHN_COMMON {
+			ld.export(sym.Name, addrs[i])
+		}
+	}
+	for name := range ld.externs {
+		binary.Imports = append(binary.Imports, name)
+	}
+	sort.Strings(binary.Imports)
+
+	eachELFRelocation(f, func(rel *elf.Section, target uint32, r Relocation, sym uint32) {
+		if int(target) >= len(index) || index[target] < 0 || sym == 0 || int(sym) > len(syms) {
+			return
+		}
+		ld.applyELF(f, index[target], rel.Type == elf.SHT_RELA, r, addrs[sym-1], syms[sym-1].Name)
+	})
+
+	ld.finish()
+	return binary, nil
+}
+
+// applyELF applies an x86 or x86-64 ELF relocation of section sec
+func (ld *objectLinker) applyELF(f *elf.File, sec int, rela bool, r Relocation, target uint64, symbol string) {
+	size, kind := 4, -1
+	switch f.Machine {
+	case elf.EM_X86_64:
+		switch elf.R_X86_64(r.Type) {
+		case elf.R_X86_64_64:
+			size, kind = 8, relocAbsolute
+		case elf.R_X86_64_32, elf.R_X86_64_32S:
+			kind = relocAbsolute
+		case elf.R_X86_64_PC32, elf.R_X86_64_PLT32:
+			kind = relocPCRel
+		case elf.R_X86_64_PC64:
+			size, kind = 8, relocPCRel
+		case elf.R_X86_64_GOTPCREL, elf.R_X86_64_GOTPCRELX, elf.R_X86_64_REX_GOTPCRELX:
+			kind = relocGOT
+		}
+	case elf.EM_386:
+		switch elf.R_386(r.Type) {
+		case elf.R_386_32:
+			kind = relocAbsolute
+		case elf.R_386_PC32, elf.R_386_PLT32:
+			kind = relocPCRel
+		}
+	case elf.EM_AARCH64:
+		if elf.R_AARCH64(r.Type) == elf.R_AARCH64_ABS64 {
+			size, kind = 8, relocAbsolute
+		}
+	}
+	if kind < 0 {
+		return
+	}
+
+	addend := r.Addend
+	if !rela {
+		addend = ld.read(sec, r.Address, size)
+	}
+	ld.apply(sec, r.Address, size, kind, r.Type, target, addend, symbol)
+}
+
+// Storage classes and section numbers of COFF symbols
+const (
+	coffSymClassExternal = 2
+	coffSymClassFile     = 103
+	coffSymUndefined     = 0
+	coffSymAbsolute      = -1
+)
+
+// Section characteristics of COFF objects
+const (
+	coffSectionUninitialized = 0x80
+	coffSectionLinkRemove    = 0x800
+	coffSectionAlignShift    = 20
+	coffSectionDiscardable   = 0x02000000
+)
+
+// COFF relocation types
+const (
+	coffRelAMD64Addr64   = 0x1
+	coffRelAMD64Addr32   = 0x2
+	coffRelAMD64Addr32NB = 0x3
+	coffRelAMD64Rel32    = 0x4
+	coffRelAMD64Rel32_5  = 0x9
+	coffRelI386Dir32     = 0x6
+	coffRelI386Dir32NB   = 0x7
+	coffRelI386Rel32     = 0x14
+	coffRelARM64Addr64   = 0xe
+)
+
+// isCOFFObject returns true if data starts with the file header of a COFF
+// object: a known machine and no optional header
+func isCOFFObject(data []byte) bool {
+	if len(data) < 20 || binary.LittleEndian.Uint16(data[16:]) != 0 {
+		return false
+	}
+	switch binary.LittleEndian.Uint16(data) {
+	case pe.IMAGE_FILE_MACHINE_I386, pe.IMAGE_FILE_MACHINE_AMD64,
+		pe.IMAGE_FILE_MACHINE_ARMNT, pe.IMAGE_FILE_MACHINE_ARM64:
+		return true
+	}
+	return false
+}
+
+// parseCOFFObject links a COFF object (.obj). Its sections use the PE
+// section flags, so it is analyzed as PE.
+func parseCOFFObject(path string, data []byte) (*Binary, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse COFF object: %w", err)
+	}
+	defer f.Close()
+
+	order := binary.LittleEndian
+	binary := &Binary{
+		Format:   "PE",
+		Kind:     KindObject,
+		Arch:     peArch(f.Machine),
+		RawData:  data,
+		FilePath: path,
+	}
+	ld := newObjectLinker(binary, order)
+
+	for _, sec := range f.Sections {
+		section := Section{Name: sec.Name, Size: uint64(sec.Size), Flags: sec.Characteristics}
+		if sec.Characteristics&coffSectionUninitialized == 0 {
+			section.Data = fileRange(data, uint64(sec.Offset), uint64(sec.Size))
+		}
+		if sec.Characteristics&(coffSectionLinkRemove|coffSectionDiscardable) == 0 {
+			align := uint64(16)
+			if n := sec.Characteristics >> coffSectionAlignShift & 0xf; n > 0 {
+				align = 1 << (n - 1)
+			}
+			section.Address = ld.place(section.Size, align)
+		}
+		binary.Sections = append(binary.Sections, section)
+	}
+
+	// Relocations index the symbol table with its auxiliary records
+	addrs := make([]uint64, len(f.COFFSymbols))
+	names := make([]string, len(f.COFFSymbols))
+	for i := 0; i < len(f.COFFSymbols); i++ {
+		sym := &f.COFFSymbols[i]
+		n := i
+		i += int(sym.NumberOfAuxSymbols)
+		name, _ := sym.FullName(f.StringTable)
+		names[n] = name
+
+		switch {
+		case sym.SectionNumber == coffSymUndefined:
+			if name != "" {
+				addrs[n] = ld.extern(name)
+			}
+		case sym.SectionNumber == coffSymAbsolute:
+			addrs[n] = uint64(sym.Value)
+		case sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(binary.Sections):
+			sec := &binary.Sections[sym.SectionNumber-1]
+			addrs[n] = sec.Address + uint64(sym.Value)
+			if sym.StorageClass == peSymClassStatic && name == sec.Name {
+				continue
+			}
+		}
+
+		if name == "" || sym.StorageClass == coffSymClassFile || sym.SectionNumber < coffSymAbsolute {
+			continue
+		}
+		binary.Symbols = append(binary.Symbols, Symbol{
+			Name:    name,
+			Address: addrs[n],
+			Type:    fmt.Sprintf("PE_SYM_%d", sym.Type),
+		})
+		if sym.StorageClass == coffSymClassExternal && sym.SectionNumber > 0 {
+			ld.export(name, addrs[n])
+		}
+	}
+	for name := range ld.externs {
+		binary.Imports = append(binary.Imports, name)
+	}
+	sort.Strings(binary.Imports)
+
+	for i, sec := range f.Sections {
+		for _, r := range sec.Relocs {
+			if int(r.SymbolTableIndex) >= len(addrs) {
+				continue
+			}
+			ld.applyCOFF(f.Machine, i, r, addrs[r.SymbolTableIndex], names[r.SymbolTableIndex])
+		}
+	}
+
+	ld.finish()
+	return binary, nil
+}
+
+// applyCOFF applies an x86 or x64 COFF relocation of section sec. Addends
+// are stored in the patched field; pc-relative fields are relative to
+// their end, or further for REL32_1 to REL32_5.
+func (ld *objectLinker) applyCOFF(machine uint16, sec int, r pe.Reloc, target uint64, symbol string) {
+	off := uint64(r.VirtualAddress)
+	size, kind, bias := 4, -1, int64(4)
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		switch {
+		case r.Type == coffRelAMD64Addr64:
+			size, kind = 8, relocAbsolute
+		case r.Type == coffRelAMD64Addr32 || r.Type == coffRelAMD64Addr32NB:
+			kind = relocAbsolute
+		case r.Type >= coffRelAMD64Rel32 && r.Type <= coffRelAMD64Rel32_5:
+			kind = relocPCRel
+			bias += int64(r.Type - coffRelAMD64Rel32)
+		}
+	case pe.IMAGE_FILE_MACHINE_I386:
+		switch r.Type {
+		case coffRelI386Dir32, coffRelI386Dir32NB:
+			kind = relocAbsolute
+		case coffRelI386Rel32:
+			kind = relocPCRel
+		}
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		if r.Type == coffRelARM64Addr64 {
+			size, kind = 8, relocAbsolute
+		}
+	}
+	if kind < 0 {
+		return
+	}
+
+	addend := ld.read(sec, off, size)
+	if kind == relocPCRel {
+		addend -= bias
+	}
+	ld.apply(sec, off, size, kind, uint32(r.Type), target, addend, symbol)
+}
+
+// Mach-O relocation types
+const (
+	machoRelocVanilla    = 0 // GENERIC_RELOC_VANILLA, X86_64_RELOC_UNSIGNED, ARM64_RELOC_UNSIGNED
+	machoRelocSigned     = 1
+	machoRelocBranch     = 2
+	machoRelocGOTLoad    = 3
+	machoRelocGOT        = 4
+	machoRelocSubtractor = 5
+	machoRelocSigned1    = 6
+	machoRelocSigned2    = 7
+	machoRelocSigned4    = 8
+)
+
+// Mach-O symbol types
+const (
+	machoSymStab = 0xe0
+	machoSymType = 0x0e
+	machoSymExt  = 0x01
+	machoSymUndf = 0x0
+	machoSymAbs  = 0x2
+	machoSymSect = 0xe
+)
+
+// parseMachOObject links a Mach-O object (MH_OBJECT). Its sections already
+// have addresses, from 0; they are moved to objectBase as a whole, so only
+// absolute references and references to undefined symbols need patching.
+func parseMachOObject(binary *Binary, f *macho.File) (*Binary, error) {
+	binary.Kind = KindObject
+	ld := newObjectLinker(binary, f.ByteOrder)
+	shift := uint64(objectBase)
+
+	for _, sec := range f.Sections {
+		section := Section{Name: sec.Name, Address: sec.Addr + shift, Size: sec.Size, Flags: sec.Flags}
+		switch sec.Flags & 0xff {
+		case 0x1, 0xc, 0x12:
+		default:
+			section.Data = fileRange(binary.RawData, uint64(sec.Offset), sec.Size)
+		}
+		binary.Sections = append(binary.Sections, section)
+		if end := section.Address + section.Size; end > ld.next {
+			ld.next = end
+		}
+	}
+
+	var addrs []uint64
+	if f.Symtab != nil {
+		addrs = make([]uint64, len(f.Symtab.Syms))
+		for i, sym := range f.Symtab.Syms {
+			if sym.Type&machoSymStab != 0 {
+				continue
+			}
+			switch sym.Type & machoSymType {
+			case machoSymUndf:
+				addrs[i] = ld.extern(sym.Name)
+				continue
+			case machoSymAbs:
+				addrs[i] = sym.Value
+			case machoSymSect:
+				addrs[i] = sym.Value + shift
+			}
+			binary.Symbols = append(binary.Symbols, Symbol{
+				Name:    sym.Name,
+				Address: addrs[i],
+				Type:    fmt.Sprintf("MACHO_SYM_%d", sym.Type),
+			})
+			if sym.Type&machoSymExt != 0 && sym.Type&machoSymType == machoSymSect {
+				ld.export(sym.Name, addrs[i])
+			}
+		}
+	}
+	for name := range ld.externs {
+		binary.Imports = append(binary.Imports, name)
+	}
+	sort.Strings(binary.Imports)
+
+	for i, sec := range f.Sections {
+		for j := 0; j < len(sec.Relocs); j++ {
+			r := sec.Relocs[j]
+			if r.Type == machoRelocSubtractor && f.Cpu == macho.CpuAmd64 {
+				j++ // Paired with the following UNSIGNED: a difference, position independent
+				continue
+			}
+			var target uint64
+			var symbol string
+			if r.Extern {
+				if addrs == nil || int(r.Value) >= len(addrs) {
+					continue
+				}
+				target, symbol = addrs[r.Value], f.Symtab.Syms[r.Value].Name
+			}
+			ld.applyMachO(f.Cpu, i, r, sec.Addr, target, symbol)
+		}
+	}
+
+	ld.finish()
+	return binary, nil
+}
+
+// applyMachO applies a Mach-O relocation of section sec, originally at
+// secAddr. Addends are stored in the patched field.
+func (ld *objectLinker) applyMachO(cpu macho.Cpu, sec int, r macho.Reloc, secAddr, target uint64, symbol string) {
+	off := uint64(r.Addr)
+	if r.Scattered {
+		// Scattered entries give the section offset and a target address
+		// instead of a symbol; absolute ones only need moving
+		if r.Pcrel || r.Type != machoRelocVanilla {
+			return
+		}
+	}
+	size := 1 << r.Len
+	if size != 4 && size != 8 {
+		return
+	}
+
+	addend := ld.read(sec, off, size)
+	switch {
+	case !r.Pcrel && r.Type == machoRelocVanilla:
+		// Absolute: the field holds the address, or the addend to an
+		// undefined symbol
+		if !r.Extern {
+			target = uint64(objectBase)
+		}
+		ld.apply(sec, off, size, relocAbsolute, uint32(r.Type), target, addend, symbol)
+
+	case !r.Extern:
+		// Pc-relative within the object: moved along with it
+
+	case cpu == macho.CpuAmd64:
+		kind := relocPCRel
+		switch r.Type {
+		case machoRelocSigned, machoRelocBranch:
+		case machoRelocGOTLoad, machoRelocGOT:
+			kind = relocGOT
+		case machoRelocSigned1, machoRelocSigned2, machoRelocSigned4:
+			// SIGNED_1, _2 and _4: an immediate of that size follows
+			addend -= int64(1) << (r.Type - machoRelocSigned1)
+		default:
+			return
+		}
+		ld.apply(sec, off, size, kind, uint32(r.Type), target, addend-4, symbol)
+
+	case cpu == macho.Cpu386 && r.Type == machoRelocVanilla:
+		// The field is relative to the original address of its end
+		ld.apply(sec, off, size, relocPCRel, uint32(r.Type), target, addend+int64(secAddr+off), symbol)
+	}
+}
@@ -1,24 +1,39 @@
 package parser
 
 import (
+	"bytes"
 	"debug/elf"
 	"debug/macho"
 	"debug/pe"
 	"fmt"
-	"os"
+	"sync"
 )
 
 // Binary represents a parsed executable
 type Binary struct {
-	Format      string // "PE", "ELF", "Mach-O"
-	Arch        string // "x86", "x86_64", "arm", etc.
-	EntryPoint  uint64
-	Sections    []Section
-	Symbols     []Symbol
-	Imports     []string
-	Exports     []string
-	RawData     []byte
-	FilePath    string
+	Format         string // "PE", "ELF", "Mach-O", "WASM", "raw", "Intel HEX", "S-record"
+	Arch           string // "x86", "x86_64", "arm", etc.
+	Kind           string // KindExecutable, KindSharedLibrary or KindObject
+	EntryPoint     uint64
+	ImageBase      uint64 // Preferred load address of PE images, included in all their addresses
+	PE             *PEHeader
+	Slice          *Slice      // Slice of a universal Mach-O file the binary was read from, nil for thin files
+	Wasm           *WasmModule // Types and functions of WebAssembly modules, nil for other formats
+	Sections       []Section
+	Symbols        []Symbol
+	Imports        []string // Imported symbols, as "name:dll" in PE images
+	Exports        []string // Names of the exported symbols
+	ImportTable    []Import // PE imports with their DLL and IAT slot, delay-loaded ones included
+	ExportTable    []Export // Exported definitions: PE export directory, Mach-O export trie, ELF dynamic or object symbols
+	Libraries      []string // Shared libraries the binary links against
+	FunctionStarts []uint64 // Function start addresses the linker recorded (Mach-O LC_FUNCTION_STARTS)
+	Relocations    []Relocation
+	RawData        []byte // Read-only mapping of the whole file
+	FilePath       string
+	Packer         string // Packer the binary was unpacked from, "" if it was loaded as stored
+
+	ptrSize int // Pointer size the file headers record, 0 if they do not
+	unmap   func() error
 }
 
 // Section represents a section in the binary
@@ -26,10 +41,63 @@ type Section struct {
 	Name    string
 	Address uint64
 	Size    uint64
-	Data    []byte
+	Data    []byte // Read-only slice of RawData, nil if the section has no file contents
 	Flags   uint32
+
+	lazy *lazyData // Decoder for contents not stored verbatim in the file
+}
+
+// lazyData materializes section contents the first time they are needed.
+// It is shared by copies of a Section, so the work is done at most once.
+type lazyData struct {
+	once sync.Once
+	load func() []byte
+	data []byte
+}
+
+// Contents returns the section contents. Unlike Data it also covers
+// sections that must be decoded first, such as compressed ELF debug
+// sections; these are decompressed on the first call.
+func (s *Section) Contents() []byte {
+	if s.lazy == nil {
+		return s.Data
+	}
+	s.lazy.once.Do(func() {
+		s.lazy.data = s.lazy.load()
+	})
+	return s.lazy.data
 }
 
+// Close releases the file mapping. Section data, RawData and anything
+// slicing them must not be used afterwards.
+func (b *Binary) Close() error {
+	if b.unmap == nil {
+		return nil
+	}
+	err := b.unmap()
+	b.unmap = nil
+	b.RawData = nil
+	for i := range b.Sections {
+		b.Sections[i].Data = nil
+	}
+	return err
+}
+
+// fileRange returns data[offset:offset+size], clipped to the file, without
+// copying
+func fileRange(data []byte, offset, size uint64) []byte {
+	if offset >= uint64(len(data)) {
+		return nil
+	}
+	end := offset + size
+	if end > uint64(len(data)) || end < offset {
+		end = uint64(len(data))
+	}
+	return data[offset:end:end]
+}
+
+func noUnmap() error { return nil }
+
 // Symbol represents a symbol in the binary
 type Symbol struct {
 	Name    string
@@ -38,41 +106,89 @@ type Symbol struct {
 	Type    string
 }
 
-// ParseExecutable detects and parses the executable format
-func ParseExecutable(path string) (*Binary, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+// Relocation represents a relocation entry that patches a pointer-sized slot
+type Relocation struct {
+	Address uint64 // Address of the patched slot (section offset in ELFRelocations of object files)
+	Type    uint32 // Format-specific relocation type
+	Symbol  string // Referenced symbol, empty for base-relative relocations and PE base relocations
+	Addend  int64
+	Section string // Patched section, set for object files only
+}
+
+// PointerSize returns the size in bytes of a pointer in the binary: the
+// one its headers record (ELF class, PE optional header, Mach-O CPU type),
+// or the one declared for its architecture for files that record none,
+// such as raw images and COFF objects
+func (b *Binary) PointerSize() int {
+	if b.ptrSize != 0 {
+		return b.ptrSize
+	}
+	return PointerSize(b.Arch)
+}
+
+// BytesAt returns up to size bytes of section data starting at addr, or
+// nil if addr is not backed by any section
+func (b *Binary) BytesAt(addr uint64, size int) []byte {
+	for _, sec := range b.Sections {
+		if addr < sec.Address || addr >= sec.Address+uint64(len(sec.Data)) {
+			continue
+		}
+		data := sec.Data[addr-sec.Address:]
+		if len(data) > size {
+			data = data[:size]
+		}
+		return data
 	}
+	return nil
+}
+
+// ParseExecutable detects and parses the executable format. The file is
+// memory-mapped rather than read: section data are slices of the mapping,
+// so nothing is copied and pages are only loaded when a pass reads them.
+// Call Close to release the mapping once the Binary is no longer needed.
+// Universal Mach-O files fail with a *UniversalError listing their slices;
+// use ParseArch to select one. A member of a static library is parsed by
+// giving its path as "archive(member)".
+func ParseExecutable(path string) (*Binary, error) {
+	return ParseArch(path, "")
+}
 
+// parseData detects the format of a file's contents and parses them
+func parseData(path string, data []byte) (*Binary, error) {
 	// Detect format by magic bytes
 	if len(data) < 4 {
 		return nil, fmt.Errorf("file too small to be a valid executable")
 	}
 
-	// Try PE format (Windows)
-	if data[0] == 'M' && data[1] == 'Z' {
-		return parsePE(path, data)
+	if f, ok := probeFormat(data); ok {
+		return f.Parse(path, data)
 	}
 
-	// Try ELF format (Linux)
-	if len(data) >= 4 && data[0] == 0x7f && data[1] == 'E' && data[2] == 'L' && data[3] == 'F' {
-		return parseELF(path, data)
-	}
-
-	// Try Mach-O format (macOS)
-	if len(data) >= 4 {
-		magic := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
-		if magic == 0xfeedface || magic == 0xfeedfacf || magic == 0xcefaedfe || magic == 0xcffaedfe {
-			return parseMachO(path, data)
-		}
+	if IsArchive(data) {
+		return nil, fmt.Errorf("static library: name a member as %s(member)", path)
 	}
 
 	return nil, fmt.Errorf("unknown executable format")
 }
 
+// peArch names the architecture of a PE/COFF machine type
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "x86"
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "x86_64"
+	case pe.IMAGE_FILE_MACHINE_ARM, pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	default:
+		return fmt.Sprintf("unknown(0x%x)", machine)
+	}
+}
+
 func parsePE(path string, data []byte) (*Binary, error) {
-	f, err := pe.Open(path)
+	f, err := pe.NewFile(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse PE: %w", err)
 	}
@@ -82,56 +198,99 @@ func parsePE(path string, data []byte) (*Binary, error) {
 		Format:   "PE",
 		RawData:  data,
 		FilePath: path,
+		PE:       &PEHeader{Characteristics: f.Characteristics},
 	}
 
-	// Determine architecture
-	switch f.Machine {
-	case pe.IMAGE_FILE_MACHINE_I386:
-		binary.Arch = "x86"
-	case pe.IMAGE_FILE_MACHINE_AMD64:
-		binary.Arch = "x86_64"
-	case pe.IMAGE_FILE_MACHINE_ARM:
-		binary.Arch = "arm"
-	case pe.IMAGE_FILE_MACHINE_ARM64:
-		binary.Arch = "arm64"
-	default:
-		binary.Arch = fmt.Sprintf("unknown(0x%x)", f.Machine)
+	binary.Arch = peArch(f.Machine)
+	binary.Kind = KindExecutable
+	if binary.PE.IsDLL() {
+		binary.Kind = KindSharedLibrary
+	}
+
+	// Addresses are virtual addresses at the preferred base, as in the
+	// code's absolute references; the headers store RVAs
+	img := newPEImage(f, data)
+	binary.ImageBase = img.base
+	binary.ptrSize = img.ptrSize
+	var entry uint32
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		entry = oh.AddressOfEntryPoint
+		binary.PE.Subsystem = oh.Subsystem
+		binary.PE.DllCharacteristics = oh.DllCharacteristics
+	case *pe.OptionalHeader64:
+		entry = oh.AddressOfEntryPoint
+		binary.PE.Subsystem = oh.Subsystem
+		binary.PE.DllCharacteristics = oh.DllCharacteristics
+	}
+	// Resource-only DLLs have no entry point
+	if entry != 0 {
+		binary.EntryPoint = img.base + uint64(entry)
 	}
 
 	// Parse sections
 	for _, sec := range f.Sections {
-		data, _ := sec.Data()
 		binary.Sections = append(binary.Sections, Section{
 			Name:    sec.Name,
-			Address: uint64(sec.VirtualAddress),
+			Address: img.base + uint64(sec.VirtualAddress),
 			Size:    uint64(sec.Size),
-			Data:    data,
+			Data:    fileRange(data, uint64(sec.Offset), uint64(sec.Size)),
 			Flags:   sec.Characteristics,
 		})
 	}
 
-	// Parse symbols
+	// Parse symbols; their values are offsets in their section. Static
+	// symbols named after their section define it and name no code.
 	for _, sym := range f.Symbols {
+		addr := uint64(sym.Value)
+		if sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(binary.Sections) {
+			sec := binary.Sections[sym.SectionNumber-1]
+			if sym.StorageClass == peSymClassStatic && sym.Name == sec.Name {
+				continue
+			}
+			addr += sec.Address
+		}
 		binary.Symbols = append(binary.Symbols, Symbol{
 			Name:    sym.Name,
-			Address: uint64(sym.Value),
+			Address: addr,
 			Type:    fmt.Sprintf("PE_SYM_%d", sym.Type),
 		})
 	}
 
-	// Parse imports
-	imports, err := f.ImportedSymbols()
-	if err == nil {
-		for _, imp := range imports {
-			binary.Imports = append(binary.Imports, imp)
+	// Parse imports, delay-loaded ones last
+	binary.ImportTable = append(img.imports(), img.delayImports()...)
+	for _, imp := range binary.ImportTable {
+		binary.Imports = append(binary.Imports, imp.Symbol()+":"+imp.DLL)
+	}
+	for _, group := range ImportsByDLL(binary.ImportTable) {
+		binary.Libraries = append(binary.Libraries, group[0].DLL)
+	}
+
+	// Exports name the functions of DLLs that have no symbol table
+	binary.ExportTable = img.exports()
+	for _, exp := range binary.ExportTable {
+		name := exp.Name
+		if name == "" {
+			name = fmt.Sprintf("Ordinal_%d", exp.Ordinal)
+		}
+		binary.Exports = append(binary.Exports, name)
+		if exp.Forwarder == "" {
+			binary.Symbols = append(binary.Symbols, Symbol{
+				Name:    name,
+				Address: exp.Address,
+				Type:    "PE_EXPORT",
+			})
 		}
 	}
 
+	// Base relocations mark the slots holding addresses
+	binary.Relocations = img.baseRelocations()
+
 	return binary, nil
 }
 
 func parseELF(path string, data []byte) (*Binary, error) {
-	f, err := elf.Open(path)
+	f, err := elf.NewFile(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse ELF: %w", err)
 	}
@@ -157,17 +316,27 @@ func parseELF(path string, data []byte) (*Binary, error) {
 	default:
 		binary.Arch = fmt.Sprintf("unknown(0x%x)", f.Machine)
 	}
+	binary.ptrSize = 8
+	if f.Class == elf.ELFCLASS32 {
+		binary.ptrSize = 4
+	}
 
-	// Parse sections
+	switch f.Type {
+	case elf.ET_REL:
+		return parseELFObject(binary, f)
+	case elf.ET_DYN:
+		binary.Kind = elfDynamicKind(f)
+	default:
+		binary.Kind = KindExecutable
+	}
+
+	// Parse sections; files without section headers, such as packed
+	// executables, only describe their segments
 	for _, sec := range f.Sections {
-		data, _ := sec.Data()
-		binary.Sections = append(binary.Sections, Section{
-			Name:    sec.Name,
-			Address: sec.Addr,
-			Size:    sec.Size,
-			Data:    data,
-			Flags:   uint32(sec.Flags),
-		})
+		binary.Sections = append(binary.Sections, elfSection(data, sec))
+	}
+	if len(f.Sections) == 0 {
+		binary.Sections = elfSegments(data, f)
 	}
 
 	// Parse symbols
@@ -183,7 +352,7 @@ func parseELF(path string, data []byte) (*Binary, error) {
 		}
 	}
 
-	// Parse dynamic symbols
+	// Parse dynamic symbols; the global definitions are the exports
 	dynSyms, err := f.DynamicSymbols()
 	if err == nil {
 		for _, sym := range dynSyms {
@@ -193,6 +362,11 @@ func parseELF(path string, data []byte) (*Binary, error) {
 				Size:    sym.Size,
 				Type:    fmt.Sprintf("DYN_SYM_%d", sym.Info),
 			})
+			bind := elf.ST_BIND(sym.Info)
+			if sym.Name != "" && sym.Section != elf.SHN_UNDEF && (bind == elf.STB_GLOBAL || bind == elf.STB_WEAK) {
+				binary.Exports = append(binary.Exports, sym.Name)
+				binary.ExportTable = append(binary.ExportTable, Export{Name: sym.Name, Address: sym.Value})
+			}
 		}
 	}
 
@@ -204,11 +378,181 @@ func parseELF(path string, data []byte) (*Binary, error) {
 		}
 	}
 
+	// Parse relocations
+	binary.Relocations = ELFRelocations(f)
+
+	binary.Libraries, _ = f.ImportedLibraries()
+
 	return binary, nil
 }
 
+// elfSection returns the Section of an ELF section header
+func elfSection(data []byte, sec *elf.Section) Section {
+	section := Section{
+		Name:    sec.Name,
+		Address: sec.Addr,
+		Size:    sec.Size,
+		Flags:   uint32(sec.Flags),
+	}
+	switch {
+	case sec.Type == elf.SHT_NOBITS:
+		// .bss and friends occupy no file space
+	case sec.Flags&elf.SHF_COMPRESSED != 0:
+		section.lazy = &lazyData{load: func() []byte {
+			contents, _ := sec.Data()
+			return contents
+		}}
+	default:
+		section.Data = fileRange(data, sec.Offset, sec.FileSize)
+	}
+	return section
+}
+
+// elfSegments returns a section for each loadable segment, named after
+// its index, with the ELF section flags matching its permissions
+func elfSegments(data []byte, f *elf.File) []Section {
+	var sections []Section
+	for i, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		flags := elf.SHF_ALLOC
+		if prog.Flags&elf.PF_X != 0 {
+			flags |= elf.SHF_EXECINSTR
+		}
+		if prog.Flags&elf.PF_W != 0 {
+			flags |= elf.SHF_WRITE
+		}
+		sections = append(sections, Section{
+			Name:    fmt.Sprintf("LOAD%d", i),
+			Address: prog.Vaddr,
+			Size:    prog.Memsz,
+			Data:    fileRange(data, prog.Off, prog.Filesz),
+			Flags:   uint32(flags),
+		})
+	}
+	return sections
+}
+
+// elfDynamicKind tells position independent executables from shared
+// libraries: both are ET_DYN, but only executables request an interpreter
+// or are flagged DF_1_PIE, and only libraries have a soname
+func elfDynamicKind(f *elf.File) string {
+	const df1PIE = 0x08000000
+	if flags, err := f.DynValue(elf.DT_FLAGS_1); err == nil && len(flags) > 0 && flags[0]&df1PIE != 0 {
+		return KindExecutable
+	}
+	if soname, err := f.DynString(elf.DT_SONAME); err == nil && len(soname) > 0 {
+		return KindSharedLibrary
+	}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return KindExecutable
+		}
+	}
+	return KindSharedLibrary
+}
+
+// ELFRelocations decodes every REL/RELA section, resolving symbol indices
+// against the symbol table the section is linked to
+func ELFRelocations(f *elf.File) []Relocation {
+	var relocs []Relocation
+
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	eachELFRelocation(f, func(rel *elf.Section, target uint32, r Relocation, sym uint32) {
+		var table []elf.Symbol
+		if int(rel.Link) < len(f.Sections) {
+			switch f.Sections[rel.Link].Type {
+			case elf.SHT_DYNSYM:
+				table = dynSyms
+			case elf.SHT_SYMTAB:
+				table = syms
+			}
+		}
+
+		// Symbol index 0 is the null symbol; debug/elf omits it
+		if sym > 0 && int(sym) <= len(table) {
+			r.Symbol = table[sym-1].Name
+		}
+		if f.Type == elf.ET_REL && int(target) < len(f.Sections) {
+			r.Section = f.Sections[target].Name
+		}
+		relocs = append(relocs, r)
+	})
+
+	return relocs
+}
+
+// eachELFRelocation calls fn for every entry of the REL/RELA sections with
+// the index of the section it patches and of its symbol. Entries of REL
+// sections carry no addend; it is stored in the patched field.
+func eachELFRelocation(f *elf.File, fn func(rel *elf.Section, target uint32, r Relocation, sym uint32)) {
+	is64 := f.Class == elf.ELFCLASS64
+
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_RELA && sec.Type != elf.SHT_REL {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			continue
+		}
+
+		isRela := sec.Type == elf.SHT_RELA
+		entSize := 8
+		if is64 {
+			entSize = 16
+		}
+		if isRela {
+			entSize += entSize / 2
+		}
+
+		for off := 0; off+entSize <= len(data); off += entSize {
+			var r Relocation
+			var symIdx uint32
+
+			if is64 {
+				r.Address = f.ByteOrder.Uint64(data[off:])
+				info := f.ByteOrder.Uint64(data[off+8:])
+				symIdx = uint32(info >> 32)
+				r.Type = uint32(info)
+				if isRela {
+					r.Addend = int64(f.ByteOrder.Uint64(data[off+16:]))
+				}
+			} else {
+				r.Address = uint64(f.ByteOrder.Uint32(data[off:]))
+				info := f.ByteOrder.Uint32(data[off+4:])
+				symIdx = info >> 8
+				r.Type = info & 0xff
+				if isRela {
+					r.Addend = int64(int32(f.ByteOrder.Uint32(data[off+8:])))
+				}
+			}
+
+			fn(sec, sec.Info, r, symIdx)
+		}
+	}
+}
+
+// machoArch names the architecture of a Mach-O CPU type
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "x86"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		return "arm64"
+	default:
+		return fmt.Sprintf("unknown(0x%x)", uint32(cpu))
+	}
+}
+
 func parseMachO(path string, data []byte) (*Binary, error) {
-	f, err := macho.Open(path)
+	f, err := macho.NewFile(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Mach-O: %w", err)
 	}
@@ -220,30 +564,35 @@ func parseMachO(path string, data []byte) (*Binary, error) {
 		FilePath: path,
 	}
 
-	// Determine architecture
-	switch f.Cpu {
-	case macho.Cpu386:
-		binary.Arch = "x86"
-	case macho.CpuAmd64:
-		binary.Arch = "x86_64"
-	case macho.CpuArm:
-		binary.Arch = "arm"
-	case macho.CpuArm64:
-		binary.Arch = "arm64"
+	binary.Arch = machoArch(f.Cpu)
+	img := newMachOImage(f, data)
+	binary.ptrSize = img.ptrSize
+
+	switch f.Type {
+	case macho.TypeObj:
+		return parseMachOObject(binary, f)
+	case macho.TypeDylib, macho.TypeBundle:
+		binary.Kind = KindSharedLibrary
 	default:
-		binary.Arch = fmt.Sprintf("unknown(0x%x)", f.Cpu)
+		binary.Kind = KindExecutable
 	}
 
 	// Parse sections
 	for _, sec := range f.Sections {
-		data, _ := sec.Data()
-		binary.Sections = append(binary.Sections, Section{
+		section := Section{
 			Name:    sec.Name,
 			Address: sec.Addr,
 			Size:    sec.Size,
-			Data:    data,
 			Flags:   sec.Flags,
-		})
+		}
+		// Zero-fill sections (S_ZEROFILL, S_GB_ZEROFILL, S_THREAD_LOCAL_ZEROFILL)
+		// occupy no file space
+		switch sec.Flags & 0xff {
+		case 0x1, 0xc, 0x12:
+		default:
+			section.Data = fileRange(data, uint64(sec.Offset), sec.Size)
+		}
+		binary.Sections = append(binary.Sections, section)
 	}
 
 	// Parse symbols
@@ -257,5 +606,29 @@ func parseMachO(path string, data []byte) (*Binary, error) {
 		}
 	}
 
+	// Parse imports
+	imports, err := f.ImportedSymbols()
+	if err == nil {
+		binary.Imports = imports
+	}
+
+	// The load commands give what dyld needs: the entry point, libraries,
+	// the slots it binds to imported symbols or rebases, and the exports
+	binary.EntryPoint = img.entryPoint()
+	binary.Libraries = img.libraries
+	binary.FunctionStarts = img.functionStartAddresses()
+	switch {
+	case img.chainedFixups != nil:
+		binary.Relocations = img.chainedFixupRelocations()
+	case img.binds != nil:
+		binary.Relocations = append(img.bindRelocations(), img.rebaseRelocations()...)
+	default:
+		binary.Relocations = img.indirectRelocations()
+	}
+	binary.ExportTable = img.exports()
+	for _, exp := range binary.ExportTable {
+		binary.Exports = append(binary.Exports, exp.Name)
+	}
+
 	return binary, nil
 }
@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// The pointer size comes from the file headers, without an instruction
+// set backend declaring it, which this package's tests do not load
+func TestPointerSize(t *testing.T) {
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"hello", 8},
+		{"hello32.exe", 4},
+		{"hello64.exe", 8},
+	}
+	for _, tt := range tests {
+		b, err := ParseExecutable(filepath.Join("testdata", "upx", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b.PointerSize(); got != tt.want {
+			t.Errorf("%s: pointer size %d, want %d", tt.file, got, tt.want)
+		}
+		b.Close()
+	}
+
+	SetPointerSize("test32", 4)
+	raw, err := ParseRaw(filepath.Join("testdata", "sample.bin"), "test32", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if got := raw.PointerSize(); got != 4 {
+		t.Errorf("raw image: pointer size %d, want the declared 4", got)
+	}
+}
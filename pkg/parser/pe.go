@@ -0,0 +1,379 @@
+package parser
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Import is a function imported from a shared library
+type Import struct {
+	DLL     string
+	Name    string // Empty for imports by ordinal
+	Ordinal uint16 // Ordinal for imports by ordinal, the loader's hint otherwise
+	IAT     uint64 // Address of the import address table slot the loader fills
+	Delayed bool   // Resolved on first call by the delay-load helper
+}
+
+// Symbol returns the name calls to the import are shown with: its name,
+// or Ordinal_<n> for imports by ordinal
+func (imp Import) Symbol() string {
+	if imp.Name != "" {
+		return imp.Name
+	}
+	return fmt.Sprintf("Ordinal_%d", imp.Ordinal)
+}
+
+// Export is a symbol exported by the image
+type Export struct {
+	Name      string // Empty for exports by ordinal only
+	Ordinal   uint32
+	Address   uint64 // Zero for forwarders
+	Forwarder string // "DLL.Name" or "DLL.#ordinal" the loader resolves instead
+}
+
+// PEHeader holds the file and optional header fields of a PE image that
+// describe how it is loaded
+type PEHeader struct {
+	Characteristics    uint16 // COFF file header flags
+	Subsystem          uint16
+	DllCharacteristics uint16
+}
+
+// IsDLL returns true for dynamic-link libraries
+func (h *PEHeader) IsDLL() bool {
+	return h.Characteristics&pe.IMAGE_FILE_DLL != 0
+}
+
+// SubsystemName returns the name of the subsystem the image runs in
+func (h *PEHeader) SubsystemName() string {
+	switch h.Subsystem {
+	case pe.IMAGE_SUBSYSTEM_NATIVE:
+		return "native"
+	case pe.IMAGE_SUBSYSTEM_WINDOWS_GUI:
+		return "Windows GUI"
+	case pe.IMAGE_SUBSYSTEM_WINDOWS_CUI:
+		return "Windows console"
+	case pe.IMAGE_SUBSYSTEM_EFI_APPLICATION:
+		return "EFI application"
+	case pe.IMAGE_SUBSYSTEM_EFI_BOOT_SERVICE_DRIVER:
+		return "EFI boot service driver"
+	case pe.IMAGE_SUBSYSTEM_EFI_RUNTIME_DRIVER:
+		return "EFI runtime driver"
+	case pe.IMAGE_SUBSYSTEM_WINDOWS_CE_GUI:
+		return "Windows CE GUI"
+	}
+	return fmt.Sprintf("unknown(%d)", h.Subsystem)
+}
+
+// dllCharacteristicNames names the DllCharacteristics flags
+var dllCharacteristicNames = []struct {
+	flag uint16
+	name string
+}{
+	{pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA, "high-entropy-va"},
+	{pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE, "aslr"},
+	{pe.IMAGE_DLLCHARACTERISTICS_FORCE_INTEGRITY, "force-integrity"},
+	{pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT, "nx"},
+	{pe.IMAGE_DLLCHARACTERISTICS_NO_ISOLATION, "no-isolation"},
+	{pe.IMAGE_DLLCHARACTERISTICS_NO_SEH, "no-seh"},
+	{pe.IMAGE_DLLCHARACTERISTICS_NO_BIND, "no-bind"},
+	{pe.IMAGE_DLLCHARACTERISTICS_APPCONTAINER, "appcontainer"},
+	{pe.IMAGE_DLLCHARACTERISTICS_WDM_DRIVER, "wdm-driver"},
+	{pe.IMAGE_DLLCHARACTERISTICS_GUARD_CF, "cfg"},
+	{pe.IMAGE_DLLCHARACTERISTICS_TERMINAL_SERVER_AWARE, "terminal-server-aware"},
+}
+
+// DllCharacteristicNames returns the names of the security and loading
+// flags set in DllCharacteristics
+func (h *PEHeader) DllCharacteristicNames() []string {
+	var names []string
+	for _, c := range dllCharacteristicNames {
+		if h.DllCharacteristics&c.flag != 0 {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// Data directory entries
+const (
+	peDirExport      = 0
+	peDirImport      = 1
+	peDirBaseReloc   = 5
+	peDirDelayImport = 13
+)
+
+// peSymClassStatic is the storage class of static COFF symbols
+const peSymClassStatic = 3
+
+// Base relocation types
+const (
+	peRelBasedHighLow = 3
+	peRelBasedDir64   = 10
+)
+
+// peImage reads the structures of a PE image addressed by RVA. The
+// addresses it returns are virtual addresses at the preferred image base.
+type peImage struct {
+	f       *pe.File
+	data    []byte
+	ptrSize int
+	base    uint64
+	dirs    []pe.DataDirectory
+}
+
+func newPEImage(f *pe.File, data []byte) *peImage {
+	img := &peImage{f: f, data: data, ptrSize: 4}
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		img.base = uint64(oh.ImageBase)
+		img.dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	case *pe.OptionalHeader64:
+		img.ptrSize = 8
+		img.base = oh.ImageBase
+		img.dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	}
+	return img
+}
+
+// directory returns the data directory entry, zero if the image has none
+func (img *peImage) directory(index int) pe.DataDirectory {
+	if index < len(img.dirs) {
+		return img.dirs[index]
+	}
+	return pe.DataDirectory{}
+}
+
+// at returns the file contents from rva to the end of its section, nil if
+// rva is not backed by the file
+func (img *peImage) at(rva uint32) []byte {
+	for _, sec := range img.f.Sections {
+		if rva >= sec.VirtualAddress && rva-sec.VirtualAddress < sec.Size {
+			off := rva - sec.VirtualAddress
+			return fileRange(img.data, uint64(sec.Offset)+uint64(off), uint64(sec.Size-off))
+		}
+	}
+	return nil
+}
+
+func (img *peImage) u32(rva uint32) (uint32, bool) {
+	b := img.at(rva)
+	if len(b) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(b), true
+}
+
+// cstring returns the NUL-terminated string at rva
+func (img *peImage) cstring(rva uint32) string {
+	b := img.at(rva)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// thunk returns the import lookup table entry at rva and whether it is
+// not the terminating zero
+func (img *peImage) thunk(rva uint32) (uint64, bool) {
+	b := img.at(rva)
+	if len(b) < img.ptrSize {
+		return 0, false
+	}
+	if img.ptrSize == 8 {
+		v := binary.LittleEndian.Uint64(b)
+		return v, v != 0
+	}
+	v := uint64(binary.LittleEndian.Uint32(b))
+	return v, v != 0
+}
+
+// importThunks decodes the import lookup table at ilt, whose slots are
+// filled in the import address table at iat
+func (img *peImage) importThunks(dll string, ilt, iat uint32, delayed bool) []Import {
+	ordinalFlag := uint64(1) << (img.ptrSize*8 - 1)
+	var imports []Import
+	for i := uint32(0); ; i++ {
+		step := i * uint32(img.ptrSize)
+		v, ok := img.thunk(ilt + step)
+		if !ok {
+			break
+		}
+		imp := Import{DLL: dll, IAT: img.base + uint64(iat+step), Delayed: delayed}
+		if v&ordinalFlag != 0 {
+			imp.Ordinal = uint16(v)
+		} else {
+			// Hint/name entry: a 16-bit hint followed by the name
+			b := img.at(uint32(v))
+			if len(b) < 2 {
+				break
+			}
+			imp.Ordinal = binary.LittleEndian.Uint16(b)
+			imp.Name = img.cstring(uint32(v) + 2)
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
+
+// imports reads the import descriptors, one per DLL, of 20 bytes each
+func (img *peImage) imports() []Import {
+	dir := img.directory(peDirImport)
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+	var imports []Import
+	for rva := dir.VirtualAddress; ; rva += 20 {
+		b := img.at(rva)
+		if len(b) < 20 {
+			break
+		}
+		ilt := binary.LittleEndian.Uint32(b[0:])
+		nameRVA := binary.LittleEndian.Uint32(b[12:])
+		iat := binary.LittleEndian.Uint32(b[16:])
+		if nameRVA == 0 && iat == 0 {
+			break
+		}
+		// Without a lookup table the unbound IAT holds the entries
+		if ilt == 0 {
+			ilt = iat
+		}
+		imports = append(imports, img.importThunks(img.cstring(nameRVA), ilt, iat, false)...)
+	}
+	return imports
+}
+
+// delayImports reads the delay-load descriptors of 32 bytes each. Those
+// written by old linkers hold virtual addresses instead of RVAs.
+func (img *peImage) delayImports() []Import {
+	dir := img.directory(peDirDelayImport)
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+	var imports []Import
+	for rva := dir.VirtualAddress; ; rva += 32 {
+		b := img.at(rva)
+		if len(b) < 32 {
+			break
+		}
+		attrs := binary.LittleEndian.Uint32(b[0:])
+		nameRVA := binary.LittleEndian.Uint32(b[4:])
+		iat := binary.LittleEndian.Uint32(b[12:])
+		nameTable := binary.LittleEndian.Uint32(b[16:])
+		if nameRVA == 0 {
+			break
+		}
+		if attrs&1 == 0 {
+			nameRVA -= uint32(img.base)
+			iat -= uint32(img.base)
+			nameTable -= uint32(img.base)
+		}
+		imports = append(imports, img.importThunks(img.cstring(nameRVA), nameTable, iat, true)...)
+	}
+	return imports
+}
+
+// exports reads the export directory. Functions whose address lies inside
+// the directory are forwarded to another DLL.
+func (img *peImage) exports() []Export {
+	dir := img.directory(peDirExport)
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+	b := img.at(dir.VirtualAddress)
+	if len(b) < 40 {
+		return nil
+	}
+	base := binary.LittleEndian.Uint32(b[16:])
+	numFuncs := binary.LittleEndian.Uint32(b[20:])
+	numNames := binary.LittleEndian.Uint32(b[24:])
+	funcs := binary.LittleEndian.Uint32(b[28:])
+	names := binary.LittleEndian.Uint32(b[32:])
+	ordinals := binary.LittleEndian.Uint32(b[36:])
+
+	// Names index the address table through the ordinal table
+	named := make(map[uint32]string)
+	for i := uint32(0); i < numNames; i++ {
+		nameRVA, ok := img.u32(names + 4*i)
+		ob := img.at(ordinals + 2*i)
+		if !ok || len(ob) < 2 {
+			break
+		}
+		named[uint32(binary.LittleEndian.Uint16(ob))] = img.cstring(nameRVA)
+	}
+
+	var exports []Export
+	for i := uint32(0); i < numFuncs; i++ {
+		rva, ok := img.u32(funcs + 4*i)
+		if !ok {
+			break
+		}
+		if rva == 0 {
+			continue // Unused ordinal
+		}
+		exp := Export{Name: named[i], Ordinal: base + i}
+		if rva >= dir.VirtualAddress && rva-dir.VirtualAddress < dir.Size {
+			exp.Forwarder = img.cstring(rva)
+		} else {
+			exp.Address = img.base + uint64(rva)
+		}
+		exports = append(exports, exp)
+	}
+	return exports
+}
+
+// baseRelocations reads the .reloc blocks: for each 4 KB page, the offsets
+// of the slots the loader adjusts when the image is not loaded at its
+// preferred base. Only slots of whole pointers are kept.
+func (img *peImage) baseRelocations() []Relocation {
+	dir := img.directory(peDirBaseReloc)
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+	var relocs []Relocation
+	for off := uint32(0); off+8 <= dir.Size; {
+		b := img.at(dir.VirtualAddress + off)
+		if len(b) < 8 {
+			break
+		}
+		page := binary.LittleEndian.Uint32(b)
+		size := binary.LittleEndian.Uint32(b[4:])
+		if size < 8 || uint64(size) > uint64(len(b)) {
+			break
+		}
+		for i := uint32(8); i+2 <= size; i += 2 {
+			entry := binary.LittleEndian.Uint16(b[i:])
+			typ := uint32(entry >> 12)
+			if typ != peRelBasedHighLow && typ != peRelBasedDir64 {
+				continue
+			}
+			relocs = append(relocs, Relocation{
+				Address: img.base + uint64(page) + uint64(entry&0xfff),
+				Type:    typ,
+			})
+		}
+		off += size
+	}
+	return relocs
+}
+
+// ImportsByDLL groups imports by the DLL they come from, keeping the order
+// of the import tables. DLL names differing only in case are one DLL.
+func ImportsByDLL(imports []Import) [][]Import {
+	var groups [][]Import
+	index := make(map[string]int)
+	for _, imp := range imports {
+		key := strings.ToLower(imp.DLL)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], imp)
+	}
+	return groups
+}
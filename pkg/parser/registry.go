@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Format is a loader for a file format: Probe recognises files by their
+// first bytes, Parse loads them. Parse may keep data, which stays mapped
+// for the life of the binary.
+type Format struct {
+	Name  string
+	Probe func(data []byte) bool
+	Parse func(path string, data []byte) (*Binary, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+
+	// formats are probed in order: the built-in loaders first, whose
+	// magic numbers do not collide, then those registered by other
+	// packages
+	formats = []Format{
+		{Name: "PE", Probe: isPE, Parse: parsePE},
+		{Name: "ELF", Probe: isELF, Parse: parseELF},
+		{Name: "Mach-O", Probe: isMachO, Parse: parseMachO},
+		{Name: "COFF", Probe: isCOFFObject, Parse: parseCOFFObject},
+		{Name: "WASM", Probe: IsWasm, Parse: parseWasm},
+		{Name: FormatIntelHex, Probe: isIntelHex, Parse: parseIntelHex},
+		{Name: FormatSRecord, Probe: isSRecord, Parse: parseSRecord},
+	}
+)
+
+// RegisterFormat adds a file format loader, typically from the init
+// function of the package implementing it. Files are offered to it only
+// if no format registered earlier recognises them.
+func RegisterFormat(f Format) {
+	if f.Name == "" || f.Probe == nil || f.Parse == nil {
+		panic("parser: RegisterFormat needs a name, a probe and a parser")
+	}
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for _, g := range formats {
+		if g.Name == f.Name {
+			panic(fmt.Sprintf("parser: format %s registered twice", f.Name))
+		}
+	}
+	formats = append(formats, f)
+}
+
+// Formats returns the registered file formats, in the order they are
+// probed
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return append([]Format(nil), formats...)
+}
+
+// probeFormat returns the first registered format recognising data
+func probeFormat(data []byte) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.Probe(data) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// isPE returns true if data starts with a DOS header
+func isPE(data []byte) bool {
+	return len(data) >= 2 && data[0] == 'M' && data[1] == 'Z'
+}
+
+// isELF returns true if data starts with the ELF magic number
+func isELF(data []byte) bool {
+	return len(data) >= 4 && data[0] == 0x7f && data[1] == 'E' && data[2] == 'L' && data[3] == 'F'
+}
+
+// isMachO returns true if data starts with a 32- or 64-bit Mach-O magic
+// number of either byte order
+func isMachO(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	magic := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+	return magic == 0xfeedface || magic == 0xfeedfacf || magic == 0xcefaedfe || magic == 0xcffaedfe
+}
+
+var (
+	pointerSizesMu sync.RWMutex
+
+	// pointerSizes are declared by the instruction set backends, which
+	// this package cannot see, for files whose headers do not record the
+	// size; 32-bit ARM has no backend yet
+	pointerSizes = map[string]int{"arm": 4}
+)
+
+// SetPointerSize records the size in bytes of a pointer on an
+// architecture. Instruction set backends call it when they register.
+func SetPointerSize(arch string, size int) {
+	pointerSizesMu.Lock()
+	defer pointerSizesMu.Unlock()
+	pointerSizes[arch] = size
+}
+
+// PointerSize returns the size in bytes of a pointer on an architecture,
+// 8 if no backend declared it. Binary.PointerSize, which prefers what the
+// file headers record, should be used for a parsed binary.
+func PointerSize(arch string) int {
+	pointerSizesMu.RLock()
+	defer pointerSizesMu.RUnlock()
+	if size, ok := pointerSizes[arch]; ok {
+		return size
+	}
+	return 8
+}
@@ -0,0 +1,138 @@
+#!/usr/bin/env python3
+"""Writes the Mach-O fixtures of the parser tests, as no toolchain here
+links for macOS.
+
+hello is a thin x86_64 executable laid out like ld64 lays out a small
+program: __PAGEZERO, __TEXT ending in __text and __cstring, __DATA with
+__data and a zero-fill __bss, __LINKEDIT holding the function starts and
+the symbol table, and LC_MAIN giving main. hello.fat is a universal file
+of an i386 slice, started by LC_UNIXTHREAD, and an arm64 slice.
+
+    python3 macho.py
+"""
+
+import struct
+
+PAGE = 0x1000
+CPU_X86, CPU_X86_64, CPU_ARM64 = 7, 0x01000007, 0x0100000C
+LC_SEGMENT, LC_SYMTAB, LC_UNIXTHREAD, LC_LOAD_DYLIB = 0x1, 0x2, 0x5, 0xC
+LC_SEGMENT_64, LC_FUNCTION_STARTS, LC_MAIN = 0x19, 0x26, 0x80000028
+S_REGULAR, S_ZEROFILL, S_CSTRING_LITERALS = 0x0, 0x1, 0x2
+S_ATTR_PURE_INSTRUCTIONS = 0x80000000 | 0x400
+
+# main at __text+0, helper at __text+8
+CODE = {
+    CPU_X86_64: bytes.fromhex("554889e531c05dc3" "c3" + "90" * 7),
+    CPU_X86: bytes.fromhex("5589e531c05dc390" "c3" + "90" * 7),
+    CPU_ARM64: bytes.fromhex("00008052c0035fd6" "c0035fd6" + "1f2003d5"),
+}
+
+
+def name16(s):
+    return s.encode().ljust(16, b"\0")
+
+
+def uleb(v):
+    out = bytearray()
+    while True:
+        b = v & 0x7F
+        v >>= 7
+        out.append(b | (0x80 if v else 0))
+        if not v:
+            return bytes(out)
+
+
+def segment(is64, name, addr, size, off, filesz, prot, sections):
+    if is64:
+        cmd = struct.pack("<II16sQQQQiiII", LC_SEGMENT_64, 72 + 80 * len(sections), name16(name),
+                          addr, size, off, filesz, prot, prot, len(sections), 0)
+        for s in sections:
+            cmd += struct.pack("<16s16sQQIIIIIIII", name16(s[0]), name16(name), s[1], s[2], s[3], s[4], 0, 0,
+                               s[5], 0, 0, 0)
+    else:
+        cmd = struct.pack("<II16sIIIIiiII", LC_SEGMENT, 56 + 68 * len(sections), name16(name),
+                          addr, size, off, filesz, prot, prot, len(sections), 0)
+        for s in sections:
+            cmd += struct.pack("<16s16sIIIIIIIII", name16(s[0]), name16(name), s[1], s[2], s[3], s[4], 0, 0,
+                               s[5], 0, 0)
+    return cmd
+
+
+def image(cpu, thread_start=False):
+    is64 = cpu != CPU_X86
+    base = 0x100000000 if is64 else 0x1000
+    code = CODE[cpu]
+    text_off = PAGE - 0x100
+    cstring_off = text_off + len(code)
+    data_addr = base + PAGE
+
+    # __LINKEDIT: function starts, symbols, strings
+    starts = uleb(text_off) + uleb(8) + b"\0"
+    starts += b"\0" * (-len(starts) % 8)
+    strtab = b" \0__mh_execute_header\0_main\0_helper\0"
+    syms = [(2, 0x0F, 1, base), (22, 0x0F, 1, base + text_off), (28, 0x0E, 1, base + text_off + 8)]
+    nlist = b"".join(struct.pack("<IBBHQ" if is64 else "<IBBHI", *s[:2], s[2], 0x10 if s[0] == 2 else 0, s[3])
+                     for s in syms)
+    linkedit_off = 2 * PAGE
+    symoff = linkedit_off + len(starts)
+    stroff = symoff + len(nlist)
+    linkedit = starts + nlist + strtab
+
+    cmds = []
+    if is64:
+        cmds.append(segment(is64, "__PAGEZERO", 0, base, 0, 0, 0, []))
+    cmds.append(segment(is64, "__TEXT", base, PAGE, 0, PAGE, 5, [
+        ("__text", base + text_off, len(code), text_off, 4, S_ATTR_PURE_INSTRUCTIONS),
+        ("__cstring", base + cstring_off, 6, cstring_off, 0, S_CSTRING_LITERALS),
+    ]))
+    cmds.append(segment(is64, "__DATA", data_addr, PAGE, PAGE, PAGE, 3, [
+        ("__data", data_addr, 8, PAGE, 3, S_REGULAR),
+        ("__bss", data_addr + 8, 0x10, 0, 3, S_ZEROFILL),
+    ]))
+    cmds.append(segment(is64, "__LINKEDIT", data_addr + PAGE, PAGE, linkedit_off, len(linkedit), 1, []))
+    cmds.append(struct.pack("<IIII", LC_FUNCTION_STARTS, 16, linkedit_off, len(starts)))
+    cmds.append(struct.pack("<IIIIII", LC_SYMTAB, 24, symoff, len(syms), stroff, len(strtab)))
+    dylib = b"/usr/lib/libSystem.B.dylib\0"
+    dylib += b"\0" * (-(24 + len(dylib)) % 8)
+    cmds.append(struct.pack("<IIIIII", LC_LOAD_DYLIB, 24 + len(dylib), 24, 2, 0x10000, 0x10000) + dylib)
+    if thread_start:
+        # x86_THREAD_STATE32: eax ebx ecx edx edi esi ebp esp ss eflags eip ...
+        regs = [0] * 16
+        regs[10] = base + text_off
+        cmds.append(struct.pack("<IIII16I", LC_UNIXTHREAD, 16 + 64, 1, 16, *regs))
+    else:
+        cmds.append(struct.pack("<IIQQ", LC_MAIN, 24, text_off, 0))
+
+    sizeofcmds = sum(len(c) for c in cmds)
+    if is64:
+        header = struct.pack("<IiiIIIII", 0xFEEDFACF, cpu, 3 if cpu == CPU_X86_64 else 0, 2, len(cmds),
+                             sizeofcmds, 0x200085, 0)
+    else:
+        header = struct.pack("<IiiIIII", 0xFEEDFACE, cpu, 3, 2, len(cmds), sizeofcmds, 0x85)
+
+    out = bytearray(linkedit_off + len(linkedit))
+    head = header + b"".join(cmds)
+    out[:len(head)] = head
+    out[text_off:text_off + len(code)] = code
+    out[cstring_off:cstring_off + 6] = b"hello\0"
+    out[PAGE:PAGE + 8] = struct.pack("<Q", 42)
+    out[linkedit_off:] = linkedit
+    return bytes(out)
+
+
+def universal(slices):
+    header = struct.pack(">II", 0xCAFEBABE, len(slices))
+    body = bytearray()
+    off = PAGE
+    for cpu, data in slices:
+        sub = 3 if cpu == CPU_X86 else 0
+        header += struct.pack(">iiIII", cpu, sub, off, len(data), 12)
+        body += data + b"\0" * (-len(data) % PAGE)
+        off += len(data) + (-len(data) % PAGE)
+    return header.ljust(PAGE, b"\0") + bytes(body)
+
+
+with open("hello", "wb") as f:
+    f.write(image(CPU_X86_64))
+with open("hello.fat", "wb") as f:
+    f.write(universal([(CPU_X86, image(CPU_X86, thread_start=True)), (CPU_ARM64, image(CPU_ARM64))]))
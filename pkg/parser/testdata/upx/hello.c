@@ -0,0 +1,19 @@
+/* The original of the UPX fixtures: a static ELF without libc, padded with
+ * a table so that upx accepts it.
+ *
+ *   gcc -Os -static -nostdlib -fno-asynchronous-unwind-tables -o hello hello.c
+ *   upx -1 -o hello.nrv hello
+ *   upx --lzma -o hello.lzma hello
+ *
+ * pack.py writes hello.nrv and hello.lzma in the same layout where upx is
+ * not available, along with the PE fixtures.
+ */
+__attribute__((used)) const unsigned char table[1 << 16] = {1, 2, 3, 4, 5, 6, 7, 8};
+
+void _start(void)
+{
+	long code = *(const volatile unsigned char *)&table[3];
+	__asm__ volatile("syscall" : : "a"(60), "D"(code));
+	for (;;)
+		;
+}
@@ -0,0 +1,289 @@
+#!/usr/bin/env python3
+"""Writes the UPX fixtures of TestUnpackUPXPacked where upx cannot run.
+
+The files follow the layout upx 3.96 gives an amd64 ELF executable and
+i386 and amd64 PE images: for ELF, l_info and p_info after the program
+headers, then b_info blocks restoring the file up to its last segment,
+the code segment filtered; for PE, UPX0 holding no data where the image
+decompresses, UPX1 holding the compressed image and a stub that jumps to
+the original entry point, and the pack header ahead of UPX1. The data are
+compressed like upx -1 (NRV2B) and upx --lzma (LZMA, lc=3 lp=0 pb=2) do,
+by an NRV2B encoder following UCL's bit format and by liblzma.
+
+hello is built from hello.c; hello32.exe and hello64.exe, which need no
+C compiler, are written here too.
+
+    python3 pack.py
+"""
+
+import lzma
+import struct
+
+M_NRV2B = 2
+M_LZMA = 14
+
+
+# NRV2B, LE32 flavour: flag bits MSB first in 32-bit little-endian words
+# placed ahead of the bytes that follow them
+class BitWriter:
+    def __init__(self):
+        self.out = bytearray()
+        self.word = None
+        self.n = 0
+
+    def bit(self, b):
+        if self.n == 0:
+            self.word = len(self.out)
+            self.out += b"\0\0\0\0"
+            self.n = 32
+        self.n -= 1
+        if b:
+            v = struct.unpack_from("<I", self.out, self.word)[0] | 1 << self.n
+            struct.pack_into("<I", self.out, self.word, v)
+
+    def byte(self, b):
+        self.out.append(b)
+
+    def gamma(self, v):
+        bits = bin(v)[3:]
+        for i, b in enumerate(bits):
+            self.bit(int(b))
+            self.bit(1 if i == len(bits) - 1 else 0)
+
+
+def nrv2b(data):
+    w = BitWriter()
+    heads = {}
+    last_off = 1
+    i = 0
+    while i < len(data):
+        best_len, best_off = 0, 0
+        for j in reversed(heads.get(data[i:i + 3], [])[-32:]):
+            off = i - j
+            n = 0
+            while i + n < len(data) and data[j + n] == data[i + n] and n < 0x10000:
+                n += 1
+            if n > best_len:
+                best_len, best_off = n, off
+        if best_len < (4 if best_off > 0xd00 else 3):
+            best_len = 0
+        step = best_len or 1
+        for k in range(i, i + step):
+            heads.setdefault(data[k:k + 3], []).append(k)
+        if not best_len:
+            w.bit(1)
+            w.byte(data[i])
+            i += 1
+            continue
+        w.bit(0)
+        if best_off == last_off:
+            w.gamma(2)
+        else:
+            w.gamma(((best_off - 1) >> 8) + 3)
+            w.byte((best_off - 1) & 0xff)
+            last_off = best_off
+        m = best_len - 1 - (1 if best_off > 0xd00 else 0)
+        if m < 4:
+            w.bit(m >> 1)
+            w.bit(m & 1)
+        else:
+            w.bit(0)
+            w.bit(0)
+            w.gamma(m - 2)
+        i += best_len
+    w.bit(0)
+    w.gamma(0x1000002)
+    w.byte(0xff)
+    return bytes(w.out)
+
+
+def compress(method, data):
+    if method == M_NRV2B:
+        return nrv2b(data)
+    raw = lzma.compress(data, format=lzma.FORMAT_RAW,
+                        filters=[{"id": lzma.FILTER_LZMA1, "lc": 3, "lp": 0, "pb": 2}])
+    return bytes([3 << 3 | 2, 0 << 4 | 3]) + raw
+
+
+# The call trick filter 0x49: targets of calls, jumps and conditional
+# jumps as big-endian offsets from addvalue bytes before buf, marked with
+# cto, a top byte no other site starts with
+def filter49(buf, addvalue):
+    orig = bytes(buf)
+
+    def sites():
+        i = 0
+        while i + 5 <= len(orig):
+            op = orig[i]
+            if op in (0xe8, 0xe9) or (i > 0 and orig[i - 1] == 0x0f and op & 0xf0 == 0x80):
+                target = (struct.unpack_from("<I", orig, i + 1)[0] + i + 1 + addvalue) & 0xffffffff
+                yield i, target
+            i += 1
+
+    kept = {orig[i + 1] for i, t in sites() if t >> 24}
+    cto = next(c for c in range(0x40, 0x100) if c not in kept)
+    out = bytearray(orig)
+    i = 0
+    while i + 5 <= len(orig):
+        op = orig[i]
+        if op in (0xe8, 0xe9) or (i > 0 and orig[i - 1] == 0x0f and op & 0xf0 == 0x80):
+            target = (struct.unpack_from("<I", orig, i + 1)[0] + i + 1 + addvalue) & 0xffffffff
+            if target >> 24 == 0:
+                struct.pack_into(">I", out, i + 1, target + (cto << 24))
+                i += 5
+                continue
+            if orig[i + 1] == cto:
+                raise ValueError("no free cto byte")
+        i += 1
+    return bytes(out), cto
+
+
+def pack_elf(original, method):
+    (phoff,) = struct.unpack_from("<Q", original, 0x20)
+    phentsize, phnum = struct.unpack_from("<HH", original, 0x36)
+    segments = []
+    for k in range(phnum):
+        p_type, p_flags, p_offset, _, _, p_filesz = struct.unpack_from("<IIQQQQ", original, phoff + k * phentsize)
+        if p_type == 1:  # PT_LOAD
+            segments.append((p_offset, p_filesz, p_flags))
+
+    blocks = bytearray()
+    start = 0
+    for offset, filesz, flags in sorted(segments):
+        end = offset + filesz
+        if end <= start:
+            continue
+        data, ftid, cto = original[start:end], 0, 0
+        if flags & 1:  # PF_X
+            data, cto = filter49(data, 0)
+            ftid = 0x49
+        packed = compress(method, data)
+        if len(packed) >= end - start:
+            packed, ftid, cto = original[start:end], 0, 0
+        blocks += struct.pack("<IIBBBB", end - start, len(packed), method, ftid, cto, 0) + packed
+        start = end
+    blocks += struct.pack("<III", 0, 0, 0)
+
+    base = 0x10000000
+    ehdr, phdr = 64, 56
+    info = struct.pack("<I4sHBB", 0, b"UPX!", 0, 13, 22)  # l_info, UPX_F_LINUX_ELF64_AMD
+    info += struct.pack("<III", 0, len(original), 0x40000)  # p_info
+    stub_at = ehdr + phdr + len(info) + len(blocks)
+    # Stands in for the loader, which is about this size
+    stub = b"\x90" * 0x7ff + b"\xc3"
+    size = stub_at + len(stub)
+    out = bytearray(original[:ehdr])
+    struct.pack_into("<Q", out, 0x18, base + stub_at)  # e_entry
+    struct.pack_into("<QQ", out, 0x20, ehdr, 0)  # e_phoff, e_shoff
+    struct.pack_into("<HHHH", out, 0x36, phdr, 1, 0x40, 0)
+    struct.pack_into("<H", out, 0x3e, 0)
+    out += struct.pack("<IIQQQQQQ", 1, 5, 0, base, base, size, size, 0x1000)
+    return bytes(out + info + blocks + stub)
+
+
+def write_pe(bits, code, rdata):
+    """A PE image with .text at 0x1000 and .rdata at 0x2000, entry at .text"""
+    def align(n, a):
+        return (n + a - 1) // a * a
+
+    machine, magic, base = (0x14c, 0x10b, 0x400000) if bits == 32 else (0x8664, 0x20b, 0x140000000)
+    sections = [(b".text", 0x1000, code, 0x60000020), (b".rdata", 0x2000, rdata, 0x40000040)]
+    raw = 0x200
+    table = bytearray()
+    body = bytearray()
+    for name, rva, data, flags in sections:
+        size = align(len(data), 0x200)
+        table += struct.pack("<8sIIIIIIHHI", name, len(data), rva, size, raw + len(body), 0, 0, 0, 0, flags)
+        body += data + b"\0" * (size - len(data))
+    image = align(0x2000 + len(rdata), 0x1000)
+    _, pe = pe_file(bits, machine, magic, base, 0x1000, 0x1000, image, table, raw, body)
+    return pe
+
+
+def pe_file(bits, machine, magic, base, entry, code_base, image, table, raw, body):
+    """The headers of a PE image and the file they start"""
+    ohsize = 224 if bits == 32 else 240
+    dos = bytearray(0x40)
+    dos[0:2] = b"MZ"
+    struct.pack_into("<I", dos, 0x3c, 0x40)
+    nsections = len(table) // 40
+    coff = struct.pack("<HHIIIHH", machine, nsections, 0, 0, 0, ohsize, 0x22 if bits == 64 else 0x102)
+    if bits == 32:
+        opt = struct.pack("<HBBIIIIIIIIIHHHHHHIIIIHHIIIIII", magic, 14, 0, 0, 0, 0, entry, code_base, 0x2000,
+                          base, 0x1000, 0x200, 4, 0, 0, 0, 4, 0, 0, image, raw, 0, 3, 0,
+                          0x100000, 0x1000, 0x100000, 0x1000, 0, 16)
+    else:
+        opt = struct.pack("<HBBIIIIIQIIHHHHHHIIIIHHQQQQII", magic, 14, 0, 0, 0, 0, entry, code_base,
+                          base, 0x1000, 0x200, 6, 0, 0, 0, 6, 0, 0, image, raw, 0, 3, 0x8160,
+                          0x100000, 0x1000, 0x100000, 0x1000, 0, 16)
+    opt += b"\0" * (ohsize - len(opt))
+    head = dos + b"PE\0\0" + coff + opt + table
+    return head, bytes(head + b"\0" * (raw - len(head)) + body)
+
+
+def pack_pe(bits, code, rdata, method):
+    """The image written by write_pe as upx packs it"""
+    machine, magic, base = (0x14c, 0x10b, 0x400000) if bits == 32 else (0x8664, 0x20b, 0x140000000)
+    # The image from .text up to its end, as mapped
+    image = bytearray((0x2000 + len(rdata) + 0xfff) // 0x1000 * 0x1000 - 0x1000)
+    image[0:len(code)] = code
+    image[0x1000:0x1000 + len(rdata)] = rdata
+    filtered, cto = filter49(bytes(image), 0)
+    packed = compress(method, filtered)
+
+    upx0_size = 0x3000
+    upx1_rva = 0x1000 + upx0_size
+    # The stub: restore the registers, then jump to the original entry
+    stub = bytearray(b"\x90" * 11 + b"\xe9")
+    jmp = upx1_rva + len(packed) + len(stub)
+    stub += struct.pack("<i", 0x1000 - (jmp + 4)) + b"\0" * 4
+    data = packed + bytes(stub)
+    entry = upx1_rva + len(packed)
+    size = (len(data) + 0x1ff) // 0x200 * 0x200
+    table = struct.pack("<8sIIIIIIHHI", b"UPX0", upx0_size, 0x1000, 0, 0x400, 0, 0, 0, 0, 0xe0000080)
+    table += struct.pack("<8sIIIIIIHHI", b"UPX1", len(data), upx1_rva, size, 0x400, 0, 0, 0, 0, 0xe0000040)
+    image_size = (upx1_rva + len(data) + 0xfff) // 0x1000 * 0x1000
+    head, _ = pe_file(bits, machine, magic, base, entry, 0x1000, image_size, table, 0x400, b"")
+
+    fmt = 9 if bits == 32 else 36  # UPX_F_W32PE_I386, UPX_F_W64PE_AMD64
+    ph = struct.pack("<4sBBBBIIIIIBBB", b"UPX!", 13, fmt, method, 1 if method == M_NRV2B else 8,
+                     0, 0, len(image), len(packed), 0x400 + size, 0x49, cto, 0)
+    ph += bytes([sum(ph[4:]) & 0xff])
+    head = bytearray(head)
+    head += b"\0" * (0x3db - len(head)) + b"3.96\0" + ph
+    head += b"\0" * (0x400 - len(head))
+    return bytes(head + data + b"\0" * (size - len(data)))
+
+
+def pe_program(bits):
+    # Calls, a jump and a conditional jump over a loop that sums the table,
+    # and the table itself
+    if bits == 32:
+        code = bytes.fromhex("55 89e5 e8 11000000 85c0 0f84 02000000 eb 00 5d c3 e9 f7ffffff"
+                             "31c0 b9 00204000 0301 40 3d 00040000 75f6 c3")
+    else:
+        code = bytes.fromhex("55 4889e5 e8 11000000 85c0 0f84 02000000 eb 00 5d c3 e9 f7ffffff"
+                             "31c0 488d0d dd0f0000 0301 ffc0 3d 00040000 75f5 c3")
+    rdata = bytes(range(256)) * 16 + b"hello from the table\0"
+    return code, rdata
+
+
+def main():
+    with open("hello", "rb") as f:
+        original = f.read()
+    with open("hello.nrv", "wb") as f:
+        f.write(pack_elf(original, M_NRV2B))
+    with open("hello.lzma", "wb") as f:
+        f.write(pack_elf(original, M_LZMA))
+
+    for bits in (32, 64):
+        code, rdata = pe_program(bits)
+        with open("hello%d.exe" % bits, "wb") as f:
+            f.write(write_pe(bits, code, rdata))
+        for method, suffix in ((M_NRV2B, "nrv"), (M_LZMA, "lzma")):
+            with open("hello%d.%s.exe" % (bits, suffix), "wb") as f:
+                f.write(pack_pe(bits, code, rdata, method))
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,158 @@
+package parser
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Magics of universal (fat) Mach-O files, stored big-endian
+const (
+	fatMagic   = 0xcafebabe
+	fatMagic64 = 0xcafebabf
+)
+
+// Slice is one architecture of a universal (fat) Mach-O file
+type Slice struct {
+	Arch   string
+	Offset uint64 // File offset of the thin Mach-O image
+	Size   uint64
+}
+
+// UniversalError reports a universal Mach-O parsed without selecting one
+// of its slices
+type UniversalError struct {
+	Slices []Slice
+}
+
+func (e *UniversalError) Error() string {
+	return fmt.Sprintf("universal binary with slices %s: select an architecture", strings.Join(sliceArchs(e.Slices), ", "))
+}
+
+func sliceArchs(slices []Slice) []string {
+	archs := make([]string, len(slices))
+	for i, s := range slices {
+		archs[i] = s.Arch
+	}
+	return archs
+}
+
+// universalSlices decodes the fat header of data. It returns nil if data
+// is not a universal Mach-O file.
+func universalSlices(data []byte) ([]Slice, error) {
+	if len(data) < 8 {
+		return nil, nil
+	}
+	magic := binary.BigEndian.Uint32(data)
+	if magic != fatMagic && magic != fatMagic64 {
+		return nil, nil
+	}
+	// Java class files share the magic; there the second word holds the
+	// class file version, always 45 or more
+	count := binary.BigEndian.Uint32(data[4:])
+	if count == 0 || count >= 45 {
+		return nil, nil
+	}
+
+	// fat_arch: cputype, cpusubtype, offset, size, align; fat_arch_64
+	// widens offset and size to 64 bits and adds a reserved word
+	entrySize := 20
+	if magic == fatMagic64 {
+		entrySize = 32
+	}
+	if 8+int(count)*entrySize > len(data) {
+		return nil, fmt.Errorf("truncated universal header")
+	}
+
+	slices := make([]Slice, count)
+	for i := range slices {
+		entry := data[8+i*entrySize:]
+		s := &slices[i]
+		s.Arch = machoArch(macho.Cpu(binary.BigEndian.Uint32(entry)))
+		if magic == fatMagic64 {
+			s.Offset = binary.BigEndian.Uint64(entry[8:])
+			s.Size = binary.BigEndian.Uint64(entry[16:])
+		} else {
+			s.Offset = uint64(binary.BigEndian.Uint32(entry[8:]))
+			s.Size = uint64(binary.BigEndian.Uint32(entry[12:]))
+		}
+		if s.Offset > uint64(len(data)) || s.Size > uint64(len(data))-s.Offset {
+			return nil, fmt.Errorf("universal slice %s lies outside the file", s.Arch)
+		}
+	}
+	return slices, nil
+}
+
+// Slices lists the architectures of a universal Mach-O file. It returns
+// nil for any other executable.
+func Slices(path string) ([]Slice, error) {
+	data, unmap, err := mapTarget(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer unmap()
+	return universalSlices(data)
+}
+
+// ParseArch parses an executable like ParseExecutable, taking the slice
+// of a universal Mach-O file built for arch. Universal files and Intel HEX
+// or S-record images, which do not record their architecture, need an
+// arch; for other executables an empty arch accepts any architecture.
+func ParseArch(path, arch string) (*Binary, error) {
+	data, unmap, err := mapTarget(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	binary, err := parseArch(path, data, arch)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	binary.unmap = unmap
+	return binary, nil
+}
+
+// parseArch parses data, or its slice for arch if it is a universal file
+func parseArch(path string, data []byte, arch string) (*Binary, error) {
+	slices, err := universalSlices(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices == nil {
+		binary, err := parseData(path, data)
+		if err != nil {
+			return nil, err
+		}
+		// Firmware images leave the architecture to the user
+		if binary.Arch == "" {
+			if arch == "" {
+				return nil, fmt.Errorf("%s image: %w", binary.Format, ErrNoArch)
+			}
+			binary.Arch = arch
+		}
+		if arch != "" && binary.Arch != arch {
+			return nil, fmt.Errorf("%s executable has no %s code", binary.Arch, arch)
+		}
+		return binary, nil
+	}
+
+	if arch == "" {
+		return nil, &UniversalError{Slices: slices}
+	}
+	for i := range slices {
+		s := &slices[i]
+		if s.Arch != arch {
+			continue
+		}
+		binary, err := parseData(path, data[s.Offset:s.Offset+s.Size])
+		if err != nil {
+			return nil, fmt.Errorf("%s slice: %w", arch, err)
+		}
+		binary.Slice = s
+		return binary, nil
+	}
+	return nil, fmt.Errorf("no %s slice in universal binary (slices: %s)", arch, strings.Join(sliceArchs(slices), ", "))
+}
@@ -0,0 +1,337 @@
+package parser
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrNotPacked is returned by UnpackUPX for binaries UPX did not pack
+var ErrNotPacked = errors.New("not packed with UPX")
+
+// upxMagic marks the UPX headers of packed files
+var upxMagic = []byte("UPX!")
+
+// Bounds on the sizes packed files claim for their contents, which the
+// decoders allocate up front: a block decompresses to at most
+// upxMaxBlockRatio times its size (LZMA packs runs of zeros about 7000 to
+// 1), a file to at most upxMaxRatio times the packed file
+const (
+	upxMaxBlockRatio = 8192
+	upxMaxRatio      = 64
+)
+
+// Compression methods of UPX; the NRV methods are the LE32 flavours the
+// x86 stubs decode
+const (
+	upxNRV2B = 2
+	upxNRV2D = 5
+	upxNRV2E = 8
+	upxLZMA  = 14
+)
+
+// upxDecompress decodes size bytes compressed with a UPX method
+func upxDecompress(method byte, src []byte, size int) ([]byte, error) {
+	switch method {
+	case upxNRV2B:
+		return unNRV(src, size, nrv2b)
+	case upxNRV2D:
+		return unNRV(src, size, nrv2d)
+	case upxNRV2E:
+		return unNRV(src, size, nrv2e)
+	case upxLZMA:
+		// Two bytes of properties: (lc+lp)<<3|pb, then lp<<4|lc
+		if len(src) < 2 {
+			return nil, errCorrupt
+		}
+		pb, lp, lc := uint(src[0]&7), uint(src[1]>>4), uint(src[1]&15)
+		return unLZMA(src[2:], size, lc, lp, pb)
+	}
+	return nil, fmt.Errorf("unsupported UPX compression method %d", method)
+}
+
+// upxUnfilter undoes the call trick filters UPX applies to x86 code before
+// compressing it. They turn the relative targets of calls, jumps (0x26,
+// 0x46) and conditional jumps (0x49) into big-endian offsets marked with
+// cto as their top byte, which compress better. The offsets count from
+// addvalue bytes before buf.
+func upxUnfilter(buf []byte, id, cto byte, addvalue uint32) error {
+	var jumps, jccs bool
+	switch id {
+	case 0:
+		return nil
+	case 0x16:
+	case 0x26, 0x46:
+		jumps = true
+	case 0x49:
+		jumps, jccs = true, true
+	default:
+		return fmt.Errorf("unsupported UPX filter 0x%02x", id)
+	}
+	for i := 0; i+5 <= len(buf); i++ {
+		op := buf[i]
+		if !(op == 0xe8 || jumps && op == 0xe9 || jccs && i > 0 && buf[i-1] == 0x0f && op&0xf0 == 0x80) {
+			continue
+		}
+		if buf[i+1] != cto {
+			continue
+		}
+		target := binary.BigEndian.Uint32(buf[i+1:]) - uint32(cto)<<24
+		binary.LittleEndian.PutUint32(buf[i+1:], target-uint32(i+1)-addvalue)
+		i += 4
+	}
+	return nil
+}
+
+// UnpackUPX returns the program a UPX-packed ELF or PE file decompresses at
+// run time, or ErrNotPacked. The result takes over the file mapping of b:
+// close it instead of b. Unpacked PE images are one section of code and
+// data in the place of UPX0; the imports the stub resolves are not
+// recovered.
+func UnpackUPX(b *Binary) (*Binary, error) {
+	var u *Binary
+	var err error
+	switch b.Format {
+	case "ELF":
+		u, err = unpackUPXELF(b)
+	case "PE":
+		u, err = unpackUPXPE(b)
+	default:
+		return nil, ErrNotPacked
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Packer = "UPX"
+	u.FilePath = b.FilePath
+	u.Slice = b.Slice
+	u.unmap = b.unmap
+	if u.EntryPoint != 0 && !hasSymbolAt(u.Symbols, u.EntryPoint) {
+		u.Symbols = append(u.Symbols, entrySymbol(u.EntryPoint))
+	}
+	return u, nil
+}
+
+func hasSymbolAt(symbols []Symbol, addr uint64) bool {
+	for _, sym := range symbols {
+		if sym.Address == addr && sym.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// upxBlocks decodes the blocks of a packed ELF file, each a b_info header
+// (uncompressed and compressed sizes, method, filter and its cto byte)
+// followed by its data, until the empty block that ends them
+func upxBlocks(data []byte, limit int) ([]byte, error) {
+	var out []byte
+	for len(out) < limit {
+		if len(data) < 12 {
+			return nil, errCorrupt
+		}
+		unc := binary.LittleEndian.Uint32(data)
+		cpr := binary.LittleEndian.Uint32(data[4:])
+		method, ftid, cto := data[8], data[9], data[10]
+		if unc == 0 {
+			break
+		}
+		data = data[12:]
+		if cpr == 0 || cpr > unc || int(cpr) > len(data) || uint64(unc) > uint64(cpr)*upxMaxBlockRatio || len(out)+int(unc) > limit {
+			return nil, errCorrupt
+		}
+
+		block := append([]byte(nil), data[:cpr]...)
+		if cpr < unc {
+			var err error
+			block, err = upxDecompress(method, data[:cpr], int(unc))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := upxUnfilter(block, ftid, cto, 0); err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+		data = data[cpr:]
+	}
+	return out, nil
+}
+
+// IsUPXELF reports whether data is an ELF file packed by UPX
+func IsUPXELF(data []byte) bool {
+	return upxInfoELF(data) >= 0
+}
+
+// upxInfoELF returns the offset of the l_info header UPX writes right
+// after the program headers of the ELF files it packs, or -1
+func upxInfoELF(data []byte) int {
+	if len(data) < 0x34 || !bytes.HasPrefix(data, []byte("\x7fELF")) || data[5] != 1 { // ELFDATA2LSB
+		return -1
+	}
+	var phoff uint64
+	var phentsize, phnum uint16
+	switch data[4] {
+	case 1: // ELFCLASS32
+		phoff = uint64(binary.LittleEndian.Uint32(data[0x1c:]))
+		phentsize = binary.LittleEndian.Uint16(data[0x2a:])
+		phnum = binary.LittleEndian.Uint16(data[0x2c:])
+	case 2: // ELFCLASS64
+		if len(data) < 0x40 {
+			return -1
+		}
+		phoff = binary.LittleEndian.Uint64(data[0x20:])
+		phentsize = binary.LittleEndian.Uint16(data[0x36:])
+		phnum = binary.LittleEndian.Uint16(data[0x38:])
+	default:
+		return -1
+	}
+	at := phoff + uint64(phentsize)*uint64(phnum)
+	if phoff == 0 || at+12 > uint64(len(data)) || !bytes.Equal(data[at+4:at+8], upxMagic) {
+		return -1
+	}
+	return int(at)
+}
+
+// unpackUPXELF rebuilds a packed ELF executable. Its program headers are
+// followed by l_info (checksum, "UPX!", loader size, version, format) and
+// p_info (original file size, block size), then by the compressed blocks
+// that, in order, restore the original file up to its last segment.
+func unpackUPXELF(b *Binary) (*Binary, error) {
+	data := b.RawData
+	if len(data) < 0x40 || data[5] != 1 { // ELFDATA2LSB
+		return nil, ErrNotPacked
+	}
+	at := upxInfoELF(data)
+	if at < 0 || at+24+12 > len(data) {
+		return nil, ErrNotPacked
+	}
+	size := binary.LittleEndian.Uint32(data[at+16:]) // p_info.p_filesize
+	if size < 0x40 || int64(size) > int64(len(data))*upxMaxRatio {
+		return nil, fmt.Errorf("UPX: bad original file size %d", size)
+	}
+
+	out, err := upxBlocks(data[at+24:], int(size))
+	if err != nil {
+		return nil, fmt.Errorf("UPX: %w", err)
+	}
+	if len(out) < 0x40 || !bytes.HasPrefix(out, []byte("\x7fELF")) {
+		return nil, fmt.Errorf("UPX: unsupported layout")
+	}
+
+	// Section headers, which follow the segments, are not restored
+	if out[4] == 2 { // ELFCLASS64
+		shoff := binary.LittleEndian.Uint64(out[0x28:])
+		shnum := uint64(binary.LittleEndian.Uint16(out[0x3c:]))
+		if shoff+shnum*0x40 > uint64(len(out)) {
+			binary.LittleEndian.PutUint64(out[0x28:], 0)
+			binary.LittleEndian.PutUint32(out[0x3c:], 0) // e_shnum and e_shstrndx
+		}
+	} else {
+		shoff := uint64(binary.LittleEndian.Uint32(out[0x20:]))
+		shnum := uint64(binary.LittleEndian.Uint16(out[0x30:]))
+		if shoff+shnum*0x28 > uint64(len(out)) {
+			binary.LittleEndian.PutUint32(out[0x20:], 0)
+			binary.LittleEndian.PutUint32(out[0x30:], 0)
+		}
+	}
+
+	u, err := parseELF(b.FilePath, out)
+	if err != nil {
+		return nil, fmt.Errorf("UPX: unpacked file: %w", err)
+	}
+	return u, nil
+}
+
+// unpackUPXPE decompresses a packed PE image. Its pack header, in the
+// file headers, holds the sizes, method and filter; the compressed data
+// starts UPX1 and decompresses to where UPX0 is mapped. The stub ends by
+// jumping to the original entry point.
+func unpackUPXPE(b *Binary) (*Binary, error) {
+	f, err := pe.NewFile(bytes.NewReader(b.RawData))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	upx0, upx1 := f.Section("UPX0"), f.Section("UPX1")
+	if upx0 == nil || upx1 == nil {
+		return nil, ErrNotPacked
+	}
+
+	// The pack header: "UPX!", version, format, method, level, checksums
+	// of the uncompressed and compressed data, their sizes, the original
+	// file size, filter and cto
+	head := b.RawData
+	if uint32(len(head)) > upx1.Offset {
+		head = head[:upx1.Offset]
+	}
+	at := bytes.LastIndex(head, upxMagic)
+	if at < 0 || at+32 > len(b.RawData) {
+		return nil, fmt.Errorf("UPX: pack header not found")
+	}
+	ph := b.RawData[at:]
+	method := ph[6]
+	uLen := binary.LittleEndian.Uint32(ph[16:])
+	cLen := binary.LittleEndian.Uint32(ph[20:])
+	filter, cto := ph[28], ph[29]
+
+	packed := fileRange(b.RawData, uint64(upx1.Offset), uint64(upx1.Size))
+	if uint64(cLen) > uint64(len(packed)) || uLen == 0 || uint64(uLen) > uint64(cLen)*upxMaxBlockRatio || uint64(uLen) > uint64(len(b.RawData))*upxMaxRatio {
+		return nil, fmt.Errorf("UPX: bad pack header")
+	}
+	image, err := upxDecompress(method, packed[:cLen], int(uLen))
+	if err != nil {
+		return nil, fmt.Errorf("UPX: %w", err)
+	}
+
+	// Calls were filtered from the start of the code section on
+	var codeBase uint32
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		codeBase = oh.BaseOfCode
+	case *pe.OptionalHeader64:
+		codeBase = oh.BaseOfCode
+	}
+	offset := uint32(0)
+	if codeBase >= upx0.VirtualAddress && uint64(codeBase-upx0.VirtualAddress) < uint64(len(image)) {
+		offset = codeBase - upx0.VirtualAddress
+	}
+	if err := upxUnfilter(image[offset:], filter, cto, offset); err != nil {
+		return nil, fmt.Errorf("UPX: %w", err)
+	}
+
+	u := *b
+	start := b.ImageBase + uint64(upx0.VirtualAddress)
+	stub := b.ImageBase + uint64(upx1.VirtualAddress)
+	u.Sections = nil
+	for _, sec := range b.Sections {
+		switch sec.Address {
+		case start:
+			sec.Size = uint64(len(image))
+			sec.Data = image
+		case stub:
+			continue
+		}
+		u.Sections = append(u.Sections, sec)
+	}
+
+	// The stub's last jump into UPX0 goes to the original entry point
+	u.EntryPoint = 0
+	end := start + uint64(upx0.VirtualSize)
+	for i := int(cLen); i+5 <= len(packed); i++ {
+		if packed[i] != 0xe9 {
+			continue
+		}
+		addr := stub + uint64(i)
+		target := addr + 5 + uint64(int64(int32(binary.LittleEndian.Uint32(packed[i+1:]))))
+		if start <= target && target < end {
+			u.EntryPoint = target
+		}
+	}
+	if u.EntryPoint == 0 {
+		return nil, fmt.Errorf("UPX: original entry point not found")
+	}
+	return &u, nil
+}
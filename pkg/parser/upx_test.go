@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The files in testdata hold sample.bin compressed with each method: the
+// NRV streams by an encoder following UCL's bit formats, with literals,
+// short and long matches, repeated offsets and offsets past the distance
+// that adds a byte to the length; the LZMA stream by liblzma (lc=3, lp=0,
+// pb=2), behind the two property bytes UPX stores.
+func TestUPXDecompress(t *testing.T) {
+	want := readTestdata(t, "sample.bin")
+	tests := []struct {
+		file   string
+		method byte
+	}{
+		{"nrv2b.bin", upxNRV2B},
+		{"nrv2d.bin", upxNRV2D},
+		{"nrv2e.bin", upxNRV2E},
+		{"lzma.bin", upxLZMA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			src := readTestdata(t, tt.file)
+			got, err := upxDecompress(tt.method, src, len(want))
+			if err != nil {
+				t.Fatalf("upxDecompress: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("output differs from sample.bin at byte %d", firstDifference(got, want))
+			}
+
+			// The sizes must match the stream exactly
+			if _, err := upxDecompress(tt.method, src, len(want)-1); err == nil {
+				t.Errorf("decoding into %d bytes succeeded", len(want)-1)
+			}
+			if _, err := upxDecompress(tt.method, src, len(want)+1); err == nil {
+				t.Errorf("decoding into %d bytes succeeded", len(want)+1)
+			}
+			if _, err := upxDecompress(tt.method, src[:len(src)/2], len(want)); err == nil {
+				t.Errorf("decoding a truncated stream succeeded")
+			}
+		})
+	}
+}
+
+// TestUnpackUPXPacked unpacks the packed files in testdata/upx, which
+// pack.py writes in the layout of upx -1 and upx --lzma, and checks that
+// the original entry point and section contents come back
+func TestUnpackUPXPacked(t *testing.T) {
+	dir := filepath.Join("testdata", "upx")
+	tests := []struct {
+		original string
+		packed   string
+	}{
+		{"hello", "hello.nrv"},
+		{"hello", "hello.lzma"},
+		{"hello32.exe", "hello32.nrv.exe"},
+		{"hello32.exe", "hello32.lzma.exe"},
+		{"hello64.exe", "hello64.nrv.exe"},
+		{"hello64.exe", "hello64.lzma.exe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.packed, func(t *testing.T) {
+			checkUnpacked(t, filepath.Join(dir, tt.original), filepath.Join(dir, tt.packed))
+		})
+	}
+
+	// upx itself, where it is installed, packs each original with every
+	// method the unpacker decodes
+	for _, original := range []string{"hello", "hello32.exe", "hello64.exe"} {
+		for _, flag := range []string{"--nrv2b", "--nrv2d", "--nrv2e", "--lzma"} {
+			t.Run("upx"+flag+"/"+original, func(t *testing.T) {
+				upx, err := exec.LookPath("upx")
+				if err != nil {
+					t.Skip("upx is not installed")
+				}
+				path := filepath.Join(t.TempDir(), original)
+				if out, err := exec.Command(upx, "-q", flag, "-o", path, filepath.Join(dir, original)).CombinedOutput(); err != nil {
+					t.Fatalf("upx %s: %v\n%s", flag, err, out)
+				}
+				checkUnpacked(t, filepath.Join(dir, original), path)
+			})
+		}
+	}
+}
+
+// checkUnpacked unpacks the file at path and compares it with original
+func checkUnpacked(t *testing.T, original, path string) {
+	t.Helper()
+	want, err := ParseExecutable(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer want.Close()
+
+	packed, err := ParseExecutable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := UnpackUPX(packed)
+	if err != nil {
+		packed.Close()
+		t.Fatalf("UnpackUPX: %v", err)
+	}
+	defer u.Close()
+
+	if u.EntryPoint != want.EntryPoint {
+		t.Errorf("entry point 0x%x, want 0x%x", u.EntryPoint, want.EntryPoint)
+	}
+	for i := range want.Sections {
+		sec := &want.Sections[i]
+		data := sec.Contents()
+		if sec.Address == 0 || len(data) == 0 {
+			continue
+		}
+		got := u.BytesAt(sec.Address, len(data))
+		if !bytes.Equal(got, data) {
+			t.Errorf("section %s differs from the original at byte %d", sec.Name, firstDifference(got, data))
+		}
+	}
+}
+
+func TestUPXUnfilter(t *testing.T) {
+	// A call, a jump and a conditional jump filtered with cto 0x11 into
+	// targets 0x20, 0x40 and 0x10, and a call left alone
+	const filtered = "e8 11 00 00 20  e9 11 00 00 40  0f 84 11 00 00 10  e8 22 00 00 00  90"
+	tests := []struct {
+		name     string
+		id       byte
+		addvalue uint32
+		want     string
+	}{
+		{"none", 0x00, 0, filtered},
+		{"calls", 0x16, 0, "e8 1f 00 00 00  e9 11 00 00 40  0f 84 11 00 00 10  e8 22 00 00 00  90"},
+		{"jumps", 0x26, 0, "e8 1f 00 00 00  e9 3a 00 00 00  0f 84 11 00 00 10  e8 22 00 00 00  90"},
+		{"jccs", 0x49, 0, "e8 1f 00 00 00  e9 3a 00 00 00  0f 84 04 00 00 00  e8 22 00 00 00  90"},
+		{"addvalue", 0x49, 0x10, "e8 0f 00 00 00  e9 2a 00 00 00  0f 84 f4 ff ff ff  e8 22 00 00 00  90"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := unhex(t, filtered)
+			if err := upxUnfilter(buf, tt.id, 0x11, tt.addvalue); err != nil {
+				t.Fatalf("upxUnfilter: %v", err)
+			}
+			if want := unhex(t, tt.want); !bytes.Equal(buf, want) {
+				t.Errorf("got % x, want % x", buf, want)
+			}
+		})
+	}
+
+	if err := upxUnfilter(unhex(t, filtered), 0x50, 0x11, 0); err == nil {
+		t.Errorf("unknown filter accepted")
+	}
+}
+
+func TestUPXBlocksSizes(t *testing.T) {
+	// b_info headers claiming more than their data can hold
+	tests := []struct {
+		name  string
+		info  string
+		limit int
+	}{
+		{"empty block", "00 10 00 00  00 00 00 00  02 00 00 00", 0x1000},
+		{"compressed larger", "10 00 00 00  20 00 00 00  02 00 00 00", 0x1000},
+		{"ratio", "00 00 10 00  10 00 00 00  0e 00 00 00", 1 << 24},
+		{"past limit", "00 20 00 00  10 00 00 00  0e 00 00 00", 0x1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(unhex(t, tt.info), make([]byte, 0x20)...)
+			if _, err := upxBlocks(data, tt.limit); err == nil {
+				t.Errorf("block accepted")
+			}
+		})
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func firstDifference(a, b []byte) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
@@ -0,0 +1,510 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// wasmMagic starts every WebAssembly binary module
+var wasmMagic = []byte("\x00asm")
+
+// WebAssembly section ids
+const (
+	wasmCustom   = 0
+	wasmType     = 1
+	wasmImport   = 2
+	wasmFunction = 3
+	wasmExport   = 7
+	wasmStart    = 8
+	wasmCode     = 10
+)
+
+// wasmSectionNames names the known sections; custom sections carry their
+// own name
+var wasmSectionNames = []string{
+	"custom", "type", "import", "function", "table", "memory", "global",
+	"export", "start", "element", "code", "data", "datacount", "tag",
+}
+
+// WasmModule holds the structure of a WebAssembly module that its
+// sections alone do not give: the signatures and the functions calls
+// refer to by index. Addresses are file offsets, as in other wasm tools.
+type WasmModule struct {
+	Types     []WasmType
+	Functions []WasmFunction // Imported functions first, in function index order
+}
+
+// WasmType is a function signature
+type WasmType struct {
+	Params  []string // Value types: i32, i64, f32, f64, v128, funcref, externref
+	Results []string
+}
+
+// WasmFunction is a function of a WebAssembly module
+type WasmFunction struct {
+	Name    string
+	Type    uint32 // Index in Types
+	Address uint64 // First instruction of the body, or the slot of an import
+	End     uint64 // End of the body, 0 for imports
+	Module  string // Module an imported function comes from, empty for bodies
+}
+
+// IsImport returns true if the function is imported rather than defined
+func (fn *WasmFunction) IsImport() bool {
+	return fn.Module != ""
+}
+
+// Function returns the function, imported or defined, at addr, or nil
+func (m *WasmModule) Function(addr uint64) *WasmFunction {
+	// Imports come first, in slot order, then the bodies in file order
+	imports := sort.Search(len(m.Functions), func(i int) bool { return !m.Functions[i].IsImport() })
+	for _, part := range [][]WasmFunction{m.Functions[:imports], m.Functions[imports:]} {
+		i := sort.Search(len(part), func(i int) bool { return part[i].Address >= addr })
+		if i < len(part) && part[i].Address == addr {
+			return &part[i]
+		}
+	}
+	return nil
+}
+
+// IsWasm returns true if data starts with the WebAssembly magic
+func IsWasm(data []byte) bool {
+	return bytes.HasPrefix(data, wasmMagic)
+}
+
+// wasmExportEntry is an exported function and its index
+type wasmExportEntry struct {
+	name  string
+	index uint32
+}
+
+// wasmReader decodes the LEB128 integers, names and vectors of a module
+type wasmReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *wasmReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (r *wasmReader) byte() byte {
+	if r.err != nil || r.off >= len(r.data) {
+		r.fail("truncated WebAssembly module")
+		return 0
+	}
+	b := r.data[r.off]
+	r.off++
+	return b
+}
+
+func (r *wasmReader) u32() uint32 {
+	var v uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b := r.byte()
+		v |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v
+		}
+	}
+	r.fail("bad LEB128 integer at offset 0x%x", r.off)
+	return 0
+}
+
+func (r *wasmReader) bytes(n uint32) []byte {
+	if r.err != nil || uint64(n) > uint64(len(r.data)-r.off) {
+		r.fail("truncated WebAssembly module")
+		return nil
+	}
+	b := r.data[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b
+}
+
+func (r *wasmReader) name() string {
+	return string(r.bytes(r.u32()))
+}
+
+// count reads the length of a vector whose elements take at least one
+// byte each, rejecting lengths the remaining data cannot hold
+func (r *wasmReader) count() uint32 {
+	n := r.u32()
+	if r.err == nil && uint64(n) > uint64(len(r.data)-r.off) {
+		r.fail("vector of %d elements exceeds its section", n)
+		return 0
+	}
+	return n
+}
+
+// limits skips the limits of a table or memory
+func (r *wasmReader) limits() {
+	flags := r.byte()
+	r.u32()
+	if flags&1 != 0 {
+		r.u32()
+	}
+}
+
+// WasmValueType names a value type
+func WasmValueType(t byte) string {
+	switch t {
+	case 0x7f:
+		return "i32"
+	case 0x7e:
+		return "i64"
+	case 0x7d:
+		return "f32"
+	case 0x7c:
+		return "f64"
+	case 0x7b:
+		return "v128"
+	case 0x70:
+		return "funcref"
+	case 0x6f:
+		return "externref"
+	default:
+		return fmt.Sprintf("type(0x%x)", t)
+	}
+}
+
+func (r *wasmReader) valueTypes() []string {
+	n := r.count()
+	types := make([]string, 0, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		types = append(types, WasmValueType(r.byte()))
+	}
+	return types
+}
+
+// parseWasm parses a WebAssembly module. Its sections are addressed at
+// their file offset; imported functions get slots in an "extern" section
+// past the end of the file, so that calls to them resolve like calls to
+// the undefined symbols of object files. Linear memory is not laid out:
+// data segments stay in the data section.
+func parseWasm(path string, data []byte) (*Binary, error) {
+	if len(data) < 8 || data[4] != 1 || data[5] != 0 || data[6] != 0 || data[7] != 0 {
+		return nil, fmt.Errorf("unsupported WebAssembly version")
+	}
+
+	binary := &Binary{
+		Format:   "WASM",
+		Arch:     "wasm",
+		ptrSize:  4, // wasm32: linear memory addresses are i32
+		Kind:     KindSharedLibrary,
+		RawData:  data,
+		FilePath: path,
+		Wasm:     &WasmModule{},
+	}
+	module := binary.Wasm
+
+	var funcTypes []uint32        // Types of the defined functions
+	var exports []wasmExportEntry // Exported functions
+	var names map[uint32]string
+	var symbols []wasmSymbol // Function symbols of object files
+	start := -1
+	isObject := false
+
+	r := &wasmReader{data: data, off: 8}
+	for r.off < len(data) && r.err == nil {
+		id := r.byte()
+		size := r.u32()
+		contents := r.bytes(size)
+		if r.err != nil {
+			break
+		}
+		offset := uint64(r.off) - uint64(size)
+
+		name := fmt.Sprintf("section%d", id)
+		if int(id) < len(wasmSectionNames) {
+			name = wasmSectionNames[id]
+		}
+		s := &wasmReader{data: contents}
+		if id == wasmCustom {
+			name = s.name()
+		}
+		binary.Sections = append(binary.Sections, Section{
+			Name:    name,
+			Address: offset,
+			Size:    uint64(size),
+			Data:    contents,
+		})
+
+		switch id {
+		case wasmCustom:
+			switch name {
+			case "name":
+				names = wasmFunctionNames(s)
+			case "linking":
+				isObject = true
+				symbols = wasmLinkingSymbols(s)
+			}
+
+		case wasmType:
+			for n := s.count(); n > 0 && s.err == nil; n-- {
+				if form := s.byte(); form != 0x60 {
+					s.fail("unsupported type form 0x%x", form)
+					break
+				}
+				params := s.valueTypes()
+				results := s.valueTypes()
+				module.Types = append(module.Types, WasmType{Params: params, Results: results})
+			}
+
+		case wasmImport:
+			for n := s.count(); n > 0 && s.err == nil; n-- {
+				mod, field := s.name(), s.name()
+				switch kind := s.byte(); kind {
+				case 0: // Function
+					module.Functions = append(module.Functions, WasmFunction{Name: field, Type: s.u32(), Module: mod})
+				case 1: // Table
+					s.byte()
+					s.limits()
+				case 2: // Memory
+					s.limits()
+				case 3: // Global
+					s.byte()
+					s.byte()
+				case 4: // Tag
+					s.byte()
+					s.u32()
+				default:
+					s.fail("unsupported import kind %d", kind)
+				}
+			}
+
+		case wasmFunction:
+			for n := s.count(); n > 0 && s.err == nil; n-- {
+				funcTypes = append(funcTypes, s.u32())
+			}
+
+		case wasmExport:
+			for n := s.count(); n > 0 && s.err == nil; n-- {
+				field := s.name()
+				kind := s.byte()
+				index := s.u32()
+				binary.Exports = append(binary.Exports, field)
+				if kind == 0 {
+					exports = append(exports, wasmExportEntry{field, index})
+				}
+			}
+
+		case wasmStart:
+			start = int(s.u32())
+
+		case wasmCode:
+			n := s.count()
+			if int(n) != len(funcTypes) {
+				s.fail("%d function bodies for %d functions", n, len(funcTypes))
+			}
+			for i := 0; i < int(n) && s.err == nil; i++ {
+				body := s.u32()
+				end := s.off + int(body)
+				for locals := s.count(); locals > 0 && s.err == nil; locals-- {
+					s.u32()
+					s.byte()
+				}
+				if end > len(contents) || s.off > end {
+					s.fail("function body %d exceeds the code section", i)
+					break
+				}
+				module.Functions = append(module.Functions, WasmFunction{
+					Type:    funcTypes[i],
+					Address: offset + uint64(s.off),
+					End:     offset + uint64(end),
+				})
+				s.off = end
+			}
+		}
+		// Broken custom sections are only metadata
+		if s.err != nil && id != wasmCustom {
+			return nil, fmt.Errorf("%s section: %w", name, s.err)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if isObject {
+		binary.Kind = KindObject
+	}
+
+	// Imports get slots past the end of the file
+	slot := (uint64(len(data)) + 15) &^ 15
+	externBase := slot
+	libraries := make(map[string]bool)
+	for i := range module.Functions {
+		fn := &module.Functions[i]
+		if !fn.IsImport() {
+			continue
+		}
+		fn.Address = slot
+		slot++
+		binary.Imports = append(binary.Imports, fn.Name)
+		binary.Relocations = append(binary.Relocations, Relocation{Address: fn.Address, Symbol: fn.Name})
+		if !libraries[fn.Module] {
+			libraries[fn.Module] = true
+			binary.Libraries = append(binary.Libraries, fn.Module)
+		}
+	}
+	if slot > externBase {
+		binary.Sections = append(binary.Sections, Section{
+			Name:    "extern",
+			Address: externBase,
+			Size:    slot - externBase,
+		})
+	}
+
+	// Name the defined functions after the name section or the symbols of
+	// object files, else their export, else their index. The global
+	// definitions of objects are their exports.
+	for _, sym := range symbols {
+		if sym.flags&wasmSymUndefined != 0 || int(sym.index) >= len(module.Functions) {
+			continue
+		}
+		if names == nil {
+			names = make(map[uint32]string)
+		}
+		if names[sym.index] == "" {
+			names[sym.index] = sym.name
+		}
+		if sym.flags&wasmSymLocal == 0 {
+			binary.Exports = append(binary.Exports, sym.name)
+			exports = append(exports, wasmExportEntry{sym.name, sym.index})
+		}
+	}
+	for _, exp := range exports {
+		if names[exp.index] == "" {
+			if names == nil {
+				names = make(map[uint32]string)
+			}
+			names[exp.index] = exp.name
+		}
+	}
+	for i := range module.Functions {
+		fn := &module.Functions[i]
+		if fn.IsImport() {
+			continue
+		}
+		fn.Name = names[uint32(i)]
+		if fn.Name == "" {
+			fn.Name = fmt.Sprintf("func%d", i)
+		}
+		binary.Symbols = append(binary.Symbols, Symbol{
+			Name:    fn.Name,
+			Address: fn.Address,
+			Size:    fn.End - fn.Address,
+			Type:    "FUNC",
+		})
+		binary.FunctionStarts = append(binary.FunctionStarts, fn.Address)
+	}
+	sort.Slice(binary.FunctionStarts, func(i, j int) bool { return binary.FunctionStarts[i] < binary.FunctionStarts[j] })
+
+	for _, exp := range exports {
+		if int(exp.index) < len(module.Functions) {
+			binary.ExportTable = append(binary.ExportTable, Export{
+				Name:    exp.name,
+				Ordinal: exp.index,
+				Address: module.Functions[exp.index].Address,
+			})
+		}
+	}
+
+	// The start function, or the _start export of WASI commands, runs the
+	// module
+	for _, exp := range binary.ExportTable {
+		if exp.Name == "_start" {
+			binary.EntryPoint = exp.Address
+		}
+	}
+	if start >= 0 && start < len(module.Functions) {
+		binary.EntryPoint = module.Functions[start].Address
+	}
+	if binary.EntryPoint != 0 && binary.Kind != KindObject {
+		binary.Kind = KindExecutable
+	}
+
+	return binary, nil
+}
+
+// Symbol flags of the linking section
+const (
+	wasmSymLocal        = 0x02
+	wasmSymUndefined    = 0x10
+	wasmSymExplicitName = 0x40
+)
+
+// wasmSymbol is a function symbol of an object file
+type wasmSymbol struct {
+	name  string
+	flags uint32
+	index uint32 // Function index
+}
+
+// wasmLinkingSymbols reads the function symbols of the symbol table
+// subsection of a linking section
+func wasmLinkingSymbols(r *wasmReader) []wasmSymbol {
+	var symbols []wasmSymbol
+	r.u32() // Version
+	for r.off < len(r.data) && r.err == nil {
+		id := r.byte()
+		sub := &wasmReader{data: r.bytes(r.u32())}
+		if r.err != nil || id != 8 {
+			continue
+		}
+		for n := sub.count(); n > 0 && sub.err == nil; n-- {
+			kind := sub.byte()
+			flags := sub.u32()
+			defined := flags&wasmSymUndefined == 0
+			switch kind {
+			case 0, 2, 4, 5: // Function, global, tag, table
+				index := sub.u32()
+				name := ""
+				if defined || flags&wasmSymExplicitName != 0 {
+					name = sub.name()
+				}
+				if kind == 0 && name != "" {
+					symbols = append(symbols, wasmSymbol{name, flags, index})
+				}
+			case 1: // Data: segment, offset and size when defined
+				sub.name()
+				if defined {
+					sub.u32()
+					sub.u32()
+					sub.u32()
+				}
+			case 3: // Section
+				sub.u32()
+			default:
+				sub.fail("unknown symbol kind %d", kind)
+			}
+		}
+		if sub.err != nil {
+			return nil
+		}
+	}
+	return symbols
+}
+
+// wasmFunctionNames reads the function names subsection of a name section
+func wasmFunctionNames(r *wasmReader) map[uint32]string {
+	for r.off < len(r.data) && r.err == nil {
+		id := r.byte()
+		sub := &wasmReader{data: r.bytes(r.u32())}
+		if r.err != nil || id != 1 {
+			continue
+		}
+		names := make(map[uint32]string)
+		for n := sub.count(); n > 0 && sub.err == nil; n-- {
+			index := sub.u32()
+			names[index] = sub.name()
+		}
+		if sub.err != nil {
+			return nil
+		}
+		return names
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+// wasmSection encodes a section of a module; contents are below 128 bytes
+func wasmSection(id byte, contents ...byte) []byte {
+	return append([]byte{id, byte(len(contents))}, contents...)
+}
+
+// testWasm returns a module importing env.print and defining add, exported,
+// and a start function named by the name section:
+//
+//	(type (func (param i32 i32) (result i32)))
+//	(type (func))
+//	(import "env" "print" (func (type 1)))
+//	(func $add (type 0) local.get 0 local.get 1 i32.add)
+//	(func $init (type 1) (local i32) call 0)
+//	(export "add" (func 1))
+//	(start 2)
+func testWasm() []byte {
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, wasmSection(wasmType, 2, 0x60, 2, 0x7f, 0x7f, 1, 0x7f, 0x60, 0, 0)...)
+	module = append(module, wasmSection(wasmImport, 1, 3, 'e', 'n', 'v', 5, 'p', 'r', 'i', 'n', 't', 0, 1)...)
+	module = append(module, wasmSection(wasmFunction, 2, 0, 1)...)
+	module = append(module, wasmSection(wasmExport, 1, 3, 'a', 'd', 'd', 0, 1)...)
+	module = append(module, wasmSection(wasmStart, 2)...)
+	module = append(module, wasmSection(wasmCode, 2,
+		7, 0, 0x20, 0, 0x20, 1, 0x6a, 0x0b, // add
+		6, 1, 1, 0x7f, 0x10, 0, 0x0b)...) // init
+	return append(module, wasmSection(wasmCustom, 4, 'n', 'a', 'm', 'e', 1, 7, 1, 2, 4, 'i', 'n', 'i', 't')...)
+}
+
+func TestParseWasm(t *testing.T) {
+	data := testWasm()
+	b, err := parseData("module.wasm", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Format != "WASM" || b.Arch != "wasm" || b.Kind != KindExecutable || b.PointerSize() != 4 {
+		t.Errorf("Format, Arch, Kind, PointerSize = %s, %s, %v, %d, want a wasm32 executable",
+			b.Format, b.Arch, b.Kind, b.PointerSize())
+	}
+
+	// Sections are addressed at their file offsets, imports past the end
+	extern := uint64(len(data)+15) &^ 15
+	sections := []imageLayout{{10, 10}, {22, 13}, {37, 3}, {42, 7}, {51, 1}, {54, 16}, {72, 14}, {extern, 1}}
+	names := []string{"type", "import", "function", "export", "start", "code", "name", "extern"}
+	if len(b.Sections) != len(sections) {
+		t.Fatalf("%d sections, want %d", len(b.Sections), len(sections))
+	}
+	for i, sec := range b.Sections {
+		if sec.Name != names[i] || sec.Address != sections[i].addr || sec.Size != sections[i].size {
+			t.Errorf("section %d = %s at %d size %d, want %s at %d size %d", i,
+				sec.Name, sec.Address, sec.Size, names[i], sections[i].addr, sections[i].size)
+		}
+	}
+
+	// Bodies start after their size and locals
+	want := []WasmFunction{
+		{Name: "print", Type: 1, Address: extern, Module: "env"},
+		{Name: "add", Type: 0, Address: 57, End: 63},
+		{Name: "init", Type: 1, Address: 67, End: 70},
+	}
+	if !reflect.DeepEqual(b.Wasm.Functions, want) {
+		t.Errorf("Functions = %+v, want %+v", b.Wasm.Functions, want)
+	}
+	types := []WasmType{{Params: []string{"i32", "i32"}, Results: []string{"i32"}}, {Params: []string{}, Results: []string{}}}
+	if !reflect.DeepEqual(b.Wasm.Types, types) {
+		t.Errorf("Types = %+v, want %+v", b.Wasm.Types, types)
+	}
+	if fn := b.Wasm.Function(67); fn == nil || fn.Name != "init" {
+		t.Errorf("Function(67) = %+v, want init", fn)
+	}
+	if b.EntryPoint != 67 {
+		t.Errorf("EntryPoint = %d, want the start function at 67", b.EntryPoint)
+	}
+	if !reflect.DeepEqual(b.Imports, []string{"print"}) || !reflect.DeepEqual(b.Libraries, []string{"env"}) {
+		t.Errorf("Imports, Libraries = %q, %q, want print from env", b.Imports, b.Libraries)
+	}
+	if exports := []Export{{Name: "add", Ordinal: 1, Address: 57}}; !reflect.DeepEqual(b.ExportTable, exports) {
+		t.Errorf("ExportTable = %+v, want %+v", b.ExportTable, exports)
+	}
+}
+
+func TestParseWasmErrors(t *testing.T) {
+	data := testWasm()
+	tests := map[string][]byte{
+		"version":   append([]byte("\x00asm\x02\x00\x00\x00"), data[8:]...),
+		"truncated": data[:60],
+		"bodies":    append(data[:35:35], append(wasmSection(wasmFunction, 3, 0, 1, 1), data[40:]...)...),
+		"type form": append(data[:11:11], append([]byte{0x5f}, data[12:]...)...),
+	}
+	for name, module := range tests {
+		if _, err := parseData("bad.wasm", module); err == nil {
+			t.Errorf("%s: parseData() succeeded", name)
+		}
+	}
+
+	// A broken name section is only metadata
+	broken := append([]byte(nil), data...)
+	broken[len(broken)-8] = 0x7f // Function names larger than their section
+	b, err := parseData("names.wasm", broken)
+	if err != nil {
+		t.Fatalf("broken name section: %v", err)
+	}
+	if name := b.Wasm.Functions[2].Name; name != "func2" {
+		t.Errorf("broken name section: function 2 named %q, want func2", name)
+	}
+}
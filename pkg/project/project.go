@@ -0,0 +1,488 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"expeer/pkg/cdecl"
+)
+
+// FormatVersion is the version of the project file format
+const FormatVersion = 1
+
+// Suffix is appended to a binary's path to name its default project file
+const Suffix = ".expeer.json"
+
+// Address is a virtual address, stored in the project file as a "0x" hex
+// string
+type Address uint64
+
+// MarshalText formats the address as hex
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("0x%x", uint64(a))), nil
+}
+
+// UnmarshalText parses a hex or decimal address
+func (a *Address) UnmarshalText(text []byte) error {
+	v, err := ParseAddress(string(text))
+	*a = Address(v)
+	return err
+}
+
+// ParseAddress parses "0x401000", "sub_401000" or a decimal number
+func ParseAddress(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		return strconv.ParseUint(s[2:], 16, 64)
+	case strings.HasPrefix(s, "sub_"):
+		return strconv.ParseUint(s[4:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// Project holds the user's annotations of one binary. Every analysis run
+// on the binary applies them; they take precedence over anything expeer
+// infers.
+type Project struct {
+	Version   int                   `json:"version"`
+	Functions map[Address]*Function `json:"functions,omitempty"`
+	Comments  map[Address]string    `json:"comments,omitempty"`
+	Structs   []*Struct             `json:"structs,omitempty"`
+	Regions   []Region              `json:"regions,omitempty"`
+
+	path string
+}
+
+// Function holds the annotations of the function starting at an address.
+// Annotating an address declares a function there even if the heuristics
+// did not find one.
+type Function struct {
+	Name      string               `json:"name,omitempty"`
+	Prototype string               `json:"prototype,omitempty"` // C declaration
+	Variables map[string]*Variable `json:"variables,omitempty"` // By decompiler name (var0, ...)
+
+	proto *cdecl.Prototype
+}
+
+// Variable renames or retypes a decompiler variable
+type Variable struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+// Struct is a user-defined structure; field offsets are computed when it
+// is defined
+type Struct struct {
+	Name   string  `json:"name"`
+	Size   int     `json:"size"`
+	Align  int     `json:"align"`
+	Fields []Field `json:"fields"`
+}
+
+// Field is one member of a Struct
+type Field struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+}
+
+// Region kinds
+const (
+	RegionCode = "code"
+	RegionData = "data"
+)
+
+// Region marks the address range [Start, End) as code or data
+type Region struct {
+	Start Address `json:"start"`
+	End   Address `json:"end"`
+	Kind  string  `json:"kind"`
+}
+
+// DefaultPath returns the project file used for a binary
+func DefaultPath(binaryPath string) string {
+	return binaryPath + Suffix
+}
+
+// New returns an empty project that will be saved at path
+func New(path string) *Project {
+	return &Project{
+		Version:   FormatVersion,
+		Functions: make(map[Address]*Function),
+		Comments:  make(map[Address]string),
+		path:      path,
+	}
+}
+
+// Load reads a project file. A missing file yields an empty project.
+func Load(path string) (*Project, error) {
+	p := New(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid project file %s: %w", path, err)
+	}
+	if p.Version > FormatVersion {
+		return nil, fmt.Errorf("project file %s has version %d, newer than supported %d",
+			path, p.Version, FormatVersion)
+	}
+	if p.Functions == nil {
+		p.Functions = make(map[Address]*Function)
+	}
+	if p.Comments == nil {
+		p.Comments = make(map[Address]string)
+	}
+	sort.Slice(p.Regions, func(i, j int) bool { return p.Regions[i].Start < p.Regions[j].Start })
+
+	for addr, fn := range p.Functions {
+		if fn.Prototype == "" {
+			continue
+		}
+		if fn.proto, err = cdecl.ParsePrototype(fn.Prototype); err != nil {
+			return nil, fmt.Errorf("invalid prototype of 0x%x in %s: %w", uint64(addr), path, err)
+		}
+	}
+	return p, nil
+}
+
+// Path returns the file the project is saved to
+func (p *Project) Path() string {
+	return p.path
+}
+
+// Empty returns true if the project has no annotations
+func (p *Project) Empty() bool {
+	return len(p.Functions) == 0 && len(p.Comments) == 0 && len(p.Structs) == 0 && len(p.Regions) == 0
+}
+
+// JSON renders the project as indented JSON
+func (p *Project) JSON() ([]byte, error) {
+	p.Version = FormatVersion
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes the project file, replacing it atomically
+func (p *Project) Save() error {
+	data, err := p.JSON()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
+
+// Digest identifies the annotations, so that analyses cached before they
+// changed are discarded. Empty and nil projects have the empty digest.
+func (p *Project) Digest() string {
+	if p == nil || p.Empty() {
+		return ""
+	}
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// function returns the annotations of the function at addr, creating them
+func (p *Project) function(addr uint64) *Function {
+	fn := p.Functions[Address(addr)]
+	if fn == nil {
+		fn = &Function{}
+		p.Functions[Address(addr)] = fn
+	}
+	return fn
+}
+
+// Rename sets the name of the function at addr
+func (p *Project) Rename(addr uint64, name string) {
+	p.function(addr).Name = name
+}
+
+// SetPrototype declares the C prototype of the function at addr. The
+// function takes the prototype's name unless it was renamed.
+func (p *Project) SetPrototype(addr uint64, decl string) error {
+	proto, err := cdecl.ParsePrototype(decl)
+	if err != nil {
+		return err
+	}
+	fn := p.function(addr)
+	fn.Prototype = proto.String()
+	fn.proto = proto
+	if fn.Name == "" {
+		fn.Name = proto.Name
+	}
+	return nil
+}
+
+// variable returns the annotations of a variable of the function at addr.
+// name may be the decompiler's name or a name the user gave it.
+func (p *Project) variable(addr uint64, name string) *Variable {
+	fn := p.function(addr)
+	if fn.Variables == nil {
+		fn.Variables = make(map[string]*Variable)
+	}
+	if v := fn.Variables[name]; v != nil {
+		return v
+	}
+	for _, v := range fn.Variables {
+		if v.Name == name {
+			return v
+		}
+	}
+	v := &Variable{}
+	fn.Variables[name] = v
+	return v
+}
+
+// RenameVariable renames a variable of the function at addr
+func (p *Project) RenameVariable(addr uint64, variable, name string) {
+	p.variable(addr, variable).Name = name
+}
+
+// RetypeVariable sets the C type of a variable of the function at addr
+func (p *Project) RetypeVariable(addr uint64, variable, typ string) {
+	p.variable(addr, variable).Type = cdecl.NormalizeType(typ)
+}
+
+// SetComment attaches a comment to an address; an empty text removes it
+func (p *Project) SetComment(addr uint64, text string) {
+	if text == "" {
+		delete(p.Comments, Address(addr))
+		return
+	}
+	p.Comments[Address(addr)] = text
+}
+
+// DefineStruct defines or replaces a structure from field declarations
+// such as "char name[16]", laying it out with natural alignment
+func (p *Project) DefineStruct(name string, fields []string, ptrSize int) (*Struct, error) {
+	s := &Struct{Name: name, Align: 1}
+	for _, decl := range fields {
+		field, err := cdecl.ParseDeclaration(decl)
+		if err != nil {
+			return nil, err
+		}
+		if field.Name == "" {
+			return nil, fmt.Errorf("field %q has no name", decl)
+		}
+		size, align, ok := cdecl.Sizeof(field.Type, ptrSize, p.structLayout)
+		if !ok {
+			return nil, fmt.Errorf("unknown size of type %q", field.Type)
+		}
+		s.Size = alignUp(s.Size, align)
+		s.Fields = append(s.Fields, Field{Name: field.Name, Type: field.Type, Offset: s.Size})
+		s.Size += size
+		if align > s.Align {
+			s.Align = align
+		}
+	}
+	s.Size = alignUp(s.Size, s.Align)
+
+	for i, old := range p.Structs {
+		if old.Name == name {
+			p.Structs[i] = s
+			return s, nil
+		}
+	}
+	p.Structs = append(p.Structs, s)
+	return s, nil
+}
+
+func (p *Project) structLayout(name string) (int, int, bool) {
+	if s := p.Struct(name); s != nil {
+		return s.Size, s.Align, true
+	}
+	return 0, 0, false
+}
+
+func alignUp(n, align int) int {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
+
+// MarkRegion marks [start, end) as code or data, replacing the parts of
+// other regions it overlaps
+func (p *Project) MarkRegion(start, end uint64, kind string) error {
+	if kind != RegionCode && kind != RegionData {
+		return fmt.Errorf("unknown region kind %q", kind)
+	}
+	if end <= start {
+		return fmt.Errorf("empty region 0x%x-0x%x", start, end)
+	}
+
+	var regions []Region
+	for _, r := range p.Regions {
+		if uint64(r.End) <= start || uint64(r.Start) >= end {
+			regions = append(regions, r)
+			continue
+		}
+		if uint64(r.Start) < start {
+			regions = append(regions, Region{Start: r.Start, End: Address(start), Kind: r.Kind})
+		}
+		if uint64(r.End) > end {
+			regions = append(regions, Region{Start: Address(end), End: r.End, Kind: r.Kind})
+		}
+	}
+	regions = append(regions, Region{Start: Address(start), End: Address(end), Kind: kind})
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+	p.Regions = regions
+	return nil
+}
+
+// Unmark removes the region containing addr. Returns false if there is none.
+func (p *Project) Unmark(addr uint64) bool {
+	for i, r := range p.Regions {
+		if addr >= uint64(r.Start) && addr < uint64(r.End) {
+			p.Regions = append(p.Regions[:i], p.Regions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// FunctionByName returns the address of the function the user named name
+func (p *Project) FunctionByName(name string) (uint64, bool) {
+	for addr, fn := range p.Functions {
+		if fn.Name == name {
+			return uint64(addr), true
+		}
+	}
+	return 0, false
+}
+
+// FunctionName returns the user's name of the function at addr. The
+// second result is true if the user declared a function at addr at all
+// (by annotating it, or as the start of a code region), even if unnamed.
+func (p *Project) FunctionName(addr uint64) (string, bool) {
+	if fn := p.Functions[Address(addr)]; fn != nil {
+		return fn.Name, true
+	}
+	for _, r := range p.Regions {
+		if r.Kind == RegionCode && uint64(r.Start) == addr {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// IsCode returns true if addr lies in a region marked as code
+func (p *Project) IsCode(addr uint64) bool {
+	return p.regionKind(addr) == RegionCode
+}
+
+// IsData returns true if addr lies in a region marked as data
+func (p *Project) IsData(addr uint64) bool {
+	return p.regionKind(addr) == RegionData
+}
+
+func (p *Project) regionKind(addr uint64) string {
+	i := sort.Search(len(p.Regions), func(i int) bool { return uint64(p.Regions[i].End) > addr })
+	if i < len(p.Regions) && addr >= uint64(p.Regions[i].Start) {
+		return p.Regions[i].Kind
+	}
+	return ""
+}
+
+// Prototype returns the declared prototype of the function at addr
+func (p *Project) Prototype(addr uint64) (*cdecl.Prototype, bool) {
+	if fn := p.Functions[Address(addr)]; fn != nil && fn.proto != nil {
+		return fn.proto, true
+	}
+	return nil, false
+}
+
+// VariableName returns the user's name for a decompiler variable
+func (p *Project) VariableName(fn uint64, name string) (string, bool) {
+	if f := p.Functions[Address(fn)]; f != nil {
+		if v := f.Variables[name]; v != nil && v.Name != "" {
+			return v.Name, true
+		}
+	}
+	return "", false
+}
+
+// VariableType returns the user's type for a variable, looked up by its
+// decompiler name or by the name the user gave it
+func (p *Project) VariableType(fn uint64, name string) (string, bool) {
+	f := p.Functions[Address(fn)]
+	if f == nil {
+		return "", false
+	}
+	if v := f.Variables[name]; v != nil && v.Type != "" {
+		return v.Type, true
+	}
+	for _, v := range f.Variables {
+		if v.Name == name && v.Type != "" {
+			return v.Type, true
+		}
+	}
+	return "", false
+}
+
+// Comment returns the user's comment at addr
+func (p *Project) Comment(addr uint64) (string, bool) {
+	text, ok := p.Comments[Address(addr)]
+	return text, ok
+}
+
+// Field names the member at offset of the user-defined structure that
+// ptrType points to
+func (p *Project) Field(ptrType string, offset int) (string, bool) {
+	name, ok := strings.CutSuffix(cdecl.NormalizeType(ptrType), "*")
+	if !ok {
+		return "", false
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "const "), "struct ")
+	s := p.Struct(strings.TrimSpace(name))
+	if s == nil {
+		return "", false
+	}
+	for _, f := range s.Fields {
+		if f.Offset == offset {
+			return f.Name, true
+		}
+	}
+	return "", false
+}
+
+// Struct returns the user-defined structure with the given name
+func (p *Project) Struct(name string) *Struct {
+	for _, s := range p.Structs {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// ISO C standard library. One declaration per statement; declarations may
+// span lines and end with ';'.
+
+// stdio.h
+int printf(const char *format, ...);
+int fprintf(FILE *stream, const char *format, ...);
+int sprintf(char *str, const char *format, ...);
+int snprintf(char *str, size_t size, const char *format, ...);
+int vprintf(const char *format, va_list ap);
+int vfprintf(FILE *stream, const char *format, va_list ap);
+int vsprintf(char *str, const char *format, va_list ap);
+int vsnprintf(char *str, size_t size, const char *format, va_list ap);
+int scanf(const char *format, ...);
+int fscanf(FILE *stream, const char *format, ...);
+int sscanf(const char *str, const char *format, ...);
+int puts(const char *s);
+int fputs(const char *s, FILE *stream);
+int putchar(int c);
+int fputc(int c, FILE *stream);
+int putc(int c, FILE *stream);
+int getchar(void);
+int fgetc(FILE *stream);
+int getc(FILE *stream);
+int ungetc(int c, FILE *stream);
+char *fgets(char *s, int size, FILE *stream);
+char *gets(char *s);
+FILE *fopen(const char *pathname, const char *mode);
+FILE *freopen(const char *pathname, const char *mode, FILE *stream);
+FILE *fdopen(int fd, const char *mode);
+int fclose(FILE *stream);
+int fflush(FILE *stream);
+size_t fread(void *ptr, size_t size, size_t nmemb, FILE *stream);
+size_t fwrite(const void *ptr, size_t size, size_t nmemb, FILE *stream);
+int fseek(FILE *stream, long offset, int whence);
+long ftell(FILE *stream);
+void rewind(FILE *stream);
+int feof(FILE *stream);
+int ferror(FILE *stream);
+void clearerr(FILE *stream);
+int fileno(FILE *stream);
+void setbuf(FILE *stream, char *buf);
+int setvbuf(FILE *stream, char *buf, int mode, size_t size);
+void perror(const char *s);
+int remove(const char *pathname);
+int rename(const char *oldpath, const char *newpath);
+FILE *tmpfile(void);
+char *tmpnam(char *s);
+
+// stdlib.h
+void *malloc(size_t size);
+void *calloc(size_t nmemb, size_t size);
+void *realloc(void *ptr, size_t size);
+void free(void *ptr);
+void *aligned_alloc(size_t alignment, size_t size);
+void exit(int status);
+void _exit(int status);
+void _Exit(int status);
+void abort(void);
+int atexit(void (*function)(void));
+int system(const char *command);
+char *getenv(const char *name);
+int atoi(const char *nptr);
+long atol(const char *nptr);
+long long atoll(const char *nptr);
+double atof(const char *nptr);
+long strtol(const char *nptr, char **endptr, int base);
+unsigned long strtoul(const char *nptr, char **endptr, int base);
+long long strtoll(const char *nptr, char **endptr, int base);
+unsigned long long strtoull(const char *nptr, char **endptr, int base);
+double strtod(const char *nptr, char **endptr);
+float strtof(const char *nptr, char **endptr);
+int rand(void);
+void srand(unsigned int seed);
+void qsort(void *base, size_t nmemb, size_t size, int (*compar)(const void *, const void *));
+void *bsearch(const void *key, const void *base, size_t nmemb, size_t size, int (*compar)(const void *, const void *));
+int abs(int j);
+long labs(long j);
+
+// string.h
+void *memcpy(void *dest, const void *src, size_t n);
+void *memmove(void *dest, const void *src, size_t n);
+void *memset(void *s, int c, size_t n);
+int memcmp(const void *s1, const void *s2, size_t n);
+void *memchr(const void *s, int c, size_t n);
+size_t strlen(const char *s);
+char *strcpy(char *dest, const char *src);
+char *strncpy(char *dest, const char *src, size_t n);
+char *strcat(char *dest, const char *src);
+char *strncat(char *dest, const char *src, size_t n);
+int strcmp(const char *s1, const char *s2);
+int strncmp(const char *s1, const char *s2, size_t n);
+int strcoll(const char *s1, const char *s2);
+char *strchr(const char *s, int c);
+char *strrchr(const char *s, int c);
+char *strstr(const char *haystack, const char *needle);
+char *strtok(char *str, const char *delim);
+size_t strspn(const char *s, const char *accept);
+size_t strcspn(const char *s, const char *reject);
+char *strpbrk(const char *s, const char *accept);
+char *strerror(int errnum);
+size_t strxfrm(char *dest, const char *src, size_t n);
+
+// ctype.h
+int isalnum(int c);
+int isalpha(int c);
+int isdigit(int c);
+int isxdigit(int c);
+int isspace(int c);
+int isupper(int c);
+int islower(int c);
+int isprint(int c);
+int ispunct(int c);
+int toupper(int c);
+int tolower(int c);
+
+// math.h
+double sqrt(double x);
+double pow(double x, double y);
+double exp(double x);
+double log(double x);
+double log10(double x);
+double sin(double x);
+double cos(double x);
+double tan(double x);
+double atan2(double y, double x);
+double floor(double x);
+double ceil(double x);
+double fabs(double x);
+double fmod(double x, double y);
+
+// time.h
+time_t time(time_t *tloc);
+clock_t clock(void);
+double difftime(time_t time1, time_t time0);
+struct tm *localtime(const time_t *timep);
+struct tm *gmtime(const time_t *timep);
+time_t mktime(struct tm *tm);
+size_t strftime(char *s, size_t max, const char *format, const struct tm *tm);
+char *ctime(const time_t *timep);
+
+// setjmp.h, signal.h, locale.h
+int setjmp(jmp_buf env);
+void longjmp(jmp_buf env, int val);
+int raise(int sig);
+char *setlocale(int category, const char *locale);
+
+// wchar.h
+size_t wcslen(const wchar_t *s);
+wchar_t *wcscpy(wchar_t *dest, const wchar_t *src);
+int wcscmp(const wchar_t *s1, const wchar_t *s2);
+int wprintf(const wchar_t *format, ...);
+size_t mbstowcs(wchar_t *dest, const char *src, size_t n);
+size_t wcstombs(char *dest, const wchar_t *src, size_t n);
+
+// glibc fortified and versioned entry points
+int __printf_chk(int flag, const char *format, ...);
+int __fprintf_chk(FILE *stream, int flag, const char *format, ...);
+int __sprintf_chk(char *str, int flag, size_t strlen, const char *format, ...);
+int __snprintf_chk(char *str, size_t maxlen, int flag, size_t strlen, const char *format, ...);
+void *__memcpy_chk(void *dest, const void *src, size_t len, size_t destlen);
+void *__memset_chk(void *dest, int c, size_t len, size_t destlen);
+char *__strcpy_chk(char *dest, const char *src, size_t destlen);
+int __isoc99_scanf(const char *format, ...);
+int __isoc99_sscanf(const char *str, const char *format, ...);
+int __isoc99_fscanf(FILE *stream, const char *format, ...);
+void __stack_chk_fail(void);
+int __libc_start_main(int (*main)(int, char **, char **), int argc, char **argv, void (*init)(void), void (*fini)(void), void (*rtld_fini)(void), void *stack_end);
+int __cxa_atexit(void (*func)(void *), void *arg, void *dso_handle);
+void __cxa_finalize(void *d);
+int *__errno_location(void);
@@ -0,0 +1,140 @@
+// POSIX and common Unix extensions
+
+// unistd.h
+ssize_t read(int fd, void *buf, size_t count);
+ssize_t write(int fd, const void *buf, size_t count);
+ssize_t pread(int fd, void *buf, size_t count, off_t offset);
+ssize_t pwrite(int fd, const void *buf, size_t count, off_t offset);
+int close(int fd);
+off_t lseek(int fd, off_t offset, int whence);
+int dup(int oldfd);
+int dup2(int oldfd, int newfd);
+int pipe(int pipefd[2]);
+pid_t fork(void);
+pid_t vfork(void);
+int execve(const char *pathname, char *const argv[], char *const envp[]);
+int execv(const char *pathname, char *const argv[]);
+int execvp(const char *file, char *const argv[]);
+int execl(const char *pathname, const char *arg, ...);
+int execlp(const char *file, const char *arg, ...);
+pid_t getpid(void);
+pid_t getppid(void);
+uid_t getuid(void);
+uid_t geteuid(void);
+gid_t getgid(void);
+int setuid(uid_t uid);
+int setgid(gid_t gid);
+int chdir(const char *path);
+char *getcwd(char *buf, size_t size);
+int access(const char *pathname, int mode);
+int unlink(const char *pathname);
+int rmdir(const char *pathname);
+int link(const char *oldpath, const char *newpath);
+int symlink(const char *target, const char *linkpath);
+ssize_t readlink(const char *pathname, char *buf, size_t bufsiz);
+int chown(const char *pathname, uid_t owner, gid_t group);
+unsigned int sleep(unsigned int seconds);
+int usleep(useconds_t usec);
+unsigned int alarm(unsigned int seconds);
+int isatty(int fd);
+long sysconf(int name);
+int gethostname(char *name, size_t len);
+int getopt(int argc, char *const argv[], const char *optstring);
+long syscall(long number, ...);
+
+// fcntl.h, sys/stat.h, sys/mman.h
+int open(const char *pathname, int flags, ...);
+int openat(int dirfd, const char *pathname, int flags, ...);
+int creat(const char *pathname, mode_t mode);
+int fcntl(int fd, int cmd, ...);
+int ioctl(int fd, unsigned long request, ...);
+int stat(const char *pathname, struct stat *statbuf);
+int fstat(int fd, struct stat *statbuf);
+int lstat(const char *pathname, struct stat *statbuf);
+int chmod(const char *pathname, mode_t mode);
+int mkdir(const char *pathname, mode_t mode);
+mode_t umask(mode_t mask);
+void *mmap(void *addr, size_t length, int prot, int flags, int fd, off_t offset);
+int munmap(void *addr, size_t length);
+int mprotect(void *addr, size_t len, int prot);
+
+// dirent.h
+DIR *opendir(const char *name);
+struct dirent *readdir(DIR *dirp);
+int closedir(DIR *dirp);
+
+// sys/wait.h, signal.h
+pid_t wait(int *wstatus);
+pid_t waitpid(pid_t pid, int *wstatus, int options);
+int kill(pid_t pid, int sig);
+sighandler_t signal(int signum, sighandler_t handler);
+int sigaction(int signum, const struct sigaction *act, struct sigaction *oldact);
+int sigemptyset(sigset_t *set);
+int sigaddset(sigset_t *set, int signum);
+
+// sys/socket.h, netdb.h, arpa/inet.h
+int socket(int domain, int type, int protocol);
+int bind(int sockfd, const struct sockaddr *addr, socklen_t addrlen);
+int listen(int sockfd, int backlog);
+int accept(int sockfd, struct sockaddr *addr, socklen_t *addrlen);
+int connect(int sockfd, const struct sockaddr *addr, socklen_t addrlen);
+ssize_t send(int sockfd, const void *buf, size_t len, int flags);
+ssize_t recv(int sockfd, void *buf, size_t len, int flags);
+ssize_t sendto(int sockfd, const void *buf, size_t len, int flags, const struct sockaddr *dest_addr, socklen_t addrlen);
+ssize_t recvfrom(int sockfd, void *buf, size_t len, int flags, struct sockaddr *src_addr, socklen_t *addrlen);
+int shutdown(int sockfd, int how);
+int setsockopt(int sockfd, int level, int optname, const void *optval, socklen_t optlen);
+int getsockopt(int sockfd, int level, int optname, void *optval, socklen_t *optlen);
+int getaddrinfo(const char *node, const char *service, const struct addrinfo *hints, struct addrinfo **res);
+void freeaddrinfo(struct addrinfo *res);
+struct hostent *gethostbyname(const char *name);
+uint16_t htons(uint16_t hostshort);
+uint32_t htonl(uint32_t hostlong);
+uint16_t ntohs(uint16_t netshort);
+uint32_t ntohl(uint32_t netlong);
+in_addr_t inet_addr(const char *cp);
+int inet_pton(int af, const char *src, void *dst);
+const char *inet_ntop(int af, const void *src, char *dst, socklen_t size);
+int select(int nfds, fd_set *readfds, fd_set *writefds, fd_set *exceptfds, struct timeval *timeout);
+int poll(struct pollfd *fds, nfds_t nfds, int timeout);
+
+// pthread.h
+int pthread_create(pthread_t *thread, const pthread_attr_t *attr, void *(*start_routine)(void *), void *arg);
+int pthread_join(pthread_t thread, void **retval);
+int pthread_detach(pthread_t thread);
+pthread_t pthread_self(void);
+void pthread_exit(void *retval);
+int pthread_mutex_init(pthread_mutex_t *mutex, const pthread_mutexattr_t *attr);
+int pthread_mutex_lock(pthread_mutex_t *mutex);
+int pthread_mutex_unlock(pthread_mutex_t *mutex);
+int pthread_mutex_destroy(pthread_mutex_t *mutex);
+int pthread_cond_wait(pthread_cond_t *cond, pthread_mutex_t *mutex);
+int pthread_cond_signal(pthread_cond_t *cond);
+int pthread_cond_broadcast(pthread_cond_t *cond);
+int pthread_once(pthread_once_t *once_control, void (*init_routine)(void));
+
+// dlfcn.h
+void *dlopen(const char *filename, int flags);
+void *dlsym(void *handle, const char *symbol);
+int dlclose(void *handle);
+char *dlerror(void);
+
+// string extensions
+char *strdup(const char *s);
+char *strndup(const char *s, size_t n);
+int strcasecmp(const char *s1, const char *s2);
+int strncasecmp(const char *s1, const char *s2, size_t n);
+size_t strnlen(const char *s, size_t maxlen);
+char *strtok_r(char *str, const char *delim, char **saveptr);
+char *strsep(char **stringp, const char *delim);
+int asprintf(char **strp, const char *fmt, ...);
+int dprintf(int fd, const char *format, ...);
+ssize_t getline(char **lineptr, size_t *n, FILE *stream);
+FILE *popen(const char *command, const char *type);
+int pclose(FILE *stream);
+int setenv(const char *name, const char *value, int overwrite);
+int unsetenv(const char *name);
+int posix_memalign(void **memptr, size_t alignment, size_t size);
+int gettimeofday(struct timeval *tv, struct timezone *tz);
+int clock_gettime(clockid_t clockid, struct timespec *tp);
+int nanosleep(const struct timespec *req, struct timespec *rem);
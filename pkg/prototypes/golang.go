@@ -0,0 +1,141 @@
+package prototypes
+
+import (
+	"fmt"
+	"strings"
+
+	"expeer/pkg/cdecl"
+)
+
+// parseGoSignature parses a Go function signature named by its symbol,
+// such as "strings.Index(s string, substr string) int". Parameter and
+// result types are kept in Go syntax; a variadic parameter becomes a
+// slice, which is how it is passed.
+func parseGoSignature(s string) (*cdecl.Prototype, error) {
+	s = strings.TrimSpace(s)
+	open := paramsStart(s)
+	if open <= 0 {
+		return nil, fmt.Errorf("not a Go function signature: %q", s)
+	}
+	end := matchingParen(s, open)
+	if end < 0 {
+		return nil, fmt.Errorf("unbalanced parentheses: %q", s)
+	}
+
+	p := &cdecl.Prototype{
+		Name:     s[:open],
+		Return:   strings.TrimSpace(s[end+1:]),
+		CallConv: cdecl.CallConvGo,
+	}
+	if p.Return == "" {
+		p.Return = "void"
+	}
+
+	params, err := parseGoParams(s[open+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	p.Params = params
+	return p, nil
+}
+
+// paramsStart returns the index of the parenthesis opening the parameter
+// list. Parentheses right after a '.' belong to a method's receiver type,
+// as in "bytes.(*Buffer).Write".
+func paramsStart(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '(' {
+			continue
+		}
+		if i > 0 && s[i-1] == '.' {
+			if end := matchingParen(s, i); end > 0 {
+				i = end
+				continue
+			}
+			return -1
+		}
+		return i
+	}
+	return -1
+}
+
+// matchingParen returns the index of the parenthesis closing the one at
+// open, or -1
+func matchingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseGoParams parses a Go parameter list. Names sharing a type, as in
+// "a, b string", each get the type.
+func parseGoParams(s string) ([]cdecl.Param, error) {
+	var params []cdecl.Param
+	untyped := 0 // Trailing names still waiting for their type
+
+	for _, part := range splitGoList(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, typ, found := strings.Cut(part, " ")
+		if !found {
+			// A lone word is a name whose type follows, or an unnamed type
+			params = append(params, cdecl.Param{Name: part})
+			untyped++
+			continue
+		}
+		typ = strings.TrimSpace(typ)
+		if strings.HasPrefix(typ, "...") {
+			typ = "[]" + typ[3:]
+		}
+		for i := len(params) - untyped; i < len(params); i++ {
+			params[i].Type = typ
+		}
+		untyped = 0
+		params = append(params, cdecl.Param{Name: name, Type: typ})
+	}
+
+	// Without any name, every entry was a type
+	if untyped == len(params) {
+		for i := range params {
+			params[i].Type = params[i].Name
+			params[i].Name = ""
+		}
+	} else if untyped > 0 {
+		return nil, fmt.Errorf("parameter %q has no type", params[len(params)-1].Name)
+	}
+
+	return params, nil
+}
+
+// splitGoList splits a Go parameter list at commas outside brackets and
+// parentheses
+func splitGoList(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
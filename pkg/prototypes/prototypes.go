@@ -0,0 +1,182 @@
+package prototypes
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"expeer/pkg/cdecl"
+)
+
+// bundled holds the prototypes shipped with expeer: the C library, POSIX,
+// the Win32 API and the Go standard library
+//
+//go:embed data/*
+var bundled embed.FS
+
+// Database maps symbol names to function prototypes
+//
+// The text format has one declaration per statement. C declarations end
+// with ';' and may span lines. Go functions start with "func" and are
+// named by their symbol, so methods include the receiver type and take
+// the receiver as the first parameter:
+//
+//	size_t strlen(const char *s);
+//	func strings.Index(s string, substr string) int
+//	func bytes.(*Buffer).WriteString(b *bytes.Buffer, s string) (n int, err error)
+//
+// Lines starting with "//" or "#" are comments.
+type Database struct {
+	protos map[string]*cdecl.Prototype
+}
+
+var (
+	defaultOnce sync.Once
+	defaultDB   *Database
+)
+
+// NewDatabase creates an empty prototype database
+func NewDatabase() *Database {
+	return &Database{protos: make(map[string]*cdecl.Prototype)}
+}
+
+// Bundled returns a new database holding the prototypes shipped with
+// expeer
+func Bundled() *Database {
+	db := NewDatabase()
+	entries, err := bundled.ReadDir("data")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		f, err := bundled.Open("data/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		err = db.Read(f)
+		f.Close()
+		if err != nil {
+			// The bundled files are part of the build
+			panic(fmt.Sprintf("prototypes: %s: %v", e.Name(), err))
+		}
+	}
+	return db
+}
+
+// Default returns the bundled database, parsed once and shared. It must
+// not be modified.
+func Default() *Database {
+	defaultOnce.Do(func() {
+		defaultDB = Bundled()
+	})
+	return defaultDB
+}
+
+// Load returns the bundled database extended with prototype files. Later
+// files override earlier ones and the bundled prototypes.
+func Load(paths ...string) (*Database, error) {
+	db := Bundled()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open prototype file: %w", err)
+		}
+		err = db.Read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return db, nil
+}
+
+// Add adds a prototype under its name, replacing any previous one
+func (db *Database) Add(p *cdecl.Prototype) {
+	db.protos[p.Name] = p
+}
+
+// Len returns the number of prototypes in the database
+func (db *Database) Len() int {
+	return len(db.protos)
+}
+
+// Read parses prototypes from r and adds them to the database
+func (db *Database) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum, start := 0, 0
+	var decl strings.Builder
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if decl.Len() == 0 && strings.HasPrefix(line, "func ") {
+			p, err := parseGoSignature(strings.TrimPrefix(line, "func "))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			db.Add(p)
+			continue
+		}
+
+		if decl.Len() == 0 {
+			start = lineNum
+		} else {
+			decl.WriteString(" ")
+		}
+		decl.WriteString(line)
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+
+		p, err := cdecl.ParsePrototype(decl.String())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", start, err)
+		}
+		db.Add(p)
+		decl.Reset()
+	}
+	if decl.Len() > 0 {
+		return fmt.Errorf("line %d: unterminated declaration", start)
+	}
+
+	return scanner.Err()
+}
+
+// Lookup returns the prototype of a symbol. Decorations added by linkers
+// and compilers are ignored: ELF symbol versions (printf@GLIBC_2.2.5),
+// import prefixes (__imp_CreateFileW), stdcall suffixes (_Sleep@4) and
+// the leading underscore of Mach-O and 32-bit Windows symbols.
+func (db *Database) Lookup(symbol string) (*cdecl.Prototype, bool) {
+	if p, ok := db.protos[symbol]; ok {
+		return p, true
+	}
+
+	name := symbol
+	for _, prefix := range []string{"__imp__", "__imp_"} {
+		if strings.HasPrefix(name, prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+	if i := strings.Index(name, "@"); i > 0 {
+		name = name[:i]
+	}
+	if p, ok := db.protos[name]; ok {
+		return p, true
+	}
+	if strings.HasPrefix(name, "_") {
+		if p, ok := db.protos[name[1:]]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,369 @@
+// Package report serializes a complete analysis as JSON for consumption by
+// other tools. The layout is described by the JSON Schema returned by Schema
+// and versioned by SchemaVersion.
+package report
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"expeer/pkg/analyzer"
+	"expeer/pkg/cfg"
+	"expeer/pkg/disasm"
+	"expeer/pkg/parallel"
+)
+
+// SchemaVersion is bumped whenever a field is removed or changes meaning.
+// Adding fields changes the minor version only: 1.1 added packing and
+// section entropy, function classes and the address of main.
+const SchemaVersion = "1.1"
+
+//go:embed schema.json
+var schema string
+
+// Schema returns the JSON Schema document describing the report
+func Schema() string {
+	return schema
+}
+
+// Report is the top-level JSON document
+type Report struct {
+	SchemaVersion string     `json:"schema_version"`
+	Binary        Binary     `json:"binary"`
+	Sections      []Section  `json:"sections"`
+	Symbols       []Symbol   `json:"symbols"`
+	Imports       []string   `json:"imports"`
+	Exports       []string   `json:"exports"`
+	Language      Language   `json:"language"`
+	Packing       Packing    `json:"packing"`
+	Strings       []String   `json:"strings"`
+	Functions     []Function `json:"functions"`
+	Classes       []Class    `json:"classes"`
+}
+
+// Binary holds the file-level metadata
+type Binary struct {
+	Path       string `json:"path"`
+	Size       int    `json:"size"`
+	Format     string `json:"format"`
+	Arch       string `json:"arch"`
+	EntryPoint string `json:"entry_point"`
+	Main       string `json:"main,omitempty"`
+	Compiler   string `json:"compiler"`
+}
+
+type Section struct {
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Size    uint64  `json:"size"`
+	Flags   uint32  `json:"flags"`
+	Entropy float64 `json:"entropy"`
+}
+
+type Symbol struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Size    uint64 `json:"size"`
+	Type    string `json:"type"`
+}
+
+// Language holds the result of language detection and the evidence for it
+type Language struct {
+	Detected     string   `json:"detected"`
+	Confidence   float64  `json:"confidence"`
+	GoIndicators []string `json:"go_indicators"`
+	CIndicators  []string `json:"c_indicators"`
+}
+
+// Packing holds the signs that the binary is packed or obfuscated
+type Packing struct {
+	Packer    string   `json:"packer"`
+	Unpacked  bool     `json:"unpacked"`
+	Anomalies []string `json:"anomalies"`
+}
+
+type String struct {
+	Address string `json:"address"`
+	Section string `json:"section"`
+	Value   string `json:"value"`
+}
+
+type Function struct {
+	Name         string        `json:"name"`
+	Start        string        `json:"start"`
+	End          string        `json:"end"`
+	Library      bool          `json:"library"`
+	Class        string        `json:"class"`
+	Calls        []string      `json:"calls"`
+	Instructions []Instruction `json:"instructions"`
+	Blocks       []Block       `json:"blocks"`
+	Loops        []Loop        `json:"loops"`
+	Conditionals []Conditional `json:"conditionals"`
+}
+
+type Instruction struct {
+	Address      string `json:"address"`
+	Bytes        string `json:"bytes"`
+	Mnemonic     string `json:"mnemonic"`
+	Operands     string `json:"operands"`
+	Category     string `json:"category"`
+	BranchTarget string `json:"branch_target,omitempty"`
+}
+
+// Block is a basic block. Blocks are referenced by ID within a function.
+type Block struct {
+	ID           int    `json:"id"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+	Successors   []int  `json:"successors"`
+	Predecessors []int  `json:"predecessors"`
+	Dominator    *int   `json:"immediate_dominator"`
+}
+
+type Loop struct {
+	Header int   `json:"header"`
+	Blocks []int `json:"blocks"`
+	Exits  []int `json:"exits"`
+	Depth  int   `json:"depth"`
+}
+
+type Conditional struct {
+	Type      string `json:"type"`
+	Condition int    `json:"condition"`
+	Then      *int   `json:"then"`
+	Else      *int   `json:"else"`
+	Merge     *int   `json:"merge"`
+	Cases     []int  `json:"cases,omitempty"`
+}
+
+// Class is a C++ class recovered from RTTI
+type Class struct {
+	Name     string   `json:"name"`
+	ABI      string   `json:"abi"`
+	TypeInfo string   `json:"type_info"`
+	VTable   string   `json:"vtable"`
+	Bases    []string `json:"bases"`
+	Methods  []Method `json:"methods"`
+}
+
+type Method struct {
+	Slot    int    `json:"slot"`
+	Address string `json:"address"`
+	Name    string `json:"name"`
+	Pure    bool   `json:"pure"`
+}
+
+// Build assembles the report of an analysis. Functions are reported on at
+// most workers goroutines (0 means one per CPU); functions whose CFG
+// cannot be built have no blocks.
+func Build(analysis *analyzer.Analysis, workers int) *Report {
+	b := analysis.Binary
+
+	r := &Report{
+		SchemaVersion: SchemaVersion,
+		Binary: Binary{
+			Path:       b.FilePath,
+			Size:       len(b.RawData),
+			Format:     b.Format,
+			Arch:       b.Arch,
+			EntryPoint: addr(b.EntryPoint),
+			Compiler:   analysis.Compiler,
+		},
+		Sections:  []Section{},
+		Symbols:   []Symbol{},
+		Imports:   nonNil(b.Imports),
+		Exports:   nonNil(b.Exports),
+		Strings:   []String{},
+		Functions: []Function{},
+		Classes:   []Class{},
+		Language: Language{
+			Detected:     analysis.DetectedLanguage,
+			Confidence:   analysis.Confidence,
+			GoIndicators: nonNil(analysis.GoIndicators),
+			CIndicators:  nonNil(analysis.CIndicators),
+		},
+	}
+
+	if analysis.Main != 0 {
+		r.Binary.Main = addr(analysis.Main)
+	}
+
+	packing := analysis.Packing
+	if packing == nil {
+		packing = analyzer.DetectPacking(b)
+	}
+	r.Packing = Packing{
+		Packer:    packing.Packer,
+		Unpacked:  packing.Unpacked,
+		Anomalies: nonNil(packing.Anomalies),
+	}
+
+	for i, sec := range b.Sections {
+		r.Sections = append(r.Sections, Section{
+			Name:    sec.Name,
+			Address: addr(sec.Address),
+			Size:    sec.Size,
+			Flags:   sec.Flags,
+			Entropy: packing.Sections[i].Entropy,
+		})
+	}
+
+	for _, sym := range b.Symbols {
+		r.Symbols = append(r.Symbols, Symbol{
+			Name:    sym.Name,
+			Address: addr(sym.Address),
+			Size:    sym.Size,
+			Type:    sym.Type,
+		})
+	}
+
+	for _, str := range analyzer.FindStrings(b, 4) {
+		r.Strings = append(r.Strings, String{
+			Address: addr(str.Address),
+			Section: str.Section,
+			Value:   str.Value,
+		})
+	}
+
+	r.Functions = append(r.Functions, parallel.Map(analysis.Functions, workers, func(fn disasm.Function) Function {
+		return buildFunction(analysis, &fn)
+	})...)
+
+	if analysis.RTTI != nil {
+		for _, c := range analysis.RTTI.Classes {
+			class := Class{
+				Name:     c.Name,
+				ABI:      c.ABI,
+				TypeInfo: addr(c.TypeInfo),
+				VTable:   addr(c.VTable),
+				Bases:    nonNil(c.Bases),
+				Methods:  []Method{},
+			}
+			for _, m := range c.Methods {
+				class.Methods = append(class.Methods, Method{
+					Slot:    m.Slot,
+					Address: addr(m.Address),
+					Name:    m.Name,
+					Pure:    m.Pure,
+				})
+			}
+			r.Classes = append(r.Classes, class)
+		}
+	}
+
+	return r
+}
+
+func buildFunction(analysis *analyzer.Analysis, fn *disasm.Function) Function {
+	out := Function{
+		Name:         fn.Name,
+		Start:        addr(fn.StartAddr),
+		End:          addr(fn.EndAddr),
+		Library:      fn.IsLibrary,
+		Class:        string(fn.Class),
+		Calls:        []string{},
+		Instructions: []Instruction{},
+		Blocks:       []Block{},
+		Loops:        []Loop{},
+		Conditionals: []Conditional{},
+	}
+
+	for _, call := range fn.Calls {
+		out.Calls = append(out.Calls, addr(call))
+	}
+
+	for _, inst := range fn.Instructions {
+		ji := Instruction{
+			Address:  addr(inst.Address),
+			Bytes:    hex.EncodeToString(inst.Bytes),
+			Mnemonic: inst.Mnemonic,
+			Operands: inst.Operands,
+			Category: inst.Category.String(),
+		}
+		if inst.BranchTarget != 0 {
+			ji.BranchTarget = addr(inst.BranchTarget)
+		}
+		out.Instructions = append(out.Instructions, ji)
+	}
+
+	graph, err := analysis.CFG(fn)
+	if err != nil {
+		return out
+	}
+
+	for _, block := range graph.Blocks {
+		idom := block.DominatedBy
+		if idom == block {
+			idom = nil // The entry block has no immediate dominator
+		}
+		out.Blocks = append(out.Blocks, Block{
+			ID:           block.ID,
+			Start:        addr(block.StartAddr),
+			End:          addr(block.EndAddr),
+			Successors:   blockIDs(block.Successors),
+			Predecessors: blockIDs(block.Predecessors),
+			Dominator:    blockID(idom),
+		})
+	}
+
+	for _, loop := range cfg.DetectLoops(graph) {
+		out.Loops = append(out.Loops, Loop{
+			Header: loop.Header.ID,
+			Blocks: blockIDs(loop.Blocks),
+			Exits:  blockIDs(loop.Exits),
+			Depth:  loop.GetDepth(),
+		})
+	}
+
+	for _, cond := range cfg.DetectConditionals(graph) {
+		out.Conditionals = append(out.Conditionals, Conditional{
+			Type:      cond.GetConditionType(),
+			Condition: cond.Condition.ID,
+			Then:      blockID(cond.ThenBranch),
+			Else:      blockID(cond.ElseBranch),
+			Merge:     blockID(cond.MergePoint),
+			Cases:     blockIDs(cond.CaseBlocks),
+		})
+	}
+
+	return out
+}
+
+// JSON renders the report as indented JSON
+func (r *Report) JSON() (string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// addr formats an address the way every address in the report is written
+func addr(a uint64) string {
+	return fmt.Sprintf("0x%x", a)
+}
+
+func blockID(b *cfg.BasicBlock) *int {
+	if b == nil {
+		return nil
+	}
+	id := b.ID
+	return &id
+}
+
+func blockIDs(blocks []*cfg.BasicBlock) []int {
+	ids := []int{}
+	for _, b := range blocks {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
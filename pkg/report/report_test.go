@@ -0,0 +1,210 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"expeer/pkg/analyzer"
+	_ "expeer/pkg/disasm" // x86 backend
+	"expeer/pkg/parser"
+)
+
+// validator checks a JSON document against the subset of JSON Schema that
+// schema.json uses: type, enum, pattern, minimum, maximum, required,
+// properties, items and local $refs. It is stricter than JSON Schema in
+// one way: objects may only hold the properties the schema declares, so
+// that fields added to the report without the schema are caught too.
+type validator struct {
+	defs   map[string]interface{}
+	errors []string
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) validate(path string, schema map[string]interface{}, value interface{}) {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, ok := v.defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if !ok {
+			v.fail(path, "unresolved $ref %s", ref)
+			return
+		}
+		schema = def
+	}
+
+	if t, ok := schema["type"]; ok && !v.hasType(t, value) {
+		v.fail(path, "%v is not of type %v", value, t)
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == value
+		}
+		if !found {
+			v.fail(path, "%v is not one of %v", value, enum)
+		}
+	}
+
+	switch val := value.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(val) {
+			v.fail(path, "%q does not match %s", val, pattern)
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && val < min {
+			v.fail(path, "%v is below %v", val, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && val > max {
+			v.fail(path, "%v is above %v", val, max)
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				v.validate(fmt.Sprintf("%s[%d]", path, i), items, item)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := val[name.(string)]; !ok {
+				v.fail(path, "missing required %s", name)
+			}
+		}
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := properties[name].(map[string]interface{})
+			if !ok {
+				v.fail(path, "undeclared property %s", name)
+				continue
+			}
+			v.validate(path+"."+name, prop, val[name])
+		}
+	}
+}
+
+// hasType reports whether value is of the JSON Schema type t, a name or
+// a list of names
+func (v *validator) hasType(t interface{}, value interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, name := range list {
+			if v.hasType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	switch val := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || t == "integer" && val == float64(int64(val))
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+// validateReport returns the ways doc departs from schema.json
+func validateReport(t *testing.T, doc []byte) []string {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(Schema()), &schema); err != nil {
+		t.Fatalf("schema.json: %v", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(doc, &value); err != nil {
+		t.Fatal(err)
+	}
+	v := &validator{defs: schema["$defs"].(map[string]interface{})}
+	v.validate("$", schema, value)
+	return v.errors
+}
+
+func buildTestdata(t *testing.T, name string) *Report {
+	t.Helper()
+	binary, err := parser.ParseExecutable(filepath.Join("..", "codegen", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { binary.Close() })
+	analysis, err := analyzer.Analyze(binary, analyzer.Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Build(analysis, 1)
+}
+
+// Reports must follow the schema they claim: C programs with loops and
+// conditionals, and C++ ones with classes
+func TestReportSchema(t *testing.T) {
+	var schema struct {
+		ID string `json:"$id"`
+	}
+	if err := json.Unmarshal([]byte(Schema()), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if want := "urn:expeer:report:" + SchemaVersion; schema.ID != want {
+		t.Errorf("schema $id = %s, want %s", schema.ID, want)
+	}
+
+	for _, name := range []string{"loops", "virtual"} {
+		r := buildTestdata(t, name)
+		if name == "virtual" && len(r.Classes) == 0 {
+			t.Errorf("%s: no classes to check", name)
+		}
+		doc, err := r.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range validateReport(t, []byte(doc)) {
+			t.Errorf("%s: %s", name, e)
+		}
+	}
+}
+
+// The validator must notice what the schema forbids
+func TestReportSchemaViolations(t *testing.T) {
+	doc, err := buildTestdata(t, "loops").JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]func(map[string]interface{}){
+		"undeclared": func(r map[string]interface{}) { r["binary"].(map[string]interface{})["bits"] = 64 },
+		"missing":    func(r map[string]interface{}) { delete(r, "functions") },
+		"pattern":    func(r map[string]interface{}) { r["binary"].(map[string]interface{})["entry_point"] = "4096" },
+		"enum": func(r map[string]interface{}) {
+			r["functions"].([]interface{})[0].(map[string]interface{})["class"] = "kernel"
+		},
+		"type":    func(r map[string]interface{}) { r["imports"] = "libc.so.6" },
+		"minimum": func(r map[string]interface{}) { r["binary"].(map[string]interface{})["size"] = -1 },
+	}
+	for name, change := range tests {
+		var r map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &r); err != nil {
+			t.Fatal(err)
+		}
+		change(r)
+		changed, _ := json.Marshal(r)
+		if errs := validateReport(t, changed); len(errs) == 0 {
+			t.Errorf("%s: report validated", name)
+		}
+	}
+}
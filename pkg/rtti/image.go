@@ -0,0 +1,158 @@
+package rtti
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+// image provides pointer-level access to the loaded sections of a binary
+type image struct {
+	binary     *parser.Binary
+	ptrSize    int
+	imageBase  uint64                       // Base of image-relative references (PE)
+	relocs     map[uint64]parser.Relocation // Slot address -> relocation
+	baseRelocs bool                         // Only relocated slots hold pointers (PE with .reloc)
+	symbols    map[uint64]string            // Address -> symbol name
+}
+
+func newImage(b *parser.Binary) *image {
+	im := &image{
+		binary:  b,
+		ptrSize: b.PointerSize(),
+		relocs:  make(map[uint64]parser.Relocation),
+		symbols: make(map[uint64]string),
+	}
+
+	if b.Format == "PE" {
+		im.imageBase = b.ImageBase
+		im.baseRelocs = len(b.Relocations) > 0
+	}
+
+	for _, r := range b.Relocations {
+		im.relocs[r.Address] = r
+	}
+
+	for _, sym := range b.Symbols {
+		if sym.Name != "" && sym.Address != 0 {
+			im.symbols[sym.Address] = sym.Name
+		}
+	}
+
+	return im
+}
+
+// section returns the section containing addr
+func (im *image) section(addr uint64) *parser.Section {
+	for i := range im.binary.Sections {
+		sec := &im.binary.Sections[i]
+		if addr >= sec.Address && addr < sec.Address+uint64(len(sec.Data)) {
+			return sec
+		}
+	}
+	return nil
+}
+
+// isCode returns true if addr lies in an executable section
+func (im *image) isCode(addr uint64) bool {
+	sec := im.section(addr)
+	if sec == nil {
+		return false
+	}
+	if im.binary.Format == "PE" {
+		return sec.Flags&0x20000000 != 0 // IMAGE_SCN_MEM_EXECUTE
+	}
+	if im.binary.Format == "ELF" {
+		return sec.Flags&0x4 != 0 // SHF_EXECINSTR
+	}
+	name := strings.ToLower(sec.Name)
+	return strings.Contains(name, "text")
+}
+
+func (im *image) readU32(addr uint64) (uint32, bool) {
+	sec := im.section(addr)
+	if sec == nil {
+		return 0, false
+	}
+	off := addr - sec.Address
+	if off+4 > uint64(len(sec.Data)) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(sec.Data[off:]), true
+}
+
+// readPointer reads a pointer-sized value at addr, applying any relocation
+// that targets the slot. Returns the pointed-to address and the symbol the
+// relocation references, if any. In PE images with base relocations a slot
+// the loader does not relocate holds a constant, read as a null pointer.
+func (im *image) readPointer(addr uint64) (uint64, string, bool) {
+	sec := im.section(addr)
+	if sec == nil {
+		return 0, "", false
+	}
+	off := addr - sec.Address
+	if off+uint64(im.ptrSize) > uint64(len(sec.Data)) {
+		return 0, "", false
+	}
+
+	var value uint64
+	if im.ptrSize == 8 {
+		value = binary.LittleEndian.Uint64(sec.Data[off:])
+	} else {
+		value = uint64(binary.LittleEndian.Uint32(sec.Data[off:]))
+	}
+
+	r, relocated := im.relocs[addr]
+	if relocated {
+		if r.Symbol != "" {
+			return uint64(r.Addend), r.Symbol, true
+		}
+		if r.Addend != 0 {
+			value = uint64(r.Addend)
+		}
+	} else if im.baseRelocs {
+		return 0, "", true
+	}
+
+	if value == 0 {
+		return 0, "", true
+	}
+
+	return value, im.symbols[value], true
+}
+
+// readCString reads a NUL-terminated string at addr
+func (im *image) readCString(addr uint64) string {
+	sec := im.section(addr)
+	if sec == nil {
+		return ""
+	}
+	data := sec.Data[addr-sec.Address:]
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i])
+		}
+		if i > 1024 {
+			break
+		}
+	}
+	return ""
+}
+
+// dataSections returns the non-executable sections that may hold RTTI
+func (im *image) dataSections() []*parser.Section {
+	var result []*parser.Section
+	for i := range im.binary.Sections {
+		sec := &im.binary.Sections[i]
+		name := strings.ToLower(sec.Name)
+		if strings.Contains(name, "rodata") ||
+			strings.Contains(name, "rdata") ||
+			strings.Contains(name, "data.rel.ro") ||
+			strings.Contains(name, "const") ||
+			name == ".data" || name == "__data" {
+			result = append(result, sec)
+		}
+	}
+	return result
+}
@@ -0,0 +1,385 @@
+package rtti
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Itanium C++ ABI type_info kinds
+const (
+	tiClass  = iota + 1 // __class_type_info: no bases
+	tiSingle            // __si_class_type_info: one public base
+	tiMulti             // __vmi_class_type_info: arbitrary bases
+)
+
+// recoverItanium locates type_info objects by the vtable they point to
+// (__cxxabiv1::__*class_type_info), then finds the vtables that reference
+// them (GCC/Clang on ELF and Mach-O)
+func recoverItanium(im *image) []*Class {
+	ptr := uint64(im.ptrSize)
+	typeInfos := make(map[uint64]*Class)
+	var order []uint64
+
+	// type_info objects
+	for _, sec := range im.dataSections() {
+		for addr := sec.Address; addr+2*ptr <= sec.Address+uint64(len(sec.Data)); addr += ptr {
+			kind := im.typeInfoKind(addr)
+			if kind == 0 {
+				continue
+			}
+
+			nameAddr, _, ok := im.readPointer(addr + ptr)
+			if !ok || nameAddr == 0 {
+				continue
+			}
+			// Reject anything that is not a well-formed mangled name
+			mangled := im.readCString(nameAddr)
+			parts, n, ok := parseItaniumName(mangled)
+			if !ok || n != len(mangled) {
+				continue
+			}
+
+			class := &Class{
+				Name:     strings.Join(parts, "::"),
+				ABI:      "itanium",
+				TypeInfo: addr,
+			}
+			class.Bases = im.itaniumBases(addr, kind)
+			typeInfos[addr] = class
+			order = append(order, addr)
+		}
+	}
+
+	if len(typeInfos) == 0 {
+		return nil
+	}
+
+	// Resolve base type_info addresses to names
+	for _, class := range typeInfos {
+		for i, base := range class.Bases {
+			if addr, err := strconv.ParseUint(base, 16, 64); err == nil {
+				if bc := typeInfos[addr]; bc != nil {
+					class.Bases[i] = bc.Name
+				}
+			}
+		}
+	}
+
+	// Primary vtables: [offset-to-top = 0][type_info*][slot0][slot1]...
+	for _, sec := range im.dataSections() {
+		for addr := sec.Address + ptr; addr+2*ptr <= sec.Address+uint64(len(sec.Data)); addr += ptr {
+			ti, _, ok := im.readPointer(addr)
+			if !ok {
+				continue
+			}
+			class := typeInfos[ti]
+			if class == nil || class.VTable != 0 {
+				continue
+			}
+			if top, sym, _ := im.readPointer(addr - ptr); top != 0 || sym != "" {
+				continue
+			}
+
+			class.VTable = addr + ptr
+			class.Methods = im.readVTable(class.VTable, 0)
+		}
+	}
+
+	var classes []*Class
+	for _, addr := range order {
+		classes = append(classes, typeInfos[addr])
+	}
+	im.inferBaseVTables(classes)
+	return classes
+}
+
+// inferBaseVTables fills in the slots of the classes whose vtable the
+// binary lacks, such as abstract bases whose constructors were inlined,
+// from the vtables of the classes deriving from them. A primary base's
+// slots come first in these, up to the last holding one of the base's own
+// functions. The addresses of the slots no derived class inherits stay
+// unknown.
+func (im *image) inferBaseVTables(classes []*Class) {
+	for _, base := range classes {
+		if base.VTable != 0 {
+			continue
+		}
+		var derived []*Class
+		slots := 0
+		for _, c := range classes {
+			if c.VTable == 0 || len(c.Bases) == 0 || c.Bases[0] != base.Name {
+				continue
+			}
+			derived = append(derived, c)
+			for _, m := range c.Methods {
+				if im.methodClass(m) == base.Name && m.Slot >= slots {
+					slots = m.Slot + 1
+				}
+			}
+		}
+
+		for slot := 0; slot < slots; slot++ {
+			m := Method{Slot: slot, Offset: int64(slot) * int64(im.ptrSize), Name: SlotName(slot)}
+			for _, c := range derived {
+				if slot >= len(c.Methods) {
+					continue
+				}
+				dm := c.Methods[slot]
+				if im.methodClass(dm) == base.Name {
+					m.Address, m.Name, m.Pure = dm.Address, dm.Name, dm.Pure
+					break
+				}
+				switch {
+				case dm.Pure:
+					m.Pure = true
+				case strings.HasPrefix(dm.Name, "~"):
+					m.Name = "~" + unqualifiedName(base.Name)
+				case dm.Name != SlotName(slot):
+					m.Name = dm.Name
+				}
+			}
+			base.Methods = append(base.Methods, m)
+		}
+	}
+}
+
+// methodClass returns the class whose symbol names the function in a
+// vtable slot, or "" if it has none
+func (im *image) methodClass(m Method) string {
+	fn := DemangleFunction(im.symbols[m.Address])
+	if i := strings.LastIndex(fn, "::"); i >= 0 {
+		return fn[:i]
+	}
+	return ""
+}
+
+// typeInfoKind returns the type_info kind of the object at addr, judged by
+// its vtable pointer, or 0 if it is not a class type_info
+func (im *image) typeInfoKind(addr uint64) int {
+	value, sym, ok := im.readPointer(addr)
+	if !ok || (value == 0 && sym == "") {
+		return 0
+	}
+	if sym == "" {
+		// Statically linked: the pointer targets vtable+2*ptr
+		sym = im.symbols[value-2*uint64(im.ptrSize)]
+	}
+
+	switch {
+	case strings.Contains(sym, "__vmi_class_type_info"):
+		return tiMulti
+	case strings.Contains(sym, "__si_class_type_info"):
+		return tiSingle
+	case strings.Contains(sym, "__class_type_info"):
+		return tiClass
+	}
+	return 0
+}
+
+// itaniumBases returns the base type_info addresses (as hex strings, later
+// resolved to names) of the type_info at addr
+func (im *image) itaniumBases(addr uint64, kind int) []string {
+	ptr := uint64(im.ptrSize)
+	var bases []uint64
+
+	switch kind {
+	case tiSingle:
+		if base, _, ok := im.readPointer(addr + 2*ptr); ok && base != 0 {
+			bases = append(bases, base)
+		}
+	case tiMulti:
+		count, ok := im.readU32(addr + 2*ptr + 4)
+		if !ok || count > 64 {
+			return nil
+		}
+		entry := addr + 2*ptr + 8
+		for i := uint32(0); i < count; i++ {
+			if base, _, ok := im.readPointer(entry); ok && base != 0 {
+				bases = append(bases, base)
+			}
+			entry += 2 * ptr // __base_class_type_info: type*, offset_flags
+		}
+	}
+
+	var result []string
+	for _, b := range bases {
+		result = append(result, strconv.FormatUint(b, 16))
+	}
+	return result
+}
+
+// readVTable reads consecutive function pointers starting at addr. Slot
+// offsets are relative to the vtable address point.
+func (im *image) readVTable(addr uint64, maxSlots int) []Method {
+	var methods []Method
+	ptr := uint64(im.ptrSize)
+
+	for slot := 0; maxSlots == 0 || slot < maxSlots; slot++ {
+		target, sym, ok := im.readPointer(addr + uint64(slot)*ptr)
+		if !ok {
+			break
+		}
+
+		m := Method{
+			Slot:    slot,
+			Offset:  int64(slot) * int64(ptr),
+			Address: target,
+			Name:    SlotName(slot),
+		}
+
+		if strings.Contains(sym, "pure_virtual") || strings.Contains(sym, "_purecall") {
+			m.Pure = true
+		} else if target == 0 || !im.isCode(target) {
+			break
+		}
+
+		if fn := DemangleFunction(im.symbols[target]); fn != "" {
+			if idx := strings.LastIndex(fn, "::"); idx >= 0 {
+				m.Name = fn[idx+2:]
+			}
+		}
+
+		methods = append(methods, m)
+	}
+
+	return methods
+}
+
+// DemangleFunction demangles an Itanium function symbol such as
+// "_ZN7Derived3fooEv" to "Derived::foo", ignoring parameter types.
+// Returns "" for names that are not mangled.
+func DemangleFunction(sym string) string {
+	if !strings.HasPrefix(sym, "_Z") {
+		return ""
+	}
+	parts, _, ok := parseItaniumName(sym[2:])
+	if !ok || len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "::")
+}
+
+// itaniumBuiltins are the one-letter builtin types of the Itanium ABI
+var itaniumBuiltins = map[byte]string{
+	'v': "void", 'b': "bool", 'c': "char", 'a': "signed char", 'h': "unsigned char",
+	's': "short", 't': "unsigned short", 'i': "int", 'j': "unsigned int",
+	'l': "long", 'm': "unsigned long", 'x': "long long", 'y': "unsigned long long",
+	'n': "__int128", 'o': "unsigned __int128", 'f': "float", 'd': "double",
+	'e': "long double", 'w': "wchar_t", 'z': "...",
+}
+
+// DemangleParams returns the parameter types of an Itanium function
+// symbol, such as ["const char*", "int"] for "_ZN3Foo3barEPKci". Symbols
+// do not record return types. ok is false for names that are not mangled
+// or use what is not decoded: templates and substitutions.
+func DemangleParams(sym string) (params []string, ok bool) {
+	if !strings.HasPrefix(sym, "_Z") {
+		return nil, false
+	}
+	s := sym[2:]
+	_, n, ok := parseItaniumName(s)
+	if !ok {
+		return nil, false
+	}
+	s = s[n:]
+	if s == "v" {
+		return []string{}, true
+	}
+	for len(s) > 0 {
+		t, n, ok := parseItaniumType(s)
+		if !ok {
+			return nil, false
+		}
+		params = append(params, t)
+		s = s[n:]
+	}
+	return params, params != nil
+}
+
+// parseItaniumType parses a <type> production made of builtin and named
+// types with pointer, reference and CV qualifiers. Returns the C++
+// spelling and the number of bytes consumed.
+func parseItaniumType(s string) (string, int, bool) {
+	if s == "" {
+		return "", 0, false
+	}
+	switch c := s[0]; {
+	case c == 'P' || c == 'R' || c == 'O':
+		inner, n, ok := parseItaniumType(s[1:])
+		suffix := map[byte]string{'P': "*", 'R': "&", 'O': "&&"}[c]
+		return inner + suffix, n + 1, ok
+	case c == 'K' || c == 'V':
+		inner, n, ok := parseItaniumType(s[1:])
+		qualifier := map[byte]string{'K': "const", 'V': "volatile"}[c]
+		if strings.HasSuffix(inner, "*") || strings.HasSuffix(inner, "&") {
+			return inner + " " + qualifier, n + 1, ok
+		}
+		return qualifier + " " + inner, n + 1, ok
+	case itaniumBuiltins[c] != "":
+		return itaniumBuiltins[c], 1, true
+	case c == 'N' || c >= '0' && c <= '9' || strings.HasPrefix(s, "St"):
+		prefix := 0
+		if strings.HasPrefix(s, "St") {
+			prefix = 2
+		}
+		parts, n, ok := parseItaniumName(s[prefix:])
+		if prefix > 0 {
+			parts = append([]string{"std"}, parts...)
+		}
+		return strings.Join(parts, "::"), n + prefix, ok
+	}
+	return "", 0, false
+}
+
+// parseItaniumName parses a <name> production: either a nested name
+// (N [CV-qualifiers] <prefix>... E) or an unscoped source name. Returns the
+// components and the number of bytes consumed.
+func parseItaniumName(s string) ([]string, int, bool) {
+	var parts []string
+	i := 0
+
+	nested := strings.HasPrefix(s, "N")
+	if nested {
+		i++
+		for i < len(s) && (s[i] == 'K' || s[i] == 'V' || s[i] == 'r') {
+			i++
+		}
+	}
+
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "St"):
+			parts = append(parts, "std")
+			i += 2
+			continue
+		case s[i] == 'C' && i+1 < len(s) && s[i+1] >= '1' && s[i+1] <= '5' && len(parts) > 0:
+			parts = append(parts, parts[len(parts)-1])
+			i += 2
+		case s[i] == 'D' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '5' && len(parts) > 0:
+			parts = append(parts, "~"+parts[len(parts)-1])
+			i += 2
+		case s[i] >= '0' && s[i] <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(s[i:j])
+			if n == 0 || j+n > len(s) {
+				return nil, 0, false
+			}
+			parts = append(parts, s[j:j+n])
+			i = j + n
+		default:
+			return nil, 0, false
+		}
+
+		if !nested {
+			return parts, i, true
+		}
+		if i < len(s) && s[i] == 'E' {
+			return parts, i + 1, true
+		}
+	}
+
+	return nil, 0, false
+}
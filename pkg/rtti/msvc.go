@@ -0,0 +1,175 @@
+package rtti
+
+import (
+	"bytes"
+	"strings"
+)
+
+// recoverMSVC locates TypeDescriptors by their decorated names, the
+// RTTICompleteObjectLocators that reference them, and the vtables whose
+// slot -1 points at a locator (MSVC on PE)
+func recoverMSVC(im *image) []*Class {
+	ptr := uint64(im.ptrSize)
+	is64 := im.ptrSize == 8
+	descriptors := make(map[uint64]*Class) // TypeDescriptor address -> class
+	var order []uint64
+
+	// TypeDescriptor: pVFTable, spare, then the decorated name ".?AVName@@"
+	for _, sec := range im.dataSections() {
+		data := sec.Data
+		for _, prefix := range []string{".?AV", ".?AU"} {
+			start := 0
+			for {
+				idx := bytes.Index(data[start:], []byte(prefix))
+				if idx < 0 {
+					break
+				}
+				nameAddr := sec.Address + uint64(start+idx)
+				start += idx + len(prefix)
+
+				if nameAddr < sec.Address+2*ptr {
+					continue
+				}
+				td := nameAddr - 2*ptr
+				name := im.readCString(nameAddr)
+				if name == "" {
+					continue
+				}
+
+				descriptors[td] = &Class{
+					Name:     demangleMSVCType(name),
+					ABI:      "msvc",
+					TypeInfo: td,
+				}
+				order = append(order, td)
+			}
+		}
+	}
+
+	if len(descriptors) == 0 {
+		return nil
+	}
+
+	// RTTICompleteObjectLocator: signature, offset, cdOffset,
+	// pTypeDescriptor, pClassDescriptor[, pSelf]. References are image
+	// relative on x64 (signature 1) and absolute on x86 (signature 0).
+	locators := make(map[uint64]*Class) // COL address -> class
+	for _, sec := range im.dataSections() {
+		for addr := sec.Address; addr+24 <= sec.Address+uint64(len(sec.Data)); addr += 4 {
+			sig, _ := im.readU32(addr)
+			if (is64 && sig != 1) || (!is64 && sig != 0) {
+				continue
+			}
+			offset, _ := im.readU32(addr + 4)
+			if offset != 0 {
+				continue // Only primary vtables
+			}
+
+			tdRef, _ := im.readU32(addr + 12)
+			class := descriptors[im.msvcRef(tdRef)]
+			if class == nil {
+				continue
+			}
+			if is64 {
+				if self, _ := im.readU32(addr + 20); im.msvcRef(self) != addr {
+					continue
+				}
+			}
+
+			locators[addr] = class
+			if chdRef, ok := im.readU32(addr + 16); ok && class.Bases == nil {
+				class.Bases = im.msvcBases(im.msvcRef(chdRef), descriptors, class)
+			}
+		}
+	}
+
+	// vtables: the slot just before the first virtual function holds the
+	// absolute address of the locator
+	for _, sec := range im.dataSections() {
+		for addr := sec.Address; addr+2*ptr <= sec.Address+uint64(len(sec.Data)); addr += ptr {
+			col, _, ok := im.readPointer(addr)
+			if !ok {
+				continue
+			}
+			class := locators[col]
+			if class == nil || class.VTable != 0 {
+				continue
+			}
+			class.VTable = addr + ptr
+			class.Methods = im.readVTable(class.VTable, 0)
+		}
+	}
+
+	var classes []*Class
+	for _, td := range order {
+		if c := descriptors[td]; c.VTable != 0 || len(c.Bases) > 0 {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// msvcRef converts a 32-bit RTTI reference to an address: an image
+// relative offset on x64, an absolute address on x86
+func (im *image) msvcRef(ref uint32) uint64 {
+	if im.ptrSize == 8 {
+		return im.imageBase + uint64(ref)
+	}
+	return uint64(ref)
+}
+
+// msvcBases reads the RTTIClassHierarchyDescriptor at chd and returns the
+// names of the direct base classes. The base class array flattens the
+// hierarchy depth first: the class itself, then each base followed by
+// the numContainedBases entries of its own bases, which are skipped.
+func (im *image) msvcBases(chd uint64, descriptors map[uint64]*Class, self *Class) []string {
+	count, ok := im.readU32(chd + 8)
+	if !ok || count > 64 {
+		return nil
+	}
+	arrayRef, ok := im.readU32(chd + 12)
+	if !ok {
+		return nil
+	}
+	array := im.msvcRef(arrayRef)
+
+	var bases []string
+	for i := uint32(1); i < count; {
+		bcdRef, ok := im.readU32(array + uint64(i)*4)
+		if !ok {
+			break
+		}
+		// RTTIBaseClassDescriptor: pTypeDescriptor, numContainedBases, ...
+		bcd := im.msvcRef(bcdRef)
+		tdRef, ok := im.readU32(bcd)
+		if !ok {
+			break
+		}
+		contained, ok := im.readU32(bcd + 4)
+		if !ok || contained >= count-i {
+			break
+		}
+		if base := descriptors[im.msvcRef(tdRef)]; base != nil && base != self {
+			bases = append(bases, base.Name)
+		}
+		i += 1 + contained
+	}
+	return bases
+}
+
+// demangleMSVCType converts a decorated type name such as ".?AVFoo@ns@@"
+// to "ns::Foo". Template names are returned undecorated but otherwise raw.
+func demangleMSVCType(s string) string {
+	s = strings.TrimPrefix(s, ".?AV")
+	s = strings.TrimPrefix(s, ".?AU")
+	s = strings.TrimSuffix(s, "@@")
+	if strings.Contains(s, "?$") {
+		return s
+	}
+
+	parts := strings.Split(s, "@")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "::")
+}
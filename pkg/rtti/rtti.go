@@ -0,0 +1,257 @@
+package rtti
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"expeer/pkg/parser"
+)
+
+// Class represents a C++ class recovered from RTTI
+type Class struct {
+	Name     string
+	ABI      string // "itanium" or "msvc"
+	TypeInfo uint64 // Address of the type_info / TypeDescriptor object
+	VTable   uint64 // Address of the first virtual function slot
+	Bases    []string
+	Methods  []Method
+}
+
+// Method represents one slot of a class vtable
+type Method struct {
+	Slot    int
+	Offset  int64  // Byte offset of the slot from the vtable address point
+	Address uint64 // 0 for the slots of vtables the binary lacks
+	Name    string
+	Pure    bool
+}
+
+// Hierarchy holds every class recovered from a binary. It is read-only
+// once Recover returns and safe for concurrent use.
+type Hierarchy struct {
+	Classes []*Class
+	ptrSize int
+	owners  map[uint64][]*Class // Method address -> classes listing it
+}
+
+// Recover walks the RTTI objects and vtables of a binary and reconstructs
+// its class hierarchy. Returns nil if no RTTI was found.
+func Recover(b *parser.Binary) *Hierarchy {
+	im := newImage(b)
+
+	var classes []*Class
+	if b.Format == "PE" {
+		classes = recoverMSVC(im)
+	} else {
+		classes = recoverItanium(im)
+	}
+
+	if len(classes) == 0 {
+		return nil
+	}
+
+	return NewHierarchy(classes, im.ptrSize)
+}
+
+// NewHierarchy builds a Hierarchy from already recovered classes, such as
+// ones loaded from the analysis cache
+func NewHierarchy(classes []*Class, ptrSize int) *Hierarchy {
+	h := &Hierarchy{
+		Classes: classes,
+		ptrSize: ptrSize,
+		owners:  make(map[uint64][]*Class),
+	}
+
+	// Base classes first so that inherited slots are attributed to the
+	// class that introduced them
+	sort.SliceStable(h.Classes, func(i, j int) bool {
+		return h.depth(h.Classes[i]) < h.depth(h.Classes[j])
+	})
+
+	for _, c := range h.Classes {
+		for _, m := range c.Methods {
+			if m.Address != 0 {
+				h.owners[m.Address] = append(h.owners[m.Address], c)
+			}
+		}
+	}
+
+	return h
+}
+
+// PointerSize returns the size in bytes of a vtable slot
+func (h *Hierarchy) PointerSize() int {
+	return h.ptrSize
+}
+
+// Find returns the class with the given name
+func (h *Hierarchy) Find(name string) *Class {
+	for _, c := range h.Classes {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// depth returns the length of the longest base chain above c
+func (h *Hierarchy) depth(c *Class) int {
+	return h.depthSeen(c, make(map[*Class]bool))
+}
+
+func (h *Hierarchy) depthSeen(c *Class, seen map[*Class]bool) int {
+	if seen[c] {
+		return 0
+	}
+	seen[c] = true
+
+	max := 0
+	for _, name := range c.Bases {
+		if base := h.Find(name); base != nil {
+			if d := h.depthSeen(base, seen) + 1; d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// MethodName returns the qualified name ("Class::method") of a virtual
+// function, if the address appears in any recovered vtable
+func (h *Hierarchy) MethodName(addr uint64) (string, bool) {
+	owners := h.owners[addr]
+	if len(owners) == 0 {
+		return "", false
+	}
+	c := owners[0]
+	for _, m := range c.Methods {
+		if m.Address == addr {
+			return c.Name + "::" + m.Name, true
+		}
+	}
+	return "", false
+}
+
+// ResolveVirtual names the method reached through a vtable slot from code
+// in the function at caller. If the caller is itself a method of a class,
+// that class is used; otherwise the slot must agree across all classes.
+// Calls through the deleting destructor are named delete. A slot holding
+// the complete destructors of different classes is named after the
+// destructor of the class they all derive from.
+func (h *Hierarchy) ResolveVirtual(caller uint64, slotOffset int64) (string, bool) {
+	if owners := h.owners[caller]; len(owners) > 0 {
+		c := owners[0]
+		for _, m := range c.Methods {
+			if m.Offset == slotOffset {
+				if c.DeletingDestructor(m) {
+					return "delete", true
+				}
+				return m.Name, true
+			}
+		}
+	}
+
+	name, agree := "", true
+	var listing, destroyed []*Class
+	deleting := 0
+	for _, c := range h.Classes {
+		for _, m := range c.Methods {
+			if m.Offset != slotOffset {
+				continue
+			}
+			if name != "" && name != m.Name {
+				agree = false
+			}
+			name = m.Name
+			listing = append(listing, c)
+			if c.DeletingDestructor(m) {
+				deleting++
+			} else if strings.HasPrefix(m.Name, "~") {
+				destroyed = append(destroyed, c)
+			}
+		}
+	}
+
+	switch {
+	case name == "":
+		return "", false
+	case deleting == len(listing):
+		return "delete", true
+	case agree:
+		return name, true
+	case len(destroyed) == len(listing):
+		if base := h.commonBase(destroyed); base != nil {
+			return "~" + unqualifiedName(base.Name), true
+		}
+	}
+	return "", false
+}
+
+// DeletingDestructor reports whether a destructor slot of the class also
+// frees the object: the second of the two destructors Itanium vtables
+// hold, complete then deleting, or the scalar deleting destructor, the
+// only one MSVC vtables hold
+func (c *Class) DeletingDestructor(m Method) bool {
+	if !strings.HasPrefix(m.Name, "~") {
+		return false
+	}
+	var slots []int
+	for _, d := range c.Methods {
+		if strings.HasPrefix(d.Name, "~") {
+			slots = append(slots, d.Slot)
+		}
+	}
+	return c.ABI == "msvc" || len(slots) == 2 && m.Slot == slots[1]
+}
+
+// commonBase returns the most derived class all the classes are or
+// derive from, or nil if there is none
+func (h *Hierarchy) commonBase(classes []*Class) *Class {
+	var common *Class
+	for _, candidate := range h.Classes {
+		all := true
+		for _, c := range classes {
+			all = all && h.derivesFrom(c, candidate, make(map[*Class]bool))
+		}
+		if all && (common == nil || h.depth(candidate) > h.depth(common)) {
+			common = candidate
+		}
+	}
+	return common
+}
+
+// derivesFrom reports whether c is base or derives from it
+func (h *Hierarchy) derivesFrom(c, base *Class, seen map[*Class]bool) bool {
+	if c == base {
+		return true
+	}
+	if seen[c] {
+		return false
+	}
+	seen[c] = true
+	for _, name := range c.Bases {
+		if b := h.Find(name); b != nil && h.derivesFrom(b, base, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// unqualifiedName returns a class name without its namespaces, as its
+// constructors and destructor are named
+func unqualifiedName(name string) string {
+	scope := name
+	if i := strings.Index(scope, "<"); i >= 0 {
+		scope = scope[:i]
+	}
+	if i := strings.LastIndex(scope, "::"); i >= 0 {
+		return name[i+2:]
+	}
+	return name
+}
+
+// SlotName returns the default name for an unnamed vtable slot
+func SlotName(slot int) string {
+	return fmt.Sprintf("vfunc%d", slot)
+}
@@ -0,0 +1,242 @@
+package signature
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"fmt"
+	"os"
+	"sort"
+
+	"expeer/pkg/parser"
+)
+
+// relocSpan marks bytes of a function covered by a relocation
+type relocSpan struct {
+	offset int
+	size   int
+	symbol string
+}
+
+// BuildFromFile creates signatures for every function defined in an ELF or
+// COFF object file, or in each object member of an ar/.lib archive.
+// Go archives use Go's own object format and are not supported.
+func BuildFromFile(path string) ([]*Signature, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if !parser.IsArchive(data) {
+		return buildFromObject(data)
+	}
+
+	members, err := parser.ParseArchive(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var sigs []*Signature
+	for _, m := range members {
+		memberSigs, err := buildFromObject(m.Data)
+		if err != nil {
+			continue // Skip non-object members (import stubs, Go objects)
+		}
+		sigs = append(sigs, memberSigs...)
+	}
+	return sigs, nil
+}
+
+func buildFromObject(data []byte) ([]*Signature, error) {
+	if bytes.HasPrefix(data, []byte("\x7fELF")) {
+		return buildFromELF(data)
+	}
+	return buildFromCOFF(data)
+}
+
+// buildFromELF creates signatures from the STT_FUNC symbols of a
+// relocatable ELF object
+func buildFromELF(data []byte) ([]*Signature, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != elf.ET_REL {
+		return nil, fmt.Errorf("not a relocatable object")
+	}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	// Wildcard width per relocation type: 8 bytes for absolute 64-bit
+	// relocations (R_X86_64_64, R_X86_64_PC64), 4 otherwise
+	relocs := make(map[string][]relocSpan)
+	for _, r := range parser.ELFRelocations(f) {
+		size := 4
+		if f.Machine == elf.EM_X86_64 && (r.Type == 1 || r.Type == 24) {
+			size = 8
+		}
+		relocs[r.Section] = append(relocs[r.Section], relocSpan{
+			offset: int(r.Address),
+			size:   size,
+			symbol: r.Symbol,
+		})
+	}
+
+	var sigs []*Signature
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Size == 0 || sym.Name == "" {
+			continue
+		}
+		if int(sym.Section) <= 0 || int(sym.Section) >= len(f.Sections) {
+			continue
+		}
+		sec := f.Sections[sym.Section]
+		if sec.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		code, err := sec.Data()
+		if err != nil || sym.Value+sym.Size > uint64(len(code)) {
+			continue
+		}
+
+		sig := newSignature(sym.Name, code[sym.Value:sym.Value+sym.Size], int(sym.Value), relocs[sec.Name])
+		if sig != nil {
+			sigs = append(sigs, sig)
+		}
+	}
+
+	return sigs, nil
+}
+
+// buildFromCOFF creates signatures from the function symbols of a COFF
+// object. COFF symbols carry no size, so each function extends to the next
+// symbol in the same section.
+func buildFromCOFF(data []byte) ([]*Signature, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	type coffFunc struct {
+		name  string
+		value uint32
+	}
+	funcs := make(map[int][]coffFunc) // Section number -> functions
+
+	for _, sym := range f.COFFSymbols {
+		if sym.Type != 0x20 || sym.SectionNumber <= 0 { // DT_FUNCTION
+			continue
+		}
+		name, err := sym.FullName(f.StringTable)
+		if err != nil || name == "" {
+			continue
+		}
+		funcs[int(sym.SectionNumber)] = append(funcs[int(sym.SectionNumber)], coffFunc{name, sym.Value})
+	}
+
+	var sigs []*Signature
+	for num, list := range funcs {
+		if num > len(f.Sections) {
+			continue
+		}
+		sec := f.Sections[num-1]
+		if sec.Characteristics&0x20 == 0 { // IMAGE_SCN_CNT_CODE
+			continue
+		}
+		code, err := sec.Data()
+		if err != nil {
+			continue
+		}
+
+		var spans []relocSpan
+		for _, r := range sec.Relocs {
+			size := 4
+			if f.Machine == pe.IMAGE_FILE_MACHINE_AMD64 && r.Type == 1 { // IMAGE_REL_AMD64_ADDR64
+				size = 8
+			}
+			symbol := ""
+			if int(r.SymbolTableIndex) < len(f.COFFSymbols) {
+				s := f.COFFSymbols[r.SymbolTableIndex]
+				if s.StorageClass == 2 { // IMAGE_SYM_CLASS_EXTERNAL
+					symbol, _ = s.FullName(f.StringTable)
+				}
+			}
+			spans = append(spans, relocSpan{offset: int(r.VirtualAddress), size: size, symbol: symbol})
+		}
+
+		sort.Slice(list, func(i, j int) bool { return list[i].value < list[j].value })
+		for i, fn := range list {
+			end := uint32(len(code))
+			if i+1 < len(list) {
+				end = list[i+1].value
+			}
+			if fn.value >= end || end > uint32(len(code)) {
+				continue
+			}
+			if sig := newSignature(fn.name, code[fn.value:end], int(fn.value), spans); sig != nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+
+	return sigs, nil
+}
+
+// newSignature builds a signature from a function's bytes. base is the
+// function's offset within its section, used to place relocation spans.
+func newSignature(name string, code []byte, base int, spans []relocSpan) *Signature {
+	if len(code) < MinFunctionSize {
+		return nil
+	}
+
+	mask := make([]bool, len(code))
+	for i := range mask {
+		mask[i] = true
+	}
+
+	sig := &Signature{
+		Name: name,
+		Size: len(code),
+	}
+
+	for _, span := range spans {
+		off := span.offset - base
+		if off < 0 || off >= len(code) {
+			continue
+		}
+		for i := off; i < off+span.size && i < len(code); i++ {
+			mask[i] = false
+		}
+		if span.symbol != "" {
+			sig.Refs = append(sig.Refs, Reference{Offset: off, Name: span.symbol})
+		}
+	}
+	sort.Slice(sig.Refs, func(i, j int) bool { return sig.Refs[i].Offset < sig.Refs[j].Offset })
+
+	n := PatternSize
+	if len(code) < n {
+		n = len(code)
+	}
+	sig.Pattern = append([]byte(nil), code[:n]...)
+	sig.Mask = mask[:n]
+
+	// The CRC covers the tail up to the first relocated byte
+	tail := n
+	for tail < len(code) && tail-n < 0xFF && mask[tail] {
+		tail++
+	}
+	sig.CRCLength = tail - n
+	sig.CRC = crc16(code[n:tail])
+
+	// Zero out wildcard bytes so identical functions produce identical lines
+	for i := range sig.Pattern {
+		if !sig.Mask[i] {
+			sig.Pattern[i] = 0
+		}
+	}
+
+	return sig
+}
@@ -0,0 +1,87 @@
+package signature
+
+import (
+	"strings"
+
+	"expeer/pkg/disasm"
+)
+
+// Match returns the signature describing fn, or nil if none or more than
+// one differently named signature matches. code holds the bytes at
+// fn.StartAddr (at least MaxSize bytes where available); names resolves
+// call targets to known function names for reference checks.
+func (db *Database) Match(code []byte, fn *disasm.Function, names map[uint64]string) *Signature {
+	if len(code) == 0 {
+		return nil
+	}
+
+	var found *Signature
+	for _, sig := range db.candidates(code[0]) {
+		if !sig.matchBytes(code) || !sig.matchRefs(fn, names) {
+			continue
+		}
+		if found != nil && found.Name != sig.Name {
+			return nil // Ambiguous
+		}
+		found = sig
+	}
+
+	return found
+}
+
+// candidates returns the signatures that may start with first
+func (db *Database) candidates(first byte) []*Signature {
+	if len(db.wild) == 0 {
+		return db.index[first]
+	}
+	result := make([]*Signature, 0, len(db.index[first])+len(db.wild))
+	result = append(result, db.index[first]...)
+	return append(result, db.wild...)
+}
+
+// matchBytes checks the pattern, tail CRC and function size
+func (sig *Signature) matchBytes(code []byte) bool {
+	if len(code) < sig.Size || len(code) < len(sig.Pattern)+sig.CRCLength {
+		return false
+	}
+
+	for i, b := range sig.Pattern {
+		if sig.Mask[i] && code[i] != b {
+			return false
+		}
+	}
+
+	if sig.CRCLength > 0 {
+		tail := code[len(sig.Pattern) : len(sig.Pattern)+sig.CRCLength]
+		if crc16(tail) != sig.CRC {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchRefs rejects a signature if a referenced name conflicts with the
+// known name of the target of the branch at that offset. Unknown targets
+// are accepted.
+func (sig *Signature) matchRefs(fn *disasm.Function, names map[uint64]string) bool {
+	for _, ref := range sig.Refs {
+		addr := fn.StartAddr + uint64(ref.Offset)
+
+		for _, inst := range fn.Instructions {
+			if addr < inst.Address || addr >= inst.Address+uint64(inst.Size) {
+				continue
+			}
+			if inst.BranchTarget == 0 {
+				break
+			}
+			name := names[inst.BranchTarget]
+			if name != "" && !strings.HasPrefix(name, "sub_") && name != ref.Name {
+				return false
+			}
+			break
+		}
+	}
+
+	return true
+}
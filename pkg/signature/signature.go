@@ -0,0 +1,231 @@
+package signature
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatternSize is the number of leading bytes stored verbatim in a signature
+const PatternSize = 32
+
+// MinFunctionSize is the smallest function a signature is generated for;
+// shorter functions collide too often to be identified reliably
+const MinFunctionSize = 16
+
+// fileHeader is the first line of a signature database file
+const fileHeader = "# expeer signatures v1"
+
+// Signature identifies a library function by its code bytes
+//
+// The on-disk format is one signature per line, modelled on FLIRT .pat
+// files:
+//
+//	<pattern> <crc length> <crc16> <size> :0000 <name> [^<offset> <ref>]...
+//
+// The pattern is up to PatternSize bytes in hex with ".." for bytes covered
+// by relocations. The CRC covers the bytes following the pattern up to the
+// first relocated byte. All numbers are hex.
+type Signature struct {
+	Name      string
+	Pattern   []byte
+	Mask      []bool // false for relocated (wildcard) bytes
+	CRCLength int
+	CRC       uint16
+	Size      int
+	Refs      []Reference
+}
+
+// Reference is a symbol referenced by a relocation inside the function
+type Reference struct {
+	Offset int
+	Name   string
+}
+
+// Database is a set of signatures indexed by their first byte
+type Database struct {
+	Signatures []*Signature
+	index      map[byte][]*Signature
+	wild       []*Signature // Signatures whose first byte is a wildcard
+	maxSize    int
+}
+
+// NewDatabase creates an empty signature database
+func NewDatabase() *Database {
+	return &Database{
+		index: make(map[byte][]*Signature),
+	}
+}
+
+// Add adds a signature to the database
+func (db *Database) Add(sig *Signature) {
+	db.Signatures = append(db.Signatures, sig)
+	if len(sig.Mask) > 0 && sig.Mask[0] {
+		db.index[sig.Pattern[0]] = append(db.index[sig.Pattern[0]], sig)
+	} else {
+		db.wild = append(db.wild, sig)
+	}
+	if sig.Size > db.maxSize {
+		db.maxSize = sig.Size
+	}
+}
+
+// MaxSize returns the size of the largest function in the database
+func (db *Database) MaxSize() int {
+	return db.maxSize
+}
+
+// Load reads one or more signature files into a single database
+func Load(paths ...string) (*Database, error) {
+	db := NewDatabase()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open signature file: %w", err)
+		}
+		err = db.Read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return db, nil
+}
+
+// Read parses signatures from r and adds them to the database
+func (db *Database) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		sig, err := parseLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		db.Add(sig)
+	}
+
+	return scanner.Err()
+}
+
+// Write saves the database in text form, sorted by name
+func (db *Database) Write(w io.Writer) error {
+	sigs := append([]*Signature(nil), db.Signatures...)
+	sort.SliceStable(sigs, func(i, j int) bool {
+		return sigs[i].Name < sigs[j].Name
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, fileHeader)
+	for _, sig := range sigs {
+		fmt.Fprintln(bw, sig.String())
+	}
+	return bw.Flush()
+}
+
+// String formats the signature as a database line
+func (sig *Signature) String() string {
+	var sb strings.Builder
+
+	for i, b := range sig.Pattern {
+		if sig.Mask[i] {
+			sb.WriteString(fmt.Sprintf("%02X", b))
+		} else {
+			sb.WriteString("..")
+		}
+	}
+	sb.WriteString(fmt.Sprintf(" %02X %04X %04X :0000 %s", sig.CRCLength, sig.CRC, sig.Size, sig.Name))
+	for _, ref := range sig.Refs {
+		sb.WriteString(fmt.Sprintf(" ^%04X %s", ref.Offset, ref.Name))
+	}
+
+	return sb.String()
+}
+
+func parseLine(line string) (*Signature, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("expected at least 6 fields, got %d", len(fields))
+	}
+
+	sig := &Signature{}
+
+	pattern := fields[0]
+	if len(pattern)%2 != 0 || len(pattern)/2 > PatternSize {
+		return nil, fmt.Errorf("bad pattern %q", pattern)
+	}
+	for i := 0; i < len(pattern); i += 2 {
+		if pattern[i:i+2] == ".." {
+			sig.Pattern = append(sig.Pattern, 0)
+			sig.Mask = append(sig.Mask, false)
+			continue
+		}
+		b, err := strconv.ParseUint(pattern[i:i+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern byte %q", pattern[i:i+2])
+		}
+		sig.Pattern = append(sig.Pattern, byte(b))
+		sig.Mask = append(sig.Mask, true)
+	}
+
+	crcLen, err1 := strconv.ParseUint(fields[1], 16, 8)
+	crc, err2 := strconv.ParseUint(fields[2], 16, 16)
+	size, err3 := strconv.ParseUint(fields[3], 16, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, fmt.Errorf("bad crc/size fields")
+	}
+	sig.CRCLength = int(crcLen)
+	sig.CRC = uint16(crc)
+	sig.Size = int(size)
+	if sig.Size < len(sig.Pattern)+sig.CRCLength {
+		return nil, fmt.Errorf("size %X shorter than the pattern and CRC", sig.Size)
+	}
+
+	if !strings.HasPrefix(fields[4], ":") {
+		return nil, fmt.Errorf("expected public name offset, got %q", fields[4])
+	}
+	sig.Name = fields[5]
+
+	for i := 6; i < len(fields); i += 2 {
+		if !strings.HasPrefix(fields[i], "^") {
+			continue
+		}
+		off, err := strconv.ParseUint(fields[i][1:], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad reference offset %q", fields[i])
+		}
+		if i+1 == len(fields) {
+			return nil, fmt.Errorf("reference %q without a name", fields[i])
+		}
+		sig.Refs = append(sig.Refs, Reference{Offset: int(off), Name: fields[i+1]})
+	}
+
+	return sig, nil
+}
+
+// crc16 computes the CRC-16/X-25 checksum used for signature tails
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
@@ -0,0 +1,203 @@
+package signature
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"expeer/pkg/disasm"
+)
+
+// greetLine is the signature of greet in testdata/lib.o: relocated bytes
+// are wildcards and the tail after the pattern starts with one, so it has
+// no CRC
+const greetLine = "4883EC088B05........4889FEBF........8D500331C08915........E8.... 00 0000 0032 :0000 greet " +
+	"^0006 counter ^0019 counter ^001E printf ^0025 stdout ^002E fflush"
+
+func TestBuildFromFile(t *testing.T) {
+	sigs, err := BuildFromFile("testdata/lib.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tiny is shorter than MinFunctionSize
+	if len(sigs) != 1 {
+		t.Fatalf("%d signatures, want greet only", len(sigs))
+	}
+	if got := sigs[0].String(); got != greetLine {
+		t.Errorf("greet:\ngot  %s\nwant %s", got, greetLine)
+	}
+
+	var buf bytes.Buffer
+	db := NewDatabase()
+	db.Add(sigs[0])
+	if err := db.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read := NewDatabase()
+	if err := read.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Signatures) != 1 || read.Signatures[0].String() != greetLine {
+		t.Errorf("Read(Write()) = %v, want greet unchanged", read.Signatures)
+	}
+}
+
+// linkedGreet returns the code of greet from testdata/lib.o as the linker
+// would leave it, its relocations filled in, and the function with its
+// call to printf at 0x401d
+func linkedGreet(t *testing.T) ([]byte, *disasm.Function) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/lib.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte{0x48, 0x83, 0xec, 0x08, 0x8b, 0x05})
+	if i < 0 {
+		t.Fatal("greet not found in lib.o")
+	}
+	code := append([]byte(nil), data[i:i+0x32]...)
+	for _, off := range []int{0x06, 0x0e, 0x19, 0x1e, 0x25, 0x2e} {
+		copy(code[off:], []byte{0x78, 0x56, 0x34, 0x12})
+	}
+	fn := &disasm.Function{StartAddr: 0x4000, EndAddr: 0x4032, Instructions: []disasm.Instruction{
+		{Address: 0x4017, Size: 6},
+		{Address: 0x401d, Size: 5, BranchTarget: 0x5000},
+		{Address: 0x4022, Size: 7},
+	}}
+	return code, fn
+}
+
+func TestMatch(t *testing.T) {
+	code, fn := linkedGreet(t)
+	greet, err := parseLine(greetLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := NewDatabase()
+	db.Add(greet)
+	if got := db.Match(code, fn, nil); got != greet {
+		t.Errorf("Match(relocated bytes filled in) = %v, want greet", got)
+	}
+	if got := db.Match(code[:0x31], fn, nil); got != nil {
+		t.Errorf("Match(shorter than the function) = %v, want nil", got)
+	}
+	changed := append([]byte(nil), code...)
+	changed[0x0a] = 0x90
+	if got := db.Match(changed, fn, nil); got != nil {
+		t.Errorf("Match(pattern byte changed) = %v, want nil", got)
+	}
+
+	// Referenced names must agree with the known names of call targets
+	for name, want := range map[string]bool{"printf": true, "sub_5000": true, "": true, "puts": false} {
+		names := map[uint64]string{0x5000: name}
+		if got := db.Match(code, fn, names) != nil; got != want {
+			t.Errorf("Match(call to %q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMatchTail(t *testing.T) {
+	code := make([]byte, 48)
+	for i := range code {
+		code[i] = byte(i)
+	}
+	sig := newSignature("ramp", code, 0, nil)
+	if sig.CRCLength != 16 || sig.CRC != crc16(code[32:]) {
+		t.Fatalf("CRCLength, CRC = %d, %04X, want the 16 bytes after the pattern", sig.CRCLength, sig.CRC)
+	}
+
+	db := NewDatabase()
+	db.Add(sig)
+	fn := &disasm.Function{}
+	if db.Match(code, fn, nil) != sig {
+		t.Errorf("Match(same code) = nil")
+	}
+	changed := append([]byte(nil), code...)
+	changed[40] ^= 0xff
+	if db.Match(changed, fn, nil) != nil {
+		t.Errorf("Match(tail byte changed) matched despite the CRC")
+	}
+
+	// A relocation ends the CRC at the first wildcard
+	sig = newSignature("ramp", code, 0x100, []relocSpan{{offset: 0x100 + 36, size: 4}})
+	if sig.CRCLength != 4 || sig.CRC != crc16(code[32:36]) {
+		t.Errorf("CRCLength, CRC = %d, %04X, want the 4 bytes before the relocation", sig.CRCLength, sig.CRC)
+	}
+	changed = append([]byte(nil), code...)
+	changed[37], changed[40] = 0xee, 0xee
+	if !sig.matchBytes(changed) {
+		t.Errorf("matchBytes() checked relocated bytes or bytes past the CRC")
+	}
+}
+
+func TestMatchConflicts(t *testing.T) {
+	code, fn := linkedGreet(t)
+	line := strings.Replace(greetLine, " greet ", " %s ", 1)
+	parse := func(name string) *Signature {
+		sig, err := parseLine(strings.Replace(line, "%s", name, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	// Identical functions under one name, as several archives may hold
+	db := NewDatabase()
+	db.Add(parse("greet"))
+	db.Add(parse("greet"))
+	if got := db.Match(code, fn, nil); got == nil || got.Name != "greet" {
+		t.Errorf("Match(duplicates) = %v, want greet", got)
+	}
+
+	// Identical functions under two names identify neither
+	db.Add(parse("welcome"))
+	if got := db.Match(code, fn, nil); got != nil {
+		t.Errorf("Match(greet or welcome) = %s, want nil", got.Name)
+	}
+
+	// Unless the references tell them apart
+	other := parse("welcome")
+	other.Refs[2].Name = "puts"
+	db = NewDatabase()
+	db.Add(parse("greet"))
+	db.Add(other)
+	if got := db.Match(code, fn, map[uint64]string{0x5000: "printf"}); got == nil || got.Name != "greet" {
+		t.Errorf("Match(call to printf) = %v, want greet", got)
+	}
+
+	// Signatures starting with a wildcard are candidates for any first byte
+	wild := parse("wild")
+	wild.Mask[0] = false
+	db = NewDatabase()
+	db.Add(wild)
+	changed := append([]byte(nil), code...)
+	changed[0] = 0xcc
+	if got := db.Match(changed, fn, nil); got != wild {
+		t.Errorf("Match(wildcard first byte) = %v, want wild", got)
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	tests := map[string]string{
+		"fields":        "4883EC08 00 0000 0010 :0000",
+		"odd pattern":   "4883EC0 00 0000 0010 :0000 f",
+		"long pattern":  strings.Repeat("90", PatternSize+1) + " 00 0000 0030 :0000 f",
+		"pattern byte":  "4883ZZ08 00 0000 0010 :0000 f",
+		"CRC length":    "4883EC08 100 0000 0010 :0000 f",
+		"CRC":           "4883EC08 00 GGGG 0010 :0000 f",
+		"size":          "4883EC08 00 0000 -10 :0000 f",
+		"short size":    "4883EC0848 04 1234 0008 :0000 f",
+		"public offset": "4883EC08 00 0000 0010 0000 f",
+		"ref offset":    "4883EC08 00 0000 0010 :0000 f ^XYZ g",
+		"dangling ref":  "4883EC08 00 0000 0010 :0000 f ^0004",
+	}
+	for name, line := range tests {
+		input := fileHeader + "\n\n# comment\n" + line + "\n"
+		err := NewDatabase().Read(strings.NewReader(input))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 4: ") {
+			t.Errorf("%s: Read(%q) = %v, want an error on line 4", name, line, err)
+		}
+	}
+}
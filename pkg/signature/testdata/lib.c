@@ -0,0 +1,17 @@
+/* A library object, the fixture of the signature tests:
+ *
+ *   gcc -O2 -fno-pic -fcf-protection=none -c -o lib.o lib.c
+ */
+#include <stdio.h>
+
+int counter;
+
+void greet(const char *name) {
+	counter += 3;
+	printf("hello, %s (%d)\n", name, counter);
+	fflush(stdout);
+}
+
+int tiny(void) {
+	return 1;
+}